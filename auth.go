@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Role is what an authenticated caller may do. Each role includes the ones below it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	// RoleRead may inspect sessions, jobs, events and metrics
+	RoleRead
+	// RoleWrite may additionally warm, start, build and clean its sessions
+	RoleWrite
+	// RoleAdmin may additionally clean up every cyanprint resource and run try sessions from local paths
+	RoleAdmin
+)
+
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(s) {
+	case "read":
+		return RoleRead, nil
+	case "write":
+		return RoleWrite, nil
+	case "admin":
+		return RoleAdmin, nil
+	default:
+		return RoleNone, fmt.Errorf("invalid role '%s': must be read, write or admin", s)
+	}
+}
+
+func (r Role) String() string {
+	switch r {
+	case RoleRead:
+		return "read"
+	case RoleWrite:
+		return "write"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// Authenticator identifies the caller of a request.
+// ok is false if the request carries no credentials the authenticator recognises.
+type Authenticator interface {
+	Authenticate(r *http.Request) (role Role, ok bool)
+}
+
+// TokenAuthenticator accepts static bearer tokens
+type TokenAuthenticator struct {
+	tokens map[string]Role
+}
+
+// LoadTokens reads a token file with one "<role> <token>" pair per line.
+// Blank lines and lines starting with # are ignored.
+func LoadTokens(path string) (*TokenAuthenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token file: %w", err)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	a := &TokenAuthenticator{tokens: make(map[string]Role)}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("token file line %d: expected '<role> <token>'", line)
+		}
+		role, err := ParseRole(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("token file line %d: %w", line, err)
+		}
+		a.tokens[fields[1]] = role
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	if len(a.tokens) == 0 {
+		return nil, fmt.Errorf("token file %s contains no tokens", path)
+	}
+	return a, nil
+}
+
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (Role, bool) {
+	header := r.Header.Get("Authorization")
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found || token == "" {
+		return RoleNone, false
+	}
+	// compare against every token so the time taken does not leak which one matched
+	role := RoleNone
+	matched := false
+	for t, rl := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			role = rl
+			matched = true
+		}
+	}
+	return role, matched
+}
+
+// CertAuthenticator accepts TLS client certificates verified against the server's client CA.
+// The role is taken from the certificate subject's organizational unit (read, write or admin);
+// verified certificates without one get DefaultRole.
+type CertAuthenticator struct {
+	DefaultRole Role
+}
+
+func (a CertAuthenticator) Authenticate(r *http.Request) (Role, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return RoleNone, false
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	role := a.DefaultRole
+	for _, ou := range leaf.Subject.OrganizationalUnit {
+		if rl, err := ParseRole(ou); err == nil && rl > role {
+			role = rl
+		}
+	}
+	return role, true
+}
+
+// Auth enforces roles on routes. With no authenticators, auth is disabled and every caller is an admin.
+type Auth struct {
+	Authenticators []Authenticator
+}
+
+func (a Auth) Enabled() bool {
+	return len(a.Authenticators) > 0
+}
+
+// role returns the highest role any authenticator grants the request
+func (a Auth) role(r *http.Request) (Role, bool) {
+	best := RoleNone
+	authenticated := false
+	for _, authenticator := range a.Authenticators {
+		if role, ok := authenticator.Authenticate(r); ok {
+			authenticated = true
+			if role > best {
+				best = role
+			}
+		}
+	}
+	return best, authenticated
+}
+
+// Require rejects requests whose caller does not have at least the given role
+func (a Auth) Require(min Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+		role, ok := a.role(c.Request)
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="boron"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ProblemDetails{
+				Title:   "Unauthorized",
+				Status:  401,
+				Detail:  "A bearer token or client certificate is required",
+				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/401",
+				TraceId: traceId(c),
+				Data:    nil,
+			})
+			return
+		}
+		if role < min {
+			c.AbortWithStatusJSON(http.StatusForbidden, ProblemDetails{
+				Title:   "Forbidden",
+				Status:  403,
+				Detail:  fmt.Sprintf("This endpoint requires the %s role, caller has %s", min, role),
+				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/403",
+				TraceId: traceId(c),
+				Data:    nil,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// NetworkGuard restricts routes to callers from the given networks, e.g. containers on the cyanprint network
+type NetworkGuard struct {
+	Networks []*net.IPNet
+}
+
+func (g NetworkGuard) allowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range g.Networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Require rejects requests that do not come from one of the guard's networks.
+// The peer address is used, never forwarding headers, since those are set by the caller.
+func (g NetworkGuard) Require() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if g.allowed(c.Request.RemoteAddr) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, ProblemDetails{
+			Title:   "Forbidden",
+			Status:  403,
+			Detail:  "This endpoint is only reachable from the cyanprint network",
+			Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/403",
+			TraceId: traceId(c),
+			Data:    nil,
+		})
+	}
+}
+
+// ParseCIDRs parses networks in CIDR notation
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid network '%s': %w", cidr, err)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// localNetworks returns the networks of this host's non-loopback interfaces.
+// Inside a container attached only to the cyanprint network, that is the cyanprint subnet.
+func localNetworks() ([]*net.IPNet, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var networks []*net.IPNet
+	for _, addr := range addrs {
+		n, ok := addr.(*net.IPNet)
+		if !ok || n.IP.IsLoopback() {
+			continue
+		}
+		networks = append(networks, &net.IPNet{IP: n.IP.Mask(n.Mask), Mask: n.Mask})
+	}
+	return networks, nil
+}
+
+// serverTLSConfig builds the TLS configuration for serving with certFile and keyFile.
+// When clientCAFile is set, client certificates are verified against it if presented,
+// leaving clients free to authenticate with bearer tokens instead.
+func serverTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA file %s contains no certificates", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AtomiCloud/sulfone.boron/docker_executor"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func writeTokenFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+	return path
+}
+
+// TestLoadTokens tests parsing of token files
+func TestLoadTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "valid with comments", content: "# team tokens\nread r-token\n\nadmin a-token\n"},
+		{name: "unknown role", content: "root r-token\n", wantErr: true},
+		{name: "missing token", content: "read\n", wantErr: true},
+		{name: "empty", content: "# nothing\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadTokens(writeTokenFile(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadTokens() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestAuthRequire tests that routes are rejected without credentials or with a role that is too low
+func TestAuthRequire(t *testing.T) {
+	tokens, err := LoadTokens(writeTokenFile(t, "read r-token\nwrite w-token\nadmin a-token\n"))
+	if err != nil {
+		t.Fatalf("Failed to load tokens: %v", err)
+	}
+	auth := Auth{Authenticators: []Authenticator{tokens, CertAuthenticator{DefaultRole: RoleRead}}}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/read", auth.Require(RoleRead), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/admin", auth.Require(RoleAdmin), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	adminCert := &x509.Certificate{Subject: pkix.Name{OrganizationalUnit: []string{"admin"}}}
+	plainCert := &x509.Certificate{Subject: pkix.Name{CommonName: "someone"}}
+
+	tests := []struct {
+		name string
+		path string
+		auth string
+		cert *x509.Certificate
+		want int
+	}{
+		{name: "no credentials", path: "/read", want: http.StatusUnauthorized},
+		{name: "unknown token", path: "/read", auth: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "not bearer", path: "/read", auth: "Basic r-token", want: http.StatusUnauthorized},
+		{name: "read token on read route", path: "/read", auth: "Bearer r-token", want: http.StatusOK},
+		{name: "write token on admin route", path: "/admin", auth: "Bearer w-token", want: http.StatusForbidden},
+		{name: "admin token on admin route", path: "/admin", auth: "Bearer a-token", want: http.StatusOK},
+		{name: "admin certificate", path: "/admin", cert: adminCert, want: http.StatusOK},
+		{name: "certificate without role", path: "/admin", cert: plainCert, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			if tt.cert != nil {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.cert}}}
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+// TestAuthDisabled tests that without authenticators every route is open
+func TestAuthDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/admin", Auth{}.Require(RoleAdmin), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
+
+// TestNetworkGuard tests that only peers on the guarded networks are let through, ignoring forwarding headers
+func TestNetworkGuard(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"172.20.0.0/16"})
+	if err != nil {
+		t.Fatalf("Failed to parse networks: %v", err)
+	}
+	guard := NetworkGuard{Networks: networks}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/zip", guard.Require(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{name: "cyanprint container", remoteAddr: "172.20.0.5:41234", want: http.StatusOK},
+		{name: "outside network", remoteAddr: "10.0.0.8:41234", want: http.StatusForbidden},
+		{name: "loopback", remoteAddr: "127.0.0.1:41234", want: http.StatusForbidden},
+		{name: "spoofed forwarding header", remoteAddr: "10.0.0.8:41234", forwarded: "172.20.0.5", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/zip", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+// TestBuildInternalGuard tests that internal endpoints are restricted to the configured networks or the
+// cyanprint network, and that without either the guard fails closed unless no runtime is reachable
+func TestBuildInternalGuard(t *testing.T) {
+	unreachable, err := client.NewClientWithOpts(client.WithHost("unix://" + filepath.Join(t.TempDir(), "docker.sock")))
+	if err != nil {
+		t.Fatalf("Failed to create Docker client: %v", err)
+	}
+	failing := docker_executor.NewFakeRuntime()
+	failing.FailOn(docker_executor.FakeCreateNetwork, failing.Settings().Network, errors.New("permission denied"))
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		runtime docker_executor.ContainerRuntime
+		want    string
+		wantErr bool
+	}{
+		{name: "configured", cfg: Config{Auth: AuthConfig{InternalNetworks: []string{"10.1.0.0/16"}}}, runtime: failing, want: "10.1.0.0/16"},
+		{name: "cyanprint network created at startup", runtime: docker_executor.NewFakeRuntime(), want: "172.20.0.0/16"},
+		{name: "network can't be created", runtime: failing, wantErr: true},
+		{name: "subnets unknown", runtime: &docker_executor.KubernetesRuntime{Client: fake.NewSimpleClientset()}, wantErr: true},
+		{name: "no runtime reachable", runtime: &docker_executor.DockerClient{Docker: unreachable, Context: context.Background()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard, err := buildInternalGuard(tt.cfg, tt.runtime)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got networks %v", guard.Networks)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tt.want != "" && (len(guard.Networks) != 1 || guard.Networks[0].String() != tt.want) {
+				t.Errorf("Expected networks [%s], got %v", tt.want, guard.Networks)
+			}
+		})
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	rt "runtime"
+	"time"
+
+	"github.com/AtomiCloud/sulfone.boron/docker_executor"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the coordinator's configuration. It is loaded once at startup from, in increasing order of
+// precedence, built-in defaults, a YAML file, BORON_* environment variables and command line flags.
+type Config struct {
+	Listen      string `yaml:"listen"`
+	Registry    string `yaml:"registry"`
+	Parallelism int    `yaml:"parallelism"`
+	// SessionTTL is how long a session may be idle before the reaper cleans it; 0 disables the reaper
+	SessionTTL      time.Duration `yaml:"session_ttl"`
+	ReapInterval    time.Duration `yaml:"reap_interval"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	Log             LogConfig     `yaml:"log"`
+	TraceExporter   string        `yaml:"trace_exporter"`
+	Auth            AuthConfig    `yaml:"auth"`
+	TLS             TLSConfig     `yaml:"tls"`
+	GC              GCConfig      `yaml:"gc"`
+	// Runtime is the container runtime sessions run on: docker or kubernetes
+	Runtime    string                           `yaml:"runtime"`
+	Docker     docker_executor.Config           `yaml:"docker"`
+	Kubernetes docker_executor.KubernetesConfig `yaml:"kubernetes"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type AuthConfig struct {
+	// TokenFile holds "<role> <token>" lines accepted as bearer tokens
+	TokenFile string `yaml:"token_file"`
+	// InternalNetworks may call the merger-internal endpoints; defaults to the cyanprint network's subnets
+	InternalNetworks []string `yaml:"internal_networks"`
+}
+
+// TLSConfig enables HTTPS; ClientCA additionally authenticates client certificates
+type TLSConfig struct {
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+	ClientCA string `yaml:"client_ca"`
+}
+
+// GCConfig sets up garbage collection of the least recently used images and template volumes
+type GCConfig struct {
+	// Budget is the disk space cyanprint images and volumes may take; 0 disables collection
+	Budget   docker_executor.ByteSize `yaml:"budget"`
+	Interval time.Duration            `yaml:"interval"`
+	// StateFile records when each image and template volume was last used
+	StateFile string `yaml:"state_file"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Listen:          ":9000",
+		Registry:        "https://api.zinc.sulfone.raichu.cluster.atomi.cloud",
+		Parallelism:     rt.NumCPU(),
+		SessionTTL:      time.Hour,
+		ReapInterval:    time.Minute,
+		ShutdownTimeout: 2 * time.Minute,
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		TraceExporter: "none",
+		Runtime:       RuntimeDocker,
+		Docker:        docker_executor.DefaultConfig(),
+		Kubernetes:    docker_executor.DefaultKubernetesConfig(),
+		GC: GCConfig{
+			Interval:  10 * time.Minute,
+			StateFile: filepath.Join(os.TempDir(), "boron-usage.json"),
+		},
+	}
+}
+
+func (c Config) Validate() error {
+	if c.Listen == "" {
+		return errors.New("listen address must not be empty")
+	}
+	if c.Registry == "" {
+		return errors.New("registry must not be empty")
+	}
+	if c.Parallelism < 1 {
+		return fmt.Errorf("parallelism must be at least 1, got %d", c.Parallelism)
+	}
+	if c.SessionTTL < 0 || c.ShutdownTimeout < 0 {
+		return errors.New("session TTL and shutdown timeout must not be negative")
+	}
+	if c.SessionTTL > 0 && c.ReapInterval <= 0 {
+		return fmt.Errorf("reap interval must be positive, got %s", c.ReapInterval)
+	}
+	if c.GC.Budget < 0 {
+		return fmt.Errorf("gc budget must not be negative, got %s", c.GC.Budget)
+	}
+	if c.GC.Budget > 0 && c.GC.Interval <= 0 {
+		return fmt.Errorf("gc interval must be positive, got %s", c.GC.Interval)
+	}
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		return errors.New("TLS needs both a certificate and a key")
+	}
+	switch c.Runtime {
+	case RuntimeDocker:
+	case RuntimeKubernetes:
+		if err := c.Kubernetes.Validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("runtime must be %s or %s, got '%s'", RuntimeDocker, RuntimeKubernetes, c.Runtime)
+	}
+	return c.Docker.Validate()
+}
+
+// configFlags are the flags of every command that reads the configuration.
+// Each overrides the file setting of the same name and can also be set with its BORON_* variable.
+func configFlags() []cli.Flag {
+	d := DefaultConfig()
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Usage:   "YAML configuration file",
+			EnvVars: []string{"BORON_CONFIG"},
+		},
+		&cli.StringFlag{
+			Name:    "listen",
+			Usage:   "Address to serve the API on",
+			Value:   d.Listen,
+			EnvVars: []string{"BORON_LISTEN"},
+		},
+		&cli.StringFlag{
+			Name:    "registry",
+			Aliases: []string{"r"},
+			Usage:   "Zinc registry endpoint",
+			Value:   d.Registry,
+			EnvVars: []string{"BORON_REGISTRY"},
+		},
+		&cli.IntFlag{
+			Name:    "parallelism",
+			Usage:   "Maximum number of images pulled or containers started at once",
+			Value:   d.Parallelism,
+			EnvVars: []string{"BORON_PARALLELISM"},
+		},
+		&cli.DurationFlag{
+			Name:    "session-ttl",
+			Usage:   "Clean sessions that have had no activity for this long (0 disables the reaper)",
+			Value:   d.SessionTTL,
+			EnvVars: []string{docker_executor.EnvSessionTTL},
+		},
+		&cli.DurationFlag{
+			Name:    "reap-interval",
+			Usage:   "How often to look for expired sessions",
+			Value:   d.ReapInterval,
+			EnvVars: []string{"BORON_REAP_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:    "shutdown-timeout",
+			Usage:   "How long to wait for in-flight builds on SIGINT/SIGTERM before cancelling and cleaning them",
+			Value:   d.ShutdownTimeout,
+			EnvVars: []string{"BORON_SHUTDOWN_TIMEOUT"},
+		},
+		&cli.StringFlag{
+			Name:    "gc-budget",
+			Usage:   "Evict the least recently used images and template volumes once cyanprint's take more than this, e.g. 20g (default: no limit)",
+			EnvVars: []string{docker_executor.EnvGCBudget},
+		},
+		&cli.DurationFlag{
+			Name:    "gc-interval",
+			Usage:   "How often to check disk usage against --gc-budget",
+			Value:   d.GC.Interval,
+			EnvVars: []string{"BORON_GC_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:    "gc-state-file",
+			Usage:   "File recording when each image and template volume was last used",
+			Value:   d.GC.StateFile,
+			EnvVars: []string{"BORON_GC_STATE_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "log-level",
+			Usage:   "Minimum level to log: debug, info, warn or error",
+			Value:   d.Log.Level,
+			EnvVars: []string{"BORON_LOG_LEVEL"},
+		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Usage:   "Log output format: text or json",
+			Value:   d.Log.Format,
+			EnvVars: []string{"BORON_LOG_FORMAT"},
+		},
+		&cli.StringFlag{
+			Name:    "trace-exporter",
+			Usage:   "Where to export traces: none, otlp (configured with OTEL_EXPORTER_OTLP_* variables) or stdout",
+			Value:   d.TraceExporter,
+			EnvVars: []string{"BORON_TRACE_EXPORTER"},
+		},
+		&cli.StringFlag{
+			Name:    "token-file",
+			Usage:   "File of '<role> <token>' lines (roles: read, write, admin) accepted as bearer tokens",
+			EnvVars: []string{"BORON_TOKEN_FILE"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "internal-network",
+			Usage:   "CIDR allowed to call the merger-internal /merge and /zip endpoints (default: the cyanprint network)",
+			EnvVars: []string{docker_executor.EnvInternalNetworks},
+		},
+		&cli.StringFlag{
+			Name:    "tls-cert",
+			Usage:   "Serve HTTPS with this certificate",
+			EnvVars: []string{"BORON_TLS_CERT"},
+		},
+		&cli.StringFlag{
+			Name:    "tls-key",
+			Usage:   "Private key for --tls-cert",
+			EnvVars: []string{"BORON_TLS_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "tls-client-ca",
+			Usage:   "Authenticate client certificates signed by this CA; the role is read from the subject's OU",
+			EnvVars: []string{"BORON_TLS_CLIENT_CA"},
+		},
+		&cli.StringFlag{
+			Name:    "runtime",
+			Usage:   "Container runtime to run sessions on: docker or kubernetes",
+			Value:   d.Runtime,
+			EnvVars: []string{"BORON_RUNTIME"},
+		},
+		&cli.StringFlag{
+			Name:    "network",
+			Usage:   "Docker bridge network cyanprint containers join",
+			Value:   d.Docker.Network,
+			EnvVars: []string{"BORON_NETWORK"},
+		},
+		&cli.IntFlag{
+			Name:    "health-check-attempts",
+			Usage:   "How many times to probe a started container before giving up on it",
+			Value:   d.Docker.HealthCheck.Attempts,
+			EnvVars: []string{"BORON_HEALTH_CHECK_ATTEMPTS"},
+		},
+		&cli.DurationFlag{
+			Name:    "health-check-interval",
+			Usage:   "Time before the second health check probe, doubling for each probe after it",
+			Value:   d.Docker.HealthCheck.Interval,
+			EnvVars: []string{"BORON_HEALTH_CHECK_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:    "health-check-timeout",
+			Usage:   "How long a started container has to become ready, unless its type sets its own",
+			Value:   d.Docker.HealthCheck.Timeout,
+			EnvVars: []string{"BORON_HEALTH_CHECK_TIMEOUT"},
+		},
+		&cli.StringFlag{
+			Name:    "workspace-template-dir",
+			Usage:   "Where the template volume is mounted in mergers and processors",
+			Value:   d.Docker.Workspace.TemplateDir,
+			EnvVars: []string{docker_executor.EnvWorkspaceTemplateDir},
+		},
+		&cli.StringFlag{
+			Name:    "workspace-area-dir",
+			Usage:   "Where the working volume is mounted in mergers and processors",
+			Value:   d.Docker.Workspace.AreaDir,
+			EnvVars: []string{docker_executor.EnvWorkspaceAreaDir},
+		},
+		&cli.StringFlag{
+			Name:    "docker-config",
+			Usage:   "Docker config.json to read registry credentials from (default: $DOCKER_CONFIG/config.json or ~/.docker/config.json)",
+			EnvVars: []string{"BORON_DOCKER_CONFIG"},
+		},
+		&cli.BoolFlag{
+			Name:    "session-networks",
+			Usage:   "Give each session its own network",
+			Value:   d.Docker.Isolation.SessionNetworks,
+			EnvVars: []string{"BORON_SESSION_NETWORKS"},
+		},
+		&cli.BoolFlag{
+			Name:    "deny-egress",
+			Usage:   "Cut processors and plugins off from everything outside their session network",
+			EnvVars: []string{"BORON_DENY_EGRESS"},
+		},
+		&cli.StringFlag{
+			Name:    "coordinator-container",
+			Usage:   "Container or pod the coordinator runs in, attached to session networks (default: the hostname)",
+			EnvVars: []string{"BORON_COORDINATOR_CONTAINER"},
+		},
+		&cli.StringFlag{
+			Name:    "sandbox-user",
+			Usage:   "Numeric uid:gid containers with the strict security profile run as",
+			Value:   d.Docker.Security.User,
+			EnvVars: []string{docker_executor.EnvSandboxUser},
+		},
+		&cli.StringFlag{
+			Name:    "kubeconfig",
+			Usage:   "Kubeconfig file of the cluster to run on (default: the in-cluster service account)",
+			EnvVars: []string{"BORON_KUBECONFIG"},
+		},
+		&cli.StringFlag{
+			Name:    "kubernetes-namespace",
+			Usage:   "Namespace cyanprint pods, services and volume claims are created in; the coordinator must run in it",
+			Value:   d.Kubernetes.Namespace,
+			EnvVars: []string{"BORON_KUBERNETES_NAMESPACE"},
+		},
+		&cli.StringFlag{
+			Name:    "kubernetes-storage-class",
+			Usage:   "ReadWriteMany storage class of volume claims (default: the cluster default)",
+			EnvVars: []string{"BORON_KUBERNETES_STORAGE_CLASS"},
+		},
+		&cli.StringFlag{
+			Name:    "kubernetes-volume-size",
+			Usage:   "Storage requested by each volume claim",
+			Value:   d.Kubernetes.VolumeSize,
+			EnvVars: []string{"BORON_KUBERNETES_VOLUME_SIZE"},
+		},
+		&cli.StringFlag{
+			Name:    "coordinator-image",
+			Usage:   "Image of this coordinator that mergers run on kubernetes, as <reference>:<tag>",
+			EnvVars: []string{"BORON_COORDINATOR_IMAGE"},
+		},
+	}
+}
+
+// loadConfig builds the effective configuration of a command run with configFlags.
+// It is not validated, so `config print` can show an invalid configuration.
+func loadConfig(c *cli.Context) (Config, error) {
+	cfg := DefaultConfig()
+	if path := c.String("config"); path != "" {
+		if err := readConfigFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	// flags win over environment variables; cli resolves that, IsSet is true for either
+	setString(c, "listen", &cfg.Listen)
+	setString(c, "registry", &cfg.Registry)
+	setInt(c, "parallelism", &cfg.Parallelism)
+	setDuration(c, "session-ttl", &cfg.SessionTTL)
+	setDuration(c, "reap-interval", &cfg.ReapInterval)
+	setDuration(c, "shutdown-timeout", &cfg.ShutdownTimeout)
+	if c.IsSet("gc-budget") {
+		budget, err := docker_executor.ParseByteSize(c.String("gc-budget"))
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid gc budget: %w", err)
+		}
+		cfg.GC.Budget = budget
+	}
+	setDuration(c, "gc-interval", &cfg.GC.Interval)
+	setString(c, "gc-state-file", &cfg.GC.StateFile)
+	setString(c, "log-level", &cfg.Log.Level)
+	setString(c, "log-format", &cfg.Log.Format)
+	setString(c, "trace-exporter", &cfg.TraceExporter)
+	setString(c, "token-file", &cfg.Auth.TokenFile)
+	if c.IsSet("internal-network") {
+		cfg.Auth.InternalNetworks = c.StringSlice("internal-network")
+	}
+	setString(c, "tls-cert", &cfg.TLS.Cert)
+	setString(c, "tls-key", &cfg.TLS.Key)
+	setString(c, "tls-client-ca", &cfg.TLS.ClientCA)
+	setString(c, "network", &cfg.Docker.Network)
+	setInt(c, "health-check-attempts", &cfg.Docker.HealthCheck.Attempts)
+	setDuration(c, "health-check-interval", &cfg.Docker.HealthCheck.Interval)
+	setDuration(c, "health-check-timeout", &cfg.Docker.HealthCheck.Timeout)
+	setString(c, "workspace-template-dir", &cfg.Docker.Workspace.TemplateDir)
+	setString(c, "workspace-area-dir", &cfg.Docker.Workspace.AreaDir)
+	setString(c, "sandbox-user", &cfg.Docker.Security.User)
+	setString(c, "docker-config", &cfg.Docker.DockerConfig)
+	setBool(c, "session-networks", &cfg.Docker.Isolation.SessionNetworks)
+	setBool(c, "deny-egress", &cfg.Docker.Isolation.DenyEgress)
+	setString(c, "coordinator-container", &cfg.Docker.Isolation.Coordinator)
+	setString(c, "runtime", &cfg.Runtime)
+	setString(c, "kubeconfig", &cfg.Kubernetes.Kubeconfig)
+	setString(c, "kubernetes-namespace", &cfg.Kubernetes.Namespace)
+	setString(c, "kubernetes-storage-class", &cfg.Kubernetes.StorageClass)
+	setString(c, "kubernetes-volume-size", &cfg.Kubernetes.VolumeSize)
+	setString(c, "coordinator-image", &cfg.Kubernetes.CoordinatorImage)
+	return cfg, nil
+}
+
+// readConfigFile overlays the settings in the YAML file at path onto cfg. Unknown keys are rejected
+// so a typo doesn't silently leave a setting at its default.
+func readConfigFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func setString(c *cli.Context, name string, dst *string) {
+	if c.IsSet(name) {
+		*dst = c.String(name)
+	}
+}
+
+func setInt(c *cli.Context, name string, dst *int) {
+	if c.IsSet(name) {
+		*dst = c.Int(name)
+	}
+}
+
+func setBool(c *cli.Context, name string, dst *bool) {
+	if c.IsSet(name) {
+		*dst = c.Bool(name)
+	}
+}
+
+func setDuration(c *cli.Context, name string, dst *time.Duration) {
+	if c.IsSet(name) {
+		*dst = c.Duration(name)
+	}
+}
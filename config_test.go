@@ -0,0 +1,204 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AtomiCloud/sulfone.boron/docker_executor"
+	"github.com/urfave/cli/v2"
+)
+
+// runConfig loads the configuration as a command invoked with args would
+func runConfig(t *testing.T, args ...string) (Config, error) {
+	t.Helper()
+	var cfg Config
+	var loadErr error
+	app := &cli.App{
+		Flags: configFlags(),
+		Action: func(c *cli.Context) error {
+			cfg, loadErr = loadConfig(c)
+			return nil
+		},
+	}
+	if err := app.Run(append([]string{"boron"}, args...)); err != nil {
+		t.Fatalf("Failed to run app: %v", err)
+	}
+	return cfg, loadErr
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "boron.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+// TestLoadConfigDefaults tests that without a file, variables or flags the defaults are used
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := runConfig(t)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	d := DefaultConfig()
+	if cfg.Listen != d.Listen || cfg.Docker.Network != d.Docker.Network || cfg.Parallelism != d.Parallelism {
+		t.Errorf("Expected defaults, got %+v", cfg)
+	}
+	if cfg.Docker.Isolation.SessionNetworks {
+		t.Error("Expected session networks to be off by default")
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected defaults to be valid, got %v", err)
+	}
+}
+
+// TestLoadConfigPrecedence tests that flags override environment variables, which override the file
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+listen: ":8000"
+registry: https://registry.file
+session_ttl: 30m
+docker:
+  network: file-net
+  health_check:
+    attempts: 5
+`)
+	t.Setenv("BORON_REGISTRY", "https://registry.env")
+	t.Setenv("BORON_NETWORK", "env-net")
+
+	cfg, err := runConfig(t, "--config", path, "--network", "flag-net")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Listen != ":8000" {
+		t.Errorf("Expected listen from file, got %s", cfg.Listen)
+	}
+	if cfg.SessionTTL != 30*time.Minute {
+		t.Errorf("Expected session TTL from file, got %s", cfg.SessionTTL)
+	}
+	if cfg.Registry != "https://registry.env" {
+		t.Errorf("Expected registry from environment, got %s", cfg.Registry)
+	}
+	if cfg.Docker.Network != "flag-net" {
+		t.Errorf("Expected network from flag, got %s", cfg.Docker.Network)
+	}
+	if cfg.Docker.HealthCheck.Attempts != 5 {
+		t.Errorf("Expected health check attempts from file, got %d", cfg.Docker.HealthCheck.Attempts)
+	}
+	// nested settings absent from the file keep their defaults
+	if cfg.Docker.HealthCheck.Interval != 250*time.Millisecond {
+		t.Errorf("Expected default health check interval, got %s", cfg.Docker.HealthCheck.Interval)
+	}
+}
+
+// TestLoadConfigGCBudget tests that the budget is read with a unit from the file and from the flag
+func TestLoadConfigGCBudget(t *testing.T) {
+	path := writeConfigFile(t, "gc:\n  budget: 20g\n")
+	cfg, err := runConfig(t, "--config", path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.GC.Budget != 20<<30 {
+		t.Errorf("Expected a 20GiB budget from file, got %s", cfg.GC.Budget)
+	}
+	cfg, err = runConfig(t, "--config", path, "--gc-budget", "512m")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.GC.Budget != 512<<20 {
+		t.Errorf("Expected a 512MiB budget from flag, got %s", cfg.GC.Budget)
+	}
+	if _, err := runConfig(t, "--gc-budget", "lots"); err == nil {
+		t.Error("Expected an error for an invalid budget")
+	}
+}
+
+// TestMergerInternalNetworks tests that the coordinator's internal networks reach mergers, which read
+// them back from their environment
+func TestMergerInternalNetworks(t *testing.T) {
+	path := writeConfigFile(t, "auth:\n  internal_networks: [10.244.0.0/16, 10.96.0.0/12]\n")
+	cfg, err := runConfig(t, "--config", path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	r, err := newRuntimes(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create runtimes: %v", err)
+	}
+	networks := r.cfg.Docker.InternalNetworks
+	if len(networks) != 2 {
+		t.Fatalf("Expected both internal networks passed on to mergers, got %v", networks)
+	}
+
+	t.Setenv(docker_executor.EnvInternalNetworks, strings.Join(networks, ","))
+	merger, err := runConfig(t)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !slices.Equal(merger.Auth.InternalNetworks, networks) {
+		t.Errorf("Expected the merger to read %v, got %v", networks, merger.Auth.InternalNetworks)
+	}
+}
+
+// TestMergerReaperDisabled tests that the environment mergers are started with turns off their session
+// reaper and garbage collector
+func TestMergerReaperDisabled(t *testing.T) {
+	t.Setenv(docker_executor.EnvSessionTTL, "0")
+	t.Setenv(docker_executor.EnvGCBudget, "0")
+	cfg, err := runConfig(t)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.SessionTTL != 0 || cfg.GC.Budget != 0 {
+		t.Errorf("Expected no session TTL and no gc budget, got %s and %s", cfg.SessionTTL, cfg.GC.Budget)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected the merger's config to be valid, got %v", err)
+	}
+}
+
+// TestLoadConfigUnknownKey tests that misspelt settings are reported instead of ignored
+func TestLoadConfigUnknownKey(t *testing.T) {
+	path := writeConfigFile(t, "paralelism: 4\n")
+	if _, err := runConfig(t, "--config", path); err == nil {
+		t.Error("Expected an error for an unknown key")
+	}
+}
+
+// TestConfigValidate tests that settings the coordinator cannot run with are rejected
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+	}{
+		{name: "no parallelism", modify: func(c *Config) { c.Parallelism = 0 }},
+		{name: "cert without key", modify: func(c *Config) { c.TLS.Cert = "cert.pem" }},
+		{name: "reaper without interval", modify: func(c *Config) { c.ReapInterval = 0 }},
+		{name: "no network", modify: func(c *Config) { c.Docker.Network = "" }},
+		{name: "no health check interval", modify: func(c *Config) { c.Docker.HealthCheck.Interval = 0 }},
+		{name: "gc without interval", modify: func(c *Config) {
+			c.GC.Budget = 1 << 30
+			c.GC.Interval = 0
+		}},
+		{name: "unknown runtime", modify: func(c *Config) { c.Runtime = "podman" }},
+		{name: "kubernetes without coordinator image", modify: func(c *Config) { c.Runtime = RuntimeKubernetes }},
+		{name: "egress denied without session networks", modify: func(c *Config) {
+			c.Docker.Isolation.SessionNetworks = false
+			c.Docker.Isolation.DenyEgress = true
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(&cfg)
+			if err := cfg.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
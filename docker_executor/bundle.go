@@ -0,0 +1,197 @@
+package docker_executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// BundleFormat is the version of the bundle layout, raised when an older coordinator couldn't import it
+const BundleFormat = 1
+
+// A bundle is a tar archive of bundleManifestFile followed by bundleImagesFile, the runtime's image
+// archive. The manifest comes first so importing can stream the images straight into the runtime.
+const (
+	bundleManifestFile = "cyanprint-bundle.json"
+	bundleImagesFile   = "images.tar"
+)
+
+// BundleManifest describes a bundle: the template it was exported for, which is what warming and
+// starting take, and the images it holds
+type BundleManifest struct {
+	Format       int                `json:"format"`
+	CreatedAt    time.Time          `json:"created_at"`
+	BoronVersion string             `json:"boron_version"`
+	Template     TemplateVersionRes `json:"template"`
+	Images       []string           `json:"images"`
+}
+
+// BundleImages returns the images warming and starting the template use: the template and blob images
+// and those of its processors, plugins and resolvers, without duplicates. Images pinned by digest are
+// refused, as loading an image doesn't keep the digest it was pulled by, so it could never be found.
+func BundleImages(template TemplateVersionRes) ([]DockerImageReference, error) {
+	if template.Principal.Properties == nil {
+		return nil, fmt.Errorf("template %s has no properties to take its images from", template.Principal.ID)
+	}
+	p := template.Principal.Properties
+	images := []DockerImageReference{
+		NewDockerImageReference(p.TemplateDockerReference, p.TemplateDockerTag),
+		NewDockerImageReference(p.BlobDockerReference, p.BlobDockerTag),
+	}
+	for _, processor := range template.Processors {
+		images = append(images, NewDockerImageReference(processor.DockerReference, processor.DockerTag))
+	}
+	for _, plugin := range template.Plugins {
+		images = append(images, NewDockerImageReference(plugin.DockerReference, plugin.DockerTag))
+	}
+	for _, resolver := range template.Resolvers {
+		images = append(images, NewDockerImageReference(strings.TrimSpace(resolver.DockerReference), strings.TrimSpace(resolver.DockerTag)))
+	}
+
+	var unique []DockerImageReference
+	seen := make(map[string]bool)
+	for _, image := range images {
+		name := DockerImageToString(image)
+		if image.Reference == "" || image.Tag == "" {
+			if image.Digest != "" {
+				return nil, fmt.Errorf("image %s is pinned by digest only, which loading doesn't keep; pin it by tag to bundle it", name)
+			}
+			return nil, fmt.Errorf("image '%s' is missing a reference or tag", name)
+		}
+		if image.Digest != "" {
+			return nil, fmt.Errorf("image %s is pinned by digest, which loading doesn't keep; pin it by tag to bundle it", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, image)
+		}
+	}
+	return unique, nil
+}
+
+// ExportBundle writes a bundle of the template's images, which must be present locally, to w. The images
+// are staged in a temporary file, as the archive records their size ahead of them.
+func ExportBundle(d ContainerRuntime, template TemplateVersionRes, w io.Writer) (BundleManifest, error) {
+	images, err := BundleImages(template)
+	if err != nil {
+		return BundleManifest{}, err
+	}
+	manifest := BundleManifest{
+		Format:       BundleFormat,
+		CreatedAt:    time.Now().UTC(),
+		BoronVersion: boronVersion(),
+		Template:     template,
+	}
+	for _, image := range images {
+		manifest.Images = append(manifest.Images, DockerImageToString(image))
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return BundleManifest{}, err
+	}
+
+	staged, err := os.CreateTemp("", "cyanprint-bundle-*.tar")
+	if err != nil {
+		return BundleManifest{}, err
+	}
+	defer func() {
+		_ = staged.Close()
+		_ = os.Remove(staged.Name())
+	}()
+	if err := d.SaveImages(images, staged); err != nil {
+		return BundleManifest{}, fmt.Errorf("failed to save images: %w", err)
+	}
+	size, err := staged.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return BundleManifest{}, err
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return BundleManifest{}, err
+	}
+
+	tw := tar.NewWriter(w)
+	if err := writeBundleFile(tw, bundleManifestFile, int64(len(manifestJSON)), bytes.NewReader(manifestJSON)); err != nil {
+		return BundleManifest{}, err
+	}
+	if err := writeBundleFile(tw, bundleImagesFile, size, staged); err != nil {
+		return BundleManifest{}, err
+	}
+	return manifest, tw.Close()
+}
+
+func writeBundleFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: time.Now()}); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// ImportBundle loads a bundle's images into the runtime and checks that warming will find every one of
+// them locally. It returns the bundle's manifest, whose template is the one to warm.
+func ImportBundle(d ContainerRuntime, r io.Reader) (BundleManifest, error) {
+	tr := tar.NewReader(r)
+	var manifest BundleManifest
+	if err := nextBundleFile(tr, bundleManifestFile); err != nil {
+		return manifest, err
+	}
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if manifest.Format != BundleFormat {
+		return manifest, fmt.Errorf("unsupported bundle format %d, expected %d", manifest.Format, BundleFormat)
+	}
+	var images []DockerImageReference
+	for _, name := range manifest.Images {
+		image, err := DockerImageToStruct(name)
+		if err != nil {
+			return manifest, fmt.Errorf("invalid bundle manifest: %w", err)
+		}
+		images = append(images, image)
+	}
+
+	if err := nextBundleFile(tr, bundleImagesFile); err != nil {
+		return manifest, err
+	}
+	if err := d.LoadImages(tr, images); err != nil {
+		return manifest, err
+	}
+
+	local, err := d.ListImages()
+	if err != nil {
+		return manifest, fmt.Errorf("failed to list images: %w", err)
+	}
+	var missing []string
+	for _, image := range images {
+		if !image.foundIn(local) {
+			missing = append(missing, DockerImageToString(image))
+		}
+	}
+	if len(missing) > 0 {
+		return manifest, fmt.Errorf("images were loaded but are not found as cyanprint images: %s", strings.Join(missing, ", "))
+	}
+	return manifest, nil
+}
+
+// nextBundleFile advances to the next file of the bundle, which must be name
+func nextBundleFile(tr *tar.Reader, name string) error {
+	h, err := tr.Next()
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid bundle: missing %s", name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid bundle: %w", err)
+	}
+	if h.Name != name {
+		return fmt.Errorf("invalid bundle: expected %s, found %s", name, h.Name)
+	}
+	return nil
+}
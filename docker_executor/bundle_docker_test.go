@@ -0,0 +1,108 @@
+package docker_executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/build"
+	imageTypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+)
+
+// dockerForTest returns a client of the local Docker daemon, skipping the test if there is none
+func dockerForTest(t *testing.T) *DockerClient {
+	t.Helper()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		t.Skipf("no Docker client: %v", err)
+	}
+	t.Cleanup(func() { _ = cli.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := cli.Ping(ctx); err != nil {
+		t.Skipf("no Docker daemon: %v", err)
+	}
+	return &DockerClient{Docker: cli, Context: context.Background()}
+}
+
+// buildPlainImage builds a one-file image without the cyanprint.dev label, as one built outside
+// cyanprint would be
+func buildPlainImage(t *testing.T, d *DockerClient, name string) {
+	t.Helper()
+	files := map[string]string{"Dockerfile": "FROM scratch\nCOPY hello /hello\n", "hello": "hello\n"}
+	var buildContext bytes.Buffer
+	tw := tar.NewWriter(&buildContext)
+	for _, file := range []string{"Dockerfile", "hello"} {
+		if err := tw.WriteHeader(&tar.Header{Name: file, Mode: 0o644, Size: int64(len(files[file]))}); err != nil {
+			t.Fatalf("failed to write build context: %v", err)
+		}
+		if _, err := tw.Write([]byte(files[file])); err != nil {
+			t.Fatalf("failed to write build context: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to write build context: %v", err)
+	}
+	res, err := d.Docker.ImageBuild(d.Context, &buildContext, build.ImageBuildOptions{Tags: []string{name}, Version: build.BuilderV1, Remove: true})
+	if err != nil {
+		t.Fatalf("ImageBuild() error = %v", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if _, err := readPullStream(res.Body, nil); err != nil {
+		t.Fatalf("ImageBuild() error = %v", err)
+	}
+}
+
+// TestDockerBundleRoundTrip tests that an image built locally without the cyanprint.dev label survives
+// export, removal and import under its tag, gaining the label
+func TestDockerBundleRoundTrip(t *testing.T) {
+	d := dockerForTest(t)
+	image := NewDockerImageReference("cyanprint.local/bundle-test", strconv.FormatInt(time.Now().UnixNano(), 10))
+	name := DockerImageToString(image)
+	remove := func() error {
+		_, err := d.Docker.ImageRemove(d.Context, name, imageTypes.RemoveOptions{Force: true, PruneChildren: true})
+		return err
+	}
+	buildPlainImage(t, d, name)
+	t.Cleanup(func() { _ = remove() })
+
+	template := TemplateVersionRes{Principal: TemplateVersionPrincipalRes{ID: "template-1", Properties: &PropertyRes{
+		TemplateDockerReference: image.Reference,
+		TemplateDockerTag:       image.Tag,
+		BlobDockerReference:     image.Reference,
+		BlobDockerTag:           image.Tag,
+	}}}
+	var bundle bytes.Buffer
+	if _, err := ExportBundle(d, template, &bundle); err != nil {
+		t.Fatalf("ExportBundle() error = %v", err)
+	}
+	if err := remove(); err != nil {
+		t.Fatalf("failed to remove %s: %v", name, err)
+	}
+
+	manifest, err := ImportBundle(d, &bundle)
+	if err != nil {
+		t.Fatalf("ImportBundle() error = %v", err)
+	}
+	if len(manifest.Images) != 1 || manifest.Images[0] != name {
+		t.Errorf("manifest images = %v, want [%s]", manifest.Images, name)
+	}
+	inspect, err := d.Docker.ImageInspect(d.Context, name)
+	if err != nil {
+		t.Fatalf("imported image %s not found: %v", name, err)
+	}
+	if inspect.Config == nil || inspect.Config.Labels[labelDev] != "true" {
+		t.Errorf("imported image is not labelled %s=true", labelDev)
+	}
+	local, err := d.ListImages()
+	if err != nil {
+		t.Fatalf("ListImages() error = %v", err)
+	}
+	if !image.foundIn(local) {
+		t.Errorf("imported image %s not listed among cyanprint images", name)
+	}
+}
@@ -0,0 +1,106 @@
+package docker_executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func bundleTemplate() TemplateVersionRes {
+	template := testTemplate()
+	template.Resolvers = []ResolverRes{{ID: "resolver-1", DockerReference: "registry.local/resolver", DockerTag: "1"}}
+	// a processor shared by two steps is bundled once
+	template.Processors = append(template.Processors, ProcessorRes{ID: "processor-3", DockerReference: "registry.local/processor-a", DockerTag: "1"})
+	return template
+}
+
+// TestBundleRoundTrip tests that a bundle exported from one host's images lets another host warm the
+// template without pulling anything
+func TestBundleRoundTrip(t *testing.T) {
+	template := bundleTemplate()
+	images, err := BundleImages(template)
+	if err != nil {
+		t.Fatalf("BundleImages() error = %v", err)
+	}
+	if len(images) != 6 {
+		t.Fatalf("BundleImages() = %v, want 6 images", images)
+	}
+	src := NewFakeRuntime()
+	src.AddImage(images...)
+
+	var bundle bytes.Buffer
+	exported, err := ExportBundle(src, template, &bundle)
+	if err != nil {
+		t.Fatalf("ExportBundle() error = %v", err)
+	}
+
+	dst := NewFakeRuntime()
+	imported, err := ImportBundle(dst, &bundle)
+	if err != nil {
+		t.Fatalf("ImportBundle() error = %v", err)
+	}
+	if !slices.Equal(imported.Images, exported.Images) || imported.Template.Principal.ID != "template-1" {
+		t.Errorf("imported manifest = %+v, want %+v", imported, exported)
+	}
+
+	local, _ := dst.ListImages()
+	de := TemplateExecutor{Docker: dst, Template: imported.Template.Principal, Resolvers: imported.Template.Resolvers}
+	if missing, image := de.missingTemplateImages(local); missing {
+		t.Errorf("template image %s is missing", DockerImageToString(image))
+	}
+	if missing, image := de.missingTemplateVolumeImage(local); missing {
+		t.Errorf("blob image %s is missing", DockerImageToString(image))
+	}
+	if missing, image := de.missingResolverImages(imported.Template.Resolvers[0], local); missing {
+		t.Errorf("resolver image %s is missing", DockerImageToString(image))
+	}
+	e := Executor{Docker: dst, Template: imported.Template, Sessions: NewSessionRegistry()}
+	if _, _, errs := e.Warm("s1"); len(errs) > 0 {
+		t.Fatalf("Warm() errors = %v", errs)
+	}
+	if pulls := dst.Pulls(); len(pulls) > 0 {
+		t.Errorf("pulls = %v, want none", pulls)
+	}
+}
+
+// TestBundleExportErrors tests that missing images, digest pins and templates without images fail export
+func TestBundleExportErrors(t *testing.T) {
+	missing := NewFakeRuntime()
+	if _, err := ExportBundle(missing, bundleTemplate(), &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "no such image") {
+		t.Errorf("ExportBundle() of missing images error = %v", err)
+	}
+
+	pinned := bundleTemplate()
+	pinned.Plugins[0].DockerTag += "@" + testDigest
+	if _, err := BundleImages(pinned); err == nil || !strings.Contains(err.Error(), "pinned by digest") {
+		t.Errorf("BundleImages() of a pinned image error = %v", err)
+	}
+
+	noProperties := bundleTemplate()
+	noProperties.Principal.Properties = nil
+	if _, err := BundleImages(noProperties); err == nil {
+		t.Error("BundleImages() of a template without properties succeeded")
+	}
+}
+
+// TestBundleImportInvalid tests that archives that aren't bundles of this format are refused
+func TestBundleImportInvalid(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	_ = writeBundleFile(tw, bundleImagesFile, 2, strings.NewReader("[]"))
+	_ = tw.Close()
+	if _, err := ImportBundle(NewFakeRuntime(), &archive); err == nil || !strings.Contains(err.Error(), "expected "+bundleManifestFile) {
+		t.Errorf("ImportBundle() without a manifest error = %v", err)
+	}
+
+	archive.Reset()
+	tw = tar.NewWriter(&archive)
+	manifest := `{"format": 99}`
+	_ = writeBundleFile(tw, bundleManifestFile, int64(len(manifest)), strings.NewReader(manifest))
+	_ = tw.Close()
+	if _, err := ImportBundle(NewFakeRuntime(), &archive); err == nil || !strings.Contains(err.Error(), "unsupported bundle format") {
+		t.Errorf("ImportBundle() of a newer format error = %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package docker_executor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CleanupTypes are the types cleanup can be limited to: the cyan types of containers, "volume" for
+// volumes along with the containers that unzip templates into them, and "image" for images
+var CleanupTypes = []string{"template", "processor", "plugin", "resolver", "merger", "volume", "image"}
+
+// CleanupFilter limits Cleanup to some resources. A resource is removed only if it meets every set
+// criterion, so criteria a resource can't meet exclude it: images belong to no template or session and
+// never stop, and volumes never stop. The zero filter removes everything.
+type CleanupFilter struct {
+	// Types, if set, are the CleanupTypes to remove
+	Types []string
+	// TemplateId keeps to the template's containers and volumes and those of its sessions. Containers
+	// are matched by their cyanprint.template label, or if they have none, by their session.
+	TemplateId string
+	SessionId  string
+	// OlderThan keeps to resources created at least this long ago. Images count from when they were
+	// built, as with docker image prune.
+	OlderThan time.Duration
+	// StoppedOnly keeps to stopped containers
+	StoppedOnly bool
+	// DryRun returns what would be removed without removing it
+	DryRun bool
+}
+
+func (f CleanupFilter) Validate() error {
+	for _, t := range f.Types {
+		if !slices.Contains(CleanupTypes, t) {
+			return fmt.Errorf("unknown cleanup type '%s', expected one of %s", t, strings.Join(CleanupTypes, ", "))
+		}
+	}
+	if f.OlderThan < 0 {
+		return fmt.Errorf("age must not be negative, got %s", f.OlderThan)
+	}
+	return nil
+}
+
+// selective is true when the filter leaves some resources out
+func (f CleanupFilter) selective() bool {
+	return len(f.Types) > 0 || f.TemplateId != "" || f.SessionId != "" || f.OlderThan > 0 || f.StoppedOnly
+}
+
+// cleanupMatcher decides which resources a CleanupFilter selects
+type cleanupMatcher struct {
+	d      ContainerRuntime
+	filter CleanupFilter
+	cutoff time.Time
+	// sessions are the sessions of filter.TemplateId, found by their session volumes
+	sessions map[string]bool
+	// created holds when each image and volume was created, by name, when filtering by age
+	created map[string]time.Time
+}
+
+func newCleanupMatcher(d ContainerRuntime, filter CleanupFilter) (cleanupMatcher, error) {
+	m := cleanupMatcher{d: d, filter: filter, sessions: make(map[string]bool), created: make(map[string]time.Time)}
+	if filter.TemplateId != "" {
+		volumes, err := d.ListVolumes()
+		if err != nil {
+			return m, fmt.Errorf("failed to list volumes: %w", err)
+		}
+		for _, v := range volumes {
+			if v.CyanId == filter.TemplateId && v.SessionId != "" {
+				m.sessions[v.SessionId] = true
+			}
+		}
+	}
+	if filter.OlderThan > 0 {
+		m.cutoff = time.Now().Add(-filter.OlderThan)
+		images, volumes, err := d.DiskUsage()
+		if err != nil {
+			return m, fmt.Errorf("failed to read resource ages: %w", err)
+		}
+		for _, img := range images {
+			for _, ref := range img.Refs {
+				m.created[DockerImageToString(ref)] = img.Created
+			}
+		}
+		for _, v := range volumes {
+			m.created[DockerVolumeToString(v.Volume)] = v.Created
+		}
+	}
+	return m, nil
+}
+
+func (m cleanupMatcher) hasType(t string) bool {
+	return len(m.filter.Types) == 0 || slices.Contains(m.filter.Types, t)
+}
+
+// old reports whether a resource of the given name was created before the cutoff
+func (m cleanupMatcher) old(name string) bool {
+	if m.filter.OlderThan <= 0 {
+		return true
+	}
+	created, ok := m.created[name]
+	return ok && created.Before(m.cutoff)
+}
+
+func (m cleanupMatcher) container(c DockerContainerReference, running bool) bool {
+	f := m.filter
+	if (f.StoppedOnly && running) || !m.hasType(c.CyanType) || (f.SessionId != "" && c.SessionId != f.SessionId) {
+		return false
+	}
+	if f.TemplateId == "" && f.OlderThan <= 0 {
+		return true
+	}
+	// a container that can't be inspected is gone already
+	state, err := m.d.InspectContainer(c)
+	if err != nil {
+		return false
+	}
+	if f.TemplateId != "" && state.Template != f.TemplateId &&
+		(state.Template != "" || (c.CyanId != f.TemplateId && !m.sessions[c.SessionId])) {
+		return false
+	}
+	return f.OlderThan <= 0 || state.Created.Before(m.cutoff)
+}
+
+func (m cleanupMatcher) volume(v DockerVolumeReference) bool {
+	f := m.filter
+	return !f.StoppedOnly && m.hasType("volume") &&
+		(f.TemplateId == "" || v.CyanId == f.TemplateId) &&
+		(f.SessionId == "" || v.SessionId == f.SessionId) &&
+		m.old(DockerVolumeToString(v))
+}
+
+func (m cleanupMatcher) image(i DockerImageReference) bool {
+	f := m.filter
+	return !f.StoppedOnly && m.hasType("image") && f.TemplateId == "" && f.SessionId == "" &&
+		m.old(DockerImageToString(i))
+}
+
+// Cleanup removes the cyanprint resources (containers, images, volumes) labeled cyanprint.dev=true that
+// filter selects from the runtime, or with filter.DryRun only lists them.
+// It returns lists of successfully removed resources, the sessions left without any containers or volumes,
+// whose state kept outside the runtime can be released, and the first error encountered (if any)
+func Cleanup(d ContainerRuntime, filter CleanupFilter) (containersRemoved []string, imagesRemoved []string, volumesRemoved []string, sessionsCleaned []string, err error) {
+	var firstError error
+	m, err := newCleanupMatcher(d, filter)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	touched := make(map[string]bool)
+
+	// 1. Remove containers
+	runningContainers, stoppedContainers, listErr := d.ListContainer()
+	if listErr != nil {
+		return nil, nil, nil, nil, fmt.Errorf("failed to list containers: %w", listErr)
+	}
+	var allContainers []DockerContainerReference
+	for _, c := range runningContainers {
+		if m.container(c, true) {
+			allContainers = append(allContainers, c)
+		}
+	}
+	for _, c := range stoppedContainers {
+		if m.container(c, false) {
+			allContainers = append(allContainers, c)
+		}
+	}
+	if filter.DryRun {
+		for _, c := range allContainers {
+			containersRemoved = append(containersRemoved, DockerContainerToString(c))
+		}
+	} else if len(allContainers) > 0 {
+		containerErrors := d.RemoveAllContainers(allContainers)
+		for i, c := range allContainers {
+			if containerErrors[i] != nil {
+				if firstError == nil {
+					firstError = fmt.Errorf("failed to remove container %s: %w", DockerContainerToString(c), containerErrors[i])
+				}
+			} else {
+				containersRemoved = append(containersRemoved, DockerContainerToString(c))
+				touched[c.SessionId] = true
+			}
+		}
+	}
+
+	// session networks go once their containers are gone
+	if d.Settings().Isolation.SessionNetworks && !filter.DryRun {
+		sessions, listErr := cleanedSessions(d, filter, allContainers)
+		if listErr != nil {
+			return containersRemoved, nil, nil, nil, fmt.Errorf("failed to list session networks: %w", listErr)
+		}
+		for _, session := range sessions {
+			if err := d.RemoveSessionNetwork(session); err != nil && firstError == nil {
+				firstError = fmt.Errorf("failed to remove network of session %s: %w", session, err)
+			}
+		}
+	}
+
+	// 2. Remove images
+	allImages, listErr := d.ListImages()
+	if listErr != nil {
+		return containersRemoved, nil, nil, nil, fmt.Errorf("failed to list images: %w", listErr)
+	}
+	var images []DockerImageReference
+	for _, img := range allImages {
+		if m.image(img) {
+			images = append(images, img)
+		}
+	}
+	if filter.DryRun {
+		for _, img := range images {
+			imagesRemoved = append(imagesRemoved, DockerImageToString(img))
+		}
+	} else if len(images) > 0 {
+		imageErrors := d.RemoveAllImages(images)
+		for i, img := range images {
+			if imageErrors[i] != nil {
+				if firstError == nil {
+					firstError = fmt.Errorf("failed to remove image %s: %w", DockerImageToString(img), imageErrors[i])
+				}
+			} else {
+				imagesRemoved = append(imagesRemoved, DockerImageToString(img))
+			}
+		}
+	}
+
+	// 3. Remove volumes
+	allVolumes, listErr := d.ListVolumes()
+	if listErr != nil {
+		return containersRemoved, imagesRemoved, nil, nil, fmt.Errorf("failed to list volumes: %w", listErr)
+	}
+	var volumes []DockerVolumeReference
+	for _, v := range allVolumes {
+		if m.volume(v) {
+			volumes = append(volumes, v)
+		}
+	}
+	if filter.DryRun {
+		for _, v := range volumes {
+			volumesRemoved = append(volumesRemoved, DockerVolumeToString(v))
+		}
+	} else if len(volumes) > 0 {
+		volumeErrors := d.RemoveAllVolumes(volumes)
+		for i, v := range volumes {
+			if volumeErrors[i] != nil {
+				if firstError == nil {
+					firstError = fmt.Errorf("failed to remove volume %s: %w", DockerVolumeToString(v), volumeErrors[i])
+				}
+			} else {
+				volumesRemoved = append(volumesRemoved, DockerVolumeToString(v))
+				touched[v.SessionId] = true
+			}
+		}
+	}
+
+	// 4. Report the sessions that are gone
+	delete(touched, "")
+	if len(touched) > 0 {
+		sessionsCleaned, listErr = emptiedSessions(d, touched)
+		if listErr != nil {
+			return containersRemoved, imagesRemoved, volumesRemoved, nil, fmt.Errorf("failed to list what is left of cleaned sessions: %w", listErr)
+		}
+	}
+
+	return containersRemoved, imagesRemoved, volumesRemoved, sessionsCleaned, firstError
+}
+
+// emptiedSessions returns those of the sessions cleanup removed resources of that have no containers or
+// volumes left
+func emptiedSessions(d ContainerRuntime, touched map[string]bool) ([]string, error) {
+	running, stopped, err := d.ListContainer()
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := d.ListVolumes()
+	if err != nil {
+		return nil, err
+	}
+	left := make(map[string]bool)
+	for _, c := range append(running, stopped...) {
+		left[c.SessionId] = true
+	}
+	for _, v := range volumes {
+		left[v.SessionId] = true
+	}
+	var out []string
+	for session := range touched {
+		if !left[session] {
+			out = append(out, session)
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// cleanedSessions returns the sessions whose networks should go. A full cleanup removes every session
+// network; a filtered one only those of sessions it removed containers from and that have none left.
+func cleanedSessions(d ContainerRuntime, filter CleanupFilter, removed []DockerContainerReference) ([]string, error) {
+	sessions, err := d.ListSessionNetworks()
+	if err != nil || !filter.selective() {
+		return sessions, err
+	}
+	running, stopped, err := d.ListContainer()
+	if err != nil {
+		return nil, err
+	}
+	left := make(map[string]bool)
+	for _, c := range append(running, stopped...) {
+		left[c.SessionId] = true
+	}
+	touched := make(map[string]bool)
+	for _, c := range removed {
+		touched[c.SessionId] = true
+	}
+	var out []string
+	for _, session := range sessions {
+		if touched[session] && !left[session] {
+			out = append(out, session)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,197 @@
+package docker_executor
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// cleanupFixture holds two sessions: s1 of template t1, whose template container and volume were
+// created two hours ago, and s2 of template t2
+type cleanupFixture struct {
+	rt                                     *FakeRuntime
+	template, helper, p1, m1, p2           string
+	templateVol, sessionVol, otherVol, img string
+}
+
+func newCleanupFixture() cleanupFixture {
+	rt := NewFakeRuntime()
+	rt.Config.Isolation.SessionNetworks = true
+	created := time.Now().Add(-2 * time.Hour)
+	rt.Now = func() time.Time { return created }
+
+	template := DockerContainerReference{CyanId: "t1", CyanType: "template"}
+	helper := DockerContainerReference{CyanId: "t1", CyanType: "volume"}
+	templateVol := DockerVolumeReference{CyanId: "t1"}
+	rt.AddContainer(template, true)
+	rt.AddContainer(helper, false)
+	rt.AddVolume(templateVol)
+
+	rt.Now = time.Now
+	p1 := DockerContainerReference{CyanId: "p1", CyanType: "processor", SessionId: "s1"}
+	m1 := DockerContainerReference{CyanId: "m1", CyanType: "merger", SessionId: "s1"}
+	p2 := DockerContainerReference{CyanId: "p2", CyanType: "processor", SessionId: "s2"}
+	sessionVol := DockerVolumeReference{CyanId: "t1", SessionId: "s1"}
+	otherVol := DockerVolumeReference{CyanId: "t2", SessionId: "s2"}
+	rt.AddContainer(p1, true)
+	rt.AddContainer(m1, false)
+	rt.AddContainer(p2, true)
+	rt.AddVolume(sessionVol)
+	rt.AddVolume(otherVol)
+	img := DockerImageReference{Reference: "registry.local/processor", Tag: "1"}
+	rt.AddImage(img)
+	_ = rt.CreateSessionNetwork("s1", nil)
+	_ = rt.CreateSessionNetwork("s2", nil)
+
+	return cleanupFixture{
+		rt:          rt,
+		template:    DockerContainerToString(template),
+		helper:      DockerContainerToString(helper),
+		p1:          DockerContainerToString(p1),
+		m1:          DockerContainerToString(m1),
+		p2:          DockerContainerToString(p2),
+		templateVol: DockerVolumeToString(templateVol),
+		sessionVol:  DockerVolumeToString(sessionVol),
+		otherVol:    DockerVolumeToString(otherVol),
+		img:         DockerImageToString(img),
+	}
+}
+
+func sameItems(got, want []string) bool {
+	got, want = slices.Clone(got), slices.Clone(want)
+	slices.Sort(got)
+	slices.Sort(want)
+	return slices.Equal(got, want)
+}
+
+func TestCleanupFilter(t *testing.T) {
+	f := newCleanupFixture()
+	tests := []struct {
+		name                        string
+		filter                      CleanupFilter
+		containers, images, volumes []string
+	}{
+		{"everything", CleanupFilter{},
+			[]string{f.template, f.helper, f.p1, f.m1, f.p2}, []string{f.img}, []string{f.templateVol, f.sessionVol, f.otherVol}},
+		{"type", CleanupFilter{Types: []string{"processor"}},
+			[]string{f.p1, f.p2}, nil, nil},
+		{"volume type", CleanupFilter{Types: []string{"volume"}},
+			[]string{f.helper}, nil, []string{f.templateVol, f.sessionVol, f.otherVol}},
+		{"image type", CleanupFilter{Types: []string{"image"}},
+			nil, []string{f.img}, nil},
+		{"session", CleanupFilter{SessionId: "s1"},
+			[]string{f.p1, f.m1}, nil, []string{f.sessionVol}},
+		{"template", CleanupFilter{TemplateId: "t1"},
+			[]string{f.template, f.helper, f.p1, f.m1}, nil, []string{f.templateVol, f.sessionVol}},
+		{"stopped", CleanupFilter{StoppedOnly: true},
+			[]string{f.helper, f.m1}, nil, nil},
+		// the fake's images have no build time, so count as old
+		{"age", CleanupFilter{OlderThan: time.Hour},
+			[]string{f.template, f.helper}, []string{f.img}, []string{f.templateVol}},
+		{"combined", CleanupFilter{Types: []string{"processor", "merger"}, SessionId: "s1", StoppedOnly: true},
+			[]string{f.m1}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dry := tt.filter
+			dry.DryRun = true
+			containers, images, volumes, _, err := Cleanup(f.rt, dry)
+			if err != nil {
+				t.Fatalf("Cleanup() error = %v", err)
+			}
+			if !sameItems(containers, tt.containers) || !sameItems(images, tt.images) || !sameItems(volumes, tt.volumes) {
+				t.Errorf("Cleanup() = %v, %v, %v, want %v, %v, %v", containers, images, volumes, tt.containers, tt.images, tt.volumes)
+			}
+		})
+	}
+	if len(f.rt.Containers()) != 5 || len(f.rt.Images()) != 1 || len(f.rt.Volumes()) != 3 {
+		t.Errorf("dry runs removed resources: %v, %v, %v", f.rt.Containers(), f.rt.Images(), f.rt.Volumes())
+	}
+}
+
+// TestCleanupSession tests that a filtered cleanup removes only what it selects, and the networks of
+// sessions left without containers
+func TestCleanupSession(t *testing.T) {
+	f := newCleanupFixture()
+	containers, images, volumes, sessions, err := Cleanup(f.rt, CleanupFilter{SessionId: "s1"})
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if !sameItems(containers, []string{f.p1, f.m1}) || len(images) > 0 || !sameItems(volumes, []string{f.sessionVol}) {
+		t.Errorf("Cleanup() = %v, %v, %v", containers, images, volumes)
+	}
+	if !slices.Equal(sessions, []string{"s1"}) {
+		t.Errorf("sessions cleaned = %v, want [s1]", sessions)
+	}
+	if !sameItems(f.rt.Containers(), []string{f.template, f.helper, f.p2}) {
+		t.Errorf("containers left = %v", f.rt.Containers())
+	}
+	if !sameItems(f.rt.Volumes(), []string{f.templateVol, f.otherVol}) {
+		t.Errorf("volumes left = %v", f.rt.Volumes())
+	}
+	if _, ok := f.rt.SessionNetwork("s1"); ok {
+		t.Error("network of the cleaned session was kept")
+	}
+	if _, ok := f.rt.SessionNetwork("s2"); !ok {
+		t.Error("network of another session was removed")
+	}
+}
+
+// TestCleanupSessionsCleaned tests that only sessions left without containers or volumes are reported
+// cleaned, and none on a dry run
+func TestCleanupSessionsCleaned(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter CleanupFilter
+		want   []string
+	}{
+		{name: "everything", want: []string{"s1", "s2"}},
+		{name: "volume left", filter: CleanupFilter{Types: []string{"processor"}}},
+		{name: "template", filter: CleanupFilter{TemplateId: "t1"}, want: []string{"s1"}},
+		{name: "dry run", filter: CleanupFilter{SessionId: "s1", DryRun: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newCleanupFixture()
+			_, _, _, sessions, err := Cleanup(f.rt, tt.filter)
+			if err != nil {
+				t.Fatalf("Cleanup() error = %v", err)
+			}
+			if !slices.Equal(sessions, tt.want) {
+				t.Errorf("sessions cleaned = %v, want %v", sessions, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanupFilterValidate(t *testing.T) {
+	if err := (CleanupFilter{Types: []string{"template", "image"}, OlderThan: time.Hour}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := (CleanupFilter{Types: []string{"network"}}).Validate(); err == nil {
+		t.Error("Validate() accepted an unknown type")
+	}
+	if err := (CleanupFilter{OlderThan: -time.Hour}).Validate(); err == nil {
+		t.Error("Validate() accepted a negative age")
+	}
+}
+
+// TestCleanupTemplateLabel tests that the template filter finds containers by their template label,
+// even when their session's volume is gone
+func TestCleanupTemplateLabel(t *testing.T) {
+	f := newCleanupFixture()
+	p3 := DockerContainerReference{CyanId: "p3", CyanType: "processor", SessionId: "s3"}
+	image := DockerImageReference{Reference: "registry.local/processor", Tag: "1"}
+	_ = f.rt.CreateSessionNetwork("s3", nil)
+	if err := f.rt.CreateContainerWithReadWriteVolume(p3, DockerVolumeReference{CyanId: "t1"}, DockerVolumeReference{CyanId: "t2", SessionId: "s2"}, image, ResourceLimits{}); err != nil {
+		t.Fatalf("CreateContainerWithReadWriteVolume() error = %v", err)
+	}
+	containers, _, _, _, err := Cleanup(f.rt, CleanupFilter{TemplateId: "t1", DryRun: true})
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	want := []string{f.template, f.helper, f.p1, f.m1, DockerContainerToString(p3)}
+	if !sameItems(containers, want) {
+		t.Errorf("containers = %v, want %v", containers, want)
+	}
+}
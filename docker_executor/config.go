@@ -0,0 +1,254 @@
+package docker_executor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Environment variables a merger container reads its workspace layout from.
+// The coordinator sets them when starting mergers, so both sides agree on where volumes are mounted.
+const (
+	EnvWorkspaceTemplateDir = "BORON_WORKSPACE_TEMPLATE_DIR"
+	EnvWorkspaceAreaDir     = "BORON_WORKSPACE_AREA_DIR"
+	// EnvInternalNetworks is the comma-separated CIDRs allowed to call a merger's /merge and /zip
+	EnvInternalNetworks = "BORON_INTERNAL_NETWORKS"
+	// EnvSessionTTL and EnvGCBudget are set to 0 for mergers, which have no runtime to reap sessions or
+	// collect garbage in
+	EnvSessionTTL = "BORON_SESSION_TTL"
+	EnvGCBudget   = "BORON_GC_BUDGET"
+)
+
+// Config holds the settings shared by the Docker client, executors and merger
+type Config struct {
+	// Network is the Docker bridge network every cyanprint container joins
+	Network     string            `yaml:"network"`
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+	Workspace   WorkspaceConfig   `yaml:"workspace"`
+	// Resources limits containers by cyan type: template, processor, plugin, resolver and merger
+	Resources map[string]ResourcePolicy `yaml:"resources"`
+	Security  SecurityConfig            `yaml:"security"`
+	Isolation IsolationConfig           `yaml:"isolation"`
+	// Registries holds pull credentials by registry host, e.g. ghcr.io
+	Registries RegistryCredentials `yaml:"registries"`
+	// DockerConfig is the Docker config.json consulted for registries without configured credentials.
+	// Empty uses $DOCKER_CONFIG/config.json or ~/.docker/config.json.
+	DockerConfig string `yaml:"docker_config"`
+	// InternalNetworks are passed on to mergers, which restrict their /merge and /zip to them. The server
+	// sets them from auth.internal_networks; a merger given none falls back to its own interfaces' networks,
+	// which on Kubernetes would shut out a coordinator on another node.
+	InternalNetworks []string `yaml:"-"`
+}
+
+// HealthCheckConfig controls how long containers are given to become ready after starting. Probes back
+// off exponentially from Interval to MaxInterval and give up after Attempts probes or the type's
+// timeout, whichever comes first.
+type HealthCheckConfig struct {
+	Attempts int           `yaml:"attempts"`
+	Interval time.Duration `yaml:"interval"`
+	// MaxInterval caps the backoff; one below Interval keeps probes at Interval
+	MaxInterval time.Duration `yaml:"max_interval"`
+	// Timeout is how long a container has to become ready unless its type's probe sets one
+	Timeout time.Duration `yaml:"timeout"`
+	// LogLines is how many of its last log lines are reported for a container that exits before it is ready,
+	// or that a failed warm, start or build blames
+	LogLines int `yaml:"log_lines"`
+	// Probes overrides the health path and timeout by cyan type
+	Probes map[string]ProbeConfig `yaml:"probes"`
+}
+
+// ProbeConfig is how readiness is checked for one cyan type
+type ProbeConfig struct {
+	// Path is requested on the type's port; it must answer 200 once the container is ready
+	Path    string        `yaml:"path"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Probe returns the probe of cyanType, with the defaults for unset fields
+func (c HealthCheckConfig) Probe(cyanType string) ProbeConfig {
+	p := c.Probes[cyanType]
+	if p.Path == "" {
+		p.Path = "/"
+	}
+	if p.Timeout == 0 {
+		p.Timeout = c.Timeout
+	}
+	return p
+}
+
+func (c HealthCheckConfig) validate() error {
+	if c.Attempts < 1 {
+		return fmt.Errorf("health check attempts must be at least 1, got %d", c.Attempts)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("health check interval must be positive, got %s", c.Interval)
+	}
+	if c.MaxInterval < 0 {
+		return fmt.Errorf("health check max interval must not be negative, got %s", c.MaxInterval)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("health check timeout must be positive, got %s", c.Timeout)
+	}
+	if c.LogLines < 0 {
+		return fmt.Errorf("health check log lines must not be negative, got %d", c.LogLines)
+	}
+	for cyanType, p := range c.Probes {
+		if p.Path != "" && !strings.HasPrefix(p.Path, "/") {
+			return fmt.Errorf("health check path of %s must start with /, got '%s'", cyanType, p.Path)
+		}
+		if p.Timeout < 0 {
+			return fmt.Errorf("health check timeout of %s must not be negative, got %s", cyanType, p.Timeout)
+		}
+	}
+	return nil
+}
+
+// IsolationConfig controls how sessions are kept apart on the network
+type IsolationConfig struct {
+	// SessionNetworks gives each session its own network holding its processors, plugins and merger,
+	// the coordinator, and the shared template and resolver containers it uses. It is off by default, as
+	// the coordinator must run in a container to join those networks.
+	SessionNetworks bool `yaml:"session_networks"`
+	// DenyEgress cuts processors and plugins off from everything outside their session network
+	DenyEgress bool `yaml:"deny_egress"`
+	// Coordinator is the container (or pod) the coordinator runs in, attached to every session network.
+	// Empty uses the hostname, which Docker and Kubernetes set to the container ID or pod name.
+	Coordinator string `yaml:"coordinator"`
+}
+
+// WorkspaceConfig is where the template and working volumes are mounted in mergers and processors
+type WorkspaceConfig struct {
+	TemplateDir string `yaml:"template_dir"`
+	AreaDir     string `yaml:"area_dir"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Network: "cyanprint",
+		HealthCheck: HealthCheckConfig{
+			Attempts:    60,
+			Interval:    250 * time.Millisecond,
+			MaxInterval: 2 * time.Second,
+			Timeout:     time.Minute,
+			LogLines:    20,
+		},
+		Workspace: WorkspaceConfig{
+			TemplateDir: "/workspace/cyanprint",
+			AreaDir:     "/workspace/area",
+		},
+		Resources: defaultResources(),
+		Security:  defaultSecurity(),
+	}
+}
+
+// Validate reports settings that would leave the coordinator unable to run containers
+func (c Config) Validate() error {
+	if c.Network == "" {
+		return fmt.Errorf("docker network must not be empty")
+	}
+	if err := c.HealthCheck.validate(); err != nil {
+		return err
+	}
+	if c.Workspace.TemplateDir == "" || c.Workspace.AreaDir == "" {
+		return fmt.Errorf("workspace directories must not be empty")
+	}
+	for cyanType, p := range c.Resources {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("invalid %s resources: %w", cyanType, err)
+		}
+	}
+	if c.Isolation.DenyEgress && !c.Isolation.SessionNetworks {
+		return fmt.Errorf("denying egress requires session networks")
+	}
+	for host, cred := range c.Registries {
+		if err := cred.validate(); err != nil {
+			return fmt.Errorf("invalid credentials for registry %s: %w", host, err)
+		}
+	}
+	if err := c.Security.validate(); err != nil {
+		return fmt.Errorf("invalid security settings: %w", err)
+	}
+	return nil
+}
+
+// withDefaults fills unset fields from DefaultConfig, so clients built without a config
+// (e.g. by the setup and cleanup commands) behave as they always have
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.Network == "" {
+		c.Network = d.Network
+	}
+	if c.HealthCheck.Attempts == 0 {
+		c.HealthCheck.Attempts = d.HealthCheck.Attempts
+	}
+	if c.HealthCheck.Interval == 0 {
+		c.HealthCheck.Interval = d.HealthCheck.Interval
+	}
+	if c.HealthCheck.MaxInterval == 0 {
+		c.HealthCheck.MaxInterval = d.HealthCheck.MaxInterval
+	}
+	if c.HealthCheck.Timeout == 0 {
+		c.HealthCheck.Timeout = d.HealthCheck.Timeout
+	}
+	if c.HealthCheck.LogLines == 0 {
+		c.HealthCheck.LogLines = d.HealthCheck.LogLines
+	}
+	if c.Workspace.TemplateDir == "" {
+		c.Workspace.TemplateDir = d.Workspace.TemplateDir
+	}
+	if c.Workspace.AreaDir == "" {
+		c.Workspace.AreaDir = d.Workspace.AreaDir
+	}
+	if c.Resources == nil {
+		c.Resources = d.Resources
+	}
+	if c.Security.Profiles == nil {
+		c.Security.Profiles = d.Security.Profiles
+	}
+	if c.Security.User == "" {
+		c.Security.User = d.Security.User
+	}
+	return c
+}
+
+// sessionNetwork is the name of the session's own network
+func (c Config) sessionNetwork(session string) string {
+	return c.Network + "-" + session
+}
+
+// sessionNetworked reports whether cc joins its session's network instead of the shared one
+func (c Config) sessionNetworked(cc DockerContainerReference) bool {
+	if !c.Isolation.SessionNetworks || cc.SessionId == "" {
+		return false
+	}
+	switch cc.CyanType {
+	case "processor", "plugin", "merger":
+		return true
+	}
+	return false
+}
+
+// coordinatorName is the container or pod the coordinator runs in
+func (c Config) coordinatorName() (string, error) {
+	if c.Isolation.Coordinator != "" {
+		return c.Isolation.Coordinator, nil
+	}
+	return os.Hostname()
+}
+
+// mergerEnv passes the workspace layout, sandbox user and internal networks on to a merger container,
+// and turns off its session reaper and garbage collector
+func (c Config) mergerEnv() []string {
+	env := []string{
+		EnvWorkspaceTemplateDir + "=" + c.Workspace.TemplateDir,
+		EnvWorkspaceAreaDir + "=" + c.Workspace.AreaDir,
+		EnvSandboxUser + "=" + c.Security.User,
+		EnvSessionTTL + "=0",
+		EnvGCBudget + "=0",
+	}
+	if len(c.InternalNetworks) > 0 {
+		env = append(env, EnvInternalNetworks+"="+strings.Join(c.InternalNetworks, ","))
+	}
+	return env
+}
@@ -1,27 +1,46 @@
 package docker_executor
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"fmt"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
 	container "github.com/docker/docker/api/types/container"
+	dockerEvents "github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	imageTypes "github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
 	networkTypes "github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
-	"os"
+	"log/slog"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type DockerClient struct {
 	Docker           *client.Client
 	Context          context.Context
 	ParallelismLimit int
+	Events           Emitter
+	Logger           *slog.Logger
+	Config           Config
 }
 
-const networkName = "cyanprint"
+func (d *DockerClient) log() *slog.Logger {
+	return loggerOrDefault(d.Logger)
+}
+
+func (d *DockerClient) config() Config {
+	return d.Config.withDefaults()
+}
 
 func (d *DockerClient) WaitContainer(ref DockerContainerReference) (int, error) {
 
@@ -41,6 +60,132 @@ func (d *DockerClient) WaitContainer(ref DockerContainerReference) (int, error)
 
 }
 
+func (d *DockerClient) InspectContainer(ref DockerContainerReference) (ContainerState, error) {
+	c, err := d.Docker.ContainerInspect(d.Context, DockerContainerToString(ref))
+	if err != nil {
+		return ContainerState{}, err
+	}
+	state := ContainerState{}
+	if c.Config != nil {
+		state.Created, _ = time.Parse(time.RFC3339, c.Config.Labels[labelCreatedAt])
+		state.Image, _ = DockerImageToStruct(c.Config.Image)
+		state.Template = c.Config.Labels[labelTemplate]
+	}
+	if state.Created.IsZero() {
+		state.Created, _ = time.Parse(time.RFC3339Nano, c.Created)
+	}
+	if c.State != nil {
+		state.Running = c.State.Running || c.State.Restarting
+		state.ExitCode = c.State.ExitCode
+		state.OOMKilled = c.State.OOMKilled
+	}
+	if c.HostConfig != nil {
+		r := c.HostConfig.Resources
+		state.Limits = ResourceLimits{CPUs: float64(r.NanoCPUs) / 1e9, Memory: ByteSize(r.Memory)}
+		if r.PidsLimit != nil {
+			state.Limits.Pids = *r.PidsLimit
+		}
+	}
+	return state, nil
+}
+
+// WatchContainer follows Docker's events for the container's die event
+func (d *DockerClient) WatchContainer(ref DockerContainerReference) (<-chan struct{}, func()) {
+	ctx, cancel := context.WithCancel(d.OperationContext())
+	f := filters.NewArgs(
+		filters.Arg("type", string(dockerEvents.ContainerEventType)),
+		filters.Arg("container", DockerContainerToString(ref)),
+		filters.Arg("event", string(dockerEvents.ActionDie)),
+	)
+	messages, errs := d.Docker.Events(ctx, dockerEvents.ListOptions{Filters: f})
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-messages:
+			close(stopped)
+		case err := <-errs:
+			// readiness still inspects the container after failed probes, so a lost stream only slows it down
+			if ctx.Err() == nil {
+				d.log().Debug("Stopped watching container events", LogKeyContainer, DockerContainerToString(ref), LogKeyError, err)
+			}
+		case <-ctx.Done():
+		}
+	}()
+	return stopped, cancel
+}
+
+func (d *DockerClient) ContainerLogs(ref DockerContainerReference, lines int) ([]string, error) {
+	reader, err := d.Docker.ContainerLogs(d.OperationContext(), DockerContainerToString(ref), container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       strconv.Itoa(lines),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	// containers run without a TTY, so stdout and stderr arrive multiplexed
+	var out bytes.Buffer
+	if _, err := stdcopy.StdCopy(&out, &out, reader); err != nil {
+		return nil, err
+	}
+	return splitLogLines(out.String()), nil
+}
+
+// demuxedLogs is a container's log stream with stdout and stderr written into one pipe
+type demuxedLogs struct {
+	*io.PipeReader
+	raw io.Closer
+}
+
+// Close closes the raw stream too, since a followed stream may be waiting on it for output
+func (l demuxedLogs) Close() error {
+	_ = l.raw.Close()
+	return l.PipeReader.Close()
+}
+
+func (d *DockerClient) StreamLogs(ref DockerContainerReference, opts LogOptions) (io.ReadCloser, error) {
+	tail := "all"
+	if opts.Tail > 0 {
+		tail = strconv.Itoa(opts.Tail)
+	}
+	reader, err := d.Docker.ContainerLogs(d.OperationContext(), DockerContainerToString(ref), container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Tail:       tail,
+	})
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, reader)
+		_ = reader.Close()
+		_ = pw.CloseWithError(err)
+	}()
+	return demuxedLogs{PipeReader: pr, raw: reader}, nil
+}
+
+// dockerResources converts limits to their HostConfig form. Swap is capped at the memory limit,
+// so a container over its limit is killed rather than left swapping.
+func dockerResources(limits ResourceLimits) container.Resources {
+	r := container.Resources{
+		NanoCPUs:   int64(limits.CPUs * 1e9),
+		Memory:     int64(limits.Memory),
+		MemorySwap: int64(limits.Memory),
+	}
+	if limits.Pids > 0 {
+		r.PidsLimit = &limits.Pids
+	}
+	for name, v := range limits.Ulimits {
+		r.Ulimits = append(r.Ulimits, &container.Ulimit{Name: name, Soft: v, Hard: v})
+	}
+	return r
+}
+
 func (d *DockerClient) ListImages() ([]DockerImageReference, error) {
 
 	f := filters.NewArgs()
@@ -55,33 +200,91 @@ func (d *DockerClient) ListImages() ([]DockerImageReference, error) {
 	var imageNames []DockerImageReference
 
 	for _, image := range images {
-		for _, tags := range image.RepoTags {
-			s, err := DockerImageToStruct(tags)
-			if err != nil {
-				return nil, err
-			}
-			imageNames = append(imageNames, s)
+		refs, err := localImageReferences(image)
+		if err != nil {
+			return nil, err
 		}
+		imageNames = append(imageNames, refs...)
 	}
 
 	return imageNames, nil
 }
 
+// localImageReferences lists an image under each of its tags, carrying the digest it was pulled with from
+// that repository, and under digests of repositories it has no tag in (images pulled by digest only)
+func localImageReferences(image imageTypes.Summary) ([]DockerImageReference, error) {
+	digests := make(map[string]DockerImageReference)
+	var order []string
+	for _, repoDigest := range image.RepoDigests {
+		if strings.HasPrefix(repoDigest, "<none>") {
+			continue
+		}
+		ref, err := DockerImageToStruct(repoDigest)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := digests[ref.name()]; !ok {
+			order = append(order, ref.name())
+		}
+		digests[ref.name()] = ref
+	}
+
+	var refs []DockerImageReference
+	tagged := make(map[string]bool)
+	for _, tag := range image.RepoTags {
+		if strings.HasPrefix(tag, "<none>") {
+			continue
+		}
+		ref, err := DockerImageToStruct(tag)
+		if err != nil {
+			return nil, err
+		}
+		ref.Digest = digests[ref.name()].Digest
+		tagged[ref.name()] = true
+		refs = append(refs, ref)
+	}
+	for _, name := range order {
+		if !tagged[name] {
+			refs = append(refs, digests[name])
+		}
+	}
+	return refs, nil
+}
+
 func (d *DockerClient) PullImages(images []DockerImageReference) []error {
 
 	errChan := make(chan error, len(images))
 	semaphore := make(chan int, d.ParallelismLimit)
 
+	done := contextOrBackground(d.Context).Done()
 	for _, image := range images {
-		semaphore <- 0
+		select {
+		case semaphore <- 0:
+		case <-done:
+			// cancelled while waiting for a free slot: don't start pulls that can only fail
+			errChan <- fmt.Errorf("pull of %s cancelled: %w", DockerImageToString(image), d.Context.Err())
+			continue
+		}
 		go func(image DockerImageReference) {
 			ref := DockerImageToString(image)
-			fmt.Println("📥 Pulling image:", ref)
-			reader, err := d.Docker.ImagePull(d.Context, ref, imageTypes.PullOptions{
-				All: true,
-			})
+			start := time.Now()
+			ctx, span := startSpan(d.Context, "docker.image.pull", attribute.String(LogKeyImage, ref))
+			d.log().Info("Pulling image", LogKeyImage, ref)
+			d.Events.Emit(Event{Type: EventImagePullStarted, Image: ref})
+			auth, err := d.registryAuth(ref)
+			var reader io.ReadCloser
+			if err == nil {
+				reader, err = d.Docker.ImagePull(ctx, ref, imageTypes.PullOptions{
+					All:          true,
+					RegistryAuth: auth,
+				})
+			}
 			if err != nil {
-				fmt.Println("🚨Failed to pull image [Docker.ImagePull]:", ref)
+				d.log().Error("Failed to pull image", LogKeyImage, ref, LogKeyError, err)
+				d.Events.Emit(Event{Type: EventImagePullFinished, Image: ref, Error: err.Error()})
+				imagePulls.WithLabelValues(ref, resultLabel(err)).Inc()
+				observeSince(imagePullDuration, start, ref, resultLabel(err))
+				endSpan(span, err)
 				errChan <- err
 				<-semaphore
 				return
@@ -89,13 +292,21 @@ func (d *DockerClient) PullImages(images []DockerImageReference) []error {
 			defer func(reader io.ReadCloser) {
 				_ = reader.Close()
 			}(reader)
-			_, err = io.Copy(os.Stdout, reader)
+			// the pull only completes once its progress stream has been drained, and errors such as an
+			// unknown manifest are only reported within it
+			progress, err := readPullStream(reader, func(p PullProgress) {
+				d.Events.Emit(Event{Type: EventImagePullProgress, Image: ref, Progress: &p})
+			})
 			if err != nil {
-				fmt.Println("🚨Failed to pull image [io.Copy]:", ref)
+				err = fmt.Errorf("failed to pull %s: %w", ref, err)
+				d.log().Error("Failed to pull image", LogKeyImage, ref, LogKeyError, err)
 			} else {
-				fmt.Println("✅ Image pulled:", ref)
-
+				d.log().Info("Image pulled", LogKeyImage, ref, "layers", progress.LayersTotal, "bytes", progress.BytesTotal)
 			}
+			d.Events.Emit(Event{Type: EventImagePullFinished, Image: ref, Progress: &progress, Error: errorString(err)})
+			imagePulls.WithLabelValues(ref, resultLabel(err)).Inc()
+			observeSince(imagePullDuration, start, ref, resultLabel(err))
+			endSpan(span, err)
 			errChan <- err
 			<-semaphore
 		}(image)
@@ -165,73 +376,156 @@ func (d *DockerClient) ListContainer() ([]DockerContainerReference, []DockerCont
 	var cyanRunning []DockerContainerReference
 	var cyanStopped []DockerContainerReference
 	for _, con := range containers {
-		for _, name := range con.Names {
-			n := strings.TrimPrefix(name, "/")
-			containerRef, err := DockerContainerNameToStruct(n)
-			if err != nil {
-				return nil, nil, err
+		n := containerName(con.Names)
+		containerRef, err := containerIdentity(con.Labels, n)
+		if err != nil {
+			d.log().Warn("Skipping container with a malformed name, run boron doctor to remove it", LogKeyContainer, n, LogKeyError, err)
+			continue
+		}
+		if con.State == "running" {
+			cyanRunning = append(cyanRunning, containerRef)
+		} else {
+			cyanStopped = append(cyanStopped, containerRef)
+		}
+	}
+	return cyanRunning, cyanStopped, nil
+}
+
+// containerName returns a container's name without Docker's leading slash
+func containerName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(names[0], "/")
+}
+
+// ListSessionActivity returns, for every session that still owns containers or volumes,
+// the creation time of its most recently created resource.
+// Resources created before session labels existed fall back to name parsing and Docker's own creation time.
+func (d *DockerClient) ListSessionActivity() (map[string]time.Time, error) {
+	f := filters.NewArgs()
+	f.Add("label", "cyanprint.dev=true")
+	activity := make(map[string]time.Time)
+	record := func(session string, t time.Time) {
+		if session == "" {
+			return
+		}
+		if last, ok := activity[session]; !ok || t.After(last) {
+			activity[session] = t
+		}
+	}
+
+	containers, err := d.Docker.ContainerList(d.Context, container.ListOptions{
+		All:     true,
+		Filters: f,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, con := range containers {
+		created := time.Unix(con.Created, 0).UTC()
+		if t, e := time.Parse(time.RFC3339, con.Labels[labelCreatedAt]); e == nil {
+			created = t
+		}
+		session, ok := con.Labels[labelSession]
+		if !ok {
+			if ref, e := DockerContainerNameToStruct(containerName(con.Names)); e == nil {
+				session = ref.SessionId
 			}
-			if con.State == "running" {
-				cyanRunning = append(cyanRunning, containerRef)
-			} else {
-				cyanStopped = append(cyanStopped, containerRef)
+		}
+		record(session, created)
+	}
+
+	volumes, err := d.Docker.VolumeList(d.Context, volume.ListOptions{
+		Filters: f,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, vol := range volumes.Volumes {
+		created, e := time.Parse(time.RFC3339, vol.Labels[labelCreatedAt])
+		if e != nil {
+			created, e = time.Parse(time.RFC3339, vol.CreatedAt)
+			if e != nil {
+				created = time.Now().UTC()
+			}
+		}
+		session, ok := vol.Labels[labelSession]
+		if !ok {
+			ref, e := DockerVolumeNameToStruct(vol.Name)
+			if e == nil {
+				session = ref.SessionId
 			}
 		}
+		record(session, created)
 	}
-	return cyanRunning, cyanStopped, nil
+	return activity, nil
 }
 
-func (d *DockerClient) CreateContainer(cc DockerContainerReference, image DockerImageReference) error {
+func (d *DockerClient) CreateContainer(cc DockerContainerReference, image DockerImageReference, limits ResourceLimits) (err error) {
 
 	name := DockerContainerToString(cc)
 	imageName := DockerImageToString(image)
-	c, err := d.Docker.ContainerCreate(d.Context, &container.Config{
-		Image: imageName,
-		Labels: map[string]string{
-			"cyanprint.dev": "true",
-		},
+	start := time.Now()
+	ctx, span := startSpan(d.Context, "docker.container.create", append(containerSpanAttrs(cc), attribute.String(LogKeyImage, imageName))...)
+	defer func() {
+		observeContainerCreation(cc, start, err)
+		endSpan(span, err)
+	}()
+
+	c, err := d.containerCreate(ctx, cc, &container.Config{
+		Image:  imageName,
+		Labels: containerLabels(cc, containerTemplate(cc, DockerVolumeReference{})),
 	}, &container.HostConfig{
-		NetworkMode: networkName,
-	}, nil, nil, name)
+		NetworkMode: container.NetworkMode(d.config().Network),
+		Resources:   dockerResources(limits),
+	}, name)
 	if err != nil {
 		return err
 	}
-	err = d.Docker.ContainerStart(d.Context, c.ID, container.StartOptions{})
+	err = d.Docker.ContainerStart(ctx, c.ID, container.StartOptions{})
 	if err != nil {
 		return err
 	}
+	d.emitContainerCreated(cc, imageName)
 	return nil
 }
 
-func (d *DockerClient) CreateContainerWithVolume(cc DockerContainerReference, v DockerVolumeReference, image DockerImageReference) error {
+func (d *DockerClient) CreateContainerWithVolume(cc DockerContainerReference, v DockerVolumeReference, image DockerImageReference, limits ResourceLimits) (err error) {
 
 	name := DockerContainerToString(cc)
 	imageName := DockerImageToString(image)
 	volName := DockerVolumeToString(v)
+	start := time.Now()
+	ctx, span := startSpan(d.Context, "docker.container.create", append(containerSpanAttrs(cc), attribute.String(LogKeyImage, imageName))...)
+	defer func() {
+		observeContainerCreation(cc, start, err)
+		endSpan(span, err)
+	}()
 
-	c, err := d.Docker.ContainerCreate(d.Context, &container.Config{
-		Image: imageName,
-		Labels: map[string]string{
-			"cyanprint.dev": "true",
-		},
+	c, err := d.containerCreate(ctx, cc, &container.Config{
+		Image:  imageName,
+		Labels: containerLabels(cc, containerTemplate(cc, v)),
 	}, &container.HostConfig{
-		NetworkMode: networkName,
+		NetworkMode: container.NetworkMode(d.config().Network),
+		Resources:   dockerResources(limits),
 		Mounts: []mount.Mount{
 			{
 				Type:     "volume",
 				Source:   volName,
-				Target:   "/workspace/cyanprint",
+				Target:   d.config().Workspace.TemplateDir,
 				ReadOnly: false,
 			},
 		},
-	}, nil, nil, name)
+	}, name)
 	if err != nil {
 		return err
 	}
-	err = d.Docker.ContainerStart(d.Context, c.ID, container.StartOptions{})
+	err = d.Docker.ContainerStart(ctx, c.ID, container.StartOptions{})
 	if err != nil {
 		return err
 	}
+	d.emitContainerCreated(cc, imageName)
 	return nil
 }
 
@@ -239,9 +533,15 @@ func (d *DockerClient) CreateContainerWithCopyMount(
 	cc DockerContainerReference,
 	sourcePath string,
 	targetVolume DockerVolumeReference,
-) error {
+) (err error) {
 	name := DockerContainerToString(cc)
 	targetVolName := DockerVolumeToString(targetVolume)
+	start := time.Now()
+	ctx, span := startSpan(d.Context, "docker.container.copy", containerSpanAttrs(cc)...)
+	defer func() {
+		observeContainerCreation(cc, start, err)
+		endSpan(span, err)
+	}()
 
 	// Get self-image (coordinator)
 	image, err := d.GetCoordinatorImage()
@@ -249,16 +549,15 @@ func (d *DockerClient) CreateContainerWithCopyMount(
 		return fmt.Errorf("failed to get coordinator image: %w", err)
 	}
 	imageName := DockerImageToString(image)
+	span.SetAttributes(attribute.String(LogKeyImage, imageName))
 
 	// Create container with bind mount for source and volume mount for target
-	c, err := d.Docker.ContainerCreate(d.Context, &container.Config{
-		Image: imageName,
-		Cmd:   []string{"cp", "-r", "/source/.", "/target/"},
-		Labels: map[string]string{
-			"cyanprint.dev": "true",
-		},
+	c, err := d.Docker.ContainerCreate(ctx, &container.Config{
+		Image:  imageName,
+		Cmd:    []string{"cp", "-r", "/source/.", "/target/"},
+		Labels: containerLabels(cc, containerTemplate(cc, targetVolume)),
 	}, &container.HostConfig{
-		NetworkMode: networkName,
+		NetworkMode: container.NetworkMode(d.config().Network),
 		Mounts: []mount.Mount{
 			{
 				Type:     "bind",
@@ -279,19 +578,19 @@ func (d *DockerClient) CreateContainerWithCopyMount(
 
 	// Ensure container is removed on all exit paths
 	defer func() {
-		_ = d.Docker.ContainerRemove(d.Context, c.ID, container.RemoveOptions{
+		_ = d.Docker.ContainerRemove(ctx, c.ID, container.RemoveOptions{
 			Force: true,
 		})
 	}()
 
 	// Start container
-	err = d.Docker.ContainerStart(d.Context, c.ID, container.StartOptions{})
+	err = d.Docker.ContainerStart(ctx, c.ID, container.StartOptions{})
 	if err != nil {
 		return err
 	}
 
 	// Wait for completion and check exit status
-	statusCh, errCh := d.Docker.ContainerWait(d.Context, c.ID, container.WaitConditionNotRunning)
+	statusCh, errCh := d.Docker.ContainerWait(ctx, c.ID, container.WaitConditionNotRunning)
 	select {
 	case err := <-errCh:
 		if err != nil {
@@ -306,6 +605,53 @@ func (d *DockerClient) CreateContainerWithCopyMount(
 	return nil
 }
 
+// containerCreate creates a container with the security profile of its cyan type applied, reachable at its
+// ContainerHost. A merger on a session network also joins the shared network, through which it reaches the
+// registry.
+func (d *DockerClient) containerCreate(ctx context.Context, cc DockerContainerReference, cfg *container.Config, hc *container.HostConfig, name string) (container.CreateResponse, error) {
+	if err := d.config().Security.applyDockerSecurity(cc.CyanType, cfg, hc); err != nil {
+		return container.CreateResponse{}, err
+	}
+	var endpoint *networkTypes.EndpointSettings
+	var nc *networkTypes.NetworkingConfig
+	if host := ContainerHost(cc); host != name {
+		endpoint = &networkTypes.EndpointSettings{Aliases: []string{host}}
+		nc = &networkTypes.NetworkingConfig{EndpointsConfig: map[string]*networkTypes.EndpointSettings{string(hc.NetworkMode): endpoint}}
+	}
+	c, err := d.Docker.ContainerCreate(ctx, cfg, hc, nc, nil, name)
+	if err != nil {
+		return c, err
+	}
+	if cc.CyanType == "merger" && d.config().sessionNetworked(cc) {
+		if err := d.Docker.NetworkConnect(ctx, d.config().Network, c.ID, endpoint); err != nil {
+			return c, fmt.Errorf("failed to attach merger to network %s: %w", d.config().Network, err)
+		}
+	}
+	return c, nil
+}
+
+// networkOf is the network a container starts on
+func (d *DockerClient) networkOf(cc DockerContainerReference) string {
+	if d.config().sessionNetworked(cc) {
+		return d.config().sessionNetwork(cc.SessionId)
+	}
+	return d.config().Network
+}
+
+func (d *DockerClient) emitContainerCreated(cc DockerContainerReference, image string) {
+	d.Events.Emit(containerCreatedEvent(cc, image))
+}
+
+func containerCreatedEvent(cc DockerContainerReference, image string) Event {
+	return Event{
+		Type:      EventContainerCreated,
+		Image:     image,
+		Container: DockerContainerToString(cc),
+		CyanId:    cc.CyanId,
+		CyanType:  cc.CyanType,
+	}
+}
+
 func (d *DockerClient) RemoveContainer(cc DockerContainerReference) error {
 	name := DockerContainerToString(cc)
 	err := d.Docker.ContainerRemove(d.Context, name, container.RemoveOptions{
@@ -318,6 +664,35 @@ func (d *DockerClient) RemoveContainer(cc DockerContainerReference) error {
 	return nil
 }
 
+// RestartContainer starts a stopped container again, keeping its configuration
+func (d *DockerClient) RestartContainer(ref DockerContainerReference) error {
+	return d.Docker.ContainerStart(d.Context, DockerContainerToString(ref), container.StartOptions{})
+}
+
+func (d *DockerClient) ListMalformedContainers() ([]string, error) {
+	f := filters.NewArgs()
+	f.Add("label", "cyanprint.dev=true")
+	containers, err := d.Docker.ContainerList(d.Context, container.ListOptions{
+		All:     true,
+		Filters: f,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var malformed []string
+	for _, con := range containers {
+		n := containerName(con.Names)
+		if _, err := containerIdentity(con.Labels, n); err != nil {
+			malformed = append(malformed, n)
+		}
+	}
+	return malformed, nil
+}
+
+func (d *DockerClient) RemoveContainerNamed(name string) error {
+	return d.Docker.ContainerRemove(d.Context, name, container.RemoveOptions{Force: true})
+}
+
 type indexedError struct {
 	index int
 	err   error
@@ -331,12 +706,12 @@ func (d *DockerClient) RemoveAllContainers(containerRefs []DockerContainerRefere
 	for i, containerRef := range containerRefs {
 		semaphore <- 0
 		go func(idx int, cc DockerContainerReference) {
-			fmt.Println("🗑 Removing container:", DockerContainerToString(cc))
+			d.log().Info("Removing container", containerAttrs(cc)...)
 			err := d.RemoveContainer(cc)
 			if err != nil {
-				fmt.Println("🚨 Failed to remove container:", DockerContainerToString(cc))
+				d.log().Error("Failed to remove container", append(containerAttrs(cc), LogKeyError, err)...)
 			} else {
-				fmt.Println("✅ Container removed:", DockerContainerToString(cc))
+				d.log().Info("Container removed", containerAttrs(cc)...)
 			}
 			errChan <- indexedError{index: idx, err: err}
 			<-semaphore
@@ -376,12 +751,12 @@ func (d *DockerClient) RemoveAllVolumes(volRefs []DockerVolumeReference) []error
 	for i, volRef := range volRefs {
 		semaphore <- 0
 		go func(idx int, v DockerVolumeReference) {
-			fmt.Println("🗑 Removing volume:", DockerVolumeToString(v))
+			d.log().Info("Removing volume", LogKeyVolume, DockerVolumeToString(v))
 			err := d.RemoveVolume(v)
 			if err != nil {
-				fmt.Println("🚨 Failed to remove volume:", DockerVolumeToString(v))
+				d.log().Error("Failed to remove volume", LogKeyVolume, DockerVolumeToString(v), LogKeyError, err)
 			} else {
-				fmt.Println("✅ Volume removed:", DockerVolumeToString(v))
+				d.log().Info("Volume removed", LogKeyVolume, DockerVolumeToString(v))
 			}
 			errChan <- indexedError{index: idx, err: err}
 			<-semaphore
@@ -404,43 +779,54 @@ func (d *DockerClient) RemoveAllVolumes(volRefs []DockerVolumeReference) []error
 	return allErr
 }
 
-func (d *DockerClient) CreateContainerWithReadWriteVolume(cc DockerContainerReference, readVolume, writeVolume DockerVolumeReference, image DockerImageReference) error {
+func (d *DockerClient) CreateContainerWithReadWriteVolume(cc DockerContainerReference, readVolume, writeVolume DockerVolumeReference, image DockerImageReference, limits ResourceLimits) (err error) {
 
 	name := DockerContainerToString(cc)
 	imageName := DockerImageToString(image)
 
 	readVolName := DockerVolumeToString(readVolume)
 	writeVolName := DockerVolumeToString(writeVolume)
+	var env []string
+	if cc.CyanType == "merger" {
+		env = d.config().mergerEnv()
+	}
+	start := time.Now()
+	ctx, span := startSpan(d.Context, "docker.container.create", append(containerSpanAttrs(cc), attribute.String(LogKeyImage, imageName))...)
+	defer func() {
+		observeContainerCreation(cc, start, err)
+		endSpan(span, err)
+	}()
 
-	c, err := d.Docker.ContainerCreate(d.Context, &container.Config{
-		Image: imageName,
-		Labels: map[string]string{
-			"cyanprint.dev": "true",
-		},
+	c, err := d.containerCreate(ctx, cc, &container.Config{
+		Image:  imageName,
+		Env:    env,
+		Labels: containerLabels(cc, containerTemplate(cc, readVolume)),
 	}, &container.HostConfig{
-		NetworkMode: networkName,
+		NetworkMode: container.NetworkMode(d.networkOf(cc)),
+		Resources:   dockerResources(limits),
 		Mounts: []mount.Mount{
 			{
 				Type:     "volume",
 				Source:   readVolName,
-				Target:   "/workspace/cyanprint",
+				Target:   d.config().Workspace.TemplateDir,
 				ReadOnly: true,
 			},
 			{
 				Type:     "volume",
 				Source:   writeVolName,
-				Target:   "/workspace/area",
+				Target:   d.config().Workspace.AreaDir,
 				ReadOnly: false,
 			},
 		},
-	}, nil, nil, name)
+	}, name)
 	if err != nil {
 		return err
 	}
-	err = d.Docker.ContainerStart(d.Context, c.ID, container.StartOptions{})
+	err = d.Docker.ContainerStart(ctx, c.ID, container.StartOptions{})
 	if err != nil {
 		return err
 	}
+	d.emitContainerCreated(cc, imageName)
 	return nil
 }
 
@@ -450,15 +836,30 @@ func (d *DockerClient) CyanPrintNetworkExist() (bool, error) {
 		return false, err
 	}
 	for _, network := range networks {
-		if network.Name == networkName {
+		if network.Name == d.config().Network {
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
+// NetworkSubnets returns the subnets of the cyanprint network
+func (d *DockerClient) NetworkSubnets() ([]string, error) {
+	n, err := d.Docker.NetworkInspect(d.Context, d.config().Network, networkTypes.InspectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var subnets []string
+	for _, c := range n.IPAM.Config {
+		if c.Subnet != "" {
+			subnets = append(subnets, c.Subnet)
+		}
+	}
+	return subnets, nil
+}
+
 func (d *DockerClient) CreateNetwork() error {
-	_, err := d.Docker.NetworkCreate(d.Context, networkName, networkTypes.CreateOptions{
+	_, err := d.Docker.NetworkCreate(d.Context, d.config().Network, networkTypes.CreateOptions{
 		Driver: "bridge",
 	})
 	if err != nil {
@@ -469,24 +870,146 @@ func (d *DockerClient) CreateNetwork() error {
 
 func (d *DockerClient) EnforceNetwork() error {
 
-	fmt.Println("🔍 Check if network exists...")
+	d.log().Debug("Checking if network exists", "network", d.config().Network)
 	exist, err := d.CyanPrintNetworkExist()
-	fmt.Println("🌀 Network exists: ", exist)
+	d.log().Debug("Network lookup finished", "network", d.config().Network, "exists", exist)
 	if err != nil {
 		return err
 	}
 	if !exist {
-		fmt.Println("🌐 Creating network...")
+		d.log().Info("Creating network", "network", d.config().Network)
 		err = d.CreateNetwork()
 		if err != nil {
-			fmt.Println("🚨 Failed to create network")
+			d.log().Error("Failed to create network", "network", d.config().Network, LogKeyError, err)
 			return err
 		}
-		fmt.Println("✅ Network created")
+		d.log().Info("Network created", "network", d.config().Network)
+	}
+	return nil
+}
+
+// CreateSessionNetwork creates the session's network, internal if egress is denied, and attaches the
+// coordinator and shared containers to it. Containers already attached are left alone.
+func (d *DockerClient) CreateSessionNetwork(session string, shared []DockerContainerReference) error {
+	name := d.config().sessionNetwork(session)
+	n, err := d.Docker.NetworkInspect(d.Context, name, networkTypes.InspectOptions{})
+	if cerrdefs.IsNotFound(err) {
+		d.log().Info("Creating session network", "network", name)
+		_, err = d.Docker.NetworkCreate(d.Context, name, networkTypes.CreateOptions{
+			Driver:   "bridge",
+			Internal: d.config().Isolation.DenyEgress,
+			Labels:   resourceLabels(session),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create session network %s: %w", name, err)
+		}
+		n, err = d.Docker.NetworkInspect(d.Context, name, networkTypes.InspectOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	coordinator, err := d.config().coordinatorName()
+	if err != nil {
+		return fmt.Errorf("failed to determine the coordinator container: %w", err)
+	}
+	targets := []string{coordinator}
+	for _, c := range shared {
+		targets = append(targets, DockerContainerToString(c))
+	}
+	for _, target := range targets {
+		if isAttached(n, target) {
+			continue
+		}
+		if err := d.Docker.NetworkConnect(d.Context, name, target, nil); err != nil {
+			if target == coordinator {
+				return fmt.Errorf("failed to attach the coordinator (%s) to session network %s, set its container with --coordinator-container: %w", coordinator, name, err)
+			}
+			return fmt.Errorf("failed to attach %s to session network %s: %w", target, name, err)
+		}
+	}
+	return nil
+}
+
+// CoordinatorAttached looks the coordinator up by its container name or hostname, as session networks do
+func (d *DockerClient) CoordinatorAttached() (bool, error) {
+	coordinator, err := d.config().coordinatorName()
+	if err != nil {
+		return false, err
+	}
+	if _, err := d.Docker.ContainerInspect(d.Context, coordinator); cerrdefs.IsNotFound(err) {
+		return false, ErrNoCoordinatorContainer
+	} else if err != nil {
+		return false, err
+	}
+	n, err := d.Docker.NetworkInspect(d.Context, d.config().Network, networkTypes.InspectOptions{})
+	if cerrdefs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return isAttached(n, coordinator), nil
+}
+
+func (d *DockerClient) AttachCoordinator() error {
+	coordinator, err := d.config().coordinatorName()
+	if err != nil {
+		return err
+	}
+	return d.Docker.NetworkConnect(d.Context, d.config().Network, coordinator, nil)
+}
+
+// isAttached reports whether the container named, or identified by a possibly short ID as used for
+// hostnames, is attached to the network
+func isAttached(n networkTypes.Inspect, ref string) bool {
+	for id, e := range n.Containers {
+		if e.Name == ref || strings.HasPrefix(id, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveSessionNetwork detaches every container from the session's network and removes it.
+// A network that doesn't exist is not an error.
+func (d *DockerClient) RemoveSessionNetwork(session string) error {
+	name := d.config().sessionNetwork(session)
+	n, err := d.Docker.NetworkInspect(d.Context, name, networkTypes.InspectOptions{})
+	if cerrdefs.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for id := range n.Containers {
+		if err := d.Docker.NetworkDisconnect(d.Context, n.ID, id, true); err != nil && !cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("failed to detach %s from session network %s: %w", id, name, err)
+		}
+	}
+	if err := d.Docker.NetworkRemove(d.Context, n.ID); err != nil && !cerrdefs.IsNotFound(err) {
+		return err
 	}
 	return nil
 }
 
+// ListSessionNetworks returns the sessions that have a network
+func (d *DockerClient) ListSessionNetworks() ([]string, error) {
+	f := filters.NewArgs()
+	f.Add("label", labelDev+"=true")
+	f.Add("label", labelSession)
+	networks, err := d.Docker.NetworkList(d.Context, networkTypes.ListOptions{Filters: f})
+	if err != nil {
+		return nil, err
+	}
+	var sessions []string
+	for _, n := range networks {
+		if session := n.Labels[labelSession]; session != "" {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
 func (d *DockerClient) ListVolumes() ([]DockerVolumeReference, error) {
 
 	f := filters.NewArgs()
@@ -499,29 +1022,83 @@ func (d *DockerClient) ListVolumes() ([]DockerVolumeReference, error) {
 	}
 	var volumeNames []DockerVolumeReference
 	for _, vol := range volumes.Volumes {
-		v, err := DockerVolumeNameToStruct(vol.Name)
+		v, err := volumeIdentity(vol.Labels, vol.Name)
 		if err != nil {
-			return nil, err
+			d.log().Warn("Skipping volume with a malformed name", LogKeyVolume, vol.Name, LogKeyError, err)
+			continue
 		}
 		volumeNames = append(volumeNames, v)
 	}
 	return volumeNames, nil
 }
 
+// DiskUsage reads image and volume sizes from Docker's disk usage report; sizes Docker didn't compute count
+// as 0. An image's size includes layers it shares with other images.
+func (d *DockerClient) DiskUsage() ([]StoredImage, []StoredVolume, error) {
+	du, err := d.Docker.DiskUsage(d.OperationContext(), types.DiskUsageOptions{
+		Types: []types.DiskUsageObject{types.ImageObject, types.VolumeObject},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var images []StoredImage
+	for _, image := range du.Images {
+		if image.Labels[labelDev] != "true" {
+			continue
+		}
+		refs, err := localImageReferences(*image)
+		if err != nil {
+			return nil, nil, err
+		}
+		// dangling images have no name to remove them by; docker image prune removes them
+		if len(refs) == 0 {
+			continue
+		}
+		images = append(images, StoredImage{
+			Refs:    refs,
+			Size:    ByteSize(max(image.Size, 0)),
+			Created: time.Unix(image.Created, 0).UTC(),
+			InUse:   image.Containers > 0,
+		})
+	}
+	var volumes []StoredVolume
+	for _, vol := range du.Volumes {
+		if vol.Labels[labelDev] != "true" {
+			continue
+		}
+		ref, err := volumeIdentity(vol.Labels, vol.Name)
+		if err != nil {
+			continue
+		}
+		stored := StoredVolume{Volume: ref}
+		if t, err := time.Parse(time.RFC3339, vol.CreatedAt); err == nil {
+			stored.Created = t.UTC()
+		}
+		if vol.UsageData != nil {
+			stored.Size = ByteSize(max(vol.UsageData.Size, 0))
+			stored.InUse = vol.UsageData.RefCount > 0
+		}
+		volumes = append(volumes, stored)
+	}
+	return images, volumes, nil
+}
+
 func (d *DockerClient) CreateVolume(vol DockerVolumeReference) error {
 	volName := DockerVolumeToString(vol)
 
 	_, err := d.Docker.VolumeCreate(d.Context, volume.CreateOptions{
-		Labels: map[string]string{
-			"cyanprint.dev": "true",
-		},
-		Name: volName,
+		Labels: volumeLabels(vol),
+		Name:   volName,
 	})
 	return err
 }
 
 // RemoveImage removes a Docker image by its reference
 func (d *DockerClient) RemoveImage(imageRef DockerImageReference) error {
+	// a tag and digest together aren't a name Docker stores, so remove by the tag when there is one
+	if imageRef.Tag != "" {
+		imageRef.Digest = ""
+	}
 	imageName := DockerImageToString(imageRef)
 	_, err := d.Docker.ImageRemove(d.Context, imageName, imageTypes.RemoveOptions{
 		Force:         true,
@@ -538,12 +1115,12 @@ func (d *DockerClient) RemoveAllImages(imageRefs []DockerImageReference) []error
 	for i, imageRef := range imageRefs {
 		semaphore <- 0
 		go func(idx int, img DockerImageReference) {
-			fmt.Println("🗑 Removing image:", DockerImageToString(img))
+			d.log().Info("Removing image", LogKeyImage, DockerImageToString(img))
 			err := d.RemoveImage(img)
 			if err != nil {
-				fmt.Println("🚨 Failed to remove image:", DockerImageToString(img))
+				d.log().Error("Failed to remove image", LogKeyImage, DockerImageToString(img), LogKeyError, err)
 			} else {
-				fmt.Println("✅ Image removed:", DockerImageToString(img))
+				d.log().Info("Image removed", LogKeyImage, DockerImageToString(img))
 			}
 			errChan <- indexedError{index: idx, err: err}
 			<-semaphore
@@ -566,65 +1143,76 @@ func (d *DockerClient) RemoveAllImages(imageRefs []DockerImageReference) []error
 	return allErr
 }
 
-// Cleanup removes all Docker resources (containers, images, volumes) labeled cyanprint.dev=true
-// It returns lists of successfully removed resources and the first error encountered (if any)
-func (d *DockerClient) Cleanup() (containersRemoved []string, imagesRemoved []string, volumesRemoved []string, err error) {
-	var firstError error
-
-	// 1. Remove containers
-	runningContainers, stoppedContainers, listErr := d.ListContainer()
-	if listErr != nil {
-		return nil, nil, nil, fmt.Errorf("failed to list containers: %w", listErr)
-	}
-	allContainers := append(runningContainers, stoppedContainers...)
-	if len(allContainers) > 0 {
-		containerErrors := d.RemoveAllContainers(allContainers)
-		for i, c := range allContainers {
-			if containerErrors[i] != nil {
-				if firstError == nil {
-					firstError = fmt.Errorf("failed to remove container %s: %w", DockerContainerToString(c), containerErrors[i])
-				}
-			} else {
-				containersRemoved = append(containersRemoved, DockerContainerToString(c))
-			}
-		}
+// SaveImages writes the images to w in the format of docker save
+func (d *DockerClient) SaveImages(images []DockerImageReference, w io.Writer) error {
+	names := make([]string, len(images))
+	for i, image := range images {
+		names[i] = DockerImageToString(image)
+	}
+	r, err := d.Docker.ImageSave(d.OperationContext(), names)
+	if err != nil {
+		return err
 	}
+	defer func() { _ = r.Close() }()
+	_, err = io.Copy(w, r)
+	return err
+}
 
-	// 2. Remove images
-	images, listErr := d.ListImages()
-	if listErr != nil {
-		return containersRemoved, nil, nil, fmt.Errorf("failed to list images: %w", listErr)
+// LoadImages loads a docker save archive. Images without the cyanprint.dev label, such as ones built
+// outside cyanprint, are rebuilt with it under the same tag, which adds no layer.
+func (d *DockerClient) LoadImages(r io.Reader, images []DockerImageReference) error {
+	ctx := d.OperationContext()
+	res, err := d.Docker.ImageLoad(ctx, r, client.ImageLoadWithQuiet(true))
+	if err != nil {
+		return err
 	}
-	if len(images) > 0 {
-		imageErrors := d.RemoveAllImages(images)
-		for i, img := range images {
-			if imageErrors[i] != nil {
-				if firstError == nil {
-					firstError = fmt.Errorf("failed to remove image %s: %w", DockerImageToString(img), imageErrors[i])
-				}
-			} else {
-				imagesRemoved = append(imagesRemoved, DockerImageToString(img))
-			}
+	defer func() { _ = res.Body.Close() }()
+	// loading reports errors within its stream, as pulling does
+	if _, err := readPullStream(res.Body, nil); err != nil {
+		return fmt.Errorf("failed to load images: %w", err)
+	}
+	for _, image := range images {
+		name := DockerImageToString(image)
+		inspect, err := d.Docker.ImageInspect(ctx, name)
+		if err != nil {
+			return fmt.Errorf("image %s is not in the archive: %w", name, err)
+		}
+		if inspect.Config != nil && inspect.Config.Labels[labelDev] == "true" {
+			continue
+		}
+		d.log().Info("Labelling image", LogKeyImage, name)
+		if err := d.labelImage(ctx, name); err != nil {
+			return fmt.Errorf("failed to label image %s: %w", name, err)
 		}
 	}
+	return nil
+}
 
-	// 3. Remove volumes
-	volumes, listErr := d.ListVolumes()
-	if listErr != nil {
-		return containersRemoved, imagesRemoved, nil, fmt.Errorf("failed to list volumes: %w", listErr)
+// labelImage rebuilds the image from itself with the cyanprint.dev label, replacing its tag. The classic
+// builder is used as it resolves FROM locally, where BuildKit may look the image up in its registry.
+func (d *DockerClient) labelImage(ctx context.Context, name string) error {
+	dockerfile := fmt.Sprintf("FROM %s\nLABEL %s=true\n", name, labelDev)
+	var buildContext bytes.Buffer
+	tw := tar.NewWriter(&buildContext)
+	if err := tw.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0o644, Size: int64(len(dockerfile))}); err != nil {
+		return err
 	}
-	if len(volumes) > 0 {
-		volumeErrors := d.RemoveAllVolumes(volumes)
-		for i, v := range volumes {
-			if volumeErrors[i] != nil {
-				if firstError == nil {
-					firstError = fmt.Errorf("failed to remove volume %s: %w", DockerVolumeToString(v), volumeErrors[i])
-				}
-			} else {
-				volumesRemoved = append(volumesRemoved, DockerVolumeToString(v))
-			}
-		}
+	if _, err := tw.Write([]byte(dockerfile)); err != nil {
+		return err
 	}
-
-	return containersRemoved, imagesRemoved, volumesRemoved, firstError
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	res, err := d.Docker.ImageBuild(ctx, &buildContext, build.ImageBuildOptions{
+		Tags:        []string{name},
+		Version:     build.BuilderV1,
+		Remove:      true,
+		ForceRemove: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+	_, err = readPullStream(res.Body, nil)
+	return err
 }
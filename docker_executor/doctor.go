@@ -0,0 +1,195 @@
+package docker_executor
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// ErrNoCoordinatorContainer is returned by CoordinatorAttached when the coordinator doesn't run in a
+// container, e.g. for the doctor command run on the host
+var ErrNoCoordinatorContainer = errors.New("the coordinator doesn't run in a container")
+
+// helperTypes are the short-lived containers that populate volumes: "volume" unzips a template's blob
+// on warm, "unzip" and "copy-helper" fill a try's blob volume from an image or a local path
+var helperTypes = []string{"volume", "unzip", "copy-helper"}
+
+// Kinds of DoctorIssue
+const (
+	IssueNetworkMissing      = "network_missing"
+	IssueCoordinatorDetached = "coordinator_detached"
+	IssueMalformedName       = "malformed_name"
+	IssueStoppedContainer    = "stopped_container"
+	IssueLeftoverHelper      = "leftover_helper"
+	IssueIncompleteVolume    = "incomplete_volume"
+)
+
+// DoctorIssue is an inconsistency between cyanprint's resources and what the executors expect, with the
+// fix Doctor.Repair applies
+type DoctorIssue struct {
+	Kind     string `json:"kind"`
+	Resource string `json:"resource"`
+	Problem  string `json:"problem"`
+	Fix      string `json:"fix"`
+	// Fixed and Error report a repair
+	Fixed bool   `json:"fixed"`
+	Error string `json:"error,omitempty"`
+
+	repair func() error
+}
+
+// Doctor finds resources that drifted into states the executors don't handle, such as a stopped
+// template container that warming takes for a running one, and repairs them
+type Doctor struct {
+	Docker ContainerRuntime
+	Logger *slog.Logger
+}
+
+func (doc Doctor) log() *slog.Logger {
+	return loggerOrDefault(doc.Logger)
+}
+
+// Examine returns every issue found, in the order they should be repaired: the network first, as
+// restarted containers join it
+func (doc Doctor) Examine() ([]DoctorIssue, error) {
+	d := doc.Docker
+	issues := []DoctorIssue{}
+
+	network := d.Settings().Network
+	exists, err := d.CyanPrintNetworkExist()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up network %s: %w", network, err)
+	}
+	if !exists {
+		issues = append(issues, DoctorIssue{
+			Kind:     IssueNetworkMissing,
+			Resource: network,
+			Problem:  "the cyanprint network does not exist, so containers can't be started",
+			Fix:      "create the network",
+			repair:   d.EnforceNetwork,
+		})
+	}
+	attached, err := d.CoordinatorAttached()
+	switch {
+	case errors.Is(err, ErrNoCoordinatorContainer):
+		doc.log().Debug("Coordinator is not a container, skipping its network check")
+	case err != nil:
+		return nil, fmt.Errorf("failed to check the coordinator's network: %w", err)
+	case !attached:
+		issues = append(issues, DoctorIssue{
+			Kind:     IssueCoordinatorDetached,
+			Resource: network,
+			Problem:  "the coordinator is not on the cyanprint network, so it can't reach templates, processors or plugins",
+			Fix:      "attach the coordinator to the network",
+			repair:   d.AttachCoordinator,
+		})
+	}
+
+	malformed, err := d.ListMalformedContainers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	for _, name := range malformed {
+		issues = append(issues, DoctorIssue{
+			Kind:     IssueMalformedName,
+			Resource: name,
+			Problem:  "the container is labelled as cyanprint's but has no identity labels and its name doesn't parse, so it is ignored",
+			Fix:      "remove the container",
+			repair:   func() error { return d.RemoveContainerNamed(name) },
+		})
+	}
+
+	_, stopped, err := d.ListContainer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	volumes, err := d.ListVolumes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	for _, c := range stopped {
+		name := DockerContainerToString(c)
+		state, err := d.InspectContainer(c)
+		if err != nil {
+			// removed since it was listed
+			continue
+		}
+		switch {
+		case (c.CyanType == "template" || c.CyanType == CyanTypeResolver) && c.SessionId == "":
+			issues = append(issues, DoctorIssue{
+				Kind:     IssueStoppedContainer,
+				Resource: name,
+				Problem:  fmt.Sprintf("the %s container exited with code %d, but warming takes a present container as running", c.CyanType, state.ExitCode),
+				Fix:      "restart the container",
+				repair:   func() error { return d.RestartContainer(c) },
+			})
+		case slices.Contains(helperTypes, c.CyanType):
+			issues = append(issues, doc.helperIssue(c, state, volumes))
+		}
+	}
+	return issues, nil
+}
+
+// helperIssue diagnoses a stopped volume helper. One that succeeded only wasn't removed, while one that
+// failed left its volume half populated, which warming and trying take for a complete one.
+func (doc Doctor) helperIssue(c DockerContainerReference, state ContainerState, volumes []DockerVolumeReference) DoctorIssue {
+	d := doc.Docker
+	name := DockerContainerToString(c)
+	vol := DockerVolumeReference{CyanId: c.CyanId}
+	if state.ExitCode == 0 || !slices.Contains(volumes, vol) {
+		return DoctorIssue{
+			Kind:     IssueLeftoverHelper,
+			Resource: name,
+			Problem:  fmt.Sprintf("the %s helper exited with code %d and was not removed, so the next one of its name can't be created", c.CyanType, state.ExitCode),
+			Fix:      "remove the container",
+			repair:   func() error { return d.RemoveContainer(c) },
+		}
+	}
+	issue := DoctorIssue{
+		Kind:     IssueIncompleteVolume,
+		Resource: DockerVolumeToString(vol),
+		Problem:  fmt.Sprintf("the %s helper %s exited with code %d, leaving the volume incomplete", c.CyanType, name, state.ExitCode),
+	}
+	// a local path is only known to the try that copied it
+	if c.CyanType == "copy-helper" || state.Image.Reference == "" {
+		issue.Fix = "remove the helper and the volume, to be recreated by the next warm or try"
+		issue.repair = func() error {
+			if err := d.RemoveContainer(c); err != nil {
+				return err
+			}
+			return d.RemoveVolume(vol)
+		}
+		return issue
+	}
+	issue.Fix = "re-extract " + DockerImageToString(state.Image) + " into a new volume"
+	issue.repair = func() error {
+		if err := d.RemoveContainer(c); err != nil {
+			return err
+		}
+		if err := d.RemoveVolume(vol); err != nil {
+			return err
+		}
+		if err := d.CreateVolume(vol); err != nil {
+			return err
+		}
+		return unzipVolume(d, doc.log(), c, vol, state.Image)
+	}
+	return issue
+}
+
+// Repair fixes issues in order, recording the outcome of each. A failed repair doesn't stop the rest.
+func (doc Doctor) Repair(issues []DoctorIssue) []DoctorIssue {
+	repaired := make([]DoctorIssue, len(issues))
+	for i, issue := range issues {
+		doc.log().Info("Repairing", "kind", issue.Kind, "resource", issue.Resource, "fix", issue.Fix)
+		if err := issue.repair(); err != nil {
+			doc.log().Error("Failed to repair", "kind", issue.Kind, "resource", issue.Resource, LogKeyError, err)
+			issue.Error = err.Error()
+		} else {
+			issue.Fixed = true
+		}
+		repaired[i] = issue
+	}
+	return repaired
+}
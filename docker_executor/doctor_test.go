@@ -0,0 +1,116 @@
+package docker_executor
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// brokenHelper leaves a helper of the given type that exited with code, with its volume
+func brokenHelper(t *testing.T, rt *FakeRuntime, cyanType string, code int) (DockerContainerReference, DockerVolumeReference) {
+	t.Helper()
+	helper := DockerContainerReference{CyanId: "template-" + cyanType, CyanType: cyanType}
+	vol := DockerVolumeReference{CyanId: helper.CyanId}
+	blob := DockerImageReference{Reference: "registry.local/blob", Tag: "1"}
+	rt.AddImage(blob)
+	rt.SetExitCode(DockerContainerToString(helper), code)
+	if err := rt.CreateVolume(vol); err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	if err := rt.CreateContainerWithVolume(helper, vol, blob, ResourceLimits{}); err != nil {
+		t.Fatalf("CreateContainerWithVolume() error = %v", err)
+	}
+	if _, err := rt.WaitContainer(helper); err != nil {
+		t.Fatalf("WaitContainer() error = %v", err)
+	}
+	return helper, vol
+}
+
+func issueKinds(issues []DoctorIssue) []string {
+	var kinds []string
+	for _, i := range issues {
+		kinds = append(kinds, i.Kind+" "+i.Resource)
+	}
+	return kinds
+}
+
+// TestDoctor tests that each kind of drift is reported and repaired, and that a healthy runtime has none
+func TestDoctor(t *testing.T) {
+	rt := NewFakeRuntime()
+	doctor := Doctor{Docker: rt}
+
+	template := DockerContainerReference{CyanId: "template-1", CyanType: "template"}
+	processor := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"}
+	rt.AddContainer(template, false)
+	rt.AddContainer(processor, false)
+	rt.AddMalformedContainer("cyan-broken")
+	unzip, unzipVol := brokenHelper(t, rt, "volume", 1)
+	copyHelper, copyVol := brokenHelper(t, rt, "copy-helper", 1)
+	leftover, _ := brokenHelper(t, rt, "unzip", 0)
+
+	issues, err := doctor.Examine()
+	if err != nil {
+		t.Fatalf("Examine() error = %v", err)
+	}
+	want := []string{
+		IssueNetworkMissing + " cyanprint",
+		IssueCoordinatorDetached + " cyanprint",
+		IssueMalformedName + " cyan-broken",
+		IssueIncompleteVolume + " " + DockerVolumeToString(copyVol),
+		IssueStoppedContainer + " " + DockerContainerToString(template),
+		IssueLeftoverHelper + " " + DockerContainerToString(leftover),
+		IssueIncompleteVolume + " " + DockerVolumeToString(unzipVol),
+	}
+	if got := issueKinds(issues); !slices.Equal(got, want) {
+		t.Fatalf("Examine() = %v, want %v", got, want)
+	}
+
+	// the re-extraction succeeds this time
+	rt.SetExitCode(DockerContainerToString(unzip), 0)
+	for _, issue := range doctor.Repair(issues) {
+		if !issue.Fixed || issue.Error != "" {
+			t.Errorf("repair of %s %s: fixed = %v, error = %s", issue.Kind, issue.Resource, issue.Fixed, issue.Error)
+		}
+	}
+	if state, _ := rt.InspectContainer(template); !state.Running {
+		t.Error("template container was not restarted")
+	}
+	if slices.Contains(rt.Volumes(), DockerVolumeToString(copyVol)) {
+		t.Error("volume of a failed copy was kept")
+	}
+	if !slices.Contains(rt.Volumes(), DockerVolumeToString(unzipVol)) {
+		t.Error("re-extracted volume is missing")
+	}
+	for _, c := range []DockerContainerReference{unzip, copyHelper, leftover} {
+		if slices.Contains(rt.Containers(), DockerContainerToString(c)) {
+			t.Errorf("helper %s was kept", DockerContainerToString(c))
+		}
+	}
+	if !slices.Contains(rt.Containers(), DockerContainerToString(processor)) {
+		t.Error("a session's stopped container was touched")
+	}
+
+	issues, err = doctor.Examine()
+	if err != nil || len(issues) > 0 {
+		t.Errorf("Examine() after repair = %v, %v, want no issues", issueKinds(issues), err)
+	}
+}
+
+// TestDoctorRepairFailure tests that a failed repair is reported and the rest carry on
+func TestDoctorRepairFailure(t *testing.T) {
+	rt := NewFakeRuntime()
+	_ = rt.EnforceNetwork()
+	rt.AddMalformedContainer("cyan-a")
+	rt.AddMalformedContainer("cyan-b")
+	rt.FailOn(FakeRemoveContainer, "cyan-a", errors.New("device busy"))
+
+	doctor := Doctor{Docker: rt}
+	issues, err := doctor.Examine()
+	if err != nil {
+		t.Fatalf("Examine() error = %v", err)
+	}
+	repaired := doctor.Repair(issues)
+	if len(repaired) != 2 || repaired[0].Fixed || repaired[0].Error != "device busy" || !repaired[1].Fixed {
+		t.Errorf("Repair() = %+v", repaired)
+	}
+}
@@ -1,9 +1,14 @@
 package docker_executor
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/distribution/reference"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 func StripDash(id string) string {
@@ -18,31 +23,114 @@ func InsertDash(uuid string) string {
 	return uuid[:8] + "-" + uuid[8:12] + "-" + uuid[12:16] + "-" + uuid[16:20] + "-" + uuid[20:]
 }
 
+// DockerImageReference is an image by its name (the registry host and repository, e.g.
+// localhost:5000/acme/processor) pinned by a tag, a digest or both. When a digest is set it decides
+// which image is pulled and run, and the tag is only informational.
 type DockerImageReference struct {
 	Reference string
 	Tag       string
+	Digest    string
+}
+
+// NewDockerImageReference builds the reference of a registry's dockerReference and dockerTag. A digest
+// pin may be given as the tag (sha256:...) or appended to either with @.
+func NewDockerImageReference(ref, tag string) DockerImageReference {
+	ref, refDigest, _ := strings.Cut(ref, "@")
+	tag, tagDigest, _ := strings.Cut(tag, "@")
+	if strings.HasPrefix(tag, "sha256:") {
+		tag, tagDigest = "", tag
+	}
+	d := refDigest
+	if tagDigest != "" {
+		d = tagDigest
+	}
+	return DockerImageReference{Reference: ref, Tag: tag, Digest: d}
+}
+
+// Registry returns the host the image is pulled from, docker.io for images without one
+func (i DockerImageReference) Registry() string {
+	named, err := reference.ParseNormalizedNamed(i.Reference)
+	if err != nil {
+		return ""
+	}
+	return reference.Domain(named)
+}
+
+// Repository returns the image's path within its registry, e.g. library/alpine
+func (i DockerImageReference) Repository() string {
+	named, err := reference.ParseNormalizedNamed(i.Reference)
+	if err != nil {
+		return i.Reference
+	}
+	return reference.Path(named)
+}
+
+// name is the fully qualified name, so alpine and docker.io/library/alpine compare equal
+func (i DockerImageReference) name() string {
+	named, err := reference.ParseNormalizedNamed(i.Reference)
+	if err != nil {
+		return i.Reference
+	}
+	return named.Name()
+}
+
+// foundIn reports whether a local image satisfies the reference: the same repository with the pinned
+// digest, or with the tag when none is pinned
+func (i DockerImageReference) foundIn(images []DockerImageReference) bool {
+	for _, image := range images {
+		if image.name() != i.name() {
+			continue
+		}
+		if i.Digest != "" {
+			if image.Digest == i.Digest {
+				return true
+			}
+		} else if image.Tag == i.Tag {
+			return true
+		}
+	}
+	return false
 }
 
 func DockerImageToString(image DockerImageReference) string {
-	return fmt.Sprintf("%s:%s", image.Reference, image.Tag)
+	s := image.Reference
+	if image.Tag != "" {
+		s += ":" + image.Tag
+	}
+	if image.Digest != "" {
+		s += "@" + image.Digest
+	}
+	return s
 }
 
+// DockerImageToStruct parses an image reference such as localhost:5000/acme/processor:1.0 or
+// ghcr.io/acme/processor@sha256:..., which must carry a tag or digest
 func DockerImageToStruct(imageString string) (DockerImageReference, error) {
-	parts := strings.Split(imageString, ":")
-	if len(parts) != 2 {
-		fmt.Println("Invalid image string format")
-		return DockerImageReference{}, errors.New("invalid image string format")
-	}
-	return DockerImageReference{
-		Reference: parts[0],
-		Tag:       parts[1],
-	}, nil
+	parsed, err := reference.Parse(imageString)
+	if err != nil {
+		return DockerImageReference{}, fmt.Errorf("invalid image reference '%s': %w", imageString, err)
+	}
+	named, ok := parsed.(reference.Named)
+	if !ok {
+		return DockerImageReference{}, fmt.Errorf("invalid image reference '%s': missing repository", imageString)
+	}
+	image := DockerImageReference{Reference: named.Name()}
+	if tagged, ok := named.(reference.Tagged); ok {
+		image.Tag = tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		image.Digest = digested.Digest().String()
+	}
+	if image.Tag == "" && image.Digest == "" {
+		return DockerImageReference{}, fmt.Errorf("invalid image reference '%s': missing tag or digest", imageString)
+	}
+	return image, nil
 }
 
 type DockerContainerReference struct {
-	CyanId    string
-	CyanType  string
-	SessionId string
+	CyanId    string `json:"cyan_id"`
+	CyanType  string `json:"cyan_type"`
+	SessionId string `json:"session_id"`
 }
 
 func DockerContainerToString(container DockerContainerReference) string {
@@ -55,20 +143,46 @@ func DockerContainerToString(container DockerContainerReference) string {
 	return "cyan-" + container.CyanType + "-" + templateVersionId + "-" + container.SessionId
 }
 
+// ContainerHost returns the host name a container is reached at. It is the container's name, unless that
+// isn't a valid DNS label, as UUID ids with session ids over 15 characters make it too long to be; then it
+// is cyan-<type>-<hash of the name>. Kubernetes names a container's pod and Service by it, and Docker gives
+// the container it as a network alias.
+func ContainerHost(container DockerContainerReference) string {
+	name := DockerContainerToString(container)
+	if len(validation.IsDNS1035Label(name)) == 0 {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return "cyan-" + container.CyanType + "-" + hex.EncodeToString(sum[:10])
+}
+
+// dashedCyanTypes are the cyan types with a dash, which can't be told from the rest of a name by splitting
+var dashedCyanTypes = []string{"copy-helper"}
+
+// DockerContainerNameToStruct parses cyan-<type>-<id> or cyan-<type>-<id>-<session>
 func DockerContainerNameToStruct(name string) (DockerContainerReference, error) {
-	if strings.HasPrefix(name, "cyan-") {
-		parts := strings.Split(name, "-")
-		sessionId := ""
-		if len(parts) == 4 {
-			sessionId = parts[3]
+	rest, ok := strings.CutPrefix(name, "cyan-")
+	if !ok {
+		return DockerContainerReference{}, errors.New("invalid container name: " + name)
+	}
+	cyanType := ""
+	for _, t := range dashedCyanTypes {
+		if after, ok := strings.CutPrefix(rest, t+"-"); ok {
+			cyanType, rest = t, after
 		}
-		return DockerContainerReference{
-			CyanType:  parts[1],
-			CyanId:    InsertDash(parts[2]),
-			SessionId: sessionId,
-		}, nil
 	}
-	return DockerContainerReference{}, errors.New("invalid container name: " + name)
+	if cyanType == "" {
+		cyanType, rest, _ = strings.Cut(rest, "-")
+	}
+	id, sessionId, _ := strings.Cut(rest, "-")
+	if cyanType == "" || id == "" {
+		return DockerContainerReference{}, errors.New("invalid container name: " + name)
+	}
+	return DockerContainerReference{
+		CyanType:  cyanType,
+		CyanId:    InsertDash(id),
+		SessionId: sessionId,
+	}, nil
 }
 
 type DockerVolumeReference struct {
@@ -84,19 +198,17 @@ func DockerVolumeToString(volume DockerVolumeReference) string {
 	return "cyan-" + templateVersionId + "-" + volume.SessionId
 }
 
+// DockerVolumeNameToStruct parses cyan-<id> or cyan-<id>-<session>
 func DockerVolumeNameToStruct(realName string) (DockerVolumeReference, error) {
-	if strings.HasPrefix(realName, "cyan-") {
-		parts := strings.Split(realName, "-")
-		sessionId := ""
-		if len(parts) == 3 {
-			sessionId = parts[2]
-		}
-		return DockerVolumeReference{
-			CyanId:    InsertDash(parts[1]),
-			SessionId: sessionId,
-		}, nil
+	rest, ok := strings.CutPrefix(realName, "cyan-")
+	id, sessionId, _ := strings.Cut(rest, "-")
+	if !ok || id == "" {
+		return DockerVolumeReference{}, errors.New("invalid volume name: " + realName)
 	}
-	return DockerVolumeReference{}, errors.New("invalid volume realName")
+	return DockerVolumeReference{
+		CyanId:    InsertDash(id),
+		SessionId: sessionId,
+	}, nil
 }
 
 // CyanTypeResolver is the container type string for resolver containers
@@ -0,0 +1,136 @@
+package docker_executor
+
+import (
+	"strings"
+	"testing"
+
+	imageTypes "github.com/docker/docker/api/types/image"
+)
+
+const testDigest = "sha256:4f1c0e8e1b3a6b3c0e5a9d2f7c8b6a5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b"
+
+// TestDockerImageToStruct tests parsing references with registry ports and digests
+func TestDockerImageToStruct(t *testing.T) {
+	tests := []struct {
+		image   string
+		want    DockerImageReference
+		wantErr bool
+	}{
+		{image: "alpine:3", want: DockerImageReference{Reference: "alpine", Tag: "3"}},
+		{image: "localhost:5000/foo:1.0", want: DockerImageReference{Reference: "localhost:5000/foo", Tag: "1.0"}},
+		{image: "ghcr.io/acme/processor@" + testDigest, want: DockerImageReference{Reference: "ghcr.io/acme/processor", Digest: testDigest}},
+		{image: "localhost:5000/foo:1.0@" + testDigest, want: DockerImageReference{Reference: "localhost:5000/foo", Tag: "1.0", Digest: testDigest}},
+		{image: "localhost:5000/foo", wantErr: true},
+		{image: "Foo:1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			got, err := DockerImageToStruct(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DockerImageToStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DockerImageToStruct() = %+v, want %+v", got, tt.want)
+			}
+			if !tt.wantErr && DockerImageToString(got) != tt.image {
+				t.Errorf("DockerImageToString() = %q, want %q", DockerImageToString(got), tt.image)
+			}
+		})
+	}
+}
+
+// TestNewDockerImageReference tests the ways registries pin digests
+func TestNewDockerImageReference(t *testing.T) {
+	tests := []struct {
+		name     string
+		ref, tag string
+		want     DockerImageReference
+	}{
+		{name: "tag", ref: "ghcr.io/acme/p", tag: "1", want: DockerImageReference{Reference: "ghcr.io/acme/p", Tag: "1"}},
+		{name: "digest as tag", ref: "ghcr.io/acme/p", tag: testDigest, want: DockerImageReference{Reference: "ghcr.io/acme/p", Digest: testDigest}},
+		{name: "digest on tag", ref: "ghcr.io/acme/p", tag: "1@" + testDigest, want: DockerImageReference{Reference: "ghcr.io/acme/p", Tag: "1", Digest: testDigest}},
+		{name: "digest on reference", ref: "ghcr.io/acme/p@" + testDigest, tag: "1", want: DockerImageReference{Reference: "ghcr.io/acme/p", Tag: "1", Digest: testDigest}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewDockerImageReference(tt.ref, tt.tag); got != tt.want {
+				t.Errorf("NewDockerImageReference() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDockerImageReferenceFoundIn tests that images match by repository rather than suffix, and by digest when pinned
+func TestDockerImageReferenceFoundIn(t *testing.T) {
+	local := []DockerImageReference{
+		{Reference: "alpine", Tag: "3", Digest: testDigest},
+		{Reference: "ghcr.io/other/processor", Tag: "1"},
+	}
+	tests := []struct {
+		name  string
+		image DockerImageReference
+		want  bool
+	}{
+		{name: "normalized name", image: DockerImageReference{Reference: "docker.io/library/alpine", Tag: "3"}, want: true},
+		{name: "pinned digest", image: DockerImageReference{Reference: "alpine", Tag: "3", Digest: testDigest}, want: true},
+		{name: "stale digest", image: DockerImageReference{Reference: "alpine", Tag: "3", Digest: "sha256:" + strings.Repeat("0", 64)}, want: false},
+		{name: "repository suffix", image: DockerImageReference{Reference: "processor", Tag: "1"}, want: false},
+		{name: "other registry", image: DockerImageReference{Reference: "registry.local/other/processor", Tag: "1"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.image.foundIn(local); got != tt.want {
+				t.Errorf("foundIn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLocalImageReferences tests that local images carry their repository's digest and that digest-only pulls are listed
+func TestLocalImageReferences(t *testing.T) {
+	refs, err := localImageReferences(imageTypes.Summary{
+		RepoTags:    []string{"localhost:5000/foo:1.0"},
+		RepoDigests: []string{"localhost:5000/foo@" + testDigest, "ghcr.io/acme/foo@" + testDigest},
+	})
+	if err != nil {
+		t.Fatalf("localImageReferences() error = %v", err)
+	}
+	want := []DockerImageReference{
+		{Reference: "localhost:5000/foo", Tag: "1.0", Digest: testDigest},
+		{Reference: "ghcr.io/acme/foo", Digest: testDigest},
+	}
+	if len(refs) != len(want) {
+		t.Fatalf("localImageReferences() = %+v, want %+v", refs, want)
+	}
+	for i := range want {
+		if refs[i] != want[i] {
+			t.Errorf("localImageReferences()[%d] = %+v, want %+v", i, refs[i], want[i])
+		}
+	}
+}
+
+// TestDockerContainerNameToStruct tests that names round trip, including dashed types and session ids,
+// and that malformed names are rejected rather than misread
+func TestDockerContainerNameToStruct(t *testing.T) {
+	id := "0b8f6a4e-3c2d-4e1f-9a8b-7c6d5e4f3a2b"
+	for _, ref := range []DockerContainerReference{
+		{CyanId: id, CyanType: "template"},
+		{CyanId: id, CyanType: "processor", SessionId: "s1"},
+		{CyanId: id, CyanType: "copy-helper"},
+		{CyanId: id, CyanType: "unzip", SessionId: "try-session-1"},
+	} {
+		name := DockerContainerToString(ref)
+		got, err := DockerContainerNameToStruct(name)
+		if err != nil || got != ref {
+			t.Errorf("DockerContainerNameToStruct(%q) = %+v, %v, want %+v", name, got, err, ref)
+		}
+	}
+	for _, name := range []string{"cyan-broken", "cyan-", "cyan-template-", "boron"} {
+		if got, err := DockerContainerNameToStruct(name); err == nil {
+			t.Errorf("DockerContainerNameToStruct(%q) = %+v, want an error", name, got)
+		}
+	}
+	if _, err := DockerVolumeNameToStruct("cyan-"); err == nil {
+		t.Error("DockerVolumeNameToStruct() accepted a name without an id")
+	}
+}
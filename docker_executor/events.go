@@ -0,0 +1,143 @@
+package docker_executor
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventImagePullStarted   EventType = "image_pull_started"
+	EventImagePullProgress  EventType = "image_pull_progress"
+	EventImagePullFinished  EventType = "image_pull_finished"
+	EventContainerCreated   EventType = "container_created"
+	EventHealthCheckAttempt EventType = "health_check_attempt"
+	EventProcessorStarted   EventType = "processor_started"
+	EventProcessorCompleted EventType = "processor_completed"
+	EventConflictResolved   EventType = "conflict_resolved"
+	EventPluginCompleted    EventType = "plugin_completed"
+)
+
+// Event is a progress notification emitted while warming, starting or building a session.
+// Only the fields relevant to the event's Type are set.
+type Event struct {
+	Type      EventType `json:"type"`
+	SessionId string    `json:"session_id"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// image events
+	Image    string        `json:"image,omitempty"`
+	Progress *PullProgress `json:"progress,omitempty"`
+
+	// container, health check, processor and plugin events
+	Container string `json:"container,omitempty"`
+	CyanId    string `json:"cyan_id,omitempty"`
+	CyanType  string `json:"cyan_type,omitempty"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	Attempt   int    `json:"attempt,omitempty"`
+	Healthy   bool   `json:"healthy,omitempty"`
+
+	// conflict events
+	Path       string              `json:"path,omitempty"`
+	Resolution ConflictResolveKind `json:"resolution,omitempty"`
+	Resolver   string              `json:"resolver,omitempty"`
+
+	// set when the step the event reports on failed
+	Error string `json:"error,omitempty"`
+}
+
+// eventHistoryLimit is the number of events kept per session so late subscribers can catch up
+const eventHistoryLimit = 512
+
+// EventBus fans out events to subscribers of a session and keeps a bounded history per session
+type EventBus struct {
+	mutex       sync.Mutex
+	history     map[string][]Event
+	subscribers map[string]map[chan Event]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		history:     make(map[string][]Event),
+		subscribers: make(map[string]map[chan Event]struct{}),
+	}
+}
+
+func (b *EventBus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	h := append(b.history[e.SessionId], e)
+	if len(h) > eventHistoryLimit {
+		h = h[len(h)-eventHistoryLimit:]
+	}
+	b.history[e.SessionId] = h
+	for ch := range b.subscribers[e.SessionId] {
+		// never block publishers on a slow subscriber
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the events published so far for the session and a channel of future events.
+// The returned function must be called to unsubscribe.
+func (b *EventBus) Subscribe(sessionId string) ([]Event, <-chan Event, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ch := make(chan Event, 64)
+	if b.subscribers[sessionId] == nil {
+		b.subscribers[sessionId] = make(map[chan Event]struct{})
+	}
+	b.subscribers[sessionId][ch] = struct{}{}
+	history := append([]Event{}, b.history[sessionId]...)
+	return history, ch, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, ok := b.subscribers[sessionId][ch]; ok {
+			delete(b.subscribers[sessionId], ch)
+			close(ch)
+		}
+	}
+}
+
+// Forget drops the session's history and ends all of its subscriptions
+func (b *EventBus) Forget(sessionId string) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.history, sessionId)
+	for ch := range b.subscribers[sessionId] {
+		close(ch)
+	}
+	delete(b.subscribers, sessionId)
+}
+
+// Emitter publishes events for a single session. The zero value discards all events.
+type Emitter struct {
+	Bus       *EventBus
+	SessionId string
+}
+
+func (em Emitter) Emit(e Event) {
+	if em.Bus == nil {
+		return
+	}
+	e.SessionId = em.SessionId
+	e.Timestamp = time.Now().UTC()
+	em.Bus.Publish(e)
+}
+
+// errorString returns the error message, or an empty string for a nil error
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
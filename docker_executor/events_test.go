@@ -0,0 +1,105 @@
+package docker_executor
+
+import (
+	"testing"
+	"time"
+)
+
+// receive returns the next event on ch, failing the test if none arrives
+func receive(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case e, ok := <-ch:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}
+}
+
+// TestEventBusHistory tests that subscribers get the session's earlier events, and only the last
+// eventHistoryLimit of them
+func TestEventBusHistory(t *testing.T) {
+	b := NewEventBus()
+	em := Emitter{Bus: b, SessionId: "s1"}
+	for i := 0; i < eventHistoryLimit+2; i++ {
+		em.Emit(Event{Type: EventHealthCheckAttempt, Attempt: i})
+	}
+	Emitter{Bus: b, SessionId: "s2"}.Emit(Event{Type: EventContainerCreated})
+
+	history, _, unsubscribe := b.Subscribe("s1")
+	defer unsubscribe()
+	if len(history) != eventHistoryLimit {
+		t.Fatalf("len(history) = %d, want %d", len(history), eventHistoryLimit)
+	}
+	if first := history[0]; first.Attempt != 2 || first.SessionId != "s1" || first.Timestamp.IsZero() {
+		t.Errorf("history[0] = %+v, want attempt 2 of s1 with a timestamp", first)
+	}
+	if last := history[len(history)-1]; last.Attempt != eventHistoryLimit+1 {
+		t.Errorf("last event attempt = %d, want %d", last.Attempt, eventHistoryLimit+1)
+	}
+}
+
+// TestEventBusSubscribe tests that subscribers receive their session's new events until they unsubscribe
+func TestEventBusSubscribe(t *testing.T) {
+	b := NewEventBus()
+	history, ch, unsubscribe := b.Subscribe("s1")
+	if len(history) != 0 {
+		t.Errorf("history = %v, want none", history)
+	}
+	_, other, unsubscribeOther := b.Subscribe("s2")
+	defer unsubscribeOther()
+
+	Emitter{Bus: b, SessionId: "s1"}.Emit(Event{Type: EventProcessorStarted, CyanId: "processor-1"})
+	if e := receive(t, ch); e.Type != EventProcessorStarted || e.CyanId != "processor-1" {
+		t.Errorf("received %+v", e)
+	}
+	select {
+	case e := <-other:
+		t.Errorf("another session's subscriber received %+v", e)
+	default:
+	}
+
+	unsubscribe()
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after unsubscribing")
+	}
+	// publishing after unsubscribing, and unsubscribing twice, must not panic
+	Emitter{Bus: b, SessionId: "s1"}.Emit(Event{Type: EventProcessorCompleted})
+	unsubscribe()
+}
+
+// TestEventBusForget tests that forgetting a session closes its subscriptions and drops its history
+func TestEventBusForget(t *testing.T) {
+	b := NewEventBus()
+	Emitter{Bus: b, SessionId: "s1"}.Emit(Event{Type: EventContainerCreated})
+	_, first, unsubscribeFirst := b.Subscribe("s1")
+	_, second, unsubscribeSecond := b.Subscribe("s1")
+
+	b.Forget("s1")
+	for _, ch := range []<-chan Event{first, second} {
+		if _, ok := <-ch; ok {
+			t.Error("subscription still open after Forget")
+		}
+	}
+	// unsubscribing from a forgotten session must not close the channel again
+	unsubscribeFirst()
+	unsubscribeSecond()
+
+	history, _, unsubscribe := b.Subscribe("s1")
+	defer unsubscribe()
+	if len(history) != 0 {
+		t.Errorf("history = %v, want none after Forget", history)
+	}
+}
+
+// TestEmitterZero tests that the zero Emitter and a nil bus discard events
+func TestEmitterZero(t *testing.T) {
+	Emitter{}.Emit(Event{Type: EventContainerCreated})
+	var b *EventBus
+	b.Publish(Event{Type: EventContainerCreated})
+	b.Forget("s1")
+}
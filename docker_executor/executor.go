@@ -2,35 +2,44 @@ package docker_executor
 
 import (
 	"fmt"
-	"net/http"
-	"strings"
-	"time"
+	"log/slog"
 )
 
 type Executor struct {
-	Docker   DockerClient
+	Docker   ContainerRuntime
 	Template TemplateVersionRes
+	Sessions *SessionRegistry
+	// Usage records the images and template volume the session uses, for garbage collection
+	Usage  *UsageStore
+	Events Emitter
+	Logger *slog.Logger
+}
+
+func (e Executor) log() *slog.Logger {
+	return loggerOrDefault(e.Logger)
 }
 
 type MergerReq struct {
 	MergerId string `json:"merger_id"`
 }
 
+// images are the processor and plugin images the session runs
+func (e Executor) images() []DockerImageReference {
+	var images []DockerImageReference
+	for _, processor := range e.Template.Processors {
+		images = append(images, NewDockerImageReference(processor.DockerReference, processor.DockerTag))
+	}
+	for _, plugin := range e.Template.Plugins {
+		images = append(images, NewDockerImageReference(plugin.DockerReference, plugin.DockerTag))
+	}
+	return images
+}
+
 func (e Executor) missingPluginsImages(images []DockerImageReference) []DockerImageReference {
 	var missing []DockerImageReference
 	for _, plugin := range e.Template.Plugins {
-		c := DockerImageReference{
-			Reference: plugin.DockerReference,
-			Tag:       plugin.DockerTag,
-		}
-		found := false
-		for _, i := range images {
-			if strings.HasSuffix(plugin.DockerReference, i.Reference) && i.Tag == plugin.DockerTag {
-				found = true
-				break
-			}
-		}
-		if !found {
+		c := NewDockerImageReference(plugin.DockerReference, plugin.DockerTag)
+		if !c.foundIn(images) {
 			missing = append(missing, c)
 		}
 	}
@@ -40,18 +49,8 @@ func (e Executor) missingPluginsImages(images []DockerImageReference) []DockerIm
 func (e Executor) missingProcessorImages(images []DockerImageReference) []DockerImageReference {
 	var missing []DockerImageReference
 	for _, processor := range e.Template.Processors {
-		c := DockerImageReference{
-			Reference: processor.DockerReference,
-			Tag:       processor.DockerTag,
-		}
-		found := false
-		for _, i := range images {
-			if strings.HasSuffix(processor.DockerReference, i.Reference) && i.Tag == processor.DockerTag {
-				found = true
-				break
-			}
-		}
-		if !found {
+		c := NewDockerImageReference(processor.DockerReference, processor.DockerTag)
+		if !c.foundIn(images) {
 			missing = append(missing, c)
 		}
 	}
@@ -62,7 +61,7 @@ func (e Executor) startMerger(session string, temVolRef, workVolRef DockerVolume
 
 	i, er := e.Docker.GetCoordinatorImage()
 	if er != nil {
-		fmt.Println("🚨 Error getting coordinator image", er)
+		e.log().Error("Error getting coordinator image", LogKeyError, er)
 		return er
 	}
 
@@ -71,22 +70,26 @@ func (e Executor) startMerger(session string, temVolRef, workVolRef DockerVolume
 		CyanType:  "merger",
 		SessionId: session,
 	}
-	fmt.Println("🚀 Starting merger [inner]", c.CyanId)
-	err := e.Docker.CreateContainerWithReadWriteVolume(c, temVolRef, workVolRef, i)
+	limits, err := e.Docker.Settings().Limits(c.CyanType, nil)
 	if err != nil {
-		fmt.Println("🚨 Error starting merger [inner]", c.CyanId, err)
 		return err
-	} else {
-		fmt.Println("✅ Successfully started merger [inner]", c.CyanId)
 	}
-	fmt.Println("🕠 Waiting for merger [inner]", c.CyanId, "to be ready...")
-	ep := "http://" + DockerContainerToString(c) + ":9000"
-	err = e.statusCheck(ep, 60)
+	e.log().Info("Starting merger", containerAttrs(c)...)
+	err = e.Docker.CreateContainerWithReadWriteVolume(c, temVolRef, workVolRef, i, limits)
 	if err != nil {
-		fmt.Println("🚨 Error waiting for merger [inner]", c.CyanId, err)
+		e.log().Error("Error starting merger", append(containerAttrs(c), LogKeyError, err)...)
+		return err
 	} else {
-		fmt.Println("✅ Merger [inner]", c.CyanId, "is ready")
+		e.log().Info("Successfully started merger", containerAttrs(c)...)
+	}
+	e.Sessions.AddContainers(session, c)
+	e.log().Info("Waiting for merger to be ready", containerAttrs(c)...)
+	err = newReadiness(e.Docker, e.Events, e.log()).wait(c)
+	if err != nil {
+		e.log().Error("Error waiting for merger", append(containerAttrs(c), LogKeyError, err)...)
+		return err
 	}
+	e.log().Info("Merger is ready", containerAttrs(c)...)
 	return nil
 }
 
@@ -95,41 +98,44 @@ func (e Executor) startProcessors(session string, temVolRef, workVolRef DockerVo
 	processors := e.Template.Processors
 
 	errChan := make(chan error, len(processors))
-	semaphore := make(chan int, e.Docker.ParallelismLimit)
+	semaphore := make(chan int, e.Docker.Parallelism())
 
 	for _, processor := range processors {
 		semaphore <- 0
-		i := DockerImageReference{
-			Reference: processor.DockerReference,
-			Tag:       processor.DockerTag,
-		}
+		i := NewDockerImageReference(processor.DockerReference, processor.DockerTag)
 		c := DockerContainerReference{
 			CyanId:    processor.ID,
 			CyanType:  "processor",
 			SessionId: session,
 		}
-		go func(container DockerContainerReference, image DockerImageReference) {
-			fmt.Println("🚀 Starting processor", container.CyanId)
-			err := e.Docker.CreateContainerWithReadWriteVolume(container, temVolRef, workVolRef, image)
+		go func(container DockerContainerReference, image DockerImageReference, requested *ResourceLimits) {
+			limits, err := e.Docker.Settings().Limits(container.CyanType, requested)
 			if err != nil {
-				fmt.Println("🚨 Error starting processor [inner]", container.CyanId, err)
+				errChan <- fmt.Errorf("%s: %w", container.CyanId, err)
+				<-semaphore
+				return
+			}
+			e.log().Info("Starting processor", containerAttrs(container)...)
+			err = e.Docker.CreateContainerWithReadWriteVolume(container, temVolRef, workVolRef, image, limits)
+			if err != nil {
+				e.log().Error("Error starting processor", append(containerAttrs(container), LogKeyError, err)...)
 				errChan <- err
 				<-semaphore
 				return
 			} else {
-				fmt.Println("✅ Successfully started processor [inner]", container.CyanId)
+				e.log().Info("Successfully started processor", containerAttrs(container)...)
 			}
-			fmt.Println("🕠 Waiting for processor [inner]", container.CyanId, "to be ready...")
-			ep := "http://" + DockerContainerToString(container) + ":5551"
-			err = e.statusCheck(ep, 60)
+			e.Sessions.AddContainers(session, container)
+			e.log().Info("Waiting for processor to be ready", containerAttrs(container)...)
+			err = newReadiness(e.Docker, e.Events, e.log()).wait(container)
 			if err != nil {
-				fmt.Println("🚨 Error waiting for processor [inner]", container.CyanId, err)
+				e.log().Error("Error waiting for processor", append(containerAttrs(container), LogKeyError, err)...)
 			} else {
-				fmt.Println("✅ Processor [inner]", container.CyanId, "is ready")
+				e.log().Info("Processor is ready", containerAttrs(container)...)
 			}
 			errChan <- err
 			<-semaphore
-		}(c, i)
+		}(c, i, processor.Resources)
 	}
 
 	var allErr []error
@@ -154,41 +160,44 @@ func (e Executor) startPlugins(session string, temVolRef, workVolRef DockerVolum
 	plugins := e.Template.Plugins
 
 	errChan := make(chan error, len(plugins))
-	semaphore := make(chan int, e.Docker.ParallelismLimit)
+	semaphore := make(chan int, e.Docker.Parallelism())
 
 	for _, plugin := range plugins {
 		semaphore <- 0
-		i := DockerImageReference{
-			Reference: plugin.DockerReference,
-			Tag:       plugin.DockerTag,
-		}
+		i := NewDockerImageReference(plugin.DockerReference, plugin.DockerTag)
 		c := DockerContainerReference{
 			CyanId:    plugin.ID,
 			CyanType:  "plugin",
 			SessionId: session,
 		}
-		go func(container DockerContainerReference, image DockerImageReference) {
-			fmt.Println("🚀 Starting plugin [inner]", container.CyanId)
-			err := e.Docker.CreateContainerWithReadWriteVolume(container, temVolRef, workVolRef, image)
+		go func(container DockerContainerReference, image DockerImageReference, requested *ResourceLimits) {
+			limits, err := e.Docker.Settings().Limits(container.CyanType, requested)
 			if err != nil {
-				fmt.Println("🚨 Error starting plugin [inner]", container.CyanId, err)
+				errChan <- fmt.Errorf("%s: %w", container.CyanId, err)
+				<-semaphore
+				return
+			}
+			e.log().Info("Starting plugin", containerAttrs(container)...)
+			err = e.Docker.CreateContainerWithReadWriteVolume(container, temVolRef, workVolRef, image, limits)
+			if err != nil {
+				e.log().Error("Error starting plugin", append(containerAttrs(container), LogKeyError, err)...)
 				errChan <- err
 				<-semaphore
 				return
 			} else {
-				fmt.Println("✅ Successfully started plugin [inner]", container.CyanId)
+				e.log().Info("Successfully started plugin", containerAttrs(container)...)
 			}
-			fmt.Println("🕠 Waiting for plugin [inner]", container.CyanId, "to be ready...")
-			ep := "http://" + DockerContainerToString(container) + ":5552"
-			err = e.statusCheck(ep, 60)
+			e.Sessions.AddContainers(session, container)
+			e.log().Info("Waiting for plugin to be ready", containerAttrs(container)...)
+			err = newReadiness(e.Docker, e.Events, e.log()).wait(container)
 			if err != nil {
-				fmt.Println("🚨 Error waiting for plugin [inner]", container.CyanId, err)
+				e.log().Error("Error waiting for plugin", append(containerAttrs(container), LogKeyError, err)...)
 			} else {
-				fmt.Println("✅ Plugin [inner]", container.CyanId, "is ready")
+				e.log().Info("Plugin is ready", containerAttrs(container)...)
 			}
 			errChan <- err
 			<-semaphore
-		}(c, i)
+		}(c, i, plugin.Resources)
 	}
 
 	var allErr []error
@@ -219,29 +228,29 @@ func (e Executor) listContainersVolumes() (
 	d := e.Docker
 
 	go func() {
-		fmt.Println("🔍 Looking for containers to clean...")
+		e.log().Debug("Looking for containers to clean")
 		runningContainers, stoppedContainers, err := d.ListContainer()
 		if err != nil {
-			fmt.Println("🚨 Error looking for containers", err)
+			e.log().Error("Error looking for containers", LogKeyError, err)
 			containerChan <- nil
 			errChan <- err
 		} else {
 			containerRefs := append(runningContainers, stoppedContainers...)
-			fmt.Println("✅ Successfully retrieved containers, found", len(containerRefs), "containers")
+			e.log().Debug("Successfully retrieved containers", "count", len(containerRefs))
 			containerChan <- containerRefs
 			errChan <- nil
 		}
 	}()
 
 	go func() {
-		fmt.Println("🔍 Looking for volumes to clean...")
+		e.log().Debug("Looking for volumes to clean")
 		volumes, err := d.ListVolumes()
 		if err != nil {
-			fmt.Println("🚨 Error looking for volumes", err)
+			e.log().Error("Error looking for volumes", LogKeyError, err)
 			errChan <- err
 			volumeChan <- nil
 		} else {
-			fmt.Println("✅ Successfully retrieved volumes, found", len(volumes), "volumes")
+			e.log().Debug("Successfully retrieved volumes", "count", len(volumes))
 			volumeChan <- volumes
 			errChan <- nil
 		}
@@ -263,37 +272,6 @@ func (e Executor) listContainersVolumes() (
 
 }
 
-func (e Executor) statusCheck(endpoint string, maxAttempts int) error {
-
-	for i := 0; i < maxAttempts; i++ {
-		// Send GET request
-		fmt.Println("🏓 Ping endpoint:", endpoint, "Attempt:", i+1)
-		resp, err := http.Get(endpoint)
-		if err != nil {
-			fmt.Println("🚨 Error:", err)
-			fmt.Println("⌛ Waiting for 1 second before next attempt...")
-			time.Sleep(1 * time.Second)
-			continue
-		}
-
-		if resp.StatusCode == http.StatusOK {
-			fmt.Println("✅ Health Check successful, Status code:", resp.StatusCode)
-			break // Escape from the loop if status code is 200
-		} else {
-			fmt.Println("🚨 Request failed! Status code:", resp.StatusCode)
-		}
-		if i == maxAttempts {
-			fmt.Println("🚨 Reached maximum attempts of", maxAttempts)
-			return fmt.Errorf("reached maximum attempts of %d", maxAttempts)
-		} else {
-			fmt.Println("⌛ Waiting for 1 second before next attempt...")
-			time.Sleep(1 * time.Second)
-		}
-	}
-
-	return nil
-}
-
 func (e Executor) Clean(session string) []error {
 	containers, volumes, err := e.listContainersVolumes()
 	if err != nil {
@@ -315,11 +293,20 @@ func (e Executor) Clean(session string) []error {
 	}
 
 	if errs := filterNilErrors(e.Docker.RemoveAllContainers(sessionContainer)); len(errs) > 0 {
+		e.Sessions.Fail(session, errs)
 		return errs
 	}
 	if errs := filterNilErrors(e.Docker.RemoveAllVolumes(sessionVolume)); len(errs) > 0 {
+		e.Sessions.Fail(session, errs)
 		return errs
 	}
+	if e.Docker.Settings().Isolation.SessionNetworks {
+		if err := e.Docker.RemoveSessionNetwork(session); err != nil {
+			e.Sessions.Fail(session, []error{err})
+			return []error{err}
+		}
+	}
+	e.Sessions.Clear(session)
 	return nil
 }
 
@@ -334,45 +321,82 @@ func filterNilErrors(errs []error) []error {
 	return filtered
 }
 
+// createSessionNetwork gives the session its own network, shared with the template and resolver containers it uses
+func (e Executor) createSessionNetwork(session string) error {
+	running, _, err := e.Docker.ListContainer()
+	if err != nil {
+		return err
+	}
+	var shared []DockerContainerReference
+	for _, c := range running {
+		if c.SessionId != "" {
+			continue
+		}
+		if c.CyanType == "template" && c.CyanId == e.Template.Principal.ID {
+			shared = append(shared, c)
+			continue
+		}
+		if c.CyanType == CyanTypeResolver {
+			for _, r := range e.Template.Resolvers {
+				if r.ID == c.CyanId {
+					shared = append(shared, c)
+					break
+				}
+			}
+		}
+	}
+	e.log().Info("Creating session network", "shared", len(shared))
+	return e.Docker.CreateSessionNetwork(session, shared)
+}
+
 func (e Executor) Start(session string, readVolRef, writeVolRef DockerVolumeReference, req MergerReq) []error {
 
+	e.Sessions.AddVolumes(session, writeVolRef)
+	e.Usage.Touch(session, e.images(), []DockerVolumeReference{readVolRef})
+	if e.Docker.Settings().Isolation.SessionNetworks {
+		if err := e.createSessionNetwork(session); err != nil {
+			e.log().Error("Error creating session network", LogKeyError, err)
+			e.Sessions.Fail(session, []error{err})
+			return []error{err}
+		}
+	}
 	errChan := make(chan []error)
 
 	// start processors
 	go func() {
-		fmt.Println("🚀 Starting processors...")
+		e.log().Info("Starting processors")
 		errs := e.startProcessors(session, readVolRef, writeVolRef)
 		if len(errs) > 0 {
-			fmt.Println("🚨 Error starting processors", errs)
+			e.log().Error("Error starting processors", "errors", errs)
 			errChan <- errs
 		} else {
-			fmt.Println("✅ Successfully started processors")
+			e.log().Info("Successfully started processors")
 			errChan <- nil
 		}
 	}()
 
 	// start plugins
 	go func() {
-		fmt.Println("🚀 Starting plugins...")
+		e.log().Info("Starting plugins")
 		errs := e.startPlugins(session, readVolRef, writeVolRef)
 		if len(errs) > 0 {
-			fmt.Println("🚨 Error starting plugins", errs)
+			e.log().Error("Error starting plugins", "errors", errs)
 			errChan <- errs
 		} else {
-			fmt.Println("✅ Successfully started plugins")
+			e.log().Info("Successfully started plugins")
 			errChan <- nil
 		}
 	}()
 
 	// start merger
 	go func() {
-		fmt.Println("🚀 Starting merger...")
+		e.log().Info("Starting merger")
 		err := e.startMerger(session, readVolRef, writeVolRef, req)
 		if err != nil {
-			fmt.Println("🚨 Error starting merger", err)
+			e.log().Error("Error starting merger", LogKeyError, err)
 			errChan <- []error{err}
 		} else {
-			fmt.Println("✅ Successfully started merger")
+			e.log().Info("Successfully started merger")
 			errChan <- nil
 		}
 	}()
@@ -385,21 +409,27 @@ func (e Executor) Start(session string, readVolRef, writeVolRef DockerVolumeRefe
 	allErrs = append(allErrs, e3...)
 
 	if len(allErrs) > 0 {
+		e.Sessions.Fail(session, allErrs)
 		return allErrs
 	}
+	e.Sessions.Transition(session, e.Template.Principal.ID, SessionStarted)
 	return nil
 
 }
 
 func (e Executor) Warm(session string) (string, DockerVolumeReference, []error) {
-	fmt.Println("🔑 Starting a new session:", session)
-	fmt.Println("🔍 Looking for images...")
+	e.log().Info("Starting a new session", LogKeySession, session)
+	e.Sessions.Transition(session, e.Template.Principal.ID, SessionWarming)
+	// record the use before looking for images, so collection can't evict them while the session warms
+	e.Usage.Touch(session, e.images(), []DockerVolumeReference{{CyanId: e.Template.Principal.ID}})
+	e.log().Debug("Looking for images")
 	images, err := e.Docker.ListImages()
 	if err != nil {
-		fmt.Println("🚨 Error looking for images", err)
+		e.log().Error("Error looking for images", LogKeyError, err)
+		e.Sessions.Fail(session, []error{err})
 		return session, DockerVolumeReference{}, []error{err}
 	} else {
-		fmt.Println("✅ Successfully retrieved images, found", len(images), "images")
+		e.log().Debug("Successfully retrieved images", "count", len(images))
 	}
 
 	missingPluginImages := e.missingPluginsImages(images)
@@ -412,34 +442,35 @@ func (e Executor) Warm(session string) (string, DockerVolumeReference, []error)
 	// pull missing image
 	go func() {
 		if len(missingImages) > 0 {
-			fmt.Println("📥 Pulling missing images...")
+			e.log().Info("Pulling missing images", "count", len(missingImages))
 			errs := e.Docker.PullImages(missingImages)
 			if len(errs) > 0 {
-				fmt.Println("🚨 Error pulling images", errs)
+				e.log().Error("Error pulling images", "errors", errs)
 				errChan <- errs
 			} else {
-				fmt.Println("✅ Successfully pulled images")
+				e.log().Info("Successfully pulled images")
 				errChan <- nil
 			}
 		} else {
-			fmt.Println("✅ No missing images")
+			e.log().Info("No missing images")
 			errChan <- nil
 		}
 	}()
 
 	// start session volume
 	go func() {
-		fmt.Println("📦 Creating session volume...")
+		e.log().Info("Creating session volume")
 		v := DockerVolumeReference{
 			CyanId:    e.Template.Principal.ID,
 			SessionId: session,
 		}
 		err = e.Docker.CreateVolume(v)
 		if err != nil {
-			fmt.Println("🚨 Error creating session volume", err)
+			e.log().Error("Error creating session volume", LogKeyError, err)
 			errChan <- []error{err}
 		} else {
-			fmt.Println("✅ Successfully created session volume")
+			e.log().Info("Successfully created session volume", LogKeyVolume, DockerVolumeToString(v))
+			e.Sessions.AddVolumes(session, v)
 			errChan <- nil
 		}
 		volRefChan <- v
@@ -450,9 +481,11 @@ func (e Executor) Warm(session string) (string, DockerVolumeReference, []error)
 	volRef := <-volRefChan
 
 	if len(e1) > 0 {
+		e.Sessions.Fail(session, e1)
 		return session, volRef, e1
 	}
 	if len(e2) > 0 {
+		e.Sessions.Fail(session, e2)
 		return session, volRef, e2
 	}
 
@@ -0,0 +1,338 @@
+package docker_executor
+
+import (
+	"errors"
+	"testing"
+)
+
+func testTemplate() TemplateVersionRes {
+	return TemplateVersionRes{
+		Principal: TemplateVersionPrincipalRes{
+			ID: "template-1",
+			Properties: &PropertyRes{
+				BlobDockerReference:     "registry.local/blob",
+				BlobDockerTag:           "1",
+				TemplateDockerReference: "registry.local/template",
+				TemplateDockerTag:       "1",
+			},
+		},
+		Processors: []ProcessorRes{
+			{ID: "processor-1", DockerReference: "registry.local/processor-a", DockerTag: "1"},
+			{ID: "processor-2", DockerReference: "registry.local/processor-b", DockerTag: "2"},
+		},
+		Plugins: []PluginRes{
+			{ID: "plugin-1", DockerReference: "registry.local/plugin-a", DockerTag: "1"},
+		},
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TestExecutorWarm tests that warming pulls only missing images and that warming again pulls nothing
+func TestExecutorWarm(t *testing.T) {
+	tests := []struct {
+		name      string
+		pinned    bool
+		present   []DockerImageReference
+		wantPulls int
+	}{
+		{name: "nothing cached", wantPulls: 3},
+		{
+			name:      "processor cached",
+			present:   []DockerImageReference{{Reference: "registry.local/processor-a", Tag: "1"}},
+			wantPulls: 2,
+		},
+		{
+			name: "everything cached",
+			present: []DockerImageReference{
+				{Reference: "registry.local/processor-a", Tag: "1"},
+				{Reference: "registry.local/processor-b", Tag: "2"},
+				{Reference: "registry.local/plugin-a", Tag: "1"},
+			},
+			wantPulls: 0,
+		},
+		{
+			name:    "pinned digest differs from cached tag",
+			pinned:  true,
+			present: []DockerImageReference{{Reference: "registry.local/processor-a", Tag: "1", Digest: "sha256:0000"}},
+			// everything is pulled by digest, including processor-a whose cached tag has moved on
+			wantPulls: 3,
+		},
+		{
+			name:      "pinned digest cached",
+			pinned:    true,
+			present:   []DockerImageReference{{Reference: "registry.local/processor-a", Tag: "1", Digest: testDigest}},
+			wantPulls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewFakeRuntime()
+			rt.AddImage(tt.present...)
+			sessions := NewSessionRegistry()
+			template := testTemplate()
+			if tt.pinned {
+				for i := range template.Processors {
+					template.Processors[i].DockerTag += "@" + testDigest
+				}
+				template.Plugins[0].DockerTag += "@" + testDigest
+			}
+			e := Executor{Docker: rt, Template: template, Sessions: sessions}
+
+			_, vol, errs := e.Warm("s1")
+			if len(errs) > 0 {
+				t.Fatalf("Warm() errors = %v", errs)
+			}
+			if got := len(rt.Pulls()); got != tt.wantPulls {
+				t.Errorf("pulls = %d, want %d", got, tt.wantPulls)
+			}
+			if !contains(rt.Volumes(), DockerVolumeToString(vol)) {
+				t.Errorf("session volume %s was not created", DockerVolumeToString(vol))
+			}
+
+			_, _, errs = e.Warm("s1")
+			if len(errs) > 0 {
+				t.Fatalf("second Warm() errors = %v", errs)
+			}
+			if got := len(rt.Pulls()); got != tt.wantPulls {
+				t.Errorf("pulls after second warm = %d, want %d", got, tt.wantPulls)
+			}
+			if got := len(rt.Volumes()); got != 1 {
+				t.Errorf("volumes after second warm = %d, want 1", got)
+			}
+		})
+	}
+}
+
+// TestExecutorWarmPullFailure tests that a failed pull fails the session
+func TestExecutorWarmPullFailure(t *testing.T) {
+	rt := NewFakeRuntime()
+	rt.FailOn(FakePullImage, "registry.local/plugin-a:1", errors.New("manifest unknown"))
+	sessions := NewSessionRegistry()
+	e := Executor{Docker: rt, Template: testTemplate(), Sessions: sessions}
+
+	_, _, errs := e.Warm("s1")
+	if len(errs) != 1 {
+		t.Fatalf("Warm() errors = %v, want 1 error", errs)
+	}
+	s, _ := sessions.Get("s1")
+	if s.State != SessionFailed {
+		t.Errorf("State = %q, want %q", s.State, SessionFailed)
+	}
+}
+
+// TestExecutorClean tests that cleaning removes only the session's own containers and volumes
+func TestExecutorClean(t *testing.T) {
+	own := []DockerContainerReference{
+		{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"},
+		{CyanId: "merger-1", CyanType: "merger", SessionId: "s1"},
+	}
+	other := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s2"}
+	template := DockerContainerReference{CyanId: "template-1", CyanType: "template"}
+	ownVol := DockerVolumeReference{CyanId: "template-1", SessionId: "s1"}
+	otherVol := DockerVolumeReference{CyanId: "template-1", SessionId: "s2"}
+
+	tests := []struct {
+		name      string
+		fail      func(rt *FakeRuntime)
+		wantErrs  int
+		wantState SessionState
+	}{
+		{name: "clean", wantState: SessionCleaned},
+		{
+			name: "container removal fails",
+			fail: func(rt *FakeRuntime) {
+				rt.FailOn(FakeRemoveContainer, DockerContainerToString(own[1]), errors.New("device busy"))
+			},
+			wantErrs:  1,
+			wantState: SessionFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewFakeRuntime()
+			for _, c := range append(own, other, template) {
+				rt.AddContainer(c, true)
+			}
+			rt.StopContainer(own[0])
+			rt.AddVolume(ownVol)
+			rt.AddVolume(otherVol)
+			if tt.fail != nil {
+				tt.fail(rt)
+			}
+			sessions := NewSessionRegistry()
+			sessions.Transition("s1", "template-1", SessionStarted)
+			e := Executor{Docker: rt, Sessions: sessions}
+
+			errs := e.Clean("s1")
+			if len(errs) != tt.wantErrs {
+				t.Fatalf("Clean() errors = %v, want %d", errs, tt.wantErrs)
+			}
+			s, _ := sessions.Get("s1")
+			if s.State != tt.wantState {
+				t.Errorf("State = %q, want %q", s.State, tt.wantState)
+			}
+			for _, c := range []DockerContainerReference{other, template} {
+				if !contains(rt.Containers(), DockerContainerToString(c)) {
+					t.Errorf("container %s of another session was removed", DockerContainerToString(c))
+				}
+			}
+			if !contains(rt.Volumes(), DockerVolumeToString(otherVol)) {
+				t.Errorf("volume of another session was removed")
+			}
+			if tt.wantErrs == 0 {
+				if contains(rt.Containers(), DockerContainerToString(own[0])) || contains(rt.Volumes(), DockerVolumeToString(ownVol)) {
+					t.Errorf("session resources left behind: containers %v, volumes %v", rt.Containers(), rt.Volumes())
+				}
+			}
+		})
+	}
+}
+
+// TestExecutorSessionNetwork tests that a session's network holds only the shared containers it uses, that its
+// containers can't start without it, and that cleaning removes it
+func TestExecutorSessionNetwork(t *testing.T) {
+	rt := NewFakeRuntime()
+	rt.Config.Isolation.SessionNetworks = true
+	template := testTemplate()
+	template.Resolvers = []ResolverRes{{ID: "resolver-1"}}
+	shared := []DockerContainerReference{
+		{CyanId: "template-1", CyanType: "template"},
+		{CyanId: "resolver-1", CyanType: CyanTypeResolver},
+	}
+	unrelated := []DockerContainerReference{
+		{CyanId: "template-2", CyanType: "template"},
+		{CyanId: "resolver-2", CyanType: CyanTypeResolver},
+		{CyanId: "processor-1", CyanType: "processor", SessionId: "s2"},
+	}
+	for _, c := range append(shared, unrelated...) {
+		rt.AddContainer(c, true)
+	}
+	rt.AddImage(DockerImageReference{Reference: "registry.local/processor-a", Tag: "1"})
+	sessions := NewSessionRegistry()
+	e := Executor{Docker: rt, Template: template, Sessions: sessions}
+
+	processor := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"}
+	image := DockerImageReference{Reference: "registry.local/processor-a", Tag: "1"}
+	if err := rt.CreateContainer(processor, image, ResourceLimits{}); err == nil {
+		t.Fatal("processor started without its session network")
+	}
+
+	if err := e.createSessionNetwork("s1"); err != nil {
+		t.Fatalf("createSessionNetwork() error = %v", err)
+	}
+	attached, ok := rt.SessionNetwork("s1")
+	want := []string{"coordinator", DockerContainerToString(shared[1]), DockerContainerToString(shared[0])}
+	if !ok || len(attached) != len(want) {
+		t.Fatalf("attached = %v, want %v", attached, want)
+	}
+	for _, name := range want {
+		if !contains(attached, name) {
+			t.Errorf("attached = %v, missing %s", attached, name)
+		}
+	}
+	if err := rt.CreateContainer(processor, image, ResourceLimits{}); err != nil {
+		t.Fatalf("CreateContainer() error = %v", err)
+	}
+
+	sessions.Transition("s1", "template-1", SessionStarted)
+	if errs := e.Clean("s1"); len(errs) > 0 {
+		t.Fatalf("Clean() errors = %v", errs)
+	}
+	if _, ok := rt.SessionNetwork("s1"); ok {
+		t.Error("session network left behind")
+	}
+}
+
+func testTryRequest(source string) TryExecutorReq {
+	return TryExecutorReq{
+		SessionId:       "s1",
+		LocalTemplateId: "local-1",
+		Source:          source,
+		Path:            "/home/user/template",
+		Template:        testTemplate(),
+	}
+}
+
+// TestTrySetup tests that try setup populates the blob volume, pulls images and is idempotent
+func TestTrySetup(t *testing.T) {
+	blob := DockerImageReference{Reference: "registry.local/blob", Tag: "1"}
+	for _, source := range []string{"image", "path"} {
+		t.Run(source, func(t *testing.T) {
+			rt := NewFakeRuntime()
+			rt.AddImage(blob)
+			e := TryExecutor{Docker: rt, Request: testTryRequest(source)}
+
+			res, errs := e.TrySetup()
+			if len(errs) > 0 {
+				t.Fatalf("TrySetup() errors = %v", errs)
+			}
+			if !contains(rt.Volumes(), DockerVolumeToString(res.BlobVolume)) {
+				t.Errorf("blob volume %s was not created", DockerVolumeToString(res.BlobVolume))
+			}
+			if got := len(rt.Containers()); got != 0 {
+				t.Errorf("helper containers left behind: %v", rt.Containers())
+			}
+			if got := len(rt.Pulls()); got != 3 {
+				t.Errorf("pulls = %d, want 3", got)
+			}
+
+			if _, errs := e.TrySetup(); len(errs) > 0 {
+				t.Fatalf("second TrySetup() errors = %v", errs)
+			}
+			if got := len(rt.Pulls()); got != 3 {
+				t.Errorf("pulls after second setup = %d, want 3", got)
+			}
+		})
+	}
+}
+
+// TestTrySetupRollback tests that a failed blob extraction removes the half-populated volume and its helper container
+func TestTrySetupRollback(t *testing.T) {
+	blob := DockerImageReference{Reference: "registry.local/blob", Tag: "1"}
+	unzip := DockerContainerToString(DockerContainerReference{CyanId: "local-1", CyanType: "unzip", SessionId: "s1"})
+	copyHelper := DockerContainerToString(DockerContainerReference{CyanId: "local-1", CyanType: "copy-helper"})
+
+	tests := []struct {
+		name   string
+		source string
+		fail   func(rt *FakeRuntime)
+	}{
+		{name: "unzip exits non-zero", source: "image", fail: func(rt *FakeRuntime) { rt.SetExitCode(unzip, 2) }},
+		{name: "unzip wait fails", source: "image", fail: func(rt *FakeRuntime) {
+			rt.FailOn(FakeWaitContainer, unzip, errors.New("connection reset"))
+		}},
+		{name: "copy exits non-zero", source: "path", fail: func(rt *FakeRuntime) { rt.SetExitCode(copyHelper, 1) }},
+		{name: "copy cannot start", source: "path", fail: func(rt *FakeRuntime) {
+			rt.FailOn(FakeCreateContainer, copyHelper, errors.New("bind source path does not exist"))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewFakeRuntime()
+			rt.AddImage(blob)
+			tt.fail(rt)
+			e := TryExecutor{Docker: rt, Request: testTryRequest(tt.source)}
+
+			if _, errs := e.TrySetup(); len(errs) == 0 {
+				t.Fatal("TrySetup() expected errors")
+			}
+			if got := rt.Volumes(); len(got) != 0 {
+				t.Errorf("volumes left behind: %v", got)
+			}
+			if got := rt.Containers(); len(got) != 0 {
+				t.Errorf("containers left behind: %v", got)
+			}
+			if got := len(rt.Pulls()); got != 0 {
+				t.Errorf("pulls = %d, want none after a failed blob", got)
+			}
+		})
+	}
+}
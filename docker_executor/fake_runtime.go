@@ -0,0 +1,790 @@
+package docker_executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeOp names a FakeRuntime operation that can be made to fail with FailOn
+type FakeOp string
+
+const (
+	FakeCreateContainer FakeOp = "create_container"
+	FakeWaitContainer   FakeOp = "wait_container"
+	FakeRemoveContainer FakeOp = "remove_container"
+	FakeCreateVolume    FakeOp = "create_volume"
+	FakeRemoveVolume    FakeOp = "remove_volume"
+	FakePullImage       FakeOp = "pull_image"
+	FakeRemoveImage     FakeOp = "remove_image"
+	// FakeCreateNetwork fails CreateSessionNetwork, where the name is the session, and CreateNetwork, where
+	// it is the cyanprint network
+	FakeCreateNetwork FakeOp = "create_network"
+)
+
+type fakeContainer struct {
+	ref      DockerContainerReference
+	image    string
+	running  bool
+	exitCode int
+	oomKill  bool
+	limits   ResourceLimits
+	volumes  []string
+	created  time.Time
+	template string
+	logs     []string
+	// stopped is closed when the container stops, for WatchContainer
+	stopped chan struct{}
+}
+
+func newFakeContainer(c fakeContainer) *fakeContainer {
+	c.stopped = make(chan struct{})
+	if !c.running {
+		close(c.stopped)
+	}
+	return &c
+}
+
+func (c *fakeContainer) stop() {
+	if c.running {
+		c.running = false
+		close(c.stopped)
+	}
+}
+
+type fakeVolume struct {
+	ref     DockerVolumeReference
+	created time.Time
+}
+
+// FakeRuntime is an in-memory ContainerRuntime for tests. Containers run until waited on or stopped,
+// and exit with the code set by SetExitCode (0 by default). Operations can be made to fail with FailOn.
+// It is safe for concurrent use.
+type FakeRuntime struct {
+	// Config is returned by Settings; unset fields fall back to DefaultConfig
+	Config Config
+	// Coordinator is returned by GetCoordinatorImage
+	Coordinator DockerImageReference
+	// Now stamps created resources; defaults to time.Now
+	Now func() time.Time
+
+	mutex      sync.Mutex
+	containers map[string]*fakeContainer
+	volumes    map[string]fakeVolume
+	images     map[string]DockerImageReference
+	networks   map[string]bool
+	// sessionNetworks holds the names attached to each session's network
+	sessionNetworks map[string][]string
+	exitCodes       map[string]int
+	failures        map[FakeOp]map[string]error
+	pulls           []DockerImageReference
+	sizes           map[string]ByteSize
+	// malformed holds containers whose names don't parse
+	malformed map[string]bool
+	// coordinatorDetached is set when the coordinator is off the cyanprint network
+	coordinatorDetached bool
+	// coordinatorOutside is set when the coordinator doesn't run in a container
+	coordinatorOutside bool
+}
+
+var _ ContainerRuntime = (*FakeRuntime)(nil)
+
+func NewFakeRuntime() *FakeRuntime {
+	return &FakeRuntime{
+		Coordinator:     DockerImageReference{Reference: "ghcr.io/atomicloud/sulfone.boron/sulfone-boron", Tag: "latest"},
+		containers:      make(map[string]*fakeContainer),
+		volumes:         make(map[string]fakeVolume),
+		images:          make(map[string]DockerImageReference),
+		networks:        make(map[string]bool),
+		sessionNetworks: make(map[string][]string),
+		exitCodes:       make(map[string]int),
+		failures:        make(map[FakeOp]map[string]error),
+		sizes:           make(map[string]ByteSize),
+		malformed:       make(map[string]bool),
+	}
+}
+
+// FailOn makes op fail with err for the resource with the given name, e.g. DockerContainerToString(ref).
+// A nil err clears the failure.
+func (f *FakeRuntime) FailOn(op FakeOp, name string, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.failures[op] == nil {
+		f.failures[op] = make(map[string]error)
+	}
+	if err == nil {
+		delete(f.failures[op], name)
+		return
+	}
+	f.failures[op][name] = err
+}
+
+// SetExitCode sets the code the named container exits with when waited on or stopped. It applies to
+// containers created or added after it is set.
+func (f *FakeRuntime) SetExitCode(name string, code int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.exitCodes[name] = code
+}
+
+// AddImage makes images present as if already pulled
+func (f *FakeRuntime) AddImage(images ...DockerImageReference) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, i := range images {
+		f.images[DockerImageToString(i)] = i
+	}
+}
+
+// AddContainer adds an existing container, running or stopped
+func (f *FakeRuntime) AddContainer(ref DockerContainerReference, running bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := DockerContainerToString(ref)
+	f.containers[name] = newFakeContainer(fakeContainer{ref: ref, running: running, exitCode: f.exitCodes[name], created: f.now(), template: containerTemplate(ref, DockerVolumeReference{})})
+}
+
+// AddVolume adds an existing volume
+func (f *FakeRuntime) AddVolume(ref DockerVolumeReference) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.volumes[DockerVolumeToString(ref)] = fakeVolume{ref: ref, created: f.now()}
+}
+
+// AddMalformedContainer adds a cyanprint container whose name doesn't parse
+func (f *FakeRuntime) AddMalformedContainer(name string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.malformed[name] = true
+}
+
+// DetachCoordinator takes the coordinator off the cyanprint network
+func (f *FakeRuntime) DetachCoordinator() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.coordinatorDetached = true
+}
+
+// RunCoordinatorOutside makes the coordinator run on the host rather than in a container
+func (f *FakeRuntime) RunCoordinatorOutside() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.coordinatorOutside = true
+}
+
+// StopContainer stops a running container, as if it had crashed
+func (f *FakeRuntime) StopContainer(ref DockerContainerReference) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if c, ok := f.containers[DockerContainerToString(ref)]; ok {
+		c.stop()
+	}
+}
+
+// OOMKill stops a running container as if it had exceeded its memory limit
+func (f *FakeRuntime) OOMKill(ref DockerContainerReference) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if c, ok := f.containers[DockerContainerToString(ref)]; ok {
+		c.stop()
+		c.oomKill = true
+		c.exitCode = 137
+	}
+}
+
+// SetLogs sets the lines the container has written, as returned by ContainerLogs
+func (f *FakeRuntime) SetLogs(ref DockerContainerReference, lines ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if c, ok := f.containers[DockerContainerToString(ref)]; ok {
+		c.logs = lines
+	}
+}
+
+// SetSize sets the disk space DiskUsage reports for the named image or volume
+func (f *FakeRuntime) SetSize(name string, size ByteSize) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.sizes[name] = size
+}
+
+// Limits returns the resource limits the named container was created with
+func (f *FakeRuntime) Limits(name string) ResourceLimits {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if c, ok := f.containers[name]; ok {
+		return c.limits
+	}
+	return ResourceLimits{}
+}
+
+// Containers returns the names of all containers, sorted
+func (f *FakeRuntime) Containers() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return sortedKeys(f.containers)
+}
+
+// Volumes returns the names of all volumes, sorted
+func (f *FakeRuntime) Volumes() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return sortedKeys(f.volumes)
+}
+
+// Images returns the names of all images, sorted
+func (f *FakeRuntime) Images() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return sortedKeys(f.images)
+}
+
+// Pulls returns every image pull attempted, in order
+func (f *FakeRuntime) Pulls() []DockerImageReference {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]DockerImageReference(nil), f.pulls...)
+}
+
+// ContainerVolumes returns the names of the volumes mounted into the named container
+func (f *FakeRuntime) ContainerVolumes(name string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if c, ok := f.containers[name]; ok {
+		return append([]string(nil), c.volumes...)
+	}
+	return nil
+}
+
+// SessionNetwork returns the names attached to the session's network, sorted, and whether it exists.
+// The coordinator is attached as "coordinator".
+func (f *FakeRuntime) SessionNetwork(session string) ([]string, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	attached, ok := f.sessionNetworks[session]
+	attached = append([]string(nil), attached...)
+	sort.Strings(attached)
+	return attached, ok
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *FakeRuntime) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+	return time.Now()
+}
+
+// failure returns the injected error for op on name; the caller must hold the mutex
+func (f *FakeRuntime) failure(op FakeOp, name string) error {
+	return f.failures[op][name]
+}
+
+func (f *FakeRuntime) ListContainer() ([]DockerContainerReference, []DockerContainerReference, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var running, stopped []DockerContainerReference
+	for _, name := range sortedKeys(f.containers) {
+		c := f.containers[name]
+		if c.running {
+			running = append(running, c.ref)
+		} else {
+			stopped = append(stopped, c.ref)
+		}
+	}
+	return running, stopped, nil
+}
+
+func (f *FakeRuntime) createContainer(cc DockerContainerReference, image DockerImageReference, limits ResourceLimits, volumes ...DockerVolumeReference) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := DockerContainerToString(cc)
+	if err := f.failure(FakeCreateContainer, name); err != nil {
+		return err
+	}
+	if _, exists := f.containers[name]; exists {
+		return fmt.Errorf("container %s already exists", name)
+	}
+	imageName := DockerImageToString(image)
+	// the coordinator's own image is always present, since it is running
+	if _, ok := f.images[imageName]; !ok && image != f.Coordinator {
+		return fmt.Errorf("no such image: %s", imageName)
+	}
+	if f.Settings().sessionNetworked(cc) {
+		if _, ok := f.sessionNetworks[cc.SessionId]; !ok {
+			return fmt.Errorf("no such network: %s", f.Settings().sessionNetwork(cc.SessionId))
+		}
+	}
+	var templateVolume DockerVolumeReference
+	if len(volumes) > 0 {
+		templateVolume = volumes[0]
+	}
+	c := newFakeContainer(fakeContainer{ref: cc, image: imageName, running: true, exitCode: f.exitCodes[name], limits: limits, created: f.now(), template: containerTemplate(cc, templateVolume)})
+	for _, v := range volumes {
+		vName := DockerVolumeToString(v)
+		if _, ok := f.volumes[vName]; !ok {
+			return fmt.Errorf("no such volume: %s", vName)
+		}
+		c.volumes = append(c.volumes, vName)
+	}
+	f.containers[name] = c
+	return nil
+}
+
+func (f *FakeRuntime) CreateContainer(cc DockerContainerReference, image DockerImageReference, limits ResourceLimits) error {
+	return f.createContainer(cc, image, limits)
+}
+
+func (f *FakeRuntime) CreateContainerWithVolume(cc DockerContainerReference, v DockerVolumeReference, image DockerImageReference, limits ResourceLimits) error {
+	return f.createContainer(cc, image, limits, v)
+}
+
+func (f *FakeRuntime) CreateContainerWithReadWriteVolume(cc DockerContainerReference, readVolume, writeVolume DockerVolumeReference, image DockerImageReference, limits ResourceLimits) error {
+	return f.createContainer(cc, image, limits, readVolume, writeVolume)
+}
+
+// CreateContainerWithCopyMount runs the copy helper to completion and removes it, like DockerClient
+func (f *FakeRuntime) CreateContainerWithCopyMount(cc DockerContainerReference, sourcePath string, targetVolume DockerVolumeReference) error {
+	if err := f.createContainer(cc, f.Coordinator, ResourceLimits{}, targetVolume); err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := DockerContainerToString(cc)
+	c := f.containers[name]
+	delete(f.containers, name)
+	if c.exitCode != 0 {
+		return fmt.Errorf("copy container failed with exit code %d", c.exitCode)
+	}
+	return nil
+}
+
+func (f *FakeRuntime) WaitContainer(ref DockerContainerReference) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := DockerContainerToString(ref)
+	if err := f.failure(FakeWaitContainer, name); err != nil {
+		return -1, err
+	}
+	c, ok := f.containers[name]
+	if !ok {
+		return -1, fmt.Errorf("no such container: %s", name)
+	}
+	c.stop()
+	return c.exitCode, nil
+}
+
+func (f *FakeRuntime) InspectContainer(ref DockerContainerReference) (ContainerState, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := DockerContainerToString(ref)
+	c, ok := f.containers[name]
+	if !ok {
+		return ContainerState{}, fmt.Errorf("no such container: %s", name)
+	}
+	state := ContainerState{Running: c.running, OOMKilled: c.oomKill, Limits: c.limits, Created: c.created, Template: c.template}
+	state.Image, _ = DockerImageToStruct(c.image)
+	if !c.running {
+		state.ExitCode = c.exitCode
+	}
+	return state, nil
+}
+
+func (f *FakeRuntime) RestartContainer(ref DockerContainerReference) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := DockerContainerToString(ref)
+	c, ok := f.containers[name]
+	if !ok {
+		return fmt.Errorf("no such container: %s", name)
+	}
+	if !c.running {
+		c.running, c.oomKill = true, false
+		c.stopped = make(chan struct{})
+	}
+	return nil
+}
+
+func (f *FakeRuntime) ListMalformedContainers() ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return sortedKeys(f.malformed), nil
+}
+
+func (f *FakeRuntime) RemoveContainerNamed(name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.failure(FakeRemoveContainer, name); err != nil {
+		return err
+	}
+	if !f.malformed[name] && f.containers[name] == nil {
+		return fmt.Errorf("no such container: %s", name)
+	}
+	delete(f.malformed, name)
+	delete(f.containers, name)
+	return nil
+}
+
+func (f *FakeRuntime) WatchContainer(ref DockerContainerReference) (<-chan struct{}, func()) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c, ok := f.containers[DockerContainerToString(ref)]
+	if !ok {
+		return nil, func() {}
+	}
+	return c.stopped, func() {}
+}
+
+func (f *FakeRuntime) ContainerLogs(ref DockerContainerReference, lines int) ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := DockerContainerToString(ref)
+	c, ok := f.containers[name]
+	if !ok {
+		return nil, fmt.Errorf("no such container: %s", name)
+	}
+	logs := c.logs
+	if len(logs) > lines {
+		logs = logs[len(logs)-lines:]
+	}
+	return append([]string(nil), logs...), nil
+}
+
+// StreamLogs returns the lines set with SetLogs; a followed stream ends after them too
+func (f *FakeRuntime) StreamLogs(ref DockerContainerReference, opts LogOptions) (io.ReadCloser, error) {
+	lines := opts.Tail
+	if lines <= 0 {
+		lines = math.MaxInt
+	}
+	logs, err := f.ContainerLogs(ref, lines)
+	if err != nil {
+		return nil, err
+	}
+	var out strings.Builder
+	for _, line := range logs {
+		out.WriteString(line + "\n")
+	}
+	return io.NopCloser(strings.NewReader(out.String())), nil
+}
+
+func (f *FakeRuntime) RemoveContainer(cc DockerContainerReference) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := DockerContainerToString(cc)
+	if err := f.failure(FakeRemoveContainer, name); err != nil {
+		return err
+	}
+	if _, ok := f.containers[name]; !ok {
+		return fmt.Errorf("no such container: %s", name)
+	}
+	delete(f.containers, name)
+	return nil
+}
+
+func (f *FakeRuntime) RemoveAllContainers(containerRefs []DockerContainerReference) []error {
+	errs := make([]error, len(containerRefs))
+	for i, ref := range containerRefs {
+		errs[i] = f.RemoveContainer(ref)
+	}
+	return errs
+}
+
+func (f *FakeRuntime) ListVolumes() ([]DockerVolumeReference, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var volumes []DockerVolumeReference
+	for _, name := range sortedKeys(f.volumes) {
+		volumes = append(volumes, f.volumes[name].ref)
+	}
+	return volumes, nil
+}
+
+// DiskUsage reports the sizes set with SetSize. Images have no creation time.
+func (f *FakeRuntime) DiskUsage() ([]StoredImage, []StoredVolume, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	used := make(map[string]bool)
+	for _, c := range f.containers {
+		used[c.image] = true
+		for _, v := range c.volumes {
+			used[v] = true
+		}
+	}
+	var images []StoredImage
+	for _, name := range sortedKeys(f.images) {
+		images = append(images, StoredImage{Refs: []DockerImageReference{f.images[name]}, Size: f.sizes[name], InUse: used[name]})
+	}
+	var volumes []StoredVolume
+	for _, name := range sortedKeys(f.volumes) {
+		v := f.volumes[name]
+		volumes = append(volumes, StoredVolume{Volume: v.ref, Size: f.sizes[name], Created: v.created, InUse: used[name]})
+	}
+	return images, volumes, nil
+}
+
+// CreateVolume is idempotent, like creating a named Docker volume
+func (f *FakeRuntime) CreateVolume(vol DockerVolumeReference) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := DockerVolumeToString(vol)
+	if err := f.failure(FakeCreateVolume, name); err != nil {
+		return err
+	}
+	if _, exists := f.volumes[name]; !exists {
+		f.volumes[name] = fakeVolume{ref: vol, created: f.now()}
+	}
+	return nil
+}
+
+func (f *FakeRuntime) RemoveVolume(vol DockerVolumeReference) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := DockerVolumeToString(vol)
+	if err := f.failure(FakeRemoveVolume, name); err != nil {
+		return err
+	}
+	if _, ok := f.volumes[name]; !ok {
+		return fmt.Errorf("no such volume: %s", name)
+	}
+	for _, c := range f.containers {
+		for _, v := range c.volumes {
+			if v == name {
+				return fmt.Errorf("volume %s is in use by %s", name, DockerContainerToString(c.ref))
+			}
+		}
+	}
+	delete(f.volumes, name)
+	return nil
+}
+
+func (f *FakeRuntime) RemoveAllVolumes(volRefs []DockerVolumeReference) []error {
+	errs := make([]error, len(volRefs))
+	for i, ref := range volRefs {
+		errs[i] = f.RemoveVolume(ref)
+	}
+	return errs
+}
+
+func (f *FakeRuntime) ListImages() ([]DockerImageReference, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var images []DockerImageReference
+	for _, name := range sortedKeys(f.images) {
+		images = append(images, f.images[name])
+	}
+	return images, nil
+}
+
+func (f *FakeRuntime) PullImages(images []DockerImageReference) []error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var errs []error
+	for _, i := range images {
+		name := DockerImageToString(i)
+		f.pulls = append(f.pulls, i)
+		if err := f.failure(FakePullImage, name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		f.images[name] = i
+	}
+	return errs
+}
+
+// SaveImages writes the images' names as the archive
+func (f *FakeRuntime) SaveImages(images []DockerImageReference, w io.Writer) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var names []string
+	for _, i := range images {
+		name := DockerImageToString(i)
+		if _, ok := f.images[name]; !ok && i != f.Coordinator {
+			return fmt.Errorf("no such image: %s", name)
+		}
+		names = append(names, name)
+	}
+	return json.NewEncoder(w).Encode(names)
+}
+
+// LoadImages adds the images of an archive from SaveImages
+func (f *FakeRuntime) LoadImages(r io.Reader, images []DockerImageReference) error {
+	var names []string
+	if err := json.NewDecoder(r).Decode(&names); err != nil {
+		return fmt.Errorf("invalid image archive: %w", err)
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, name := range names {
+		i, err := DockerImageToStruct(name)
+		if err != nil {
+			return err
+		}
+		f.images[name] = i
+	}
+	return nil
+}
+
+func (f *FakeRuntime) GetCoordinatorImage() (DockerImageReference, error) {
+	return f.Coordinator, nil
+}
+
+func (f *FakeRuntime) RemoveImage(imageRef DockerImageReference) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := DockerImageToString(imageRef)
+	if err := f.failure(FakeRemoveImage, name); err != nil {
+		return err
+	}
+	if _, ok := f.images[name]; !ok {
+		return fmt.Errorf("no such image: %s", name)
+	}
+	delete(f.images, name)
+	return nil
+}
+
+func (f *FakeRuntime) RemoveAllImages(imageRefs []DockerImageReference) []error {
+	errs := make([]error, len(imageRefs))
+	for i, ref := range imageRefs {
+		errs[i] = f.RemoveImage(ref)
+	}
+	return errs
+}
+
+func (f *FakeRuntime) CyanPrintNetworkExist() (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.networks[f.Settings().Network], nil
+}
+
+func (f *FakeRuntime) CreateNetwork() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	network := f.Settings().Network
+	if err := f.failure(FakeCreateNetwork, network); err != nil {
+		return err
+	}
+	if f.networks[network] {
+		return fmt.Errorf("network %s already exists", network)
+	}
+	f.networks[network] = true
+	return nil
+}
+
+func (f *FakeRuntime) EnforceNetwork() error {
+	exist, _ := f.CyanPrintNetworkExist()
+	if exist {
+		return nil
+	}
+	return f.CreateNetwork()
+}
+
+func (f *FakeRuntime) CoordinatorAttached() (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.coordinatorOutside {
+		return false, ErrNoCoordinatorContainer
+	}
+	return f.networks[f.Settings().Network] && !f.coordinatorDetached, nil
+}
+
+func (f *FakeRuntime) AttachCoordinator() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if network := f.Settings().Network; !f.networks[network] {
+		return fmt.Errorf("network %s not found", network)
+	}
+	f.coordinatorDetached = false
+	return nil
+}
+
+func (f *FakeRuntime) NetworkSubnets() ([]string, error) {
+	exist, _ := f.CyanPrintNetworkExist()
+	if !exist {
+		return nil, fmt.Errorf("network %s not found", f.Settings().Network)
+	}
+	return []string{"172.20.0.0/16"}, nil
+}
+
+func (f *FakeRuntime) CreateSessionNetwork(session string, shared []DockerContainerReference) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if err := f.failure(FakeCreateNetwork, session); err != nil {
+		return err
+	}
+	attached := f.sessionNetworks[session]
+	if attached == nil {
+		attached = []string{"coordinator"}
+	}
+	for _, c := range shared {
+		name := DockerContainerToString(c)
+		if _, ok := f.containers[name]; !ok {
+			return fmt.Errorf("no such container: %s", name)
+		}
+		if !slices.Contains(attached, name) {
+			attached = append(attached, name)
+		}
+	}
+	f.sessionNetworks[session] = attached
+	return nil
+}
+
+func (f *FakeRuntime) RemoveSessionNetwork(session string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.sessionNetworks, session)
+	return nil
+}
+
+func (f *FakeRuntime) ListSessionNetworks() ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return sortedKeys(f.sessionNetworks), nil
+}
+
+func (f *FakeRuntime) ListSessionActivity() (map[string]time.Time, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	activity := make(map[string]time.Time)
+	record := func(session string, t time.Time) {
+		if session == "" {
+			return
+		}
+		if last, ok := activity[session]; !ok || t.After(last) {
+			activity[session] = t
+		}
+	}
+	for _, c := range f.containers {
+		record(c.ref.SessionId, c.created)
+	}
+	for _, v := range f.volumes {
+		record(v.ref.SessionId, v.created)
+	}
+	return activity, nil
+}
+
+func (f *FakeRuntime) OperationContext() context.Context {
+	return context.Background()
+}
+
+func (f *FakeRuntime) Parallelism() int {
+	return 1
+}
+
+func (f *FakeRuntime) Settings() Config {
+	return f.Config.withDefaults()
+}
@@ -0,0 +1,210 @@
+package docker_executor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// StoredImage is an image the runtime stores, under every reference it is known by
+type StoredImage struct {
+	Refs []DockerImageReference
+	Size ByteSize
+	// Created is when the image was built, which is what eviction falls back to when its use wasn't recorded
+	Created time.Time
+	// InUse is true while any container, running or stopped, was created from the image
+	InUse bool
+}
+
+// StoredVolume is a volume the runtime stores
+type StoredVolume struct {
+	Volume  DockerVolumeReference
+	Size    ByteSize
+	Created time.Time
+	// InUse is true while any container mounts the volume
+	InUse bool
+}
+
+// GCResult reports a garbage collection. Sizes are of every cyanprint image and volume, not only the evicted.
+type GCResult struct {
+	Before         ByteSize `json:"before"`
+	After          ByteSize `json:"after"`
+	Budget         ByteSize `json:"budget"`
+	ImagesRemoved  []string `json:"images_removed"`
+	VolumesRemoved []string `json:"volumes_removed"`
+}
+
+// GarbageCollector keeps the disk space of cyanprint images and volumes under Budget by evicting the
+// least recently used template, processor, plugin and resolver images and template volumes, as recorded
+// by Usage. It never evicts what a container uses, what the last session to use it still holds, the
+// coordinator's own image, or session volumes, which the Reaper cleans with their session.
+type GarbageCollector struct {
+	Docker   ContainerRuntime
+	Usage    *UsageStore
+	Sessions *SessionRegistry
+	Budget   ByteSize
+	Interval time.Duration
+	Logger   *slog.Logger
+}
+
+func (g GarbageCollector) log() *slog.Logger {
+	return loggerOrDefault(g.Logger)
+}
+
+// Run collects every Interval until the context is cancelled
+func (g GarbageCollector) Run(ctx context.Context) {
+	if g.Budget <= 0 || g.Interval <= 0 {
+		g.log().Info("Garbage collection disabled")
+		return
+	}
+	g.log().Info("Garbage collection started", "budget", g.Budget, "interval", g.Interval)
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			g.log().Info("Garbage collection stopped")
+			return
+		case <-ticker.C:
+			res, errs := g.Collect()
+			for _, err := range errs {
+				g.log().Error("Error collecting garbage", LogKeyError, err)
+			}
+			if len(res.ImagesRemoved) > 0 || len(res.VolumesRemoved) > 0 {
+				g.log().Info("Evicted least recently used resources", "images", res.ImagesRemoved, "volumes", res.VolumesRemoved,
+					"before", res.Before, "after", res.After)
+			}
+		}
+	}
+}
+
+// gcCandidate is an image or template volume that may be evicted
+type gcCandidate struct {
+	image    *StoredImage
+	volume   *StoredVolume
+	size     ByteSize
+	lastUsed time.Time
+	keys     []string
+}
+
+// Collect evicts the least recently used images and template volumes until cyanprint's disk usage is
+// within Budget, or nothing more may be evicted. Failed removals are returned and the rest carry on.
+func (g GarbageCollector) Collect() (GCResult, []error) {
+	res := GCResult{Budget: g.Budget, ImagesRemoved: []string{}, VolumesRemoved: []string{}}
+	images, volumes, err := g.Docker.DiskUsage()
+	if err != nil {
+		return res, []error{fmt.Errorf("failed to read disk usage: %w", err)}
+	}
+	live, err := g.liveSessions()
+	if err != nil {
+		return res, []error{fmt.Errorf("failed to list live sessions: %w", err)}
+	}
+	// mergers run the coordinator's image, so evicting it would only force a pull on the next start
+	coordinator, coordinatorErr := g.Docker.GetCoordinatorImage()
+
+	var candidates []gcCandidate
+	held := func(keys []string, created time.Time) (time.Time, bool) {
+		entry, ok := g.Usage.lastUse(keys...)
+		if !ok {
+			return created, false
+		}
+		return entry.LastUsed, live[entry.Session]
+	}
+	for i := range images {
+		img := &images[i]
+		res.Before += img.Size
+		if img.InUse || (coordinatorErr == nil && coordinator.foundIn(img.Refs)) {
+			continue
+		}
+		keys := make([]string, len(img.Refs))
+		for j, ref := range img.Refs {
+			keys[j] = imageUsageKey(ref)
+		}
+		lastUsed, isHeld := held(keys, img.Created)
+		if !isHeld {
+			candidates = append(candidates, gcCandidate{image: img, size: img.Size, lastUsed: lastUsed, keys: keys})
+		}
+	}
+	for i := range volumes {
+		vol := &volumes[i]
+		res.Before += vol.Size
+		if vol.InUse || vol.Volume.SessionId != "" {
+			continue
+		}
+		keys := []string{volumeUsageKey(vol.Volume)}
+		lastUsed, isHeld := held(keys, vol.Created)
+		if !isHeld {
+			candidates = append(candidates, gcCandidate{volume: vol, size: vol.Size, lastUsed: lastUsed, keys: keys})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].lastUsed.Before(candidates[j].lastUsed)
+	})
+
+	res.After = res.Before
+	var errs []error
+	for _, c := range candidates {
+		if res.After <= g.Budget {
+			break
+		}
+		if err := g.evict(c, &res); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		res.After -= c.size
+		g.Usage.forget(c.keys...)
+	}
+	gcDiskUsage.Set(float64(res.After))
+	if res.After > g.Budget {
+		g.log().Warn("Disk usage is over budget with nothing left to evict", "usage", res.After, "budget", g.Budget)
+	}
+	return res, errs
+}
+
+// evict removes a candidate, recording it in res once every part of it is gone
+func (g GarbageCollector) evict(c gcCandidate, res *GCResult) error {
+	if c.volume != nil {
+		name := DockerVolumeToString(c.volume.Volume)
+		g.log().Info("Evicting template volume", LogKeyVolume, name, "last_used", c.lastUsed, "size", c.size)
+		if err := g.Docker.RemoveVolume(c.volume.Volume); err != nil {
+			return fmt.Errorf("failed to evict volume %s: %w", name, err)
+		}
+		gcEvictions.WithLabelValues("volume").Inc()
+		res.VolumesRemoved = append(res.VolumesRemoved, name)
+		return nil
+	}
+	var names []string
+	for _, ref := range c.image.Refs {
+		name := DockerImageToString(ref)
+		g.log().Info("Evicting image", LogKeyImage, name, "last_used", c.lastUsed, "size", c.size)
+		if err := g.Docker.RemoveImage(ref); err != nil {
+			return fmt.Errorf("failed to evict image %s: %w", name, err)
+		}
+		names = append(names, name)
+	}
+	gcEvictions.WithLabelValues("image").Inc()
+	res.ImagesRemoved = append(res.ImagesRemoved, names...)
+	return nil
+}
+
+// liveSessions are the sessions that still own resources or that the registry hasn't seen cleaned
+func (g GarbageCollector) liveSessions() (map[string]bool, error) {
+	activity, err := g.Docker.ListSessionActivity()
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]bool, len(activity))
+	for session := range activity {
+		live[session] = true
+	}
+	for _, s := range g.Sessions.List() {
+		if s.State != SessionCleaned {
+			live[s.SessionId] = true
+		}
+	}
+	// templates warmed without a session are shared, and only protected while a container uses them
+	delete(live, "")
+	return live, nil
+}
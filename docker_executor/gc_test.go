@@ -0,0 +1,161 @@
+package docker_executor
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// gcFixture is a runtime holding images and volumes of 40 and 20 bytes, with usage recorded a minute apart
+type gcFixture struct {
+	rt       *FakeRuntime
+	usage    *UsageStore
+	sessions *SessionRegistry
+	now      time.Time
+}
+
+func newGCFixture(t *testing.T) *gcFixture {
+	t.Helper()
+	f := &gcFixture{rt: NewFakeRuntime(), sessions: NewSessionRegistry(), now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	usage, err := LoadUsageStore(filepath.Join(t.TempDir(), "usage.json"))
+	if err != nil {
+		t.Fatalf("LoadUsageStore() error = %v", err)
+	}
+	usage.Now = func() time.Time { return f.now }
+	f.usage = usage
+	return f
+}
+
+func (f *gcFixture) image(name string, size ByteSize) DockerImageReference {
+	i := DockerImageReference{Reference: "registry.local/" + name, Tag: "1"}
+	f.rt.AddImage(i)
+	f.rt.SetSize(DockerImageToString(i), size)
+	return i
+}
+
+// use records a use by session, a minute after the last one
+func (f *gcFixture) use(session string, images []DockerImageReference, volumes ...DockerVolumeReference) {
+	f.now = f.now.Add(time.Minute)
+	f.usage.Touch(session, images, volumes)
+}
+
+// TestGarbageCollectorCollect tests that the least recently used images and template volumes are evicted
+// until usage is within budget, and that used, held, coordinator and session resources are kept
+func TestGarbageCollectorCollect(t *testing.T) {
+	f := newGCFixture(t)
+	unrecorded := f.image("unrecorded", 40)
+	old := f.image("old", 40)
+	mid := f.image("mid", 40)
+	recent := f.image("recent", 40)
+	running := f.image("running", 40)
+	held := f.image("held", 40)
+	f.rt.AddImage(f.rt.Coordinator)
+	f.rt.SetSize(DockerImageToString(f.rt.Coordinator), 40)
+	templateVol := DockerVolumeReference{CyanId: "template-1"}
+	sessionVol := DockerVolumeReference{CyanId: "template-1", SessionId: "s2"}
+	f.rt.AddVolume(templateVol)
+	f.rt.AddVolume(sessionVol)
+	f.rt.SetSize(DockerVolumeToString(templateVol), 20)
+	f.rt.SetSize(DockerVolumeToString(sessionVol), 50)
+
+	f.use("s1", []DockerImageReference{held})
+	f.use("gone", []DockerImageReference{old})
+	f.use("", nil, templateVol)
+	f.use("gone", []DockerImageReference{mid, running})
+	f.use("gone", []DockerImageReference{recent})
+	f.sessions.Transition("s1", "template-1", SessionWarming)
+	if err := f.rt.CreateContainer(DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s3"}, running, ResourceLimits{}); err != nil {
+		t.Fatalf("CreateContainer() error = %v", err)
+	}
+
+	gc := GarbageCollector{Docker: f.rt, Usage: f.usage, Sessions: f.sessions, Budget: 210}
+	res, errs := gc.Collect()
+	if len(errs) > 0 {
+		t.Fatalf("Collect() errors = %v", errs)
+	}
+	if res.Before != 350 || res.After != 210 {
+		t.Errorf("usage = %s -> %s, want 350 -> 210", res.Before, res.After)
+	}
+	wantImages := []string{DockerImageToString(unrecorded), DockerImageToString(old), DockerImageToString(mid)}
+	if !slices.Equal(res.ImagesRemoved, wantImages) {
+		t.Errorf("ImagesRemoved = %v, want %v", res.ImagesRemoved, wantImages)
+	}
+	if want := []string{DockerVolumeToString(templateVol)}; !slices.Equal(res.VolumesRemoved, want) {
+		t.Errorf("VolumesRemoved = %v, want %v", res.VolumesRemoved, want)
+	}
+	for _, kept := range []DockerImageReference{recent, running, held, f.rt.Coordinator} {
+		if !slices.Contains(f.rt.Images(), DockerImageToString(kept)) {
+			t.Errorf("image %s was evicted", DockerImageToString(kept))
+		}
+	}
+	if !slices.Contains(f.rt.Volumes(), DockerVolumeToString(sessionVol)) {
+		t.Error("session volume was evicted")
+	}
+	if _, ok := f.usage.lastUse(imageUsageKey(old)); ok {
+		t.Error("usage of an evicted image was kept")
+	}
+
+	// within budget, nothing more goes
+	res, errs = gc.Collect()
+	if len(errs) > 0 || len(res.ImagesRemoved) > 0 || len(res.VolumesRemoved) > 0 {
+		t.Errorf("second Collect() = %+v, %v, want nothing evicted", res, errs)
+	}
+}
+
+// TestGarbageCollectorRemovalFailure tests that an image that can't be removed is reported and the next
+// least recently used is evicted in its place
+func TestGarbageCollectorRemovalFailure(t *testing.T) {
+	f := newGCFixture(t)
+	stuck := f.image("stuck", 40)
+	next := f.image("next", 40)
+	recent := f.image("recent", 40)
+	f.use("gone", []DockerImageReference{stuck})
+	f.use("gone", []DockerImageReference{next})
+	f.use("gone", []DockerImageReference{recent})
+	f.rt.FailOn(FakeRemoveImage, DockerImageToString(stuck), errors.New("conflict"))
+
+	gc := GarbageCollector{Docker: f.rt, Usage: f.usage, Budget: 80}
+	res, errs := gc.Collect()
+	if len(errs) != 1 {
+		t.Errorf("Collect() errors = %v, want the failed removal", errs)
+	}
+	if want := []string{DockerImageToString(next)}; !slices.Equal(res.ImagesRemoved, want) {
+		t.Errorf("ImagesRemoved = %v, want %v", res.ImagesRemoved, want)
+	}
+	if res.After != 80 {
+		t.Errorf("After = %s, want 80", res.After)
+	}
+	if _, ok := f.usage.lastUse(imageUsageKey(stuck)); !ok {
+		t.Error("usage of an image that failed to be removed was dropped")
+	}
+}
+
+// TestUsageStorePersists tests that recorded usage survives reloading the state file
+func TestUsageStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "usage.json")
+	usage, err := LoadUsageStore(path)
+	if err != nil {
+		t.Fatalf("LoadUsageStore() error = %v", err)
+	}
+	used := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	usage.Now = func() time.Time { return used }
+	image := DockerImageReference{Reference: "registry.local/processor", Tag: "1"}
+	usage.Touch("s1", []DockerImageReference{image}, []DockerVolumeReference{{CyanId: "template-1"}, {CyanId: "template-1", SessionId: "s1"}})
+
+	reloaded, err := LoadUsageStore(path)
+	if err != nil {
+		t.Fatalf("LoadUsageStore() error = %v", err)
+	}
+	entry, ok := reloaded.lastUse(imageUsageKey(image))
+	if !ok || !entry.LastUsed.Equal(used) || entry.Session != "s1" {
+		t.Errorf("reloaded image usage = %+v, %v", entry, ok)
+	}
+	if _, ok := reloaded.lastUse(volumeUsageKey(DockerVolumeReference{CyanId: "template-1"})); !ok {
+		t.Error("template volume usage was not reloaded")
+	}
+	if _, ok := reloaded.lastUse(volumeUsageKey(DockerVolumeReference{CyanId: "template-1", SessionId: "s1"})); ok {
+		t.Error("session volume usage was recorded")
+	}
+}
@@ -0,0 +1,792 @@
+package docker_executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KubernetesConfig holds the settings of the Kubernetes runtime
+type KubernetesConfig struct {
+	// Kubeconfig is the path to a kubeconfig file; empty uses the in-cluster service account
+	Kubeconfig string `yaml:"kubeconfig"`
+	// Namespace holds every cyanprint pod, service and volume claim, taking the place of the Docker network.
+	// The coordinator must run in it for container names to resolve.
+	Namespace string `yaml:"namespace"`
+	// StorageClass of volume claims; it must support ReadWriteMany. Empty uses the cluster default.
+	StorageClass string `yaml:"storage_class"`
+	VolumeSize   string `yaml:"volume_size"`
+	// CoordinatorImage is the image mergers run, since there is no local image store to find it in
+	CoordinatorImage string `yaml:"coordinator_image"`
+}
+
+func DefaultKubernetesConfig() KubernetesConfig {
+	return KubernetesConfig{
+		Namespace:  "cyanprint",
+		VolumeSize: "1Gi",
+	}
+}
+
+func (c KubernetesConfig) Validate() error {
+	if c.Namespace == "" {
+		return errors.New("kubernetes namespace must not be empty")
+	}
+	if _, err := resource.ParseQuantity(c.VolumeSize); err != nil {
+		return fmt.Errorf("invalid kubernetes volume size '%s': %w", c.VolumeSize, err)
+	}
+	if _, err := DockerImageToStruct(c.CoordinatorImage); err != nil {
+		return fmt.Errorf("kubernetes coordinator image must be set as <reference>:<tag>, got '%s'", c.CoordinatorImage)
+	}
+	return nil
+}
+
+// servicePorts are the ports each kind of long-running container serves on.
+// Pods of these kinds get a Service of the same name, so http://<container name>:<port> resolves
+// in the namespace as it does on the Docker network.
+var servicePorts = map[string]int32{
+	"template":       5550,
+	"processor":      5551,
+	"plugin":         5552,
+	CyanTypeResolver: ResolverPort,
+	"merger":         9000,
+}
+
+const labelContainer = "cyanprint.container"
+
+// KubernetesRuntime runs cyanprint containers as Pods in a namespace, with a Service in front of each
+// long-running one and volumes as ReadWriteMany PersistentVolumeClaims. Images are pulled by the kubelet
+// when pods start, so image listing and pulling are no-ops.
+type KubernetesRuntime struct {
+	Client           kubernetes.Interface
+	Context          context.Context
+	ParallelismLimit int
+	Events           Emitter
+	Logger           *slog.Logger
+	Config           Config
+	Kubernetes       KubernetesConfig
+}
+
+var _ ContainerRuntime = (*KubernetesRuntime)(nil)
+
+func (k *KubernetesRuntime) log() *slog.Logger {
+	return loggerOrDefault(k.Logger)
+}
+
+func (k *KubernetesRuntime) ctx() context.Context {
+	return contextOrBackground(k.Context)
+}
+
+func (k *KubernetesRuntime) namespace() string {
+	if k.Kubernetes.Namespace == "" {
+		return DefaultKubernetesConfig().Namespace
+	}
+	return k.Kubernetes.Namespace
+}
+
+func (k *KubernetesRuntime) OperationContext() context.Context {
+	return k.ctx()
+}
+
+func (k *KubernetesRuntime) Parallelism() int {
+	return k.ParallelismLimit
+}
+
+func (k *KubernetesRuntime) Settings() Config {
+	return k.Config.withDefaults()
+}
+
+// objectMeta names and labels a cyanprint object. The creation time and version are annotations, since
+// label values can't hold them.
+func objectMeta(name string, labels map[string]string) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{
+		Name:        name,
+		Labels:      map[string]string{labelContainer: name},
+		Annotations: map[string]string{},
+	}
+	for key, value := range labels {
+		if key == labelCreatedAt || key == labelBoronVersion {
+			meta.Annotations[key] = value
+		} else {
+			meta.Labels[key] = value
+		}
+	}
+	return meta
+}
+
+var listSelector = metav1.ListOptions{LabelSelector: labelDev + "=true"}
+
+// podMount mounts a volume claim into a pod
+type podMount struct {
+	volume   DockerVolumeReference
+	path     string
+	readOnly bool
+}
+
+func (k *KubernetesRuntime) createPod(cc DockerContainerReference, image DockerImageReference, mounts []podMount, env []string, limits ResourceLimits) (err error) {
+	// the name becomes a Service name and a label value, both limited to 63 character DNS labels, which
+	// container names with long session IDs exceed; the container's identity is in its labels
+	name := ContainerHost(cc)
+	imageName := DockerImageToString(image)
+	start := time.Now()
+	ctx, span := startSpan(k.ctx(), "kubernetes.pod.create", append(containerSpanAttrs(cc), attribute.String(LogKeyImage, imageName))...)
+	defer func() {
+		observeContainerCreation(cc, start, err)
+		endSpan(span, err)
+	}()
+
+	port, serve := servicePorts[cc.CyanType]
+
+	c := corev1.Container{
+		Name:            "main",
+		Image:           imageName,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Resources:       podResources(limits),
+	}
+	for _, e := range env {
+		key, value, _ := strings.Cut(e, "=")
+		c.Env = append(c.Env, corev1.EnvVar{Name: key, Value: value})
+	}
+	if serve {
+		c.Ports = []corev1.ContainerPort{{ContainerPort: port}}
+	}
+	// the type label also lets egress policies select processors and plugins
+	var templateVolume DockerVolumeReference
+	if len(mounts) > 0 {
+		templateVolume = mounts[0].volume
+	}
+	meta := objectMeta(name, containerLabels(cc, containerTemplate(cc, templateVolume)))
+	pod := &corev1.Pod{
+		ObjectMeta: meta,
+		Spec: corev1.PodSpec{
+			// like a Docker container, a pod that stops stays stopped until it is removed
+			RestartPolicy: corev1.RestartPolicyNever,
+		},
+	}
+	for i, m := range mounts {
+		// pod volume names are DNS labels too, unlike claim names
+		volName := fmt.Sprintf("volume-%d", i)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: volName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: DockerVolumeToString(m.volume), ReadOnly: m.readOnly},
+			},
+		})
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: volName, MountPath: m.path, ReadOnly: m.readOnly})
+	}
+	securityContext, scratch := k.Settings().Security.podSecurity(cc.CyanType)
+	c.SecurityContext = securityContext
+	if scratch != nil {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, *scratch)
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: scratch.Name, MountPath: scratchDir})
+	}
+	pod.Spec.Containers = []corev1.Container{c}
+
+	if _, err = k.Client.CoreV1().Pods(k.namespace()).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	if serve {
+		svc := &corev1.Service{
+			ObjectMeta: objectMeta(name, resourceLabels(cc.SessionId)),
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{labelContainer: name},
+				Ports:    []corev1.ServicePort{{Port: port, TargetPort: intstr.FromInt32(port)}},
+			},
+		}
+		if _, err = k.Client.CoreV1().Services(k.namespace()).Create(ctx, svc, metav1.CreateOptions{}); err != nil {
+			// without its Service the pod can't be reached, so don't leave it behind
+			_ = k.deletePod(ctx, name)
+			return fmt.Errorf("failed to create service for %s: %w", name, err)
+		}
+	}
+	k.Events.Emit(containerCreatedEvent(cc, imageName))
+	return nil
+}
+
+// podResources converts limits to container resource limits. Pid limits and ulimits are set per node by
+// the kubelet and container runtime, so they are not applied.
+func podResources(limits ResourceLimits) corev1.ResourceRequirements {
+	r := corev1.ResourceList{}
+	if limits.CPUs > 0 {
+		r[corev1.ResourceCPU] = *resource.NewMilliQuantity(int64(limits.CPUs*1000), resource.DecimalSI)
+	}
+	if limits.Memory > 0 {
+		r[corev1.ResourceMemory] = *resource.NewQuantity(int64(limits.Memory), resource.BinarySI)
+	}
+	if len(r) == 0 {
+		return corev1.ResourceRequirements{}
+	}
+	return corev1.ResourceRequirements{Limits: r}
+}
+
+func (k *KubernetesRuntime) CreateContainer(cc DockerContainerReference, image DockerImageReference, limits ResourceLimits) error {
+	return k.createPod(cc, image, nil, nil, limits)
+}
+
+func (k *KubernetesRuntime) CreateContainerWithVolume(cc DockerContainerReference, v DockerVolumeReference, image DockerImageReference, limits ResourceLimits) error {
+	return k.createPod(cc, image, []podMount{{volume: v, path: k.Settings().Workspace.TemplateDir}}, nil, limits)
+}
+
+func (k *KubernetesRuntime) CreateContainerWithReadWriteVolume(cc DockerContainerReference, readVolume, writeVolume DockerVolumeReference, image DockerImageReference, limits ResourceLimits) error {
+	var env []string
+	if cc.CyanType == "merger" {
+		env = k.Settings().mergerEnv()
+	}
+	return k.createPod(cc, image, []podMount{
+		{volume: readVolume, path: k.Settings().Workspace.TemplateDir, readOnly: true},
+		{volume: writeVolume, path: k.Settings().Workspace.AreaDir},
+	}, env, limits)
+}
+
+// CreateContainerWithCopyMount is not supported: the source path is on the coordinator's host,
+// which pods on other nodes can't mount
+func (k *KubernetesRuntime) CreateContainerWithCopyMount(cc DockerContainerReference, sourcePath string, targetVolume DockerVolumeReference) error {
+	return fmt.Errorf("copying local path %s is not supported by the kubernetes runtime; build the template into an image instead", sourcePath)
+}
+
+func (k *KubernetesRuntime) ListContainer() ([]DockerContainerReference, []DockerContainerReference, error) {
+	pods, err := k.Client.CoreV1().Pods(k.namespace()).List(k.ctx(), listSelector)
+	if err != nil {
+		return nil, nil, err
+	}
+	var running, stopped []DockerContainerReference
+	for _, pod := range pods.Items {
+		ref, err := containerIdentity(pod.Labels, pod.Name)
+		if err != nil {
+			k.log().Warn("Skipping pod with a malformed name, run boron doctor to remove it", LogKeyContainer, pod.Name, LogKeyError, err)
+			continue
+		}
+		switch pod.Status.Phase {
+		case corev1.PodSucceeded, corev1.PodFailed, corev1.PodUnknown:
+			stopped = append(stopped, ref)
+		default:
+			// pending pods are still pulling their image, the equivalent of a container starting
+			running = append(running, ref)
+		}
+	}
+	return running, stopped, nil
+}
+
+// WaitContainer polls the pod every health check interval until it has stopped
+func (k *KubernetesRuntime) WaitContainer(ref DockerContainerReference) (int, error) {
+	name := ContainerHost(ref)
+	ticker := time.NewTicker(k.Settings().HealthCheck.Interval)
+	defer ticker.Stop()
+	for {
+		pod, err := k.Client.CoreV1().Pods(k.namespace()).Get(k.ctx(), name, metav1.GetOptions{})
+		if err != nil {
+			return -1, err
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			for _, s := range pod.Status.ContainerStatuses {
+				if s.State.Terminated != nil {
+					return int(s.State.Terminated.ExitCode), nil
+				}
+			}
+			if pod.Status.Phase == corev1.PodFailed {
+				return -1, fmt.Errorf("pod %s failed: %s", name, pod.Status.Message)
+			}
+			return 0, nil
+		}
+		select {
+		case <-k.ctx().Done():
+			return -1, k.ctx().Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (k *KubernetesRuntime) InspectContainer(ref DockerContainerReference) (ContainerState, error) {
+	pod, err := k.Client.CoreV1().Pods(k.namespace()).Get(k.ctx(), ContainerHost(ref), metav1.GetOptions{})
+	if err != nil {
+		return ContainerState{}, err
+	}
+	return podState(pod), nil
+}
+
+func podState(pod *corev1.Pod) ContainerState {
+	state := ContainerState{
+		Running:  pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed,
+		Created:  pod.CreationTimestamp.Time,
+		Template: pod.Labels[labelTemplate],
+	}
+	if len(pod.Spec.Containers) > 0 {
+		state.Image, _ = DockerImageToStruct(pod.Spec.Containers[0].Image)
+		r := pod.Spec.Containers[0].Resources.Limits
+		state.Limits = ResourceLimits{
+			CPUs:   float64(r.Cpu().MilliValue()) / 1000,
+			Memory: ByteSize(r.Memory().Value()),
+		}
+	}
+	for _, s := range pod.Status.ContainerStatuses {
+		if t := s.State.Terminated; t != nil {
+			state.Running = false
+			state.ExitCode = int(t.ExitCode)
+			state.OOMKilled = t.Reason == "OOMKilled"
+		}
+	}
+	return state
+}
+
+// WatchContainer watches the pod until its container terminates
+func (k *KubernetesRuntime) WatchContainer(ref DockerContainerReference) (<-chan struct{}, func()) {
+	name := ContainerHost(ref)
+	ctx, cancel := context.WithCancel(k.ctx())
+	w, err := k.Client.CoreV1().Pods(k.namespace()).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+	})
+	if err != nil {
+		cancel()
+		k.log().Debug("Failed to watch pod", LogKeyContainer, name, LogKeyError, err)
+		return nil, func() {}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		for event := range w.ResultChan() {
+			pod, ok := event.Object.(*corev1.Pod)
+			if ok && pod.Name == name && !podState(pod).Running {
+				close(stopped)
+				return
+			}
+		}
+	}()
+	return stopped, func() {
+		cancel()
+		w.Stop()
+	}
+}
+
+func (k *KubernetesRuntime) ContainerLogs(ref DockerContainerReference, lines int) ([]string, error) {
+	tail := int64(lines)
+	out, err := k.Client.CoreV1().Pods(k.namespace()).
+		GetLogs(ContainerHost(ref), &corev1.PodLogOptions{TailLines: &tail}).
+		DoRaw(k.ctx())
+	if err != nil {
+		return nil, err
+	}
+	return splitLogLines(string(out)), nil
+}
+
+func (k *KubernetesRuntime) StreamLogs(ref DockerContainerReference, opts LogOptions) (io.ReadCloser, error) {
+	logOpts := &corev1.PodLogOptions{Follow: opts.Follow}
+	if opts.Tail > 0 {
+		tail := int64(opts.Tail)
+		logOpts.TailLines = &tail
+	}
+	return k.Client.CoreV1().Pods(k.namespace()).GetLogs(ContainerHost(ref), logOpts).Stream(k.ctx())
+}
+
+func (k *KubernetesRuntime) deletePod(ctx context.Context, name string) error {
+	grace := int64(0)
+	return k.Client.CoreV1().Pods(k.namespace()).Delete(ctx, name, metav1.DeleteOptions{GracePeriodSeconds: &grace})
+}
+
+// RestartContainer replaces a stopped pod with a new one of the same spec, as pods can't be restarted.
+// Its Service, if it has one, selects the new pod by name.
+func (k *KubernetesRuntime) RestartContainer(ref DockerContainerReference) error {
+	name := ContainerHost(ref)
+	pods := k.Client.CoreV1().Pods(k.namespace())
+	old, err := pods.Get(k.ctx(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: old.Name, Labels: old.Labels, Annotations: old.Annotations},
+		Spec:       old.Spec,
+	}
+	pod.Spec.NodeName = ""
+	if err := k.deletePod(k.ctx(), name); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	// the old pod can take a moment to go
+	ticker := time.NewTicker(k.Settings().HealthCheck.Interval)
+	defer ticker.Stop()
+	for {
+		_, err := pods.Create(k.ctx(), pod, metav1.CreateOptions{})
+		if !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		select {
+		case <-k.ctx().Done():
+			return k.ctx().Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (k *KubernetesRuntime) ListMalformedContainers() ([]string, error) {
+	pods, err := k.Client.CoreV1().Pods(k.namespace()).List(k.ctx(), listSelector)
+	if err != nil {
+		return nil, err
+	}
+	var malformed []string
+	for _, pod := range pods.Items {
+		if _, err := containerIdentity(pod.Labels, pod.Name); err != nil {
+			malformed = append(malformed, pod.Name)
+		}
+	}
+	return malformed, nil
+}
+
+// RemoveContainerNamed deletes the pod and any Service of the same name
+func (k *KubernetesRuntime) RemoveContainerNamed(name string) error {
+	if err := k.deletePod(k.ctx(), name); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	err := k.Client.CoreV1().Services(k.namespace()).Delete(k.ctx(), name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// RemoveContainer deletes the pod and its Service, if it has one
+func (k *KubernetesRuntime) RemoveContainer(cc DockerContainerReference) error {
+	name := ContainerHost(cc)
+	if err := k.deletePod(k.ctx(), name); err != nil {
+		return err
+	}
+	if _, serve := servicePorts[cc.CyanType]; serve {
+		err := k.Client.CoreV1().Services(k.namespace()).Delete(k.ctx(), name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *KubernetesRuntime) RemoveAllContainers(containerRefs []DockerContainerReference) []error {
+	return parallelIndexed(k.ParallelismLimit, len(containerRefs), func(i int) error {
+		cc := containerRefs[i]
+		k.log().Info("Removing container", containerAttrs(cc)...)
+		err := k.RemoveContainer(cc)
+		if err != nil {
+			k.log().Error("Failed to remove container", append(containerAttrs(cc), LogKeyError, err)...)
+		}
+		return err
+	})
+}
+
+func (k *KubernetesRuntime) ListVolumes() ([]DockerVolumeReference, error) {
+	claims, err := k.Client.CoreV1().PersistentVolumeClaims(k.namespace()).List(k.ctx(), listSelector)
+	if err != nil {
+		return nil, err
+	}
+	var volumes []DockerVolumeReference
+	for _, claim := range claims.Items {
+		v, err := volumeIdentity(claim.Labels, claim.Name)
+		if err != nil {
+			k.log().Warn("Skipping volume claim with a malformed name", LogKeyVolume, claim.Name, LogKeyError, err)
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes, nil
+}
+
+// DiskUsage reports volume claims at their requested size. Images live on the nodes, where the kubelet's
+// image garbage collection looks after them, so none are reported.
+func (k *KubernetesRuntime) DiskUsage() ([]StoredImage, []StoredVolume, error) {
+	claims, err := k.Client.CoreV1().PersistentVolumeClaims(k.namespace()).List(k.ctx(), listSelector)
+	if err != nil {
+		return nil, nil, err
+	}
+	pods, err := k.Client.CoreV1().Pods(k.namespace()).List(k.ctx(), listSelector)
+	if err != nil {
+		return nil, nil, err
+	}
+	mounted := make(map[string]bool)
+	for _, pod := range pods.Items {
+		for _, v := range pod.Spec.Volumes {
+			if v.PersistentVolumeClaim != nil {
+				mounted[v.PersistentVolumeClaim.ClaimName] = true
+			}
+		}
+	}
+	var volumes []StoredVolume
+	for _, claim := range claims.Items {
+		ref, err := volumeIdentity(claim.Labels, claim.Name)
+		if err != nil {
+			continue
+		}
+		size := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		volumes = append(volumes, StoredVolume{
+			Volume:  ref,
+			Size:    ByteSize(size.Value()),
+			Created: claim.CreationTimestamp.UTC(),
+			InUse:   mounted[claim.Name],
+		})
+	}
+	return nil, volumes, nil
+}
+
+// CreateVolume creates a ReadWriteMany claim, since the template and session volumes are shared by several pods.
+// Like creating a named Docker volume, it succeeds if the claim already exists.
+func (k *KubernetesRuntime) CreateVolume(vol DockerVolumeReference) error {
+	name := DockerVolumeToString(vol)
+	size := k.Kubernetes.VolumeSize
+	if size == "" {
+		size = DefaultKubernetesConfig().VolumeSize
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return err
+	}
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: objectMeta(name, volumeLabels(vol)),
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: quantity},
+			},
+		},
+	}
+	if k.Kubernetes.StorageClass != "" {
+		claim.Spec.StorageClassName = &k.Kubernetes.StorageClass
+	}
+	_, err = k.Client.CoreV1().PersistentVolumeClaims(k.namespace()).Create(k.ctx(), claim, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+func (k *KubernetesRuntime) RemoveVolume(vol DockerVolumeReference) error {
+	return k.Client.CoreV1().PersistentVolumeClaims(k.namespace()).Delete(k.ctx(), DockerVolumeToString(vol), metav1.DeleteOptions{})
+}
+
+func (k *KubernetesRuntime) RemoveAllVolumes(volRefs []DockerVolumeReference) []error {
+	return parallelIndexed(k.ParallelismLimit, len(volRefs), func(i int) error {
+		v := volRefs[i]
+		k.log().Info("Removing volume", LogKeyVolume, DockerVolumeToString(v))
+		err := k.RemoveVolume(v)
+		if err != nil {
+			k.log().Error("Failed to remove volume", LogKeyVolume, DockerVolumeToString(v), LogKeyError, err)
+		}
+		return err
+	})
+}
+
+// ListImages returns nothing: nodes pull and cache images themselves
+func (k *KubernetesRuntime) ListImages() ([]DockerImageReference, error) {
+	return nil, nil
+}
+
+// PullImages does nothing: the kubelet pulls images when pods using them start
+func (k *KubernetesRuntime) PullImages(images []DockerImageReference) []error {
+	k.log().Debug("Leaving image pulls to the kubelet", "count", len(images))
+	return nil
+}
+
+func (k *KubernetesRuntime) GetCoordinatorImage() (DockerImageReference, error) {
+	if k.Kubernetes.CoordinatorImage == "" {
+		return DockerImageReference{}, errors.New("no coordinator image configured for the kubernetes runtime")
+	}
+	return DockerImageToStruct(k.Kubernetes.CoordinatorImage)
+}
+
+// RemoveImage does nothing: node image garbage collection removes unused images
+func (k *KubernetesRuntime) RemoveImage(imageRef DockerImageReference) error {
+	return nil
+}
+
+func (k *KubernetesRuntime) RemoveAllImages(imageRefs []DockerImageReference) []error {
+	return make([]error, len(imageRefs))
+}
+
+// SaveImages is not supported: images live on the nodes, not with the coordinator
+func (k *KubernetesRuntime) SaveImages(images []DockerImageReference, w io.Writer) error {
+	return errors.New("saving images is not supported by the kubernetes runtime; export bundles with the docker runtime")
+}
+
+// LoadImages is not supported: the kubelet pulls images from a registry reachable from the nodes
+func (k *KubernetesRuntime) LoadImages(r io.Reader, images []DockerImageReference) error {
+	return errors.New("loading images is not supported by the kubernetes runtime; push the bundle's images to a registry the nodes can reach")
+}
+
+// CyanPrintNetworkExist reports whether the namespace exists
+func (k *KubernetesRuntime) CyanPrintNetworkExist() (bool, error) {
+	_, err := k.Client.CoreV1().Namespaces().Get(k.ctx(), k.namespace(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CreateNetwork creates the namespace
+func (k *KubernetesRuntime) CreateNetwork() error {
+	_, err := k.Client.CoreV1().Namespaces().Create(k.ctx(), &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: k.namespace(), Labels: map[string]string{labelDev: "true"}},
+	}, metav1.CreateOptions{})
+	return err
+}
+
+func (k *KubernetesRuntime) EnforceNetwork() error {
+	exist, err := k.CyanPrintNetworkExist()
+	if err != nil {
+		return err
+	}
+	if !exist {
+		k.log().Info("Creating namespace", "namespace", k.namespace())
+		if err := k.CreateNetwork(); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// CoordinatorAttached is always true: every pod is on the cluster's pod network
+func (k *KubernetesRuntime) CoordinatorAttached() (bool, error) {
+	return true, nil
+}
+
+func (k *KubernetesRuntime) AttachCoordinator() error {
+	return nil
+}
+
+// NetworkSubnets fails: the pod network is up to the cluster's network plugin and not visible through the API
+func (k *KubernetesRuntime) NetworkSubnets() ([]string, error) {
+	return nil, errors.New("pod network subnets are not known to the kubernetes runtime")
+}
+
+// CreateSessionNetwork isolates the session's pods with a NetworkPolicy admitting only the session's own
+// pods and the coordinator's, and with egress denied, another keeping processors and plugins within the
+// session. Template and resolver pods are not isolated, so shared containers need no attaching.
+func (k *KubernetesRuntime) CreateSessionNetwork(session string, _ []DockerContainerReference) error {
+	coordinator, err := k.coordinatorSelector()
+	if err != nil {
+		return err
+	}
+	sessionPods := metav1.LabelSelector{MatchLabels: map[string]string{labelSession: session}}
+	name := k.Settings().sessionNetwork(session)
+	policies := []*networkingv1.NetworkPolicy{{
+		ObjectMeta: objectMeta(name, resourceLabels(session)),
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: sessionPods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{PodSelector: &sessionPods}, {PodSelector: coordinator}},
+			}},
+		},
+	}}
+	if k.Settings().Isolation.DenyEgress {
+		policies = append(policies, &networkingv1.NetworkPolicy{
+			ObjectMeta: objectMeta(name+"-egress", resourceLabels(session)),
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{
+					MatchLabels: map[string]string{labelSession: session},
+					MatchExpressions: []metav1.LabelSelectorRequirement{{
+						Key:      labelType,
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   []string{"processor", "plugin"},
+					}},
+				},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+				Egress:      []networkingv1.NetworkPolicyEgressRule{{To: []networkingv1.NetworkPolicyPeer{{PodSelector: &sessionPods}}}},
+			},
+		})
+	}
+	for _, p := range policies {
+		_, err := k.Client.NetworkingV1().NetworkPolicies(k.namespace()).Create(k.ctx(), p, metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create network policy %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// coordinatorSelector selects the coordinator's pod by its labels, less those that change between rollouts
+func (k *KubernetesRuntime) coordinatorSelector() (*metav1.LabelSelector, error) {
+	name, err := k.Settings().coordinatorName()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine the coordinator pod: %w", err)
+	}
+	pod, err := k.Client.CoreV1().Pods(k.namespace()).Get(k.ctx(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the coordinator pod %s, set it with --coordinator-container: %w", name, err)
+	}
+	labels := map[string]string{}
+	for key, value := range pod.Labels {
+		if key != "pod-template-hash" && key != "controller-revision-hash" {
+			labels[key] = value
+		}
+	}
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("coordinator pod %s has no labels to admit it by", name)
+	}
+	return &metav1.LabelSelector{MatchLabels: labels}, nil
+}
+
+// RemoveSessionNetwork deletes the session's network policies
+func (k *KubernetesRuntime) RemoveSessionNetwork(session string) error {
+	name := k.Settings().sessionNetwork(session)
+	for _, policy := range []string{name, name + "-egress"} {
+		err := k.Client.NetworkingV1().NetworkPolicies(k.namespace()).Delete(k.ctx(), policy, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSessionNetworks returns the sessions that have network policies
+func (k *KubernetesRuntime) ListSessionNetworks() ([]string, error) {
+	policies, err := k.Client.NetworkingV1().NetworkPolicies(k.namespace()).List(k.ctx(), listSelector)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var sessions []string
+	for _, p := range policies.Items {
+		if session := p.Labels[labelSession]; session != "" && !seen[session] {
+			seen[session] = true
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (k *KubernetesRuntime) ListSessionActivity() (map[string]time.Time, error) {
+	activity := make(map[string]time.Time)
+	record := func(meta metav1.ObjectMeta) {
+		session := meta.Labels[labelSession]
+		if session == "" {
+			return
+		}
+		created := meta.CreationTimestamp.Time
+		if t, err := time.Parse(time.RFC3339, meta.Annotations[labelCreatedAt]); err == nil {
+			created = t
+		}
+		if last, ok := activity[session]; !ok || created.After(last) {
+			activity[session] = created
+		}
+	}
+	pods, err := k.Client.CoreV1().Pods(k.namespace()).List(k.ctx(), listSelector)
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		record(pod.ObjectMeta)
+	}
+	claims, err := k.Client.CoreV1().PersistentVolumeClaims(k.namespace()).List(k.ctx(), listSelector)
+	if err != nil {
+		return nil, err
+	}
+	for _, claim := range claims.Items {
+		record(claim.ObjectMeta)
+	}
+	return activity, nil
+}
@@ -0,0 +1,458 @@
+package docker_executor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestKubernetesRuntime() (*KubernetesRuntime, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	return &KubernetesRuntime{
+		Client:           client,
+		ParallelismLimit: 2,
+		Config:           Config{HealthCheck: HealthCheckConfig{Interval: time.Millisecond}},
+		Kubernetes: KubernetesConfig{
+			Namespace:        "cyanprint",
+			VolumeSize:       "1Gi",
+			CoordinatorImage: "registry.local/boron:1",
+		},
+	}, client
+}
+
+// TestKubernetesCreateContainer tests that long-running containers get a pod and a Service of the same name
+func TestKubernetesCreateContainer(t *testing.T) {
+	k, client := newTestKubernetesRuntime()
+	cc := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"}
+	name := DockerContainerToString(cc)
+
+	if err := k.CreateContainer(cc, DockerImageReference{Reference: "registry.local/processor", Tag: "1"}, ResourceLimits{}); err != nil {
+		t.Fatalf("CreateContainer() error = %v", err)
+	}
+	pod, err := client.CoreV1().Pods("cyanprint").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("pod %s not created: %v", name, err)
+	}
+	if pod.Spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("RestartPolicy = %s, want Never", pod.Spec.RestartPolicy)
+	}
+	if got := pod.Spec.Containers[0].Image; got != "registry.local/processor:1" {
+		t.Errorf("Image = %s", got)
+	}
+	if pod.Labels[labelSession] != "s1" || pod.Labels[labelDev] != "true" {
+		t.Errorf("Labels = %v", pod.Labels)
+	}
+	svc, err := client.CoreV1().Services("cyanprint").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("service %s not created: %v", name, err)
+	}
+	if got := svc.Spec.Ports[0].Port; got != 5551 {
+		t.Errorf("service port = %d, want 5551", got)
+	}
+	if svc.Spec.Selector[labelContainer] != pod.Labels[labelContainer] {
+		t.Errorf("service selector %v doesn't match pod labels %v", svc.Spec.Selector, pod.Labels)
+	}
+
+	running, stopped, err := k.ListContainer()
+	if err != nil {
+		t.Fatalf("ListContainer() error = %v", err)
+	}
+	if len(running) != 1 || DockerContainerToString(running[0]) != name || len(stopped) != 0 {
+		t.Errorf("ListContainer() = %v, %v", running, stopped)
+	}
+}
+
+// TestKubernetesCreateContainerLongName tests that containers with UUID ids and session ids, whose names
+// are too long for Kubernetes, get short, stable names and keep their identity in their labels
+func TestKubernetesCreateContainerLongName(t *testing.T) {
+	k, client := newTestKubernetesRuntime()
+	session := "8c7d0f3e-5b2a-4e61-9d84-2f6a1c0b9e57"
+	refs := []DockerContainerReference{
+		{CyanId: "3f2b8c1d-9e4a-4b7f-a6c5-1d2e3f4a5b6c", CyanType: "processor", SessionId: session},
+		{CyanId: "a1b2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d", CyanType: "merger", SessionId: session},
+	}
+	for _, cc := range refs {
+		name := ContainerHost(cc)
+		if name == DockerContainerToString(cc) {
+			t.Fatalf("ContainerHost(%v) = %s, want it shortened", cc, name)
+		}
+		if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+			t.Fatalf("ContainerHost(%v) = %s, not a DNS label: %v", cc, name, errs)
+		}
+		if again := ContainerHost(cc); again != name {
+			t.Errorf("ContainerHost(%v) = %s then %s, want it stable", cc, name, again)
+		}
+
+		if err := k.CreateContainer(cc, DockerImageReference{Reference: "registry.local/" + cc.CyanType, Tag: "1"}, ResourceLimits{}); err != nil {
+			t.Fatalf("CreateContainer(%v) error = %v", cc, err)
+		}
+		pod, err := client.CoreV1().Pods("cyanprint").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("pod %s not created: %v", name, err)
+		}
+		if pod.Labels[labelCyanId] != cc.CyanId || pod.Labels[labelSession] != session {
+			t.Errorf("Labels = %v", pod.Labels)
+		}
+		svc, err := client.CoreV1().Services("cyanprint").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("service %s not created: %v", name, err)
+		}
+		if svc.Spec.Selector[labelContainer] != pod.Labels[labelContainer] {
+			t.Errorf("service selector %v doesn't match pod labels %v", svc.Spec.Selector, pod.Labels)
+		}
+		if _, err := k.InspectContainer(cc); err != nil {
+			t.Errorf("InspectContainer(%v) error = %v", cc, err)
+		}
+	}
+
+	running, _, err := k.ListContainer()
+	if err != nil {
+		t.Fatalf("ListContainer() error = %v", err)
+	}
+	if len(running) != 2 || !slices.Contains(running, refs[0]) || !slices.Contains(running, refs[1]) {
+		t.Errorf("ListContainer() = %v, want %v", running, refs)
+	}
+	if err := k.RemoveContainer(refs[0]); err != nil {
+		t.Fatalf("RemoveContainer() error = %v", err)
+	}
+	if _, err := client.CoreV1().Services("cyanprint").Get(context.Background(), ContainerHost(refs[0]), metav1.GetOptions{}); err == nil {
+		t.Error("service left behind")
+	}
+}
+
+// TestKubernetesServiceFailure tests that a pod whose Service can't be created is removed
+func TestKubernetesServiceFailure(t *testing.T) {
+	k, client := newTestKubernetesRuntime()
+	client.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("quota exceeded")
+	})
+	cc := DockerContainerReference{CyanId: "plugin-1", CyanType: "plugin", SessionId: "s1"}
+
+	if err := k.CreateContainer(cc, DockerImageReference{Reference: "registry.local/plugin", Tag: "1"}, ResourceLimits{}); err == nil {
+		t.Fatal("CreateContainer() expected an error")
+	}
+	pods, _ := client.CoreV1().Pods("cyanprint").List(context.Background(), metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("pod left behind without its service")
+	}
+}
+
+// TestKubernetesMergerVolumes tests that mergers mount the template claim read-only, the session claim
+// read-write and receive the workspace settings and internal networks
+func TestKubernetesMergerVolumes(t *testing.T) {
+	k, client := newTestKubernetesRuntime()
+	k.Config.InternalNetworks = []string{"10.244.0.0/16", "10.96.0.0/12"}
+	read := DockerVolumeReference{CyanId: "template-1"}
+	write := DockerVolumeReference{CyanId: "template-1", SessionId: "s1"}
+	for _, v := range []DockerVolumeReference{read, write, write} {
+		if err := k.CreateVolume(v); err != nil {
+			t.Fatalf("CreateVolume() error = %v", err)
+		}
+	}
+	claims, _ := k.ListVolumes()
+	if len(claims) != 2 {
+		t.Fatalf("ListVolumes() = %v, want 2", claims)
+	}
+
+	cc := DockerContainerReference{CyanId: "merger-1", CyanType: "merger", SessionId: "s1"}
+	image, err := k.GetCoordinatorImage()
+	if err != nil {
+		t.Fatalf("GetCoordinatorImage() error = %v", err)
+	}
+	if err := k.CreateContainerWithReadWriteVolume(cc, read, write, image, ResourceLimits{CPUs: 1.5, Memory: 512 << 20}); err != nil {
+		t.Fatalf("CreateContainerWithReadWriteVolume() error = %v", err)
+	}
+	pod, err := client.CoreV1().Pods("cyanprint").Get(context.Background(), DockerContainerToString(cc), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("pod not created: %v", err)
+	}
+	mounts := pod.Spec.Containers[0].VolumeMounts
+	if len(mounts) != 2 {
+		t.Fatalf("VolumeMounts = %v", mounts)
+	}
+	if mounts[0].MountPath != DefaultConfig().Workspace.TemplateDir || !mounts[0].ReadOnly {
+		t.Errorf("template mount = %+v", mounts[0])
+	}
+	if mounts[1].MountPath != DefaultConfig().Workspace.AreaDir || mounts[1].ReadOnly {
+		t.Errorf("area mount = %+v", mounts[1])
+	}
+	limits := pod.Spec.Containers[0].Resources.Limits
+	if limits.Cpu().String() != "1500m" || limits.Memory().String() != "512Mi" {
+		t.Errorf("Limits = %v", limits)
+	}
+	env := map[string]string{}
+	for _, e := range pod.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env[EnvWorkspaceAreaDir] != DefaultConfig().Workspace.AreaDir || env[EnvInternalNetworks] != "10.244.0.0/16,10.96.0.0/12" {
+		t.Errorf("Env = %v", env)
+	}
+	if env[EnvSessionTTL] != "0" || env[EnvGCBudget] != "0" {
+		t.Errorf("Env = %v, want the reaper and garbage collector turned off", env)
+	}
+}
+
+// TestKubernetesListByLabels tests that pods and claims are identified by their labels, which keep the
+// dashes their names lose, and that unlabelled ones fall back to their names or are skipped
+func TestKubernetesListByLabels(t *testing.T) {
+	k, client := newTestKubernetesRuntime()
+	read := DockerVolumeReference{CyanId: "template-1"}
+	write := DockerVolumeReference{CyanId: "template-1", SessionId: "s-1"}
+	for _, v := range []DockerVolumeReference{read, write} {
+		if err := k.CreateVolume(v); err != nil {
+			t.Fatalf("CreateVolume() error = %v", err)
+		}
+	}
+	cc := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s-1"}
+	if err := k.CreateContainerWithReadWriteVolume(cc, read, write, DockerImageReference{Reference: "registry.local/processor", Tag: "1"}, ResourceLimits{}); err != nil {
+		t.Fatalf("CreateContainerWithReadWriteVolume() error = %v", err)
+	}
+	pods := client.CoreV1().Pods("cyanprint")
+	legacy := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cyan-template-template2", Labels: map[string]string{labelDev: "true"}}}
+	_, _ = pods.Create(context.Background(), legacy, metav1.CreateOptions{})
+	stray := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "scratch", Labels: map[string]string{labelDev: "true"}}}
+	_, _ = client.CoreV1().PersistentVolumeClaims("cyanprint").Create(context.Background(), stray, metav1.CreateOptions{})
+
+	running, _, err := k.ListContainer()
+	if err != nil {
+		t.Fatalf("ListContainer() error = %v", err)
+	}
+	want := []DockerContainerReference{cc, {CyanId: "template2", CyanType: "template"}}
+	if len(running) != 2 || !slices.Contains(running, want[0]) || !slices.Contains(running, want[1]) {
+		t.Errorf("ListContainer() = %v, want %v", running, want)
+	}
+	state, err := k.InspectContainer(cc)
+	if err != nil || state.Template != "template-1" {
+		t.Errorf("InspectContainer() = %+v, %v, want template template-1", state, err)
+	}
+	pod, _ := pods.Get(context.Background(), DockerContainerToString(cc), metav1.GetOptions{})
+	if pod.Annotations[labelBoronVersion] == "" {
+		t.Errorf("Annotations = %v, want the boron version", pod.Annotations)
+	}
+
+	volumes, err := k.ListVolumes()
+	if err != nil || len(volumes) != 2 || !slices.Contains(volumes, write) {
+		t.Errorf("ListVolumes() = %v, %v, want %v and %v", volumes, err, read, write)
+	}
+}
+
+// TestKubernetesWaitContainer tests that waiting returns the exit code once the pod has terminated
+func TestKubernetesWaitContainer(t *testing.T) {
+	k, client := newTestKubernetesRuntime()
+	cc := DockerContainerReference{CyanId: "local-1", CyanType: "unzip", SessionId: "s1"}
+	if err := k.CreateContainer(cc, DockerImageReference{Reference: "registry.local/blob", Tag: "1"}, ResourceLimits{}); err != nil {
+		t.Fatalf("CreateContainer() error = %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		pod, _ := client.CoreV1().Pods("cyanprint").Get(context.Background(), DockerContainerToString(cc), metav1.GetOptions{})
+		pod.Status.Phase = corev1.PodFailed
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 3}},
+		}}
+		_, _ = client.CoreV1().Pods("cyanprint").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{})
+	}()
+
+	code, err := k.WaitContainer(cc)
+	if err != nil {
+		t.Fatalf("WaitContainer() error = %v", err)
+	}
+	if code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+	_, stopped, _ := k.ListContainer()
+	if len(stopped) != 1 {
+		t.Errorf("stopped = %v, want the terminated pod", stopped)
+	}
+}
+
+// TestKubernetesWatchContainer tests that watching signals a pod whose container terminated, and not other pods
+func TestKubernetesWatchContainer(t *testing.T) {
+	k, client := newTestKubernetesRuntime()
+	cc := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"}
+	other := DockerContainerReference{CyanId: "processor-2", CyanType: "processor", SessionId: "s1"}
+	for _, c := range []DockerContainerReference{cc, other} {
+		if err := k.CreateContainer(c, DockerImageReference{Reference: "registry.local/processor", Tag: "1"}, ResourceLimits{}); err != nil {
+			t.Fatalf("CreateContainer() error = %v", err)
+		}
+	}
+	stopped, stop := k.WatchContainer(cc)
+	defer stop()
+
+	terminate := func(c DockerContainerReference) {
+		pod, _ := client.CoreV1().Pods("cyanprint").Get(context.Background(), DockerContainerToString(c), metav1.GetOptions{})
+		pod.Status.Phase = corev1.PodFailed
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}},
+		}}
+		_, _ = client.CoreV1().Pods("cyanprint").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{})
+	}
+	terminate(other)
+	select {
+	case <-stopped:
+		t.Fatal("stopped fired for another pod")
+	case <-time.After(20 * time.Millisecond):
+	}
+	terminate(cc)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stopped did not fire after the pod terminated")
+	}
+
+	logs, err := k.ContainerLogs(cc, 20)
+	if err != nil || len(logs) == 0 {
+		t.Errorf("ContainerLogs() = %v, %v", logs, err)
+	}
+	stream, err := k.StreamLogs(cc, LogOptions{Tail: 20})
+	if err != nil {
+		t.Fatalf("StreamLogs() error = %v", err)
+	}
+	defer stream.Close()
+	if out, err := io.ReadAll(stream); err != nil || len(out) == 0 {
+		t.Errorf("StreamLogs() = %q, %v", out, err)
+	}
+}
+
+// TestKubernetesEnforceNetwork tests that the namespace is created once
+func TestKubernetesEnforceNetwork(t *testing.T) {
+	k, _ := newTestKubernetesRuntime()
+	for i := 0; i < 2; i++ {
+		if err := k.EnforceNetwork(); err != nil {
+			t.Fatalf("EnforceNetwork() error = %v", err)
+		}
+	}
+	exist, err := k.CyanPrintNetworkExist()
+	if err != nil || !exist {
+		t.Errorf("CyanPrintNetworkExist() = %v, %v", exist, err)
+	}
+}
+
+// TestKubernetesExecutorClean tests that the executor cleans a session's pods, services and claims
+func TestKubernetesExecutorClean(t *testing.T) {
+	k, client := newTestKubernetesRuntime()
+	own := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"}
+	other := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s2"}
+	for _, cc := range []DockerContainerReference{own, other} {
+		if err := k.CreateContainer(cc, DockerImageReference{Reference: "registry.local/processor", Tag: "1"}, ResourceLimits{}); err != nil {
+			t.Fatalf("CreateContainer() error = %v", err)
+		}
+	}
+	if err := k.CreateVolume(DockerVolumeReference{CyanId: "template-1", SessionId: "s1"}); err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	sessions := NewSessionRegistry()
+	sessions.Transition("s1", "template-1", SessionStarted)
+	e := Executor{Docker: k, Sessions: sessions}
+
+	if errs := e.Clean("s1"); len(errs) > 0 {
+		t.Fatalf("Clean() errors = %v", errs)
+	}
+	running, _, _ := k.ListContainer()
+	if len(running) != 1 || DockerContainerToString(running[0]) != DockerContainerToString(other) {
+		t.Errorf("containers after clean = %v, want only %v", running, other)
+	}
+	services, _ := client.CoreV1().Services("cyanprint").List(context.Background(), metav1.ListOptions{})
+	if len(services.Items) != 1 || services.Items[0].Name != DockerContainerToString(other) {
+		t.Errorf("services after clean = %v", services.Items)
+	}
+	if volumes, _ := k.ListVolumes(); len(volumes) != 0 {
+		t.Errorf("volumes after clean = %v", volumes)
+	}
+}
+
+// TestKubernetesSessionNetwork tests that a session's pods admit only the session and the coordinator, and that
+// denying egress keeps processors and plugins within the session
+func TestKubernetesSessionNetwork(t *testing.T) {
+	k, client := newTestKubernetesRuntime()
+	k.Config.Isolation = IsolationConfig{SessionNetworks: true, DenyEgress: true, Coordinator: "boron-0"}
+	_, _ = client.CoreV1().Pods("cyanprint").Create(context.Background(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "boron-0", Labels: map[string]string{"app": "boron", "pod-template-hash": "abc"}},
+	}, metav1.CreateOptions{})
+
+	if err := k.CreateSessionNetwork("s1", nil); err != nil {
+		t.Fatalf("CreateSessionNetwork() error = %v", err)
+	}
+	if err := k.CreateSessionNetwork("s1", nil); err != nil {
+		t.Fatalf("CreateSessionNetwork() again error = %v", err)
+	}
+	policy, err := client.NetworkingV1().NetworkPolicies("cyanprint").Get(context.Background(), "cyanprint-s1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("ingress policy not created: %v", err)
+	}
+	from := policy.Spec.Ingress[0].From
+	if len(from) != 2 || from[0].PodSelector.MatchLabels[labelSession] != "s1" {
+		t.Fatalf("ingress peers = %+v", from)
+	}
+	if got := from[1].PodSelector.MatchLabels; got["app"] != "boron" || got["pod-template-hash"] != "" {
+		t.Errorf("coordinator selector = %v", got)
+	}
+	if _, err := client.NetworkingV1().NetworkPolicies("cyanprint").Get(context.Background(), "cyanprint-s1-egress", metav1.GetOptions{}); err != nil {
+		t.Errorf("egress policy not created: %v", err)
+	}
+	if sessions, _ := k.ListSessionNetworks(); len(sessions) != 1 || sessions[0] != "s1" {
+		t.Errorf("ListSessionNetworks() = %v", sessions)
+	}
+
+	if err := k.RemoveSessionNetwork("s1"); err != nil {
+		t.Fatalf("RemoveSessionNetwork() error = %v", err)
+	}
+	if sessions, _ := k.ListSessionNetworks(); len(sessions) != 0 {
+		t.Errorf("policies left behind for %v", sessions)
+	}
+}
+
+// TestKubernetesRestartContainer tests that a stopped pod is replaced by a pending one of the same spec,
+// and that pods with malformed names are listed apart and can be removed
+func TestKubernetesRestartContainer(t *testing.T) {
+	k, client := newTestKubernetesRuntime()
+	cc := DockerContainerReference{CyanId: "template-1", CyanType: "template"}
+	name := DockerContainerToString(cc)
+	if err := k.CreateContainer(cc, DockerImageReference{Reference: "registry.local/template", Tag: "1"}, ResourceLimits{}); err != nil {
+		t.Fatalf("CreateContainer() error = %v", err)
+	}
+	pods := client.CoreV1().Pods("cyanprint")
+	pod, _ := pods.Get(context.Background(), name, metav1.GetOptions{})
+	pod.Status.Phase = corev1.PodFailed
+	_, _ = pods.UpdateStatus(context.Background(), pod, metav1.UpdateOptions{})
+
+	if err := k.RestartContainer(cc); err != nil {
+		t.Fatalf("RestartContainer() error = %v", err)
+	}
+	pod, err := pods.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("pod %s not recreated: %v", name, err)
+	}
+	if pod.Status.Phase == corev1.PodFailed || pod.Spec.Containers[0].Image != "registry.local/template:1" {
+		t.Errorf("recreated pod = %s, %s", pod.Status.Phase, pod.Spec.Containers[0].Image)
+	}
+	state, err := k.InspectContainer(cc)
+	if err != nil || DockerImageToString(state.Image) != "registry.local/template:1" {
+		t.Errorf("InspectContainer() = %+v, %v", state, err)
+	}
+
+	malformed := &corev1.Pod{ObjectMeta: objectMeta("cyan-broken", resourceLabels(""))}
+	_, _ = pods.Create(context.Background(), malformed, metav1.CreateOptions{})
+	running, _, err := k.ListContainer()
+	if err != nil || len(running) != 1 {
+		t.Errorf("ListContainer() = %v, %v, want the malformed pod skipped", running, err)
+	}
+	names, _ := k.ListMalformedContainers()
+	if len(names) != 1 || names[0] != "cyan-broken" {
+		t.Errorf("ListMalformedContainers() = %v", names)
+	}
+	if err := k.RemoveContainerNamed("cyan-broken"); err != nil {
+		t.Errorf("RemoveContainerNamed() error = %v", err)
+	}
+}
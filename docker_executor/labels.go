@@ -0,0 +1,113 @@
+package docker_executor
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Labels stamped on the resources the coordinator creates. Identity labels make a resource's type, id
+// and session readable without parsing its name, which can't tell a dash in a session id from a separator.
+const (
+	labelDev          = "cyanprint.dev"
+	labelType         = "cyanprint.type"
+	labelCyanId       = "cyanprint.cyan_id"
+	labelSession      = "cyanprint.session"
+	labelTemplate     = "cyanprint.template"
+	labelCreatedAt    = "cyanprint.created_at"
+	labelBoronVersion = "cyanprint.boron_version"
+)
+
+// volumeType is the cyanprint.type of volumes
+const volumeType = "volume"
+
+// BoronVersion is stamped on resources as cyanprint.boron_version. Release builds set it with
+// -ldflags "-X github.com/AtomiCloud/sulfone.boron/docker_executor.BoronVersion=<version>"; other builds
+// fall back to the module version Go recorded.
+var BoronVersion = ""
+
+var boronVersion = sync.OnceValue(func() string {
+	if BoronVersion != "" {
+		return BoronVersion
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+})
+
+// resourceLabels returns the labels stamped on every container, volume and network the coordinator creates.
+// The creation timestamp lets the reaper expire sessions even across coordinator restarts.
+func resourceLabels(sessionId string) map[string]string {
+	return map[string]string{
+		labelDev:          "true",
+		labelSession:      sessionId,
+		labelCreatedAt:    time.Now().UTC().Format(time.RFC3339),
+		labelBoronVersion: boronVersion(),
+	}
+}
+
+// containerLabels are the resource labels plus the container's identity
+func containerLabels(cc DockerContainerReference, template string) map[string]string {
+	labels := resourceLabels(cc.SessionId)
+	labels[labelType] = cc.CyanType
+	labels[labelCyanId] = cc.CyanId
+	if template != "" {
+		labels[labelTemplate] = template
+	}
+	return labels
+}
+
+// volumeLabels are the resource labels plus the volume's identity. A volume holds the files of the
+// template it is named after.
+func volumeLabels(vol DockerVolumeReference) map[string]string {
+	labels := resourceLabels(vol.SessionId)
+	labels[labelType] = volumeType
+	labels[labelCyanId] = vol.CyanId
+	labels[labelTemplate] = vol.CyanId
+	return labels
+}
+
+// containerTemplate returns the template a container serves: itself for a template container, otherwise
+// the template of the volume it reads from. Resolvers serve every template, so have none.
+func containerTemplate(cc DockerContainerReference, templateVolume DockerVolumeReference) string {
+	if cc.CyanType == "template" {
+		return cc.CyanId
+	}
+	return templateVolume.CyanId
+}
+
+// containerFromLabels reads a container's identity from its labels. It is false for containers created
+// before identity labels, whose names have to be parsed instead.
+func containerFromLabels(labels map[string]string) (DockerContainerReference, bool) {
+	cyanType, id := labels[labelType], labels[labelCyanId]
+	if cyanType == "" || id == "" {
+		return DockerContainerReference{}, false
+	}
+	return DockerContainerReference{CyanId: id, CyanType: cyanType, SessionId: labels[labelSession]}, true
+}
+
+// volumeFromLabels reads a volume's identity from its labels, like containerFromLabels
+func volumeFromLabels(labels map[string]string) (DockerVolumeReference, bool) {
+	id := labels[labelCyanId]
+	if labels[labelType] != volumeType || id == "" {
+		return DockerVolumeReference{}, false
+	}
+	return DockerVolumeReference{CyanId: id, SessionId: labels[labelSession]}, true
+}
+
+// containerIdentity identifies a container by its labels, or failing that its name
+func containerIdentity(labels map[string]string, name string) (DockerContainerReference, error) {
+	if ref, ok := containerFromLabels(labels); ok {
+		return ref, nil
+	}
+	return DockerContainerNameToStruct(name)
+}
+
+// volumeIdentity identifies a volume by its labels, or failing that its name
+func volumeIdentity(labels map[string]string, name string) (DockerVolumeReference, error) {
+	if ref, ok := volumeFromLabels(labels); ok {
+		return ref, nil
+	}
+	return DockerVolumeNameToStruct(name)
+}
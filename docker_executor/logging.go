@@ -0,0 +1,57 @@
+package docker_executor
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Structured log field keys shared by every component, so log lines of concurrent sessions can be told apart
+const (
+	LogKeySession   = "session_id"
+	LogKeyTemplate  = "template_id"
+	LogKeyContainer = "container"
+	LogKeyCyanType  = "cyan_type"
+	LogKeyCyanId    = "cyan_id"
+	LogKeyImage     = "image"
+	LogKeyVolume    = "volume"
+	LogKeyError     = "error"
+	LogKeyTrace     = "trace_id"
+)
+
+// NewLogger creates a structured logger writing to w.
+// level is one of debug, info, warn, error; format is text or json.
+func NewLogger(w io.Writer, level string, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level '%s': must be debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format '%s': must be text or json", format)
+	}
+}
+
+// loggerOrDefault falls back to the process-wide logger when a component was not given one
+func loggerOrDefault(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
+
+// containerAttrs returns the log fields identifying a container.
+// The session id is left to the logger's own fields, which callers set once per session.
+func containerAttrs(ref DockerContainerReference) []any {
+	return []any{
+		LogKeyContainer, DockerContainerToString(ref),
+		LogKeyCyanType, ref.CyanType,
+		LogKeyCyanId, ref.CyanId,
+	}
+}
@@ -0,0 +1,112 @@
+package docker_executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// decodeLines parses the JSON log lines written to buf
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+// TestNewLogger tests that levels and formats are parsed case-insensitively, invalid ones are rejected,
+// and messages below the level are dropped
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		level   string
+		format  string
+		want    []string
+		wantErr bool
+	}{
+		{level: "debug", format: "json", want: []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{level: "info", format: "json", want: []string{"INFO", "WARN", "ERROR"}},
+		{level: "WARN", format: "JSON", want: []string{"WARN", "ERROR"}},
+		{level: "error", format: "json", want: []string{"ERROR"}},
+		{level: "info", format: "text", want: []string{"INFO", "WARN", "ERROR"}},
+		{level: "info", format: "", want: []string{"INFO", "WARN", "ERROR"}},
+		{level: "verbose", format: "json", wantErr: true},
+		{level: "info", format: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level+"/"+tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := NewLogger(&buf, tt.level, tt.format)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("NewLogger() expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewLogger() error = %v", err)
+			}
+			l.Debug("debug")
+			l.Info("info")
+			l.Warn("warn")
+			l.Error("error")
+
+			var levels []string
+			if strings.EqualFold(tt.format, "json") {
+				for _, line := range decodeLines(t, &buf) {
+					levels = append(levels, line["level"].(string))
+				}
+			} else {
+				for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+					_, after, _ := strings.Cut(line, "level=")
+					level, _, _ := strings.Cut(after, " ")
+					levels = append(levels, level)
+				}
+			}
+			if strings.Join(levels, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("logged levels %v, want %v", levels, tt.want)
+			}
+		})
+	}
+}
+
+// TestSessionLoggerAttributes tests that components log with their session and template, and container
+// lines identify the container
+func TestSessionLoggerAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := NewLogger(&buf, "info", "json")
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+	m := Merger{Logger: l, SessionId: "s1", Template: TemplateVersionRes{Principal: TemplateVersionPrincipalRes{ID: "template-1"}}}
+	ref := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"}
+	m.log().Info("Processor started", containerAttrs(ref)...)
+	m.log().Debug("dropped")
+
+	lines := decodeLines(t, &buf)
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want 1", len(lines))
+	}
+	want := map[string]string{
+		"msg":           "Processor started",
+		LogKeySession:   "s1",
+		LogKeyTemplate:  "template-1",
+		LogKeyContainer: "cyan-processor-processor1-s1",
+		LogKeyCyanType:  "processor",
+		LogKeyCyanId:    "processor-1",
+	}
+	for key, value := range want {
+		if lines[0][key] != value {
+			t.Errorf("%s = %v, want %s", key, lines[0][key], value)
+		}
+	}
+}
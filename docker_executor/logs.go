@@ -0,0 +1,69 @@
+package docker_executor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LogOptions select the output StreamLogs returns
+type LogOptions struct {
+	// Tail is how many of the last lines to start from; 0 starts from the first
+	Tail int
+	// Follow keeps the stream open for new output until the container stops or the stream is closed
+	Follow bool
+}
+
+// ContainerCallError is a failed request to a container, such as a processor answering 500. It names
+// the container so failure responses can show what it logged.
+type ContainerCallError struct {
+	Container DockerContainerReference
+	Err       error
+}
+
+func (e *ContainerCallError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ContainerCallError) Unwrap() error {
+	return e.Err
+}
+
+// failedContainer returns the container err blames, if it blames one
+func failedContainer(err error) (DockerContainerReference, bool) {
+	var callErr *ContainerCallError
+	var notReady *ReadinessError
+	var limitErr *ContainerLimitError
+	switch {
+	case errors.As(err, &callErr):
+		return callErr.Container, true
+	case errors.As(err, &notReady):
+		return notReady.Container, true
+	case errors.As(err, &limitErr):
+		return limitErr.Container, true
+	}
+	return DockerContainerReference{}, false
+}
+
+// FailureLogs returns the last log lines of each container one of errs blames, one entry per container,
+// to attach to a failure response. A ContainerExitError already carries its container's logs, so it is skipped.
+func FailureLogs(rt ContainerRuntime, errs []error) []string {
+	lines := rt.Settings().HealthCheck.LogLines
+	seen := make(map[string]bool)
+	var out []string
+	for _, err := range errs {
+		ref, ok := failedContainer(err)
+		name := DockerContainerToString(ref)
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		// the container may be gone already; the error alone has to do then
+		logs, err := rt.ContainerLogs(ref, lines)
+		if err != nil || len(logs) == 0 {
+			continue
+		}
+		out = append(out, fmt.Sprintf("last logs of %s:\n%s", name, strings.Join(logs, "\n")))
+	}
+	return out
+}
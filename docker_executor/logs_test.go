@@ -0,0 +1,110 @@
+package docker_executor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+)
+
+// TestFailureLogs tests that failure responses get the last logs of each container an error blames, once
+func TestFailureLogs(t *testing.T) {
+	processor := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"}
+	plugin := DockerContainerReference{CyanId: "plugin-1", CyanType: "plugin", SessionId: "s1"}
+	crashed := DockerContainerReference{CyanId: "processor-2", CyanType: "processor", SessionId: "s1"}
+	gone := DockerContainerReference{CyanId: "merger-1", CyanType: "merger", SessionId: "s1"}
+
+	rt := NewFakeRuntime()
+	rt.Config.HealthCheck.LogLines = 2
+	for _, c := range []DockerContainerReference{processor, plugin, crashed} {
+		rt.AddContainer(c, true)
+	}
+	rt.SetLogs(processor, "reading templates", "TypeError: undefined is not a function", "    at process (index.js:12)")
+	rt.SetLogs(plugin, "listening on 5552")
+	rt.SetLogs(crashed, "Error: cannot find module")
+
+	callErr := &ContainerCallError{Container: processor, Err: errors.New(`500 {"error":"boom"}`)}
+	errs := []error{
+		callErr,
+		fmt.Errorf("processor failed: %w", callErr),
+		&ReadinessError{Container: plugin, Attempts: 3, Last: "connection refused"},
+		&ContainerExitError{Container: crashed, ExitCode: 1, Logs: []string{"Error: cannot find module"}},
+		&ContainerCallError{Container: gone, Err: errors.New("connection refused")},
+		errors.New("processor p does not exist in template t"),
+	}
+
+	got := FailureLogs(rt, errs)
+	want := []string{
+		"last logs of cyan-processor-processor1-s1:\nTypeError: undefined is not a function\n    at process (index.js:12)",
+		"last logs of cyan-plugin-plugin1-s1:\nlistening on 5552",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("FailureLogs() = %q, want %q", got, want)
+	}
+	if callErr.Error() != `500 {"error":"boom"}` {
+		t.Errorf("ContainerCallError.Error() = %q, want the call's error unchanged", callErr.Error())
+	}
+}
+
+// TestFailedContainer tests that errors blame the container they were raised for as it is, even where
+// its name couldn't be parsed back into it
+func TestFailedContainer(t *testing.T) {
+	// the id isn't a 32-character UUID and the session id has a dash, so the name loses both
+	c := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "try-1"}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "call", err: fmt.Errorf("processor failed: %w", &ContainerCallError{Container: c, Err: errors.New("500")}), want: true},
+		{name: "not ready", err: &ReadinessError{Container: c, Attempts: 3}, want: true},
+		{name: "out of memory", err: &ContainerLimitError{Container: c}, want: true},
+		{name: "exited", err: &ContainerExitError{Container: c, ExitCode: 1}},
+		{name: "unrelated", err: errors.New("processor p does not exist in template t")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := failedContainer(tt.err)
+			if ok != tt.want || (tt.want && got != c) {
+				t.Errorf("failedContainer() = %+v, %t, want %+v, %t", got, ok, c, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeStreamLogs(t *testing.T) {
+	c := DockerContainerReference{CyanId: "template-1", CyanType: "template"}
+	rt := NewFakeRuntime()
+	rt.AddContainer(c, true)
+	rt.SetLogs(c, "one", "two", "three")
+
+	tests := []struct {
+		name string
+		tail int
+		want string
+	}{
+		{"everything", 0, "one\ntwo\nthree\n"},
+		{"tail", 2, "two\nthree\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := rt.StreamLogs(c, LogOptions{Tail: tt.tail})
+			if err != nil {
+				t.Fatalf("StreamLogs() error = %v", err)
+			}
+			defer logs.Close()
+			out, err := io.ReadAll(logs)
+			if err != nil {
+				t.Fatalf("read error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("StreamLogs() = %q, want %q", out, tt.want)
+			}
+		})
+	}
+
+	if _, err := rt.StreamLogs(DockerContainerReference{CyanId: "missing", CyanType: "template"}, LogOptions{}); err == nil {
+		t.Error("StreamLogs() of a missing container succeeded")
+	}
+}
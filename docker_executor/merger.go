@@ -2,22 +2,41 @@ package docker_executor
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/google/uuid"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Merger struct {
+	Context          context.Context
 	ParallelismLimit int
 	RegistryClient   RegistryClient
 	Template         TemplateVersionRes
 	SessionId        string
+	Sessions         *SessionRegistry
+	Events           Emitter
+	Logger           *slog.Logger
+	Config           Config
+}
+
+func (m Merger) log() *slog.Logger {
+	return loggerOrDefault(m.Logger).With(LogKeySession, m.SessionId, LogKeyTemplate, m.Template.Principal.ID)
+}
+
+func (m Merger) config() Config {
+	return m.Config.withDefaults()
 }
 
 func copyFile(src, dst string) error {
@@ -53,7 +72,7 @@ func copyFile(src, dst string) error {
 	return os.Chmod(dst, info.Mode())
 }
 
-func PostJSON[Req any, Res any](url string, requestBody Req) (Res, error) {
+func PostJSON[Req any, Res any](ctx context.Context, url string, requestBody Req) (Res, error) {
 	var responseBody Res
 
 	// Marshal the request into JSON
@@ -63,7 +82,12 @@ func PostJSON[Req any, Res any](url string, requestBody Req) (Res, error) {
 	}
 
 	// Perform the HTTP POST request
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(contextOrBackground(ctx), http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return responseBody, fmt.Errorf("error creating POST request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := tracedClient.Do(req)
 	if err != nil {
 		return responseBody, fmt.Errorf("error performing POST request: %w", err)
 	}
@@ -136,17 +160,21 @@ func buildResolverFiles(relPath string, versions []processorFile) ([]ResolverFil
 }
 
 // callResolver makes an HTTP POST request to the resolver container
-func callResolver(resolverID string, sessionID string, req ResolverRequest) (*ResolverResponse, error) {
+func callResolver(ctx context.Context, resolverID string, sessionID string, req ResolverRequest) (*ResolverResponse, error) {
 	ref := DockerContainerReference{
 		CyanId:    resolverID,
 		CyanType:  CyanTypeResolver,
 		SessionId: "",
 	}
-	containerName := DockerContainerToString(ref)
+	containerName := ContainerHost(ref)
 	endpoint := fmt.Sprintf("http://%s:%d/api/resolve", containerName, ResolverPort)
 
 	// Use PostJSON generic function
-	response, err := PostJSON[ResolverRequest, ResolverResponse](endpoint, req)
+	start := time.Now()
+	ctx, span := startSpan(ctx, "resolver", attribute.String(LogKeyCyanId, resolverID), attribute.String(LogKeyCyanType, CyanTypeResolver))
+	response, err := PostJSON[ResolverRequest, ResolverResponse](ctx, endpoint, req)
+	observeSince(resolverCallDuration, start, resultLabel(err))
+	endSpan(span, err)
 	if err != nil {
 		// Detect connection failures (container not running)
 		if strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "no such host") || strings.Contains(err.Error(), "dial tcp") {
@@ -206,15 +234,15 @@ func (m Merger) execProcessors(processors []CyanProcessorReq) ([]string, []strin
 			semaphore <- 0
 			filePath, err := uuid.NewUUID()
 			if err != nil {
-				fmt.Printf("Error unique write-path: %v", err)
+				m.log().Error("Error generating unique write path", LogKeyError, err)
 				errChan <- err
 				<-semaphore
 				return
 			}
-			fmt.Println("🔭 Checking Processor references...")
+			m.log().Debug("Checking processor references", "processor", p.Name)
 			pp, err := m.RegistryClient.convertProcessor(p, m.Template.Processors)
 			if err != nil {
-				fmt.Printf("Error converting processor %s: %v", p.Name, err)
+				m.log().Error("Error converting processor", "processor", p.Name, LogKeyError, err)
 				errChan <- err
 				<-semaphore
 				return
@@ -228,32 +256,47 @@ func (m Merger) execProcessors(processors []CyanProcessorReq) ([]string, []strin
 			}
 			if !exist {
 				err = fmt.Errorf("processor %s does not exist in template %s", pp.Id, m.Template.Principal.ID)
-				fmt.Printf("Processor %s does not exist in template %s", pp.Id, m.Template.Principal.ID)
+				m.log().Error("Processor does not exist in template", LogKeyCyanId, pp.Id)
 				errChan <- err
 				<-semaphore
 				return
 			}
-			fmt.Println("✅ Processor references checked.")
+			m.log().Debug("Processor references checked", "processor", p.Name, LogKeyCyanId, pp.Id)
 			container := DockerContainerReference{
 				CyanId:    pp.Id,
 				CyanType:  "processor",
 				SessionId: m.SessionId,
 			}
-			endpoint := fmt.Sprintf("http://%s:5551/api/process", DockerContainerToString(container))
-			fmt.Println("🚀 Starting processor with ", endpoint)
-			res, err := PostJSON[IsoProcessorReq, IsoProcessorRes](endpoint, IsoProcessorReq{
-				ReadDir:  "/workspace/cyanprint",
-				WriteDir: "/workspace/area/" + filePath.String(),
+			endpoint := fmt.Sprintf("http://%s:5551/api/process", ContainerHost(container))
+			m.log().Info("Starting processor", append(containerAttrs(container), "endpoint", endpoint)...)
+			processorEvent := Event{
+				Container: DockerContainerToString(container),
+				CyanId:    pp.Id,
+				CyanType:  "processor",
+				Endpoint:  endpoint,
+			}
+			processorEvent.Type = EventProcessorStarted
+			m.Events.Emit(processorEvent)
+			start := time.Now()
+			ctx, span := startSpan(m.Context, "processor", containerSpanAttrs(container)...)
+			res, err := PostJSON[IsoProcessorReq, IsoProcessorRes](ctx, endpoint, IsoProcessorReq{
+				ReadDir:  m.config().Workspace.TemplateDir,
+				WriteDir: filepath.Join(m.config().Workspace.AreaDir, filePath.String()),
 				Globs:    pp.Files,
 				Config:   pp.Config,
 			})
+			observeSince(processorCallDuration, start, resultLabel(err))
+			endSpan(span, err)
+			processorEvent.Type = EventProcessorCompleted
+			processorEvent.Error = errorString(err)
+			m.Events.Emit(processorEvent)
 			if err != nil {
-				fmt.Printf("Error starting processor %s: %v", pp.Id, err)
-				errChan <- err
+				m.log().Error("Error running processor", append(containerAttrs(container), LogKeyError, err)...)
+				errChan <- &ContainerCallError{Container: container, Err: err}
 				<-semaphore
 				return
 			}
-			fmt.Println("🎉 Processor", pp.Id, "completed")
+			m.log().Info("Processor completed", containerAttrs(container)...)
 			// Store result at the processor's original index to preserve order
 			writeDirs[processorIndex] = res.OutputDir
 			processorIDs[processorIndex] = pp.Id
@@ -280,16 +323,16 @@ func (m Merger) execPlugins(mergePath string, plugins []CyanPluginReq) []error {
 
 	for _, plugin := range plugins {
 		go func(c CyanPluginReq) {
-			fmt.Println("🔭 Checking Plugin references...")
+			m.log().Debug("Checking plugin references", "plugin", c.Name)
 			p, err := m.RegistryClient.convertPlugin(c, m.Template.Plugins)
 			if err != nil {
-				fmt.Printf("🚨 Error converting plugin %s: %v\n", c.Name, err)
+				m.log().Error("Error converting plugin", "plugin", c.Name, LogKeyError, err)
 				errChan <- err
 				pluginChan <- CyanPlugin{}
 
 				return
 			}
-			fmt.Println("✅ Plugin references checked.")
+			m.log().Debug("Plugin references checked", "plugin", c.Name, LogKeyCyanId, p.Id)
 			pluginChan <- p
 			errChan <- nil
 		}(plugin)
@@ -316,17 +359,29 @@ func (m Merger) execPlugins(mergePath string, plugins []CyanPluginReq) []error {
 			CyanType:  "plugin",
 			SessionId: m.SessionId,
 		}
-		endpoint := fmt.Sprintf("http://%s:5552/api/plug", DockerContainerToString(container))
-		fmt.Println("🚀 Process plugin on ", endpoint)
-		_, err := PostJSON[IsoPluginReq, IsoPluginRes](endpoint, IsoPluginReq{
+		endpoint := fmt.Sprintf("http://%s:5552/api/plug", ContainerHost(container))
+		m.log().Info("Running plugin", append(containerAttrs(container), "endpoint", endpoint)...)
+		start := time.Now()
+		ctx, span := startSpan(m.Context, "plugin", containerSpanAttrs(container)...)
+		_, err := PostJSON[IsoPluginReq, IsoPluginRes](ctx, endpoint, IsoPluginReq{
 			Directory: mergePath,
 			Config:    plugin.Config,
 		})
+		observeSince(pluginCallDuration, start, resultLabel(err))
+		endSpan(span, err)
+		m.Events.Emit(Event{
+			Type:      EventPluginCompleted,
+			Container: DockerContainerToString(container),
+			CyanId:    plugin.Id,
+			CyanType:  "plugin",
+			Endpoint:  endpoint,
+			Error:     errorString(err),
+		})
 		if err != nil {
-			fmt.Printf("🚨 Error processing plugin %s: %v\n", plugin.Id, err)
-			return []error{err}
+			m.log().Error("Error running plugin", append(containerAttrs(container), LogKeyError, err)...)
+			return []error{&ContainerCallError{Container: container, Err: err}}
 		}
-		fmt.Println("🎉 Plugin", plugin.Id, "completed")
+		m.log().Info("Plugin completed", containerAttrs(container)...)
 	}
 	return nil
 }
@@ -345,24 +400,35 @@ func (m Merger) merge(dirs []string, processorIDs []string, mergePath string, me
 		SessionId: m.SessionId,
 	}
 
-	ep := DockerContainerToString(c)
+	ep := ContainerHost(c)
 	fullEp := "http://" + ep + ":9000/merge/" + m.SessionId
-	fmt.Println("🚀 Starting merger with ", fullEp)
-	_, err := PostJSON[MergeReq, StandardResponse](fullEp, req)
+	m.log().Info("Starting merger", append(containerAttrs(c), "endpoint", fullEp)...)
+	ctx, span := startSpan(m.Context, "merge", containerSpanAttrs(c)...)
+	res, err := PostJSON[MergeReq, MergeRes](ctx, fullEp, req)
+	endSpan(span, err)
 	if err != nil {
-		fmt.Printf("🚨 Error starting merger: %v\n", err)
-		return err
+		m.log().Error("Error running merger", append(containerAttrs(c), LogKeyError, err)...)
+		return &ContainerCallError{Container: c, Err: err}
+	}
+	for _, conflict := range res.Conflicts {
+		m.Events.Emit(Event{
+			Type:       EventConflictResolved,
+			Path:       conflict.Path,
+			Resolution: conflict.Kind,
+			Resolver:   conflict.Resolver,
+		})
 	}
-	fmt.Println("🎉 Merger completed")
+	m.log().Info("Merger completed", append(containerAttrs(c), "conflicts", len(res.Conflicts))...)
 	return nil
 }
 
 // MergeFiles used by merger container
-// Detects conflicts and calls resolvers to intelligently merge conflicting files
-func (m Merger) MergeFiles(fromDirs []string, processorIDs []string, mergeDir string) error {
+// Detects conflicts and calls resolvers to intelligently merge conflicting files.
+// Returns how each conflicting file was resolved.
+func (m Merger) MergeFiles(fromDirs []string, processorIDs []string, mergeDir string) ([]ConflictResolution, error) {
 	// Ensure merge directory exists before processing
 	if err := os.MkdirAll(mergeDir, 0755); err != nil {
-		return fmt.Errorf("failed to create merge directory '%s': %w", mergeDir, err)
+		return nil, fmt.Errorf("failed to create merge directory '%s': %w", mergeDir, err)
 	}
 
 	// Step 1: Collect all files from all processor outputs
@@ -400,13 +466,14 @@ func (m Merger) MergeFiles(fromDirs []string, processorIDs []string, mergeDir st
 			return nil
 		})
 		if err != nil {
-			return fmt.Errorf("failed to walk directory '%s': %w", dir, err)
+			return nil, fmt.Errorf("failed to walk directory '%s': %w", dir, err)
 		}
 	}
 
 	// Step 2: Identify conflicts without calling resolvers
 	var conflicts []string
 	var nonConflicts []string
+	var resolutions []ConflictResolution
 	for path, versions := range fileMap {
 		if len(versions) > 1 {
 			conflicts = append(conflicts, path)
@@ -422,26 +489,32 @@ func (m Merger) MergeFiles(fromDirs []string, processorIDs []string, mergeDir st
 		// Match resolvers using doublestar.Match()
 		matchingResolvers, err := findMatchingResolver(conflictPath, m.Template.Resolvers)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if len(matchingResolvers) == 0 {
 			// LWW: use last version
-			fmt.Printf("Conflict detected for '%s': no resolver match, using last writer wins (layer %d)\n", conflictPath, versions[len(versions)-1].Layer)
+			m.log().Info("Conflict has no matching resolver, using last writer wins", "path", conflictPath, "layer", versions[len(versions)-1].Layer)
 			lastVersion := versions[len(versions)-1]
 			destPath := filepath.Join(mergeDir, conflictPath)
 			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-				return fmt.Errorf("failed to create directory for '%s': %w", conflictPath, err)
+				return nil, fmt.Errorf("failed to create directory for '%s': %w", conflictPath, err)
 			}
 			if err := copyFile(lastVersion.Path, destPath); err != nil {
-				return fmt.Errorf("failed to copy file '%s': %w", conflictPath, err)
+				return nil, fmt.Errorf("failed to copy file '%s': %w", conflictPath, err)
 			}
+			mergeConflicts.WithLabelValues(string(ConflictLastWriterWins)).Inc()
+			resolutions = append(resolutions, ConflictResolution{
+				Path:     conflictPath,
+				Kind:     ConflictLastWriterWins,
+				Versions: len(versions),
+			})
 		} else if len(matchingResolvers) == 1 {
 			// Call resolver with all versions
 			resolver := matchingResolvers[0]
 			files, err := buildResolverFiles(conflictPath, versions)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			request := ResolverRequest{
@@ -449,15 +522,15 @@ func (m Merger) MergeFiles(fromDirs []string, processorIDs []string, mergeDir st
 				Files:  files,
 			}
 
-			fmt.Printf("Calling resolver '%s' for conflict '%s' with %d versions\n", resolver.ID, conflictPath, len(files))
-			response, err := callResolver(resolver.ID, m.SessionId, request)
+			m.log().Info("Calling resolver for conflict", "path", conflictPath, "resolver", resolver.ID, "versions", len(files))
+			response, err := callResolver(m.Context, resolver.ID, m.SessionId, request)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			// Verify resolver returned the expected path
 			if response.Path != conflictPath {
-				return fmt.Errorf("Resolver returned invalid path: expected '%s', got '%s'", conflictPath, response.Path)
+				return nil, fmt.Errorf("Resolver returned invalid path: expected '%s', got '%s'", conflictPath, response.Path)
 			}
 
 			// Determine file mode from winning (last) source file
@@ -472,19 +545,26 @@ func (m Merger) MergeFiles(fromDirs []string, processorIDs []string, mergeDir st
 			// Write resolved content to merge directory
 			destPath := filepath.Join(mergeDir, response.Path)
 			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-				return fmt.Errorf("failed to create directory for '%s': %w", response.Path, err)
+				return nil, fmt.Errorf("failed to create directory for '%s': %w", response.Path, err)
 			}
 			if err := os.WriteFile(destPath, []byte(response.Content), 0644); err != nil {
-				return fmt.Errorf("failed to write resolved file '%s': %w", response.Path, err)
+				return nil, fmt.Errorf("failed to write resolved file '%s': %w", response.Path, err)
 			}
 			// Apply exact file mode after write to bypass umask, consistent with copyFile
 			if err := os.Chmod(destPath, mode); err != nil {
-				return fmt.Errorf("failed to set file mode on resolved file '%s': %w", response.Path, err)
+				return nil, fmt.Errorf("failed to set file mode on resolved file '%s': %w", response.Path, err)
 			}
-			fmt.Printf("Successfully resolved conflict '%s' using resolver '%s'\n", conflictPath, resolver.ID)
+			m.log().Info("Resolved conflict", "path", conflictPath, "resolver", resolver.ID)
+			mergeConflicts.WithLabelValues(string(ConflictResolverCalled)).Inc()
+			resolutions = append(resolutions, ConflictResolution{
+				Path:     conflictPath,
+				Kind:     ConflictResolverCalled,
+				Resolver: resolver.ID,
+				Versions: len(versions),
+			})
 		} else {
 			// Multiple resolvers match - ERROR
-			return fmt.Errorf("Multiple resolvers match conflicting file '%s': [%s]. Template resolver configuration may be misconfigured.", conflictPath, strings.Join(getResolverIDs(matchingResolvers), ", "))
+			return nil, fmt.Errorf("Multiple resolvers match conflicting file '%s': [%s]. Template resolver configuration may be misconfigured.", conflictPath, strings.Join(getResolverIDs(matchingResolvers), ", "))
 		}
 	}
 
@@ -493,49 +573,73 @@ func (m Merger) MergeFiles(fromDirs []string, processorIDs []string, mergeDir st
 		version := fileMap[path][0]
 		destPath := filepath.Join(mergeDir, path)
 		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-			return fmt.Errorf("failed to create directory for '%s': %w", path, err)
+			return nil, fmt.Errorf("failed to create directory for '%s': %w", path, err)
 		}
 		if err := copyFile(version.Path, destPath); err != nil {
-			return fmt.Errorf("failed to copy file '%s': %w", path, err)
+			return nil, fmt.Errorf("failed to copy file '%s': %w", path, err)
 		}
 	}
 
-	return nil
+	return resolutions, nil
 }
 
 // Merge used by coordinator container
-func (m Merger) Merge(req BuildReq) (string, []error) {
+func (m Merger) Merge(req BuildReq) (mergePath string, errs []error) {
+	ctx, span := startSpan(m.Context, "build", attribute.String(LogKeySession, m.SessionId), attribute.String(LogKeyTemplate, m.Template.Principal.ID))
+	defer func() { endSpan(span, errors.Join(errs...)) }()
+	m.Context = ctx
+
+	m.Sessions.Transition(m.SessionId, m.Template.Principal.ID, SessionBuilding)
+
+	// strict processors run as the sandbox user and must be able to create their output directories
+	if err := m.config().Security.shareWithSandbox(m.config().Workspace.AreaDir); err != nil {
+		err = fmt.Errorf("failed to share working volume with the sandbox user: %w", err)
+		m.Sessions.Fail(m.SessionId, []error{err})
+		return "", []error{err}
+	}
 
 	// exec all processors
-	fmt.Println("⚙️ Executing processors...")
+	m.log().Info("Executing processors", "count", len(req.Cyan.Processors))
 	dirs, procIDs, errs := m.execProcessors(req.Cyan.Processors)
 	if len(errs) > 0 {
-		fmt.Println("🚚 Error executing processors: ", errs)
+		m.log().Error("Error executing processors", "errors", errs)
+		m.Sessions.Fail(m.SessionId, errs)
 		return "", errs
 	}
-	fmt.Println("🎉 Processors completed.")
+	m.log().Info("Processors completed")
 
 	// merge all processor outputs
-	fmt.Println("🔀 Merging processor outputs...")
+	m.log().Info("Merging processor outputs")
 	mergeDir, err := uuid.NewUUID()
 	if err != nil {
+		m.Sessions.Fail(m.SessionId, []error{err})
 		return "", []error{err}
 	}
-	mergePath := "/workspace/area/" + mergeDir.String()
+	mergePath = filepath.Join(m.config().Workspace.AreaDir, mergeDir.String())
 	err = m.merge(dirs, procIDs, mergePath, req.MergerId)
 	if err != nil {
-		fmt.Println("🚚 Error merging processor outputs: ", err)
+		m.log().Error("Error merging processor outputs", LogKeyError, err)
+		m.Sessions.Fail(m.SessionId, []error{err})
+		return "", []error{err}
+	}
+	m.log().Info("Processor outputs merged", "path", mergePath)
+
+	// plugins edit the merged files in place
+	if err := m.config().Security.shareWithSandbox(mergePath); err != nil {
+		err = fmt.Errorf("failed to share merged files with the sandbox user: %w", err)
+		m.Sessions.Fail(m.SessionId, []error{err})
 		return "", []error{err}
 	}
-	fmt.Println("🎉 Processor outputs merged.")
 
 	// exec all plugins
-	fmt.Println("⚙️ Executing plugins...")
+	m.log().Info("Executing plugins", "count", len(req.Cyan.Plugins))
 	errs = m.execPlugins(mergePath, req.Cyan.Plugins)
 	if len(errs) > 0 {
-		fmt.Println("🚚 Error executing plugins: ", errs)
+		m.log().Error("Error executing plugins", "errors", errs)
+		m.Sessions.Fail(m.SessionId, errs)
 		return "", errs
 	}
-	fmt.Println("🎉 Plugins completed.")
+	m.log().Info("Plugins completed")
+	m.Sessions.Transition(m.SessionId, m.Template.Principal.ID, SessionCompleted)
 	return mergePath, nil
 }
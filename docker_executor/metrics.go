@@ -0,0 +1,154 @@
+package docker_executor
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "boron"
+
+// callBuckets cover HTTP calls into containers, from fast health checks to long-running processors
+var callBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	imagePulls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "image_pulls_total",
+		Help:      "Image pulls by image and result.",
+	}, []string{"image", "result"})
+	imagePullDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "image_pull_duration_seconds",
+		Help:      "Time taken to pull an image, including draining its progress stream.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"image", "result"})
+
+	containerCreations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "container_creations_total",
+		Help:      "Containers created and started, by cyan type and result.",
+	}, []string{"cyan_type", "result"})
+	containerCreationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "container_creation_duration_seconds",
+		Help:      "Time taken to create and start a container.",
+		Buckets:   callBuckets,
+	}, []string{"cyan_type", "result"})
+
+	healthCheckDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "health_check_duration_seconds",
+		Help:      "Time taken for a container to become healthy, or to give up on it.",
+		Buckets:   callBuckets,
+	}, []string{"result"})
+
+	processorCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "processor_call_duration_seconds",
+		Help:      "Duration of calls to processor containers.",
+		Buckets:   callBuckets,
+	}, []string{"result"})
+	pluginCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "plugin_call_duration_seconds",
+		Help:      "Duration of calls to plugin containers.",
+		Buckets:   callBuckets,
+	}, []string{"result"})
+	resolverCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "resolver_call_duration_seconds",
+		Help:      "Duration of calls to resolver containers.",
+		Buckets:   callBuckets,
+	}, []string{"result"})
+
+	mergeConflicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "merge_conflicts_total",
+		Help:      "Conflicting files seen while merging, by how they were resolved.",
+	}, []string{"resolution"})
+
+	registryLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "registry_lookups_total",
+		Help:      "Version lookups against the registry, by kind and result.",
+	}, []string{"kind", "result"})
+	registryLookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "registry_lookup_duration_seconds",
+		Help:      "Duration of version lookups against the registry.",
+		Buckets:   callBuckets,
+	}, []string{"kind", "result"})
+
+	gcDiskUsage = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "gc_disk_usage_bytes",
+		Help:      "Disk space cyanprint images and volumes took after the last garbage collection.",
+	})
+	gcEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "gc_evictions_total",
+		Help:      "Images and template volumes evicted by garbage collection, by kind.",
+	}, []string{"kind"})
+)
+
+// resultLabel is the value of the result label for an operation that ended with err
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// sessionsDesc describes the gauge exported by the session registry
+var sessionsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(metricsNamespace, "", "sessions"),
+	"Sessions known to the coordinator, by state.",
+	[]string{"state"}, nil,
+)
+
+// Describe implements prometheus.Collector, exporting the number of sessions in each state
+func (r *SessionRegistry) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sessionsDesc
+}
+
+// Collect implements prometheus.Collector
+func (r *SessionRegistry) Collect(ch chan<- prometheus.Metric) {
+	counts := map[SessionState]int{
+		SessionWarming:   0,
+		SessionStarted:   0,
+		SessionBuilding:  0,
+		SessionCompleted: 0,
+		SessionFailed:    0,
+		SessionCleaned:   0,
+	}
+	for _, s := range r.List() {
+		// sessions only known from heartbeats have no state yet
+		if _, ok := counts[s.State]; ok {
+			counts[s.State]++
+		}
+	}
+	for state, n := range counts {
+		ch <- prometheus.MustNewConstMetric(sessionsDesc, prometheus.GaugeValue, float64(n), string(state))
+	}
+}
+
+// observeRegistryLookup records a registry lookup that started at start and ended with *err.
+// Meant to be deferred, so err is read once the lookup returns.
+func observeRegistryLookup(kind string, start time.Time, err *error) {
+	result := resultLabel(*err)
+	registryLookups.WithLabelValues(kind, result).Inc()
+	observeSince(registryLookupDuration, start, kind, result)
+}
+
+// observeContainerCreation records a container that started being created at start and ended with err
+func observeContainerCreation(cc DockerContainerReference, start time.Time, err error) {
+	result := resultLabel(err)
+	containerCreations.WithLabelValues(cc.CyanType, result).Inc()
+	observeSince(containerCreationDuration, start, cc.CyanType, result)
+}
+
+func observeSince(h *prometheus.HistogramVec, start time.Time, labels ...string) {
+	h.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,30 @@
+package docker_executor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSessionRegistryCollectsSessionsByState(t *testing.T) {
+	r := NewSessionRegistry()
+	r.Transition("s1", "t1", SessionWarming)
+	r.Transition("s2", "t1", SessionBuilding)
+	r.Transition("s3", "t1", SessionBuilding)
+	r.Touch("heartbeat-only")
+
+	expected := `
+# HELP boron_sessions Sessions known to the coordinator, by state.
+# TYPE boron_sessions gauge
+boron_sessions{state="building"} 2
+boron_sessions{state="cleaned"} 0
+boron_sessions{state="completed"} 0
+boron_sessions{state="failed"} 0
+boron_sessions{state="started"} 0
+boron_sessions{state="warming"} 1
+`
+	if err := testutil.CollectAndCompare(r, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
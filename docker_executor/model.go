@@ -49,6 +49,28 @@ type MergeReq struct {
 	Template     TemplateVersionRes `json:"template"`
 }
 
+// ConflictResolveKind is how the merger settled a file written by more than one processor
+type ConflictResolveKind string
+
+const (
+	ConflictLastWriterWins ConflictResolveKind = "last_writer_wins"
+	ConflictResolverCalled ConflictResolveKind = "resolver"
+)
+
+// ConflictResolution records how one conflicting file was merged
+type ConflictResolution struct {
+	Path     string              `json:"path"`
+	Kind     ConflictResolveKind `json:"kind"`
+	Resolver string              `json:"resolver,omitempty"`
+	Versions int                 `json:"versions"`
+}
+
+// MergeRes is returned by the merger container's merge endpoint
+type MergeRes struct {
+	Status    string               `json:"status"`
+	Conflicts []ConflictResolution `json:"conflicts"`
+}
+
 type ZipReq struct {
 	TargetDir string `json:"target_dir"`
 }
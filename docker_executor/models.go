@@ -14,35 +14,40 @@ type PropertyRes struct {
 	BlobDockerTag           string `json:"blobDockerTag"`
 	TemplateDockerReference string `json:"templateDockerReference"`
 	TemplateDockerTag       string `json:"templateDockerTag"`
+	// Resources requested for the template container, within the operator's limits
+	Resources *ResourceLimits `json:"resources,omitempty"`
 }
 
 type PluginRes struct {
-	ID              string `json:"id"`
-	Version         int64  `json:"version"`
-	CreatedAt       string `json:"createdAt"`
-	Description     string `json:"description"`
-	DockerReference string `json:"dockerReference"`
-	DockerTag       string `json:"dockerTag"`
+	ID              string          `json:"id"`
+	Version         int64           `json:"version"`
+	CreatedAt       string          `json:"createdAt"`
+	Description     string          `json:"description"`
+	DockerReference string          `json:"dockerReference"`
+	DockerTag       string          `json:"dockerTag"`
+	Resources       *ResourceLimits `json:"resources,omitempty"`
 }
 
 type ProcessorRes struct {
-	ID              string `json:"id"`
-	Version         int64  `json:"version"`
-	CreatedAt       string `json:"createdAt"`
-	Description     string `json:"description"`
-	DockerReference string `json:"dockerReference"`
-	DockerTag       string `json:"dockerTag"`
+	ID              string          `json:"id"`
+	Version         int64           `json:"version"`
+	CreatedAt       string          `json:"createdAt"`
+	Description     string          `json:"description"`
+	DockerReference string          `json:"dockerReference"`
+	DockerTag       string          `json:"dockerTag"`
+	Resources       *ResourceLimits `json:"resources,omitempty"`
 }
 
 type ResolverRes struct {
-	ID              string      `json:"id"`
-	Version         int64       `json:"version"`
-	CreatedAt       string      `json:"createdAt"`
-	Description     string      `json:"description"`
-	DockerReference string      `json:"dockerReference"`
-	DockerTag       string      `json:"dockerTag"`
-	Config          interface{} `json:"config"`
-	Files           []string    `json:"files"`
+	ID              string          `json:"id"`
+	Version         int64           `json:"version"`
+	CreatedAt       string          `json:"createdAt"`
+	Description     string          `json:"description"`
+	DockerReference string          `json:"dockerReference"`
+	DockerTag       string          `json:"dockerTag"`
+	Config          interface{}     `json:"config"`
+	Files           []string        `json:"files"`
+	Resources       *ResourceLimits `json:"resources,omitempty"`
 }
 
 type TemplateVersionPrincipalRes struct {
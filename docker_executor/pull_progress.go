@@ -0,0 +1,114 @@
+package docker_executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// pullProgressInterval is the least time between progress events of one image, which keeps a pull's
+// progress from crowding the session's event history
+const pullProgressInterval = time.Second
+
+// PullProgress is the aggregate progress of an image pull across its layers. Byte totals only count
+// layers whose size Docker has reported, so they grow as downloads start.
+type PullProgress struct {
+	LayersDone  int   `json:"layers_done"`
+	LayersTotal int   `json:"layers_total"`
+	BytesDone   int64 `json:"bytes_done"`
+	BytesTotal  int64 `json:"bytes_total"`
+}
+
+type layerProgress struct {
+	current int64
+	total   int64
+	done    bool
+}
+
+// pullTracker aggregates the per-layer messages of a pull stream
+type pullTracker struct {
+	layers map[string]*layerProgress
+	order  []string
+}
+
+func (t *pullTracker) layer(id string) *layerProgress {
+	l, ok := t.layers[id]
+	if !ok {
+		l = &layerProgress{}
+		t.layers[id] = l
+		t.order = append(t.order, id)
+	}
+	return l
+}
+
+// update applies a layer message and reports whether it changed the progress
+func (t *pullTracker) update(msg jsonmessage.JSONMessage) bool {
+	// messages without an ID are about the image as a whole, e.g. "Pulling from library/alpine"
+	if msg.ID == "" {
+		return false
+	}
+	// the first message carries the tag as its ID
+	if strings.HasPrefix(msg.Status, "Pulling from") {
+		return false
+	}
+	l := t.layer(msg.ID)
+	switch {
+	case msg.Status == "Downloading" && msg.Progress != nil:
+		l.current, l.total = msg.Progress.Current, msg.Progress.Total
+	case msg.Status == "Download complete", msg.Status == "Pull complete", msg.Status == "Already exists":
+		if l.total > 0 {
+			l.current = l.total
+		}
+		l.done = true
+	default:
+		return false
+	}
+	return true
+}
+
+func (t *pullTracker) progress() PullProgress {
+	var p PullProgress
+	for _, id := range t.order {
+		l := t.layers[id]
+		p.LayersTotal++
+		if l.done {
+			p.LayersDone++
+		}
+		p.BytesDone += l.current
+		p.BytesTotal += l.total
+	}
+	return p
+}
+
+// readPullStream decodes the JSON message stream of an image pull, calling onProgress as layers advance,
+// no more than once per pullProgressInterval. An error Docker reports within the stream, such as an
+// unknown manifest or a denied request, fails the pull with its message.
+func readPullStream(r io.Reader, onProgress func(PullProgress)) (PullProgress, error) {
+	t := &pullTracker{layers: make(map[string]*layerProgress)}
+	dec := json.NewDecoder(r)
+	var last time.Time
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return t.progress(), nil
+			}
+			return t.progress(), fmt.Errorf("failed to read pull progress: %w", err)
+		}
+		if msg.Error != nil {
+			return t.progress(), errors.New(msg.Error.Message)
+		}
+		if msg.ErrorMessage != "" {
+			return t.progress(), errors.New(msg.ErrorMessage)
+		}
+		if t.update(msg) && onProgress != nil && time.Since(last) >= pullProgressInterval {
+			last = time.Now()
+			onProgress(t.progress())
+		}
+	}
+}
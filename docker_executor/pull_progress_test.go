@@ -0,0 +1,66 @@
+package docker_executor
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestReadPullStream tests that layer messages add up to the image's progress and that errors in the stream fail the pull
+func TestReadPullStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		want    PullProgress
+		wantErr string
+	}{
+		{
+			name: "pulled",
+			stream: `{"status":"Pulling from acme/processor","id":"1"}
+{"status":"Pulling fs layer","id":"a1"}
+{"status":"Already exists","id":"b2"}
+{"status":"Downloading","progressDetail":{"current":512,"total":2048},"id":"a1"}
+{"status":"Download complete","id":"a1"}
+{"status":"Pull complete","id":"a1"}
+{"status":"Digest: sha256:abc"}
+{"status":"Status: Downloaded newer image for acme/processor:1"}`,
+			want: PullProgress{LayersDone: 2, LayersTotal: 2, BytesDone: 2048, BytesTotal: 2048},
+		},
+		{
+			name: "manifest unknown",
+			stream: `{"status":"Pulling from acme/processor","id":"1"}
+{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}`,
+			wantErr: "manifest unknown",
+		},
+		{
+			name: "interrupted",
+			stream: `{"status":"Pulling fs layer","id":"a1"}
+{"status":"Downloading","progressDetail":{"current":512,"total":2048},"id":"a1"}
+{"status":`,
+			wantErr: "failed to read pull progress",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reported []PullProgress
+			got, err := readPullStream(strings.NewReader(tt.stream), func(p PullProgress) {
+				reported = append(reported, p)
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readPullStream() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readPullStream() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("readPullStream() = %+v, want %+v", got, tt.want)
+			}
+			// progress is throttled, so a fast stream reports once
+			if len(reported) != 1 {
+				t.Errorf("reported %d progress updates, want 1", len(reported))
+			}
+		})
+	}
+}
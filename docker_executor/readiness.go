@@ -0,0 +1,176 @@
+package docker_executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// probeClient bounds each readiness probe, so a container that accepts connections but never answers
+// can't hold up its deadline
+var probeClient = NewHTTPClient(5 * time.Second)
+
+// ContainerExitError reports a container that stopped before it became ready
+type ContainerExitError struct {
+	Container DockerContainerReference
+	ExitCode  int
+	// Logs are the container's last log lines, see HealthCheckConfig.LogLines
+	Logs []string
+}
+
+func (e *ContainerExitError) Error() string {
+	msg := fmt.Sprintf("container %s exited with code %d", DockerContainerToString(e.Container), e.ExitCode)
+	if len(e.Logs) > 0 {
+		msg += ", last logs:\n" + strings.Join(e.Logs, "\n")
+	}
+	return msg
+}
+
+// ReadinessError reports a container that was still not serving its health endpoint when it ran out
+// of attempts or time
+type ReadinessError struct {
+	Container DockerContainerReference
+	Endpoint  string
+	Attempts  int
+	Elapsed   time.Duration
+	// Last is the outcome of the last probe
+	Last string
+}
+
+func (e *ReadinessError) Error() string {
+	return fmt.Sprintf("container %s not ready at %s after %d attempts in %s: %s",
+		DockerContainerToString(e.Container), e.Endpoint, e.Attempts, e.Elapsed.Round(time.Millisecond), e.Last)
+}
+
+// readiness waits for containers to serve their health endpoint. It fails as soon as the runtime reports
+// the container stopped, and otherwise probes with exponential backoff until the type's deadline.
+type readiness struct {
+	rt     ContainerRuntime
+	events Emitter
+	logger *slog.Logger
+	// get sends a probe; tests replace it to answer for containers that don't exist
+	get func(ctx context.Context, url string) (*http.Response, error)
+}
+
+func newReadiness(rt ContainerRuntime, events Emitter, logger *slog.Logger) readiness {
+	return readiness{
+		rt:     rt,
+		events: events,
+		logger: loggerOrDefault(logger),
+		get: func(ctx context.Context, url string) (*http.Response, error) {
+			return tracedGet(ctx, probeClient, url)
+		},
+	}
+}
+
+// healthEndpoint is the URL containers of c's type are probed on, over the network they share with the coordinator
+func healthEndpoint(c DockerContainerReference, probe ProbeConfig) string {
+	return fmt.Sprintf("http://%s:%d%s", ContainerHost(c), servicePorts[c.CyanType], probe.Path)
+}
+
+// wait blocks until c answers its health endpoint with 200, or fails with a ContainerExitError,
+// ContainerLimitError or ReadinessError
+func (r readiness) wait(c DockerContainerReference) (err error) {
+	cfg := r.rt.Settings().HealthCheck
+	probe := cfg.Probe(c.CyanType)
+	endpoint := healthEndpoint(c, probe)
+	name := DockerContainerToString(c)
+
+	start := time.Now()
+	ctx, span := startSpan(r.rt.OperationContext(), "health_check",
+		append(containerSpanAttrs(c), attribute.String("endpoint", endpoint))...)
+	defer func() {
+		observeSince(healthCheckDuration, start, resultLabel(err))
+		endSpan(span, err)
+	}()
+	ctx, cancel := context.WithTimeout(ctx, probe.Timeout)
+	defer cancel()
+
+	stopped, stopWatching := r.rt.WatchContainer(c)
+	defer stopWatching()
+
+	interval := cfg.Interval
+	for attempt := 1; ; attempt++ {
+		last := r.probe(ctx, endpoint)
+		event := Event{Type: EventHealthCheckAttempt, Container: name, CyanId: c.CyanId, CyanType: c.CyanType, Endpoint: endpoint, Attempt: attempt}
+		if last == "" {
+			r.logger.Debug("Container is ready", append(containerAttrs(c), "endpoint", endpoint, "attempt", attempt)...)
+			event.Healthy = true
+			r.events.Emit(event)
+			return nil
+		}
+		r.logger.Debug("Container not ready", append(containerAttrs(c), "endpoint", endpoint, "attempt", attempt, LogKeyError, last)...)
+		event.Error = last
+		r.events.Emit(event)
+
+		if exited := r.exited(c); exited != nil {
+			r.logger.Error("Container stopped before becoming ready", append(containerAttrs(c), LogKeyError, exited)...)
+			return exited
+		}
+		notReady := &ReadinessError{Container: c, Endpoint: endpoint, Attempts: attempt, Elapsed: time.Since(start), Last: last}
+		if attempt >= cfg.Attempts {
+			r.logger.Error("Reached maximum health check attempts", append(containerAttrs(c), LogKeyError, notReady)...)
+			return notReady
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-stopped:
+			timer.Stop()
+			// probe once more: the container may have been replaced, and if not, it will be reported as exited
+			stopped = nil
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				notReady.Elapsed = time.Since(start)
+				r.logger.Error("Container not ready before its deadline", append(containerAttrs(c), LogKeyError, notReady)...)
+				return notReady
+			}
+			return ctx.Err()
+		case <-timer.C:
+		}
+		interval = min(interval*2, max(cfg.MaxInterval, cfg.Interval))
+	}
+}
+
+// probe requests endpoint once, returning why the container isn't ready, or "" if it is
+func (r readiness) probe(ctx context.Context, endpoint string) string {
+	resp, err := r.get(ctx, endpoint)
+	if err != nil {
+		return err.Error()
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Sprintf("status code %d", resp.StatusCode)
+	}
+	return ""
+}
+
+// exited explains why c has stopped, with its last log lines, or returns nil while it runs
+func (r readiness) exited(c DockerContainerReference) error {
+	err := stoppedError(r.rt, c)
+	var exitErr *ContainerExitError
+	if errors.As(err, &exitErr) {
+		logs, logErr := r.rt.ContainerLogs(c, r.rt.Settings().HealthCheck.LogLines)
+		if logErr != nil {
+			r.logger.Warn("Failed to read logs of stopped container", append(containerAttrs(c), LogKeyError, logErr)...)
+		}
+		exitErr.Logs = logs
+	}
+	return err
+}
+
+// splitLogLines splits container output into lines, dropping the trailing newline
+func splitLogLines(out string) []string {
+	out = strings.TrimRight(out, "\n")
+	if out == "" {
+		return nil
+	}
+	return strings.Split(out, "\n")
+}
@@ -0,0 +1,115 @@
+package docker_executor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// answerAfter returns a probe that fails until its nth call, then answers 200
+func answerAfter(n int32) (func(ctx context.Context, url string) (*http.Response, error), *atomic.Int32) {
+	calls := &atomic.Int32{}
+	return func(ctx context.Context, url string) (*http.Response, error) {
+		if calls.Add(1) < n {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}, calls
+}
+
+func testReadiness(rt *FakeRuntime, get func(ctx context.Context, url string) (*http.Response, error)) readiness {
+	r := newReadiness(rt, Emitter{}, nil)
+	r.get = get
+	return r
+}
+
+// TestReadinessWait tests that readiness backs off until the container answers, and fails with the
+// container's exit code and logs, or with a ReadinessError once attempts or time run out
+func TestReadinessWait(t *testing.T) {
+	c := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"}
+	fast := HealthCheckConfig{Interval: time.Millisecond, MaxInterval: 4 * time.Millisecond, Timeout: time.Second}
+
+	t.Run("ready after retries", func(t *testing.T) {
+		rt := NewFakeRuntime()
+		rt.Config.HealthCheck = fast
+		rt.AddContainer(c, true)
+		get, calls := answerAfter(4)
+		if err := testReadiness(rt, get).wait(c); err != nil {
+			t.Fatalf("wait() error = %v", err)
+		}
+		if calls.Load() != 4 {
+			t.Errorf("probes = %d, want 4", calls.Load())
+		}
+	})
+
+	t.Run("exits while starting", func(t *testing.T) {
+		rt := NewFakeRuntime()
+		rt.Config.HealthCheck = HealthCheckConfig{Interval: time.Minute, Timeout: time.Hour, LogLines: 2}
+		rt.SetExitCode(DockerContainerToString(c), 3)
+		rt.AddContainer(c, true)
+		rt.SetLogs(c, "starting", "listening", "Error: cannot find module 'express'")
+		get, _ := answerAfter(1000)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			rt.StopContainer(c)
+		}()
+
+		start := time.Now()
+		err := testReadiness(rt, get).wait(c)
+		var exitErr *ContainerExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode != 3 {
+			t.Fatalf("wait() error = %v, want a ContainerExitError with code 3", err)
+		}
+		// the stop is noticed without waiting out the minute-long backoff
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("wait() took %s", time.Since(start))
+		}
+		if len(exitErr.Logs) != 2 || exitErr.Logs[1] != "Error: cannot find module 'express'" {
+			t.Errorf("Logs = %q, want the last 2 lines", exitErr.Logs)
+		}
+		if !strings.Contains(err.Error(), "cannot find module") {
+			t.Errorf("error %q does not include the logs", err)
+		}
+	})
+
+	t.Run("out of attempts", func(t *testing.T) {
+		rt := NewFakeRuntime()
+		rt.Config.HealthCheck = fast
+		rt.Config.HealthCheck.Attempts = 3
+		rt.AddContainer(c, true)
+		get, calls := answerAfter(1000)
+		err := testReadiness(rt, get).wait(c)
+		var notReady *ReadinessError
+		if !errors.As(err, &notReady) || notReady.Attempts != 3 {
+			t.Fatalf("wait() error = %v, want a ReadinessError after 3 attempts", err)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("probes = %d, want 3", calls.Load())
+		}
+	})
+
+	t.Run("type deadline", func(t *testing.T) {
+		rt := NewFakeRuntime()
+		rt.Config.HealthCheck = fast
+		rt.Config.HealthCheck.Probes = map[string]ProbeConfig{"processor": {Path: "/ready", Timeout: 30 * time.Millisecond}}
+		rt.AddContainer(c, true)
+		var probed string
+		get := func(ctx context.Context, url string) (*http.Response, error) {
+			probed = url
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		err := testReadiness(rt, get).wait(c)
+		var notReady *ReadinessError
+		if !errors.As(err, &notReady) || notReady.Last != "status code 503" {
+			t.Fatalf("wait() error = %v, want a ReadinessError with the last status", err)
+		}
+		if want := "http://cyan-processor-processor1-s1:5551/ready"; probed != want {
+			t.Errorf("probed %s, want %s", probed, want)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package docker_executor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Reaper periodically cleans sessions whose clients have gone away.
+// A session expires once TTL has passed since its last activity: a registry update, a heartbeat,
+// or (for sessions the registry does not know) the creation of its newest container or volume.
+type Reaper struct {
+	Docker   ContainerRuntime
+	Sessions *SessionRegistry
+	TTL      time.Duration
+	Interval time.Duration
+	// OnClean is called after a session has been reaped, to release state kept outside Docker
+	OnClean func(sessionId string)
+	Logger  *slog.Logger
+}
+
+func (r Reaper) log() *slog.Logger {
+	return loggerOrDefault(r.Logger)
+}
+
+// Run reaps expired sessions every Interval until the context is cancelled
+func (r Reaper) Run(ctx context.Context) {
+	if r.TTL <= 0 || r.Interval <= 0 {
+		r.log().Info("Session reaper disabled")
+		return
+	}
+	r.log().Info("Session reaper started", "ttl", r.TTL, "interval", r.Interval)
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.log().Info("Session reaper stopped")
+			return
+		case <-ticker.C:
+			reaped, errs := r.Reap(time.Now().UTC())
+			for _, err := range errs {
+				r.log().Error("Error reaping sessions", LogKeyError, err)
+			}
+			if len(reaped) > 0 {
+				r.log().Info("Reaped expired sessions", "sessions", reaped)
+			}
+		}
+	}
+}
+
+// Reap cleans every session that has been inactive for longer than TTL as of now.
+// Sessions that are currently warming or building are never reaped. Registry entries without resources,
+// such as cleaned sessions, failed warms and ids only ever heartbeated, are forgotten once inactive as long.
+func (r Reaper) Reap(now time.Time) ([]string, []error) {
+	activity, err := r.Docker.ListSessionActivity()
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	// the registry knows about heartbeats, so it takes precedence over resource creation time
+	for _, s := range r.Sessions.List() {
+		busy := s.State == SessionWarming || s.State == SessionBuilding
+		if _, hasResources := activity[s.SessionId]; !hasResources {
+			if !busy && now.Sub(s.LastActivity) > r.TTL {
+				r.Sessions.forget(s.SessionId)
+			}
+			continue
+		}
+		if busy {
+			delete(activity, s.SessionId)
+			continue
+		}
+		activity[s.SessionId] = s.LastActivity
+	}
+
+	var reaped []string
+	var allErrs []error
+	for session, last := range activity {
+		if now.Sub(last) <= r.TTL {
+			continue
+		}
+		r.log().Info("Session expired, cleaning", LogKeySession, session, "last_activity", last)
+		exec := Executor{
+			Docker:   r.Docker,
+			Sessions: r.Sessions,
+			Logger:   r.Logger,
+		}
+		if errs := exec.Clean(session); len(errs) > 0 {
+			allErrs = append(allErrs, fmt.Errorf("failed to reap session %s: %v", session, errs))
+			continue
+		}
+		if r.OnClean != nil {
+			r.OnClean(session)
+		}
+		reaped = append(reaped, session)
+	}
+	return reaped, allErrs
+}
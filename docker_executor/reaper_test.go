@@ -0,0 +1,84 @@
+package docker_executor
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestReaperReap tests which sessions expire: the registry's activity takes precedence over the age of a
+// session's resources, warming and building sessions are kept, and inactive sessions without resources
+// are forgotten
+func TestReaperReap(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// resourceAge is how long before now the session's container was created; 0 creates none
+		resourceAge time.Duration
+		// state and activityAge make up the session's registry record. Without a state, an activity age
+		// records a heartbeat for an unknown session, and neither leaves it unknown.
+		state       SessionState
+		activityAge time.Duration
+		wantReaped  bool
+		// wantKnown is whether the registry still knows the session, which cleaning records as cleaned
+		wantKnown bool
+	}{
+		{name: "resources past the TTL", resourceAge: 2 * time.Hour, wantReaped: true, wantKnown: true},
+		{name: "resources within the TTL", resourceAge: 30 * time.Minute},
+		{name: "heartbeat keeps old resources", resourceAge: 2 * time.Hour, state: SessionStarted, activityAge: time.Minute, wantKnown: true},
+		{name: "stale activity outweighs new resources", resourceAge: time.Minute, state: SessionCompleted, activityAge: 2 * time.Hour, wantReaped: true, wantKnown: true},
+		{name: "warming session", resourceAge: 2 * time.Hour, state: SessionWarming, activityAge: 2 * time.Hour, wantKnown: true},
+		{name: "building session", resourceAge: 2 * time.Hour, state: SessionBuilding, activityAge: 2 * time.Hour, wantKnown: true},
+		{name: "cleaned session past the TTL", state: SessionCleaned, activityAge: 2 * time.Hour},
+		{name: "cleaned session within the TTL", state: SessionCleaned, activityAge: time.Minute, wantKnown: true},
+		{name: "failed warm past the TTL", state: SessionFailed, activityAge: 2 * time.Hour},
+		{name: "failed warm within the TTL", state: SessionFailed, activityAge: time.Minute, wantKnown: true},
+		{name: "heartbeat alone past the TTL", activityAge: 2 * time.Hour},
+		{name: "heartbeat alone within the TTL", activityAge: time.Minute, wantKnown: true},
+		{name: "warming session without resources", state: SessionWarming, activityAge: 2 * time.Hour, wantKnown: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewFakeRuntime()
+			rt.Now = func() time.Time { return now.Add(-tt.resourceAge) }
+			sessions := NewSessionRegistry()
+			if tt.resourceAge > 0 {
+				rt.AddContainer(DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"}, true)
+			}
+			if tt.state != "" {
+				sessions.Transition("s1", "template-1", tt.state)
+			} else if tt.activityAge > 0 {
+				sessions.Touch("s1")
+			}
+			if s, ok := sessions.sessions["s1"]; ok {
+				s.LastActivity = now.Add(-tt.activityAge)
+			}
+			var cleaned []string
+			r := Reaper{Docker: rt, Sessions: sessions, TTL: time.Hour, OnClean: func(sessionId string) {
+				cleaned = append(cleaned, sessionId)
+			}}
+
+			reaped, errs := r.Reap(now)
+			if len(errs) > 0 {
+				t.Fatalf("Reap() errors = %v", errs)
+			}
+			wantReaped := []string(nil)
+			if tt.wantReaped {
+				wantReaped = []string{"s1"}
+			}
+			if !slices.Equal(reaped, wantReaped) || !slices.Equal(cleaned, wantReaped) {
+				t.Errorf("Reap() = %v and cleaned %v, want %v", reaped, cleaned, wantReaped)
+			}
+			if remaining := rt.Containers(); len(remaining) > 0 != (tt.resourceAge > 0 && !tt.wantReaped) {
+				t.Errorf("containers left = %v", remaining)
+			}
+			s, known := sessions.Get("s1")
+			if known != tt.wantKnown {
+				t.Errorf("session known = %v, want %v", known, tt.wantKnown)
+			}
+			if tt.wantReaped && known && s.State != SessionCleaned {
+				t.Errorf("reaped session state = %s, want %s", s.State, SessionCleaned)
+			}
+		})
+	}
+}
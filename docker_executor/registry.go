@@ -1,24 +1,34 @@
 package docker_executor
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type RegistryClient struct {
 	Endpoint string
+	Context  context.Context
+	Logger   *slog.Logger
 }
 
-func (rc RegistryClient) getProcessorVersion(username string, name string, version string) (RegistryProcessorVersionRes, error) {
+func (rc RegistryClient) log() *slog.Logger {
+	return loggerOrDefault(rc.Logger)
+}
+
+func (rc RegistryClient) getProcessorVersion(username string, name string, version string) (res RegistryProcessorVersionRes, err error) {
+	defer observeRegistryLookup("processor", time.Now(), &err)
 	url := rc.Endpoint + "/api/v1/Processor/slug/" + username + "/" + name + "/versions/" + version
 
-	fmt.Println("🔍 Getting version of processor:", url)
-	resp, err := http.Get(url)
+	rc.log().Debug("Getting version of processor", "url", url)
+	resp, err := tracedGet(rc.Context, tracedClient, url)
 	if err != nil {
-		fmt.Printf("🚨 Error occurred making a request to %s: %v\n", url, err)
+		rc.log().Error("Error making registry request", "url", url, LogKeyError, err)
 		return RegistryProcessorVersionRes{}, err
 	}
 	defer func(Body io.ReadCloser) {
@@ -27,31 +37,31 @@ func (rc RegistryClient) getProcessorVersion(username string, name string, versi
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("🚨 Error reading response body: %v\n", err)
+		rc.log().Error("Error reading registry response body", "url", url, LogKeyError, err)
 		return RegistryProcessorVersionRes{}, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("🚨 Unexpected status code: %d. Body: %s\n", resp.StatusCode, body)
+		rc.log().Error("Unexpected registry status code", "url", url, "status", resp.StatusCode, "body", string(body))
 		return RegistryProcessorVersionRes{}, fmt.Errorf("unexpected status code: %d. Body: %s\n", resp.StatusCode, body)
 	}
 
-	var res RegistryProcessorVersionRes
 	err = json.Unmarshal(body, &res)
 	if err != nil {
-		fmt.Printf("🚨 Error unmarshaling response to struct: %v\n", err)
+		rc.log().Error("Error unmarshaling registry response", "url", url, LogKeyError, err)
 		return RegistryProcessorVersionRes{}, err
 	}
 	return res, nil
 }
 
-func (rc RegistryClient) getProcessorVersionLatest(username string, name string) (RegistryProcessorVersionRes, error) {
+func (rc RegistryClient) getProcessorVersionLatest(username string, name string) (res RegistryProcessorVersionRes, err error) {
+	defer observeRegistryLookup("processor", time.Now(), &err)
 	url := rc.Endpoint + "/api/v1/Processor/slug/" + username + "/" + name + "/versions/latest"
 
-	fmt.Println("🔍 Getting latest version of processor:", url)
-	resp, err := http.Get(url)
+	rc.log().Debug("Getting latest version of processor", "url", url)
+	resp, err := tracedGet(rc.Context, tracedClient, url)
 	if err != nil {
-		fmt.Printf("🚨 Error occurred making a request to %s: %v\n", url, err)
+		rc.log().Error("Error making registry request", "url", url, LogKeyError, err)
 		return RegistryProcessorVersionRes{}, err
 	}
 	defer func(Body io.ReadCloser) {
@@ -60,31 +70,31 @@ func (rc RegistryClient) getProcessorVersionLatest(username string, name string)
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("🚨 Error reading response body: %v\n", err)
+		rc.log().Error("Error reading registry response body", "url", url, LogKeyError, err)
 		return RegistryProcessorVersionRes{}, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("🚨 Unexpected status code: %d. Body: %s\n", resp.StatusCode, body)
+		rc.log().Error("Unexpected registry status code", "url", url, "status", resp.StatusCode, "body", string(body))
 		return RegistryProcessorVersionRes{}, fmt.Errorf("unexpected status code: %d. Body: %s\n", resp.StatusCode, body)
 	}
 
-	var res RegistryProcessorVersionRes
 	err = json.Unmarshal(body, &res)
 	if err != nil {
-		fmt.Printf("🚨 Error unmarshaling response to struct: %v\n", err)
+		rc.log().Error("Error unmarshaling registry response", "url", url, LogKeyError, err)
 		return RegistryProcessorVersionRes{}, err
 	}
 	return res, nil
 }
 
-func (rc RegistryClient) getPluginVersion(username string, name string, version string) (RegistryPluginVersionRes, error) {
+func (rc RegistryClient) getPluginVersion(username string, name string, version string) (res RegistryPluginVersionRes, err error) {
+	defer observeRegistryLookup("plugin", time.Now(), &err)
 	url := rc.Endpoint + "/api/v1/Plugin/slug/" + username + "/" + name + "/versions/" + version
 
-	fmt.Println("🔍 Getting version of plugin:", url)
-	resp, err := http.Get(url)
+	rc.log().Debug("Getting version of plugin", "url", url)
+	resp, err := tracedGet(rc.Context, tracedClient, url)
 	if err != nil {
-		fmt.Printf("🚨 Error occurred making a request to %s: %v\n", url, err)
+		rc.log().Error("Error making registry request", "url", url, LogKeyError, err)
 		return RegistryPluginVersionRes{}, err
 	}
 	defer func(Body io.ReadCloser) {
@@ -93,31 +103,31 @@ func (rc RegistryClient) getPluginVersion(username string, name string, version
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("🚨 Error reading response body: %v\n", err)
+		rc.log().Error("Error reading registry response body", "url", url, LogKeyError, err)
 		return RegistryPluginVersionRes{}, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("🚨 Unexpected status code: %d. Body: %s\n", resp.StatusCode, body)
+		rc.log().Error("Unexpected registry status code", "url", url, "status", resp.StatusCode, "body", string(body))
 		return RegistryPluginVersionRes{}, fmt.Errorf("unexpected status code: %d. Body: %s\n", resp.StatusCode, body)
 	}
 
-	var res RegistryPluginVersionRes
 	err = json.Unmarshal(body, &res)
 	if err != nil {
-		fmt.Printf("🚨 Error unmarshaling response to struct: %v\n", err)
+		rc.log().Error("Error unmarshaling registry response", "url", url, LogKeyError, err)
 		return RegistryPluginVersionRes{}, err
 	}
 	return res, nil
 }
 
-func (rc RegistryClient) getPluginVersionLatest(username string, name string) (RegistryPluginVersionRes, error) {
+func (rc RegistryClient) getPluginVersionLatest(username string, name string) (res RegistryPluginVersionRes, err error) {
+	defer observeRegistryLookup("plugin", time.Now(), &err)
 	url := rc.Endpoint + "/api/v1/Plugin/slug/" + username + "/" + name + "/versions/latest"
 
-	fmt.Println("🔍 Getting latest version of plugin:", url)
-	resp, err := http.Get(url)
+	rc.log().Debug("Getting latest version of plugin", "url", url)
+	resp, err := tracedGet(rc.Context, tracedClient, url)
 	if err != nil {
-		fmt.Printf("🚨 Error occurred making a request to %s: %v\n", url, err)
+		rc.log().Error("Error making registry request", "url", url, LogKeyError, err)
 		return RegistryPluginVersionRes{}, err
 	}
 	defer func(Body io.ReadCloser) {
@@ -126,19 +136,18 @@ func (rc RegistryClient) getPluginVersionLatest(username string, name string) (R
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("🚨 Error reading response body: %v\n", err)
+		rc.log().Error("Error reading registry response body", "url", url, LogKeyError, err)
 		return RegistryPluginVersionRes{}, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("🚨 Unexpected status code: %d. Body: %s\n", resp.StatusCode, body)
+		rc.log().Error("Unexpected registry status code", "url", url, "status", resp.StatusCode, "body", string(body))
 		return RegistryPluginVersionRes{}, fmt.Errorf("unexpected status code: %d. Body: %s\n", resp.StatusCode, body)
 	}
 
-	var res RegistryPluginVersionRes
 	err = json.Unmarshal(body, &res)
 	if err != nil {
-		fmt.Printf("🚨 Error unmarshaling response to struct: %v\n", err)
+		rc.log().Error("Error unmarshaling registry response", "url", url, LogKeyError, err)
 		return RegistryPluginVersionRes{}, err
 	}
 	return res, nil
@@ -154,19 +163,19 @@ func (rc RegistryClient) convertProcessor(cp CyanProcessorReq, processors []Proc
 	if version == nil {
 		res, e := rc.getProcessorVersionLatest(username, name)
 		if e != nil {
-			fmt.Printf("🚨 Error getting latest version of processor %s/%s: %v\n", username, name, e)
+			rc.log().Error("Error getting latest version of processor", "processor", n, LogKeyError, e)
 			return CyanProcessor{}, e
 		}
 		for i := res.Principal.Version; i > 0; i-- {
 			v := strconv.Itoa(i)
 			r, er := rc.getProcessorVersion(username, name, v)
 			if er != nil {
-				fmt.Printf("🚨 Error getting latest version of processor %s/%s:%s %v\n", username, name, v, e)
-				return CyanProcessor{}, e
+				rc.log().Error("Error getting version of processor", "processor", n, "version", v, LogKeyError, er)
+				return CyanProcessor{}, er
 			}
 			for _, p := range processors {
 				if p.ID == r.Principal.Id {
-					fmt.Printf("✅ Processor %s (from Cyan Response)'s verions %s matches %s", n, v, p.ID)
+					rc.log().Info("Processor version matches template", "processor", n, "version", v, LogKeyCyanId, p.ID)
 					return CyanProcessor{
 						Id:        r.Principal.Id,
 						Reference: n,
@@ -178,16 +187,16 @@ func (rc RegistryClient) convertProcessor(cp CyanProcessorReq, processors []Proc
 					}, nil
 				}
 			}
-			fmt.Printf("⚠️ Processor %s (from Cyan Response)'s verions %s does not match any processor defined in Template", n, v)
+			rc.log().Debug("Processor version does not match any processor defined in template", "processor", n, "version", v)
 		}
 		er := fmt.Errorf("processor %s (from Cyan Response) does not have a matching version defined in the template", n)
-		fmt.Printf("🚨 Processor %s (from Cyan Response) does not have a matching version defined in the template", n)
+		rc.log().Error("Processor does not have a matching version defined in the template", "processor", n)
 		return CyanProcessor{}, er
 	} else {
 		v := *version
 		res, e := rc.getProcessorVersion(username, name, v)
 		if e != nil {
-			fmt.Printf("🚨 Error getting version of processor %s/%s:%s: %v\n", username, name, v, e)
+			rc.log().Error("Error getting version of processor", "processor", n, "version", v, LogKeyError, e)
 			return CyanProcessor{}, e
 		}
 		return CyanProcessor{
@@ -211,19 +220,19 @@ func (rc RegistryClient) convertPlugin(cp CyanPluginReq, plugins []PluginRes) (C
 	if version == nil {
 		res, e := rc.getPluginVersionLatest(username, name)
 		if e != nil {
-			fmt.Printf("🚨 Error getting latest version of plugin %s/%s: %v\n", username, name, e)
+			rc.log().Error("Error getting latest version of plugin", "plugin", n, LogKeyError, e)
 			return CyanPlugin{}, e
 		}
 		for i := res.Principal.Version; i > 0; i-- {
 			v := strconv.Itoa(i)
 			r, er := rc.getPluginVersion(username, name, v)
 			if er != nil {
-				fmt.Printf("🚨 Error getting latest version of plugin %s/%s:%s %v\n", username, name, v, e)
-				return CyanPlugin{}, e
+				rc.log().Error("Error getting version of plugin", "plugin", n, "version", v, LogKeyError, er)
+				return CyanPlugin{}, er
 			}
 			for _, p := range plugins {
 				if p.ID == r.Principal.Id {
-					fmt.Printf("✅ Plugin %s (from Cyan Response)'s verions %s matches %s", n, v, p.ID)
+					rc.log().Info("Plugin version matches template", "plugin", n, "version", v, LogKeyCyanId, p.ID)
 					return CyanPlugin{
 						Id:        r.Principal.Id,
 						Reference: n,
@@ -234,16 +243,16 @@ func (rc RegistryClient) convertPlugin(cp CyanPluginReq, plugins []PluginRes) (C
 					}, nil
 				}
 			}
-			fmt.Printf("⚠️ Plugin %s (from Cyan Response)'s verions %s does not match any plugin defined in Template", n, v)
+			rc.log().Debug("Plugin version does not match any plugin defined in template", "plugin", n, "version", v)
 		}
 		er := fmt.Errorf("plugin %s (from Cyan Response) does not have a matching version defined in the template", n)
-		fmt.Printf("🚨 plugin %s (from Cyan Response) does not have a matching version defined in the template", n)
+		rc.log().Error("Plugin does not have a matching version defined in the template", "plugin", n)
 		return CyanPlugin{}, er
 	} else {
 		v := *version
 		res, e := rc.getPluginVersion(username, name, v)
 		if e != nil {
-			fmt.Printf("🚨 Error getting version of plugin %s/%s:%s: %v\n", username, name, v, e)
+			rc.log().Error("Error getting version of plugin", "plugin", n, "version", v, LogKeyError, e)
 			return CyanPlugin{}, e
 		}
 		return CyanPlugin{
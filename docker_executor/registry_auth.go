@@ -0,0 +1,333 @@
+package docker_executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+	"gopkg.in/yaml.v3"
+)
+
+// dockerHubHost is the registry host of images without one, and dockerHubIndex the key Docker's
+// config.json stores its credentials under
+const (
+	dockerHubHost  = "docker.io"
+	dockerHubIndex = "https://index.docker.io/v1/"
+)
+
+// Secret is a credential that is written out redacted, so printing the configuration doesn't leak it
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "<redacted>"
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// RegistryCredential authenticates pulls from one registry host, with a username and password
+// (or token used as one), or an identity token
+type RegistryCredential struct {
+	Username string `yaml:"username" json:"username,omitempty"`
+	Password Secret `yaml:"password,omitempty" json:"password,omitempty"`
+	// PasswordFile is read for the password at each pull, so a rotated token is picked up
+	PasswordFile  string `yaml:"password_file,omitempty" json:"-"`
+	IdentityToken Secret `yaml:"identity_token,omitempty" json:"identitytoken,omitempty"`
+	RegistryToken Secret `yaml:"-" json:"registrytoken,omitempty"`
+}
+
+// UnmarshalJSON reads the credential as Docker's AuthConfig, which is what the X-Registry-Config header carries
+func (c *RegistryCredential) UnmarshalJSON(data []byte) error {
+	var a registry.AuthConfig
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	*c = RegistryCredential{
+		Username:      a.Username,
+		Password:      Secret(a.Password),
+		IdentityToken: Secret(a.IdentityToken),
+		RegistryToken: Secret(a.RegistryToken),
+	}
+	return nil
+}
+
+// UnmarshalYAML reads password and identity_token as plain strings
+func (c *RegistryCredential) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Username      string `yaml:"username"`
+		Password      string `yaml:"password"`
+		PasswordFile  string `yaml:"password_file"`
+		IdentityToken string `yaml:"identity_token"`
+	}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	*c = RegistryCredential{
+		Username:      raw.Username,
+		Password:      Secret(raw.Password),
+		PasswordFile:  raw.PasswordFile,
+		IdentityToken: Secret(raw.IdentityToken),
+	}
+	return nil
+}
+
+func (c RegistryCredential) authConfig(host string) (registry.AuthConfig, error) {
+	password := string(c.Password)
+	if c.PasswordFile != "" {
+		content, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return registry.AuthConfig{}, fmt.Errorf("failed to read password file of registry %s: %w", host, err)
+		}
+		password = strings.TrimSpace(string(content))
+	}
+	return registry.AuthConfig{
+		Username:      c.Username,
+		Password:      password,
+		IdentityToken: string(c.IdentityToken),
+		RegistryToken: string(c.RegistryToken),
+		ServerAddress: host,
+	}, nil
+}
+
+// RegistryCredentials maps registry hosts (e.g. ghcr.io) to their credentials
+type RegistryCredentials map[string]RegistryCredential
+
+// lookup finds the credential of host, whether keyed by host or by an address such as https://ghcr.io
+func (r RegistryCredentials) lookup(host string) (RegistryCredential, bool) {
+	if c, ok := r[host]; ok {
+		return c, true
+	}
+	for address, c := range r {
+		if normalizeRegistryHost(address) == host {
+			return c, true
+		}
+	}
+	return RegistryCredential{}, false
+}
+
+func (c RegistryCredential) validate() error {
+	if c.IdentityToken != "" {
+		return nil
+	}
+	if c.Username == "" || (c.Password == "" && c.PasswordFile == "") {
+		return errors.New("needs a username with a password or password_file, or an identity_token")
+	}
+	return nil
+}
+
+type registryCredentialsKey struct{}
+
+// WithRegistryCredentials attaches credentials supplied with a request to ctx. Pulls run under ctx use them
+// ahead of the configured ones.
+func WithRegistryCredentials(ctx context.Context, creds RegistryCredentials) context.Context {
+	if len(creds) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, registryCredentialsKey{}, creds)
+}
+
+func registryCredentialsFrom(ctx context.Context) RegistryCredentials {
+	creds, _ := contextOrBackground(ctx).Value(registryCredentialsKey{}).(RegistryCredentials)
+	return creds
+}
+
+// ParseRegistryConfigHeader decodes an X-Registry-Config header: base64-encoded JSON mapping registry hosts
+// to Docker AuthConfig objects. Errors never include the header's content.
+func ParseRegistryConfigHeader(header string) (RegistryCredentials, error) {
+	if header == "" {
+		return nil, nil
+	}
+	var data []byte
+	var err error
+	for _, enc := range []*base64.Encoding{base64.URLEncoding, base64.StdEncoding, base64.RawURLEncoding, base64.RawStdEncoding} {
+		if data, err = enc.DecodeString(header); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, errors.New("registry config is not valid base64")
+	}
+	var raw RegistryCredentials
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.New("registry config is not a JSON object of registry hosts to credentials")
+	}
+	creds := make(RegistryCredentials, len(raw))
+	for host, c := range raw {
+		creds[normalizeRegistryHost(host)] = c
+	}
+	return creds, nil
+}
+
+// normalizeRegistryHost reduces a registry address as found in config files, such as https://ghcr.io/v2/,
+// to its host
+func normalizeRegistryHost(address string) string {
+	if address == dockerHubIndex {
+		return dockerHubHost
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(address, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		return dockerHubHost
+	}
+	return host
+}
+
+// imageRegistryHost returns the registry host an image is pulled from
+func imageRegistryHost(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	return reference.Domain(named), nil
+}
+
+// dockerConfigFile is the subset of Docker's config.json used to find registry credentials
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// dockerConfigPath is where Docker's config.json is read from: the configured path, else $DOCKER_CONFIG or ~/.docker
+func (c Config) dockerConfigPath() string {
+	if c.DockerConfig != "" {
+		return c.DockerConfig
+	}
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// dockerConfigCredential looks host up in Docker's config.json: its credential helper, then its stored
+// auth, then the default credential store. It returns nil if the file has nothing for host.
+func dockerConfigCredential(path, host string) (*registry.AuthConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read docker config: %w", err)
+	}
+	var file dockerConfigFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse docker config %s: %w", path, err)
+	}
+
+	for address, helper := range file.CredHelpers {
+		if normalizeRegistryHost(address) == host {
+			return credentialHelper(helper, address)
+		}
+	}
+	for address, a := range file.Auths {
+		if normalizeRegistryHost(address) != host {
+			continue
+		}
+		auth := &registry.AuthConfig{ServerAddress: address, IdentityToken: a.IdentityToken}
+		if a.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth for registry %s in docker config", host)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		if auth.Username != "" || auth.IdentityToken != "" {
+			return auth, nil
+		}
+	}
+	if file.CredsStore != "" {
+		address := host
+		if host == dockerHubHost {
+			address = dockerHubIndex
+		}
+		return credentialHelper(file.CredsStore, address)
+	}
+	return nil, nil
+}
+
+// credentialHelper asks docker-credential-<helper> for the credentials of address. A helper that knows
+// nothing of address is not an error.
+func credentialHelper(helper, address string) (*registry.AuthConfig, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(address)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		// helpers print "credentials not found in native keychain" and exit 1 for unknown servers
+		if strings.Contains(stdout.String(), "credentials not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("credential helper %s failed for %s: %w", helper, normalizeRegistryHost(address), err)
+	}
+	var out struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("credential helper %s returned invalid output for %s", helper, normalizeRegistryHost(address))
+	}
+	auth := &registry.AuthConfig{ServerAddress: address}
+	// helpers return identity tokens with this placeholder username
+	if out.Username == "<token>" {
+		auth.IdentityToken = out.Secret
+	} else {
+		auth.Username, auth.Password = out.Username, out.Secret
+	}
+	return auth, nil
+}
+
+// registryAuth returns the encoded credentials to pull image with, or "" to pull anonymously. Credentials
+// sent with the request come first, then the configured registries, then Docker's config.json.
+func (d *DockerClient) registryAuth(image string) (string, error) {
+	host, err := imageRegistryHost(image)
+	if err != nil {
+		return "", err
+	}
+	var auth *registry.AuthConfig
+	if c, ok := registryCredentialsFrom(d.Context)[host]; ok {
+		a, err := c.authConfig(host)
+		if err != nil {
+			return "", err
+		}
+		auth = &a
+	} else if c, ok := d.config().Registries.lookup(host); ok {
+		a, err := c.authConfig(host)
+		if err != nil {
+			return "", err
+		}
+		auth = &a
+	} else if auth, err = dockerConfigCredential(d.config().dockerConfigPath(), host); err != nil {
+		return "", err
+	}
+	if auth == nil {
+		return "", nil
+	}
+	return registry.EncodeAuthConfig(*auth)
+}
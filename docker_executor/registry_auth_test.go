@@ -0,0 +1,115 @@
+package docker_executor
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	"gopkg.in/yaml.v3"
+)
+
+// TestParseRegistryConfigHeader tests that request credentials are keyed by host and that errors don't echo them
+func TestParseRegistryConfigHeader(t *testing.T) {
+	header := base64.URLEncoding.EncodeToString([]byte(`{"https://ghcr.io":{"username":"bot","password":"s3cret"}}`))
+	creds, err := ParseRegistryConfigHeader(header)
+	if err != nil {
+		t.Fatalf("ParseRegistryConfigHeader() error = %v", err)
+	}
+	if c := creds["ghcr.io"]; c.Username != "bot" || c.Password != "s3cret" {
+		t.Errorf("creds = %+v", creds)
+	}
+
+	for _, bad := range []string{"s3cret!!", base64.StdEncoding.EncodeToString([]byte(`["s3cret"]`))} {
+		_, err := ParseRegistryConfigHeader(bad)
+		if err == nil {
+			t.Fatalf("ParseRegistryConfigHeader(%q) expected an error", bad)
+		}
+		if strings.Contains(err.Error(), "s3cret") {
+			t.Errorf("error leaks the credentials: %v", err)
+		}
+	}
+}
+
+// TestRegistryAuth tests that request credentials beat configured ones, which beat Docker's config.json
+func TestRegistryAuth(t *testing.T) {
+	dir := t.TempDir()
+	dockerConfig := filepath.Join(dir, "config.json")
+	auth := base64.StdEncoding.EncodeToString([]byte("file-user:file-pass"))
+	content := `{"auths":{"ghcr.io":{"auth":"` + auth + `"},"https://index.docker.io/v1/":{"auth":"` + auth + `"}},
+		"credHelpers":{"registry.helper":"fake"}}`
+	if err := os.WriteFile(dockerConfig, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	helper := "#!/bin/sh\ncat >/dev/null\necho '{\"Username\":\"helper-user\",\"Secret\":\"helper-pass\"}'\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	d := &DockerClient{Config: Config{
+		DockerConfig: dockerConfig,
+		Registries:   RegistryCredentials{"registry.internal": {Username: "cfg-user", Password: "cfg-pass"}},
+	}}
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		image    string
+		wantUser string
+	}{
+		{name: "docker config auth", image: "ghcr.io/acme/processor:1", wantUser: "file-user"},
+		{name: "docker hub", image: "acme/processor:1", wantUser: "file-user"},
+		{name: "credential helper", image: "registry.helper/acme/processor:1", wantUser: "helper-user"},
+		{name: "configured registry", image: "registry.internal/acme/processor:1", wantUser: "cfg-user"},
+		{
+			name:     "request token",
+			ctx:      WithRegistryCredentials(context.Background(), RegistryCredentials{"ghcr.io": {Username: "token", Password: "short-lived"}}),
+			image:    "ghcr.io/acme/processor:1",
+			wantUser: "token",
+		},
+		{name: "anonymous", image: "quay.io/acme/processor:1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d.Context = tt.ctx
+			encoded, err := d.registryAuth(tt.image)
+			if err != nil {
+				t.Fatalf("registryAuth() error = %v", err)
+			}
+			if tt.wantUser == "" {
+				if encoded != "" {
+					t.Errorf("registryAuth() = %q, want anonymous", encoded)
+				}
+				return
+			}
+			got, err := registry.DecodeAuthConfig(encoded)
+			if err != nil {
+				t.Fatalf("DecodeAuthConfig() error = %v", err)
+			}
+			if got.Username != tt.wantUser {
+				t.Errorf("username = %q, want %q", got.Username, tt.wantUser)
+			}
+		})
+	}
+}
+
+// TestRegistryCredentialRedacted tests that printing the configuration doesn't reveal passwords
+func TestRegistryCredentialRedacted(t *testing.T) {
+	var cfg Config
+	if err := yaml.Unmarshal([]byte("registries:\n  ghcr.io:\n    username: bot\n    password: s3cret\n"), &cfg); err != nil {
+		t.Fatalf("Failed to parse YAML: %v", err)
+	}
+	if cfg.Registries["ghcr.io"].Password != "s3cret" {
+		t.Fatalf("password not read: %+v", cfg.Registries)
+	}
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "s3cret") {
+		t.Errorf("printed config leaks the password:\n%s", out)
+	}
+}
@@ -0,0 +1,282 @@
+package docker_executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/docker/go-units"
+	"gopkg.in/yaml.v3"
+)
+
+// ByteSize is a size in bytes, written in config files and registry responses as a number of bytes
+// or with a unit such as 512m, 512MiB or 2g
+type ByteSize int64
+
+func ParseByteSize(s string) (ByteSize, error) {
+	n, err := units.RAMInBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s': %w", s, err)
+	}
+	return ByteSize(n), nil
+}
+
+func (b ByteSize) String() string {
+	for _, u := range []struct {
+		size int64
+		name string
+	}{{units.GiB, "GiB"}, {units.MiB, "MiB"}, {units.KiB, "KiB"}} {
+		if int64(b) >= u.size && int64(b)%u.size == 0 {
+			return strconv.FormatInt(int64(b)/u.size, 10) + u.name
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
+
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	return b.String(), nil
+}
+
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	size, err := ParseByteSize(value.Value)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+func (b ByteSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("size must be a number of bytes or a string such as 512m: %w", err)
+	}
+	size, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// ResourceLimits caps what a container may use. Zero fields are unlimited.
+// Ulimits are set with equal soft and hard limits.
+type ResourceLimits struct {
+	CPUs    float64          `yaml:"cpus,omitempty" json:"cpus,omitempty"`
+	Memory  ByteSize         `yaml:"memory,omitempty" json:"memory,omitempty"`
+	Pids    int64            `yaml:"pids,omitempty" json:"pids,omitempty"`
+	Ulimits map[string]int64 `yaml:"ulimits,omitempty" json:"ulimits,omitempty"`
+}
+
+// ResourcePolicy is what containers of one cyan type run with unless their template asks otherwise,
+// and the most a template may ask for. A zero Max field caps requests at the default.
+// A policy set in the config file replaces the built-in one for its type as a whole.
+type ResourcePolicy struct {
+	Default ResourceLimits `yaml:"default,omitempty"`
+	Max     ResourceLimits `yaml:"max,omitempty"`
+}
+
+// ulimitNames are the ulimits Docker accepts
+var ulimitNames = map[string]bool{
+	"core": true, "cpu": true, "data": true, "fsize": true, "locks": true, "memlock": true, "msgqueue": true,
+	"nice": true, "nofile": true, "nproc": true, "rss": true, "rtprio": true, "rttime": true,
+	"sigpending": true, "stack": true,
+}
+
+func (l ResourceLimits) validate() error {
+	if l.CPUs < 0 || l.Memory < 0 || l.Pids < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	for name, v := range l.Ulimits {
+		if !ulimitNames[name] {
+			return fmt.Errorf("unknown ulimit '%s'", name)
+		}
+		if v < 0 {
+			return fmt.Errorf("ulimit %s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+func (p ResourcePolicy) validate() error {
+	if err := p.Default.validate(); err != nil {
+		return err
+	}
+	if err := p.Max.validate(); err != nil {
+		return err
+	}
+	// a template that asks for nothing must be able to run
+	_, err := p.resolve(&p.Default)
+	return err
+}
+
+// resolve applies a template's request on top of the defaults, rejecting anything above the ceiling
+func (p ResourcePolicy) resolve(requested *ResourceLimits) (ResourceLimits, error) {
+	limits := ResourceLimits{
+		CPUs:   p.Default.CPUs,
+		Memory: p.Default.Memory,
+		Pids:   p.Default.Pids,
+	}
+	if len(p.Default.Ulimits) > 0 {
+		limits.Ulimits = make(map[string]int64, len(p.Default.Ulimits))
+		for name, v := range p.Default.Ulimits {
+			limits.Ulimits[name] = v
+		}
+	}
+	if requested == nil {
+		return limits, nil
+	}
+
+	if requested.CPUs > 0 {
+		if ceiling := ceilingOf(p.Default.CPUs, p.Max.CPUs); ceiling > 0 && requested.CPUs > ceiling {
+			return ResourceLimits{}, fmt.Errorf("requested %g CPUs, above the limit of %g", requested.CPUs, ceiling)
+		}
+		limits.CPUs = requested.CPUs
+	}
+	if requested.Memory > 0 {
+		if ceiling := ceilingOf(p.Default.Memory, p.Max.Memory); ceiling > 0 && requested.Memory > ceiling {
+			return ResourceLimits{}, fmt.Errorf("requested %s of memory, above the limit of %s", requested.Memory, ceiling)
+		}
+		limits.Memory = requested.Memory
+	}
+	if requested.Pids > 0 {
+		if ceiling := ceilingOf(p.Default.Pids, p.Max.Pids); ceiling > 0 && requested.Pids > ceiling {
+			return ResourceLimits{}, fmt.Errorf("requested %d pids, above the limit of %d", requested.Pids, ceiling)
+		}
+		limits.Pids = requested.Pids
+	}
+	names := make([]string, 0, len(requested.Ulimits))
+	for name := range requested.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := requested.Ulimits[name]
+		if !ulimitNames[name] {
+			return ResourceLimits{}, fmt.Errorf("requested unknown ulimit '%s'", name)
+		}
+		if ceiling := ceilingOf(p.Default.Ulimits[name], p.Max.Ulimits[name]); ceiling > 0 && v > ceiling {
+			return ResourceLimits{}, fmt.Errorf("requested ulimit %s of %d, above the limit of %d", name, v, ceiling)
+		}
+		if limits.Ulimits == nil {
+			limits.Ulimits = make(map[string]int64)
+		}
+		limits.Ulimits[name] = v
+	}
+	return limits, nil
+}
+
+// ceilingOf is the most a template may request: the maximum if the operator set one, else the default.
+// Zero means unlimited.
+func ceilingOf[T ~int64 | ~float64](def, max T) T {
+	if max > 0 {
+		return max
+	}
+	return def
+}
+
+// Limits returns the limits a container of cyanType runs with, given what its template requested.
+// Types without a policy, such as the short-lived unzip and copy helpers, run unlimited.
+func (c Config) Limits(cyanType string, requested *ResourceLimits) (ResourceLimits, error) {
+	p, ok := c.Resources[cyanType]
+	if !ok {
+		return ResourceLimits{}, nil
+	}
+	limits, err := p.resolve(requested)
+	if err != nil {
+		return ResourceLimits{}, fmt.Errorf("%s %w", cyanType, err)
+	}
+	return limits, nil
+}
+
+func defaultResources() map[string]ResourcePolicy {
+	policy := func(cpus float64, memory ByteSize, pids int64, maxCPUs float64, maxMemory ByteSize, maxPids int64) ResourcePolicy {
+		return ResourcePolicy{
+			Default: ResourceLimits{CPUs: cpus, Memory: memory, Pids: pids, Ulimits: map[string]int64{"nofile": 4096}},
+			Max:     ResourceLimits{CPUs: maxCPUs, Memory: maxMemory, Pids: maxPids, Ulimits: map[string]int64{"nofile": 65536}},
+		}
+	}
+	return map[string]ResourcePolicy{
+		"template":       policy(1, 512*units.MiB, 512, 4, 4*units.GiB, 4096),
+		"processor":      policy(1, 1*units.GiB, 512, 4, 4*units.GiB, 4096),
+		"plugin":         policy(1, 1*units.GiB, 512, 4, 4*units.GiB, 4096),
+		CyanTypeResolver: policy(0.5, 256*units.MiB, 256, 2, 1*units.GiB, 1024),
+		"merger":         policy(2, 2*units.GiB, 1024, 4, 8*units.GiB, 4096),
+	}
+}
+
+// ContainerState is a container's state as last reported by the runtime
+type ContainerState struct {
+	Running  bool
+	ExitCode int
+	// OOMKilled is set when the container was killed for exceeding its memory limit
+	OOMKilled bool
+	Limits    ResourceLimits
+	// Created is when the container was created
+	Created time.Time
+	// Image is the image the container was created from
+	Image DockerImageReference
+	// Template is the template the container serves, from its cyanprint.template label. It is empty for
+	// resolvers and for containers created before the label.
+	Template string
+}
+
+// ContainerLimitError reports a container that was killed for exceeding its resource limits
+type ContainerLimitError struct {
+	Container DockerContainerReference
+	Limits    ResourceLimits
+}
+
+func (e *ContainerLimitError) Error() string {
+	if e.Limits.Memory > 0 {
+		return fmt.Sprintf("container %s was killed for running out of memory (limit %s)", DockerContainerToString(e.Container), e.Limits.Memory)
+	}
+	return fmt.Sprintf("container %s was killed for running out of memory", DockerContainerToString(e.Container))
+}
+
+// stoppedError explains why a container that should be serving has stopped.
+// It returns nil while the container is running, or if its state can't be read.
+func stoppedError(rt ContainerRuntime, c DockerContainerReference) error {
+	state, err := rt.InspectContainer(c)
+	if err != nil || state.Running {
+		return nil
+	}
+	if state.OOMKilled {
+		return &ContainerLimitError{Container: c, Limits: state.Limits}
+	}
+	return &ContainerExitError{Container: c, ExitCode: state.ExitCode}
+}
+
+// LimitErrors returns a ContainerLimitError for each of the session's containers that was killed for
+// exceeding its limits, to explain a failed build
+func LimitErrors(rt ContainerRuntime, session string) []error {
+	_, stopped, err := rt.ListContainer()
+	if err != nil {
+		return nil
+	}
+	var errs []error
+	for _, c := range stopped {
+		if c.SessionId != session {
+			continue
+		}
+		if err := stoppedError(rt, c); err != nil {
+			var limitErr *ContainerLimitError
+			if errors.As(err, &limitErr) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,128 @@
+package docker_executor
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestConfigLimits tests that template requests raise the defaults up to the operator's ceiling
+func TestConfigLimits(t *testing.T) {
+	cfg := Config{Resources: map[string]ResourcePolicy{
+		"processor": {
+			Default: ResourceLimits{CPUs: 1, Memory: 512 << 20, Pids: 100, Ulimits: map[string]int64{"nofile": 1024}},
+			Max:     ResourceLimits{CPUs: 2, Memory: 1 << 30, Ulimits: map[string]int64{"nofile": 4096}},
+		},
+	}}
+	tests := []struct {
+		name      string
+		cyanType  string
+		requested *ResourceLimits
+		want      ResourceLimits
+		wantErr   bool
+	}{
+		{
+			name:     "defaults",
+			cyanType: "processor",
+			want:     ResourceLimits{CPUs: 1, Memory: 512 << 20, Pids: 100, Ulimits: map[string]int64{"nofile": 1024}},
+		},
+		{
+			name:      "within ceiling",
+			cyanType:  "processor",
+			requested: &ResourceLimits{Memory: 1 << 30, Ulimits: map[string]int64{"nofile": 4096}},
+			want:      ResourceLimits{CPUs: 1, Memory: 1 << 30, Pids: 100, Ulimits: map[string]int64{"nofile": 4096}},
+		},
+		{name: "memory above ceiling", cyanType: "processor", requested: &ResourceLimits{Memory: 2 << 30}, wantErr: true},
+		// without a maximum, the default is the ceiling
+		{name: "pids above default", cyanType: "processor", requested: &ResourceLimits{Pids: 200}, wantErr: true},
+		{name: "unknown ulimit", cyanType: "processor", requested: &ResourceLimits{Ulimits: map[string]int64{"files": 1}}, wantErr: true},
+		{name: "type without policy", cyanType: "unzip", requested: &ResourceLimits{Memory: 8 << 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cfg.Limits(tt.cyanType, tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Limits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.CPUs != tt.want.CPUs || got.Memory != tt.want.Memory || got.Pids != tt.want.Pids ||
+				got.Ulimits["nofile"] != tt.want.Ulimits["nofile"] {
+				t.Errorf("Limits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestByteSize tests that sizes are read with or without units and written back in the largest exact unit
+func TestByteSize(t *testing.T) {
+	var cfg ResourceLimits
+	if err := yaml.Unmarshal([]byte("memory: 512m\n"), &cfg); err != nil {
+		t.Fatalf("Failed to parse YAML: %v", err)
+	}
+	if cfg.Memory != 512<<20 {
+		t.Errorf("Memory = %d, want %d", cfg.Memory, 512<<20)
+	}
+	if got := cfg.Memory.String(); got != "512MiB" {
+		t.Errorf("String() = %s, want 512MiB", got)
+	}
+
+	var req ProcessorRes
+	if err := json.Unmarshal([]byte(`{"resources":{"memory":1073741824,"cpus":0.5}}`), &req); err != nil {
+		t.Fatalf("Failed to parse JSON: %v", err)
+	}
+	if req.Resources == nil || req.Resources.Memory != 1<<30 || req.Resources.CPUs != 0.5 {
+		t.Errorf("Resources = %+v", req.Resources)
+	}
+	if err := json.Unmarshal([]byte(`{"resources":{"memory":"lots"}}`), &req); err == nil {
+		t.Error("Expected an error for an invalid size")
+	}
+}
+
+// TestLimitErrors tests that only the session's containers killed for running out of memory are reported
+func TestLimitErrors(t *testing.T) {
+	rt := NewFakeRuntime()
+	killed := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"}
+	crashed := DockerContainerReference{CyanId: "plugin-1", CyanType: "plugin", SessionId: "s1"}
+	other := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s2"}
+	image := DockerImageReference{Reference: "registry.local/processor", Tag: "1"}
+	rt.AddImage(image)
+	for _, c := range []DockerContainerReference{killed, crashed, other} {
+		if err := rt.CreateContainer(c, image, ResourceLimits{Memory: 256 << 20}); err != nil {
+			t.Fatalf("CreateContainer() error = %v", err)
+		}
+	}
+	rt.OOMKill(killed)
+	rt.OOMKill(other)
+	rt.StopContainer(crashed)
+
+	errs := LimitErrors(rt, "s1")
+	if len(errs) != 1 {
+		t.Fatalf("LimitErrors() = %v, want 1 error", errs)
+	}
+	var limitErr *ContainerLimitError
+	if !errors.As(errs[0], &limitErr) || limitErr.Container != killed {
+		t.Errorf("LimitErrors() = %v, want a limit error for %s", errs, DockerContainerToString(killed))
+	}
+	if limitErr != nil && limitErr.Limits.Memory != 256<<20 {
+		t.Errorf("reported memory limit = %s, want 256MiB", limitErr.Limits.Memory)
+	}
+}
+
+// TestDefaultResourcesValid tests that the built-in limits pass validation
+func TestDefaultResourcesValid(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	cfg := DefaultConfig()
+	cfg.Resources["processor"] = ResourcePolicy{
+		Default: ResourceLimits{Memory: 2 << 30},
+		Max:     ResourceLimits{Memory: 1 << 30},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an error for a default above the maximum")
+	}
+}
@@ -0,0 +1,138 @@
+package docker_executor
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// ContainerRuntime is the container engine the executors, reaper and server run cyanprint resources on.
+// Resources are addressed by their cyanprint references rather than engine IDs, and creating a container
+// also starts it. DockerClient is the production implementation; FakeRuntime keeps everything in memory
+// so executors can be tested without a Docker daemon.
+type ContainerRuntime interface {
+	// ListContainer returns the cyanprint containers, split into running and stopped
+	ListContainer() ([]DockerContainerReference, []DockerContainerReference, error)
+	// CreateContainer creates and starts a container constrained to limits; see Config.Limits
+	CreateContainer(cc DockerContainerReference, image DockerImageReference, limits ResourceLimits) error
+	// CreateContainerWithVolume mounts v at the workspace template directory
+	CreateContainerWithVolume(cc DockerContainerReference, v DockerVolumeReference, image DockerImageReference, limits ResourceLimits) error
+	// CreateContainerWithReadWriteVolume mounts readVolume read-only at the workspace template directory
+	// and writeVolume at the workspace area directory
+	CreateContainerWithReadWriteVolume(cc DockerContainerReference, readVolume, writeVolume DockerVolumeReference, image DockerImageReference, limits ResourceLimits) error
+	// CreateContainerWithCopyMount copies sourcePath on the host into targetVolume
+	CreateContainerWithCopyMount(cc DockerContainerReference, sourcePath string, targetVolume DockerVolumeReference) error
+	// WaitContainer blocks until the container stops and returns its exit code
+	WaitContainer(ref DockerContainerReference) (int, error)
+	// InspectContainer reports whether the container is running, and if not, how it stopped
+	InspectContainer(ref DockerContainerReference) (ContainerState, error)
+	// WatchContainer returns a channel closed when the container stops, until stop is called.
+	// The channel is nil if the runtime can't watch the container.
+	WatchContainer(ref DockerContainerReference) (stopped <-chan struct{}, stop func())
+	// ContainerLogs returns the last lines the container wrote to stdout and stderr
+	ContainerLogs(ref DockerContainerReference, lines int) ([]string, error)
+	// StreamLogs streams what the container wrote to stdout and stderr, interleaved, until it is closed
+	StreamLogs(ref DockerContainerReference, opts LogOptions) (io.ReadCloser, error)
+	// RestartContainer starts a stopped container again as it was created
+	RestartContainer(ref DockerContainerReference) error
+	// ListMalformedContainers returns the names of cyanprint containers that don't parse, which
+	// ListContainer skips
+	ListMalformedContainers() ([]string, error)
+	RemoveContainer(cc DockerContainerReference) error
+	// RemoveContainerNamed removes a container by name, for those ListMalformedContainers returns
+	RemoveContainerNamed(name string) error
+	// RemoveAllContainers returns one error per reference, nil where removal succeeded
+	RemoveAllContainers(containerRefs []DockerContainerReference) []error
+
+	ListVolumes() ([]DockerVolumeReference, error)
+	// DiskUsage returns the cyanprint images and volumes with the disk space they take and whether
+	// a container uses them
+	DiskUsage() ([]StoredImage, []StoredVolume, error)
+	CreateVolume(vol DockerVolumeReference) error
+	RemoveVolume(vol DockerVolumeReference) error
+	// RemoveAllVolumes returns one error per reference, nil where removal succeeded
+	RemoveAllVolumes(volRefs []DockerVolumeReference) []error
+
+	ListImages() ([]DockerImageReference, error)
+	// PullImages returns only the errors of failed pulls
+	PullImages(images []DockerImageReference) []error
+	// GetCoordinatorImage returns the newest image of the coordinator itself, which mergers run
+	GetCoordinatorImage() (DockerImageReference, error)
+	RemoveImage(imageRef DockerImageReference) error
+	// RemoveAllImages returns one error per reference, nil where removal succeeded
+	RemoveAllImages(imageRefs []DockerImageReference) []error
+	// SaveImages writes local images to w as one archive, which needn't be pulled to be loaded
+	SaveImages(images []DockerImageReference, w io.Writer) error
+	// LoadImages loads an archive written by SaveImages and labels images as cyanprint's, so ListImages
+	// finds them without pulling
+	LoadImages(r io.Reader, images []DockerImageReference) error
+
+	CyanPrintNetworkExist() (bool, error)
+	CreateNetwork() error
+	EnforceNetwork() error
+	NetworkSubnets() ([]string, error)
+	// CoordinatorAttached reports whether the coordinator is on the cyanprint network, or returns
+	// ErrNoCoordinatorContainer if it doesn't run in a container of the runtime
+	CoordinatorAttached() (bool, error)
+	AttachCoordinator() error
+	// CreateSessionNetwork gives a session its own network and attaches the coordinator and the shared
+	// containers it uses; see IsolationConfig. It may be called again for a session that has one.
+	CreateSessionNetwork(session string, shared []DockerContainerReference) error
+	// RemoveSessionNetwork removes the session's network, if it has one
+	RemoveSessionNetwork(session string) error
+	// ListSessionNetworks returns the sessions that have a network
+	ListSessionNetworks() ([]string, error)
+
+	// ListSessionActivity returns the creation time of each session's most recently created resource
+	ListSessionActivity() (map[string]time.Time, error)
+
+	// OperationContext is the context the runtime's operations run under
+	OperationContext() context.Context
+	// Parallelism is how many operations callers should run against the runtime at once
+	Parallelism() int
+	// Settings is the configuration the runtime creates containers with
+	Settings() Config
+}
+
+var _ ContainerRuntime = (*DockerClient)(nil)
+
+// IsUnreachable reports whether err is a failure to reach the runtime at all, as in a merger container,
+// which has no Docker socket
+func IsUnreachable(err error) bool {
+	return err != nil && client.IsErrConnectionFailed(err)
+}
+
+func (d *DockerClient) OperationContext() context.Context {
+	return contextOrBackground(d.Context)
+}
+
+func (d *DockerClient) Parallelism() int {
+	return d.ParallelismLimit
+}
+
+func (d *DockerClient) Settings() Config {
+	return d.config()
+}
+
+// parallelIndexed runs fn for each index in 0..n with at most limit running at once, returning one error per index
+func parallelIndexed(limit, n int, fn func(i int) error) []error {
+	errChan := make(chan indexedError, n)
+	semaphore := make(chan int, max(limit, 1))
+
+	for i := 0; i < n; i++ {
+		semaphore <- 0
+		go func(idx int) {
+			errChan <- indexedError{index: idx, err: fn(idx)}
+			<-semaphore
+		}(i)
+	}
+
+	allErr := make([]error, n)
+	for i := 0; i < n; i++ {
+		ie := <-errChan
+		allErr[ie.index] = ie.err
+	}
+	return allErr
+}
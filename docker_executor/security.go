@@ -0,0 +1,212 @@
+package docker_executor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Security profiles a cyan type's containers may run with
+const (
+	// SecurityStrict drops every capability and privilege escalation, mounts the root filesystem read-only
+	// with tmpfs scratch space, and runs as the sandbox user
+	SecurityStrict = "strict"
+	// SecurityCompatible drops the capabilities templates don't need and privilege escalation, but keeps
+	// the image's user and a writable root filesystem for images that rely on them
+	SecurityCompatible = "compatible"
+	// SecurityUnconfined runs with the runtime's defaults
+	SecurityUnconfined = "unconfined"
+)
+
+// EnvSandboxUser passes the sandbox user on to merger containers, which hand the shared working
+// volume over to it so strict processors and plugins can write there
+const EnvSandboxUser = "BORON_SANDBOX_USER"
+
+// compatibleDroppedCaps are dropped from Docker's default capabilities under the compatible profile
+var compatibleDroppedCaps = []string{"AUDIT_WRITE", "MKNOD", "NET_RAW", "SETFCAP", "SETPCAP", "SYS_CHROOT"}
+
+// scratchDir is mounted as tmpfs in containers with a read-only root filesystem
+const scratchDir = "/tmp"
+
+// SecurityConfig controls the privileges containers run with, by cyan type
+type SecurityConfig struct {
+	// Profiles maps cyan types to strict, compatible or unconfined. Types not listed, such as the merger
+	// and the unzip and copy helpers, run unconfined.
+	Profiles map[string]string `yaml:"profiles"`
+	// User is the numeric uid:gid strict containers run as
+	User string `yaml:"user"`
+	// TmpfsSize bounds the scratch space of strict containers
+	TmpfsSize ByteSize `yaml:"tmpfs_size"`
+	// Seccomp is a seccomp profile applied to confined containers: a JSON file on the coordinator's host
+	// with Docker, a profile path relative to the kubelet's seccomp directory on Kubernetes.
+	// Empty keeps the runtime's default profile.
+	Seccomp string `yaml:"seccomp"`
+	// AppArmor is the name of an AppArmor profile loaded on the hosts, applied to confined containers
+	AppArmor string `yaml:"apparmor"`
+}
+
+// defaultSecurity confines every template, processor, plugin and resolver under the compatible profile,
+// which the images published so far run under. Operators opt types into strict once their images cope
+// with a read-only root filesystem and the sandbox user.
+func defaultSecurity() SecurityConfig {
+	return SecurityConfig{
+		Profiles: map[string]string{
+			"template":       SecurityCompatible,
+			"processor":      SecurityCompatible,
+			"plugin":         SecurityCompatible,
+			CyanTypeResolver: SecurityCompatible,
+		},
+		User:      "65534:65534",
+		TmpfsSize: 64 * units.MiB,
+	}
+}
+
+func (s SecurityConfig) validate() error {
+	for cyanType, p := range s.Profiles {
+		switch p {
+		case SecurityStrict, SecurityCompatible, SecurityUnconfined:
+		default:
+			return fmt.Errorf("unknown security profile '%s' for %s, must be strict, compatible or unconfined", p, cyanType)
+		}
+	}
+	if _, _, err := s.sandboxUser(); err != nil {
+		return err
+	}
+	if s.TmpfsSize < 0 {
+		return fmt.Errorf("tmpfs size must not be negative")
+	}
+	return nil
+}
+
+// Profile returns the security profile containers of cyanType run with
+func (s SecurityConfig) Profile(cyanType string) string {
+	if p, ok := s.Profiles[cyanType]; ok {
+		return p
+	}
+	return SecurityUnconfined
+}
+
+// sandboxUser parses User as uid:gid; a missing gid is the uid
+func (s SecurityConfig) sandboxUser() (int, int, error) {
+	uidStr, gidStr, hasGid := strings.Cut(s.User, ":")
+	if !hasGid {
+		gidStr = uidStr
+	}
+	uid, err := strconv.Atoi(uidStr)
+	if err != nil || uid < 0 {
+		return 0, 0, fmt.Errorf("sandbox user must be a numeric uid:gid, got '%s'", s.User)
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil || gid < 0 {
+		return 0, 0, fmt.Errorf("sandbox user must be a numeric uid:gid, got '%s'", s.User)
+	}
+	if uid == 0 {
+		return 0, 0, fmt.Errorf("sandbox user must not be root")
+	}
+	return uid, gid, nil
+}
+
+// applyDockerSecurity sets the container and host config of a cyanType container for its profile
+func (s SecurityConfig) applyDockerSecurity(cyanType string, cfg *container.Config, hc *container.HostConfig) error {
+	profile := s.Profile(cyanType)
+	if profile == SecurityUnconfined {
+		return nil
+	}
+	hc.SecurityOpt = append(hc.SecurityOpt, "no-new-privileges")
+	if s.Seccomp != "" {
+		// the API takes the profile itself, as the docker CLI does after reading the file
+		seccomp, err := os.ReadFile(s.Seccomp)
+		if err != nil {
+			return fmt.Errorf("failed to read seccomp profile: %w", err)
+		}
+		hc.SecurityOpt = append(hc.SecurityOpt, "seccomp="+string(seccomp))
+	}
+	if s.AppArmor != "" {
+		hc.SecurityOpt = append(hc.SecurityOpt, "apparmor="+s.AppArmor)
+	}
+
+	if profile == SecurityCompatible {
+		hc.CapDrop = compatibleDroppedCaps
+		return nil
+	}
+	hc.CapDrop = []string{"ALL"}
+	hc.ReadonlyRootfs = true
+	tmpfs := "rw,noexec,nosuid,nodev"
+	if s.TmpfsSize > 0 {
+		tmpfs += ",size=" + strconv.FormatInt(int64(s.TmpfsSize), 10)
+	}
+	hc.Tmpfs = map[string]string{scratchDir: tmpfs}
+	cfg.User = s.User
+	return nil
+}
+
+// podSecurity returns the security context of a cyanType container for its profile, and the scratch
+// volume strict containers mount, if any
+func (s SecurityConfig) podSecurity(cyanType string) (*corev1.SecurityContext, *corev1.Volume) {
+	profile := s.Profile(cyanType)
+	if profile == SecurityUnconfined {
+		return nil, nil
+	}
+	noEscalation := false
+	sc := &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &noEscalation,
+		SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+	if s.Seccomp != "" {
+		sc.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost, LocalhostProfile: &s.Seccomp}
+	}
+	if s.AppArmor != "" {
+		sc.AppArmorProfile = &corev1.AppArmorProfile{Type: corev1.AppArmorProfileTypeLocalhost, LocalhostProfile: &s.AppArmor}
+	}
+
+	if profile == SecurityCompatible {
+		sc.Capabilities = &corev1.Capabilities{}
+		for _, c := range compatibleDroppedCaps {
+			sc.Capabilities.Drop = append(sc.Capabilities.Drop, corev1.Capability(c))
+		}
+		return sc, nil
+	}
+	readOnly, nonRoot := true, true
+	uid, gid, _ := s.sandboxUser()
+	runAsUser, runAsGroup := int64(uid), int64(gid)
+	sc.Capabilities = &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}
+	sc.ReadOnlyRootFilesystem = &readOnly
+	sc.RunAsNonRoot = &nonRoot
+	sc.RunAsUser = &runAsUser
+	sc.RunAsGroup = &runAsGroup
+
+	scratch := &corev1.Volume{
+		Name:         "scratch",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}},
+	}
+	if s.TmpfsSize > 0 {
+		scratch.EmptyDir.SizeLimit = resource.NewQuantity(int64(s.TmpfsSize), resource.BinarySI)
+	}
+	return sc, scratch
+}
+
+// shareWithSandbox gives the sandbox user ownership of the tree at root, so strict processors and plugins
+// can write to the working volume the merger fills as root. It does nothing unless running as root.
+func (s SecurityConfig) shareWithSandbox(root string) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	uid, gid, err := s.sandboxUser()
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(root, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
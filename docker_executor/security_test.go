@@ -0,0 +1,125 @@
+package docker_executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestApplyDockerSecurity tests the container settings of each profile
+func TestApplyDockerSecurity(t *testing.T) {
+	s := defaultSecurity()
+	s.AppArmor = "cyanprint-sandbox"
+	s.Profiles["processor"] = SecurityStrict
+
+	tests := []struct {
+		name         string
+		cyanType     string
+		wantUser     string
+		wantReadOnly bool
+		wantCapDrop  []string
+		wantOpts     []string
+	}{
+		{
+			name:         "strict processor",
+			cyanType:     "processor",
+			wantUser:     "65534:65534",
+			wantReadOnly: true,
+			wantCapDrop:  []string{"ALL"},
+			wantOpts:     []string{"no-new-privileges", "apparmor=cyanprint-sandbox"},
+		},
+		{
+			name:        "compatible template",
+			cyanType:    "template",
+			wantCapDrop: compatibleDroppedCaps,
+			wantOpts:    []string{"no-new-privileges", "apparmor=cyanprint-sandbox"},
+		},
+		{
+			name:        "compatible plugin by default",
+			cyanType:    "plugin",
+			wantCapDrop: compatibleDroppedCaps,
+			wantOpts:    []string{"no-new-privileges", "apparmor=cyanprint-sandbox"},
+		},
+		{name: "unconfined merger", cyanType: "merger"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, hc := &container.Config{}, &container.HostConfig{}
+			if err := s.applyDockerSecurity(tt.cyanType, cfg, hc); err != nil {
+				t.Fatalf("applyDockerSecurity() error = %v", err)
+			}
+			if cfg.User != tt.wantUser {
+				t.Errorf("User = %q, want %q", cfg.User, tt.wantUser)
+			}
+			if hc.ReadonlyRootfs != tt.wantReadOnly {
+				t.Errorf("ReadonlyRootfs = %v, want %v", hc.ReadonlyRootfs, tt.wantReadOnly)
+			}
+			if len(hc.CapDrop) != len(tt.wantCapDrop) || (len(hc.CapDrop) > 0 && hc.CapDrop[0] != tt.wantCapDrop[0]) {
+				t.Errorf("CapDrop = %v, want %v", hc.CapDrop, tt.wantCapDrop)
+			}
+			if len(hc.SecurityOpt) != len(tt.wantOpts) {
+				t.Fatalf("SecurityOpt = %v, want %v", hc.SecurityOpt, tt.wantOpts)
+			}
+			for i := range tt.wantOpts {
+				if hc.SecurityOpt[i] != tt.wantOpts[i] {
+					t.Errorf("SecurityOpt = %v, want %v", hc.SecurityOpt, tt.wantOpts)
+				}
+			}
+			if _, ok := hc.Tmpfs[scratchDir]; ok != tt.wantReadOnly {
+				t.Errorf("Tmpfs = %v", hc.Tmpfs)
+			}
+		})
+	}
+}
+
+// TestSecurityConfigValidate tests that unknown profiles and root or non-numeric sandbox users are rejected
+func TestSecurityConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*SecurityConfig)
+		wantErr bool
+	}{
+		{name: "defaults", modify: func(*SecurityConfig) {}},
+		{name: "uid only", modify: func(s *SecurityConfig) { s.User = "1000" }},
+		{name: "unknown profile", modify: func(s *SecurityConfig) { s.Profiles["plugin"] = "paranoid" }, wantErr: true},
+		{name: "root user", modify: func(s *SecurityConfig) { s.User = "0:0" }, wantErr: true},
+		{name: "named user", modify: func(s *SecurityConfig) { s.User = "nobody" }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := defaultSecurity()
+			tt.modify(&s)
+			if err := s.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestKubernetesPodSecurity tests that strict pods run as the sandbox user with a read-only root and scratch volume
+func TestKubernetesPodSecurity(t *testing.T) {
+	k, client := newTestKubernetesRuntime()
+	k.Config.Security.Profiles = map[string]string{CyanTypeResolver: SecurityStrict}
+	cc := DockerContainerReference{CyanId: "resolver-1", CyanType: CyanTypeResolver, SessionId: "s1"}
+	if err := k.CreateContainer(cc, DockerImageReference{Reference: "registry.local/resolver", Tag: "1"}, ResourceLimits{}); err != nil {
+		t.Fatalf("CreateContainer() error = %v", err)
+	}
+	pod, err := client.CoreV1().Pods("cyanprint").Get(context.Background(), DockerContainerToString(cc), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("pod not created: %v", err)
+	}
+	sc := pod.Spec.Containers[0].SecurityContext
+	if sc == nil || sc.RunAsUser == nil || *sc.RunAsUser != 65534 || !*sc.ReadOnlyRootFilesystem || *sc.AllowPrivilegeEscalation {
+		t.Fatalf("SecurityContext = %+v", sc)
+	}
+	if sc.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+		t.Errorf("SeccompProfile = %+v", sc.SeccompProfile)
+	}
+	mounts := pod.Spec.Containers[0].VolumeMounts
+	if len(mounts) != 1 || mounts[0].MountPath != scratchDir {
+		t.Errorf("VolumeMounts = %v, want the scratch volume", mounts)
+	}
+}
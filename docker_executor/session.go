@@ -0,0 +1,231 @@
+package docker_executor
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionState is the lifecycle state of a session tracked by the coordinator
+type SessionState string
+
+const (
+	SessionWarming   SessionState = "warming"
+	SessionStarted   SessionState = "started"
+	SessionBuilding  SessionState = "building"
+	SessionCompleted SessionState = "completed"
+	SessionFailed    SessionState = "failed"
+	SessionCleaned   SessionState = "cleaned"
+)
+
+// SessionInfo is a snapshot of a session held by the SessionRegistry
+type SessionInfo struct {
+	SessionId    string                     `json:"session_id"`
+	State        SessionState               `json:"state"`
+	TemplateId   string                     `json:"template_id"`
+	Containers   []DockerContainerReference `json:"containers"`
+	Volumes      []DockerVolumeReference    `json:"volumes"`
+	CreatedAt    time.Time                  `json:"created_at"`
+	UpdatedAt    time.Time                  `json:"updated_at"`
+	LastActivity time.Time                  `json:"last_activity"`
+	LastError    *string                    `json:"last_error"`
+}
+
+// SessionRegistry tracks sessions in memory as they move through
+// warming -> started -> building -> completed/failed -> cleaned.
+// A nil registry is valid and ignores all updates.
+type SessionRegistry struct {
+	mutex    sync.RWMutex
+	sessions map[string]*SessionInfo
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		sessions: make(map[string]*SessionInfo),
+	}
+}
+
+// upsert returns the session record, creating it if it does not exist yet.
+// Caller must hold the write lock.
+func (r *SessionRegistry) upsert(sessionId string) *SessionInfo {
+	s, ok := r.sessions[sessionId]
+	if !ok {
+		now := time.Now().UTC()
+		s = &SessionInfo{
+			SessionId:    sessionId,
+			Containers:   []DockerContainerReference{},
+			Volumes:      []DockerVolumeReference{},
+			CreatedAt:    now,
+			UpdatedAt:    now,
+			LastActivity: now,
+		}
+		r.sessions[sessionId] = s
+	}
+	return s
+}
+
+// Transition moves the session to the given state, recording the template it belongs to
+func (r *SessionRegistry) Transition(sessionId string, templateId string, state SessionState) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s := r.upsert(sessionId)
+	s.State = state
+	if templateId != "" {
+		s.TemplateId = templateId
+	}
+	if state != SessionFailed {
+		s.LastError = nil
+	}
+	s.UpdatedAt = time.Now().UTC()
+	s.LastActivity = s.UpdatedAt
+}
+
+// Fail moves the session to the failed state and records the errors that caused it
+func (r *SessionRegistry) Fail(sessionId string, errs []error) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s := r.upsert(sessionId)
+	s.State = SessionFailed
+	if len(errs) > 0 {
+		msg := errs[0].Error()
+		for _, err := range errs[1:] {
+			msg += "; " + err.Error()
+		}
+		s.LastError = &msg
+	}
+	s.UpdatedAt = time.Now().UTC()
+	s.LastActivity = s.UpdatedAt
+}
+
+// AddContainers records containers that were created for the session
+func (r *SessionRegistry) AddContainers(sessionId string, containers ...DockerContainerReference) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s := r.upsert(sessionId)
+	for _, c := range containers {
+		found := false
+		for _, existing := range s.Containers {
+			if existing == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.Containers = append(s.Containers, c)
+		}
+	}
+	s.UpdatedAt = time.Now().UTC()
+	s.LastActivity = s.UpdatedAt
+}
+
+// AddVolumes records volumes that were created for the session
+func (r *SessionRegistry) AddVolumes(sessionId string, volumes ...DockerVolumeReference) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s := r.upsert(sessionId)
+	for _, v := range volumes {
+		found := false
+		for _, existing := range s.Volumes {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.Volumes = append(s.Volumes, v)
+		}
+	}
+	s.UpdatedAt = time.Now().UTC()
+	s.LastActivity = s.UpdatedAt
+}
+
+// Clear marks the session as cleaned and forgets its containers and volumes
+func (r *SessionRegistry) Clear(sessionId string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s := r.upsert(sessionId)
+	s.State = SessionCleaned
+	s.Containers = []DockerContainerReference{}
+	s.Volumes = []DockerVolumeReference{}
+	s.UpdatedAt = time.Now().UTC()
+	s.LastActivity = s.UpdatedAt
+}
+
+// Touch records client activity (e.g. a heartbeat) without changing the session's state.
+// Sessions unknown to the registry (e.g. after a coordinator restart) are adopted.
+func (r *SessionRegistry) Touch(sessionId string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s := r.upsert(sessionId)
+	s.LastActivity = time.Now().UTC()
+}
+
+// forget drops a session from the registry entirely
+func (r *SessionRegistry) forget(sessionId string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.sessions, sessionId)
+}
+
+// Get returns a copy of the session, and whether it is known to the registry
+func (r *SessionRegistry) Get(sessionId string) (SessionInfo, bool) {
+	if r == nil {
+		return SessionInfo{}, false
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	s, ok := r.sessions[sessionId]
+	if !ok {
+		return SessionInfo{}, false
+	}
+	return s.copy(), true
+}
+
+// List returns copies of all known sessions, oldest first
+func (r *SessionRegistry) List() []SessionInfo {
+	if r == nil {
+		return []SessionInfo{}
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	sessions := make([]SessionInfo, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s.copy())
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
+func (s *SessionInfo) copy() SessionInfo {
+	c := *s
+	c.Containers = append([]DockerContainerReference{}, s.Containers...)
+	c.Volumes = append([]DockerVolumeReference{}, s.Volumes...)
+	if s.LastError != nil {
+		e := *s.LastError
+		c.LastError = &e
+	}
+	return c
+}
@@ -0,0 +1,82 @@
+package docker_executor
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestSessionRegistryLifecycle tests that sessions move through states and keep their resources
+func TestSessionRegistryLifecycle(t *testing.T) {
+	r := NewSessionRegistry()
+
+	vol := DockerVolumeReference{CyanId: "template-1", SessionId: "s1"}
+	con := DockerContainerReference{CyanId: "processor-1", CyanType: "processor", SessionId: "s1"}
+
+	r.Transition("s1", "template-1", SessionWarming)
+	r.AddVolumes("s1", vol, vol)
+	r.AddContainers("s1", con)
+	r.Transition("s1", "", SessionStarted)
+
+	s, ok := r.Get("s1")
+	if !ok {
+		t.Fatalf("Get(s1) expected session to exist")
+	}
+	if s.State != SessionStarted {
+		t.Errorf("State = %q, want %q", s.State, SessionStarted)
+	}
+	if s.TemplateId != "template-1" {
+		t.Errorf("TemplateId = %q, want %q", s.TemplateId, "template-1")
+	}
+	if len(s.Volumes) != 1 {
+		t.Errorf("len(Volumes) = %d, want 1 (duplicates should be ignored)", len(s.Volumes))
+	}
+	if len(s.Containers) != 1 {
+		t.Errorf("len(Containers) = %d, want 1", len(s.Containers))
+	}
+
+	r.Fail("s1", []error{errors.New("first"), errors.New("second")})
+	s, _ = r.Get("s1")
+	if s.State != SessionFailed {
+		t.Errorf("State = %q, want %q", s.State, SessionFailed)
+	}
+	if s.LastError == nil || *s.LastError != "first; second" {
+		t.Errorf("LastError = %v, want %q", s.LastError, "first; second")
+	}
+
+	r.Clear("s1")
+	s, _ = r.Get("s1")
+	if s.State != SessionCleaned {
+		t.Errorf("State = %q, want %q", s.State, SessionCleaned)
+	}
+	if len(s.Containers) != 0 || len(s.Volumes) != 0 {
+		t.Errorf("Clear should forget resources, got %d containers and %d volumes", len(s.Containers), len(s.Volumes))
+	}
+}
+
+// TestSessionRegistryGetReturnsCopy tests that callers cannot mutate registry state through snapshots
+func TestSessionRegistryGetReturnsCopy(t *testing.T) {
+	r := NewSessionRegistry()
+	r.AddContainers("s1", DockerContainerReference{CyanId: "a", CyanType: "plugin", SessionId: "s1"})
+
+	s, _ := r.Get("s1")
+	s.Containers[0].CyanId = "mutated"
+
+	again, _ := r.Get("s1")
+	if again.Containers[0].CyanId != "a" {
+		t.Errorf("registry state was mutated through a snapshot: %q", again.Containers[0].CyanId)
+	}
+}
+
+// TestSessionRegistryNil tests that a nil registry ignores updates
+func TestSessionRegistryNil(t *testing.T) {
+	var r *SessionRegistry
+	r.Transition("s1", "t", SessionWarming)
+	r.Fail("s1", []error{errors.New("x")})
+	r.Clear("s1")
+	if _, ok := r.Get("s1"); ok {
+		t.Errorf("nil registry should not know any session")
+	}
+	if len(r.List()) != 0 {
+		t.Errorf("nil registry should list no sessions")
+	}
+}
@@ -2,15 +2,22 @@ package docker_executor
 
 import (
 	"fmt"
-	"net/http"
+	"log/slog"
 	"strings"
-	"time"
 )
 
 type TemplateExecutor struct {
-	Docker    DockerClient
+	Docker    ContainerRuntime
 	Template  TemplateVersionPrincipalRes
 	Resolvers []ResolverRes
+	// Usage records the template's images and volume, for garbage collection
+	Usage  *UsageStore
+	Events Emitter
+	Logger *slog.Logger
+}
+
+func (de TemplateExecutor) log() *slog.Logger {
+	return loggerOrDefault(de.Logger).With(LogKeyTemplate, de.Template.ID)
 }
 
 func (de TemplateExecutor) missingTemplateContainer(containers []DockerContainerReference) (bool, DockerContainerReference) {
@@ -46,30 +53,14 @@ func (de TemplateExecutor) missingTemplateVolume(volumes []DockerVolumeReference
 
 func (de TemplateExecutor) missingTemplateVolumeImage(images []DockerImageReference) (bool, DockerImageReference) {
 	template := de.Template
-	i := DockerImageReference{
-		Reference: template.Properties.BlobDockerReference,
-		Tag:       template.Properties.BlobDockerTag,
-	}
-	for _, image := range images {
-		if strings.HasSuffix(i.Reference, image.Reference) && image.Tag == i.Tag {
-			return false, i
-		}
-	}
-	return true, i
+	i := NewDockerImageReference(template.Properties.BlobDockerReference, template.Properties.BlobDockerTag)
+	return !i.foundIn(images), i
 }
 
 func (de TemplateExecutor) missingTemplateImages(images []DockerImageReference) (bool, DockerImageReference) {
 	template := de.Template
-	i := DockerImageReference{
-		Reference: template.Properties.TemplateDockerReference,
-		Tag:       template.Properties.TemplateDockerTag,
-	}
-	for _, image := range images {
-		if strings.HasSuffix(i.Reference, image.Reference) && image.Tag == i.Tag {
-			return false, i
-		}
-	}
-	return true, i
+	i := NewDockerImageReference(template.Properties.TemplateDockerReference, template.Properties.TemplateDockerTag)
+	return !i.foundIn(images), i
 }
 
 func (de TemplateExecutor) missingResolverContainer(resolver ResolverRes, containers []DockerContainerReference) (bool, DockerContainerReference) {
@@ -87,16 +78,8 @@ func (de TemplateExecutor) missingResolverContainer(resolver ResolverRes, contai
 }
 
 func (de TemplateExecutor) missingResolverImages(resolver ResolverRes, images []DockerImageReference) (bool, DockerImageReference) {
-	i := DockerImageReference{
-		Reference: resolver.DockerReference,
-		Tag:       resolver.DockerTag,
-	}
-	for _, image := range images {
-		if strings.HasSuffix(i.Reference, image.Reference) && image.Tag == i.Tag {
-			return false, i
-		}
-	}
-	return true, i
+	i := NewDockerImageReference(resolver.DockerReference, resolver.DockerTag)
+	return !i.foundIn(images), i
 }
 
 func (de TemplateExecutor) listContainersVolumesImages() (
@@ -111,44 +94,44 @@ func (de TemplateExecutor) listContainersVolumesImages() (
 	d := de.Docker
 
 	go func() {
-		fmt.Println("🔍 Looking for volumes...")
+		de.log().Debug("Looking for volumes")
 		volumes, err := d.ListVolumes()
 		if err != nil {
-			fmt.Println("🚨 Error looking for volumes", err)
+			de.log().Error("Error looking for volumes", LogKeyError, err)
 			errChan <- []error{err}
 		} else {
-			fmt.Println("✅ Successfully retrieved volumes, found", len(volumes), "volumes")
+			de.log().Debug("Successfully retrieved volumes", "count", len(volumes))
 			volumeChan <- volumes
 		}
 	}()
 
 	go func() {
-		fmt.Println("🔍 Looking for containers...")
+		de.log().Debug("Looking for containers")
 		runningContainers, stoppedContainers, err := d.ListContainer()
 		if err != nil {
-			fmt.Println("🚨 Error looking for containers", err)
+			de.log().Error("Error looking for containers", LogKeyError, err)
 			errChan <- []error{err}
 		} else {
-			fmt.Println("🗑️ Removing stopped containers...")
-			errs := d.RemoveAllContainers(stoppedContainers)
+			de.log().Info("Removing stopped containers", "count", len(stoppedContainers))
+			errs := filterNilErrors(d.RemoveAllContainers(stoppedContainers))
 			if len(errs) > 0 {
-				fmt.Println("🚨 Error removing containers", err)
+				de.log().Error("Error removing containers", "errors", errs)
 				errChan <- errs
 			} else {
-				fmt.Println("✅ Successfully removed stopped containers, found", len(runningContainers), "running containers")
+				de.log().Debug("Successfully removed stopped containers", "running", len(runningContainers))
 				containerChan <- runningContainers
 			}
 		}
 	}()
 
 	go func() {
-		fmt.Println("🔍 Looking for images...")
+		de.log().Debug("Looking for images")
 		images, err := d.ListImages()
 		if err != nil {
-			fmt.Println("🚨 Error looking for images", err)
+			de.log().Error("Error looking for images", LogKeyError, err)
 			errChan <- []error{err}
 		} else {
-			fmt.Println("✅ Successfully retrieved images, found", len(images), "images")
+			de.log().Debug("Successfully retrieved images", "count", len(images))
 			imageChan <- images
 		}
 	}()
@@ -172,11 +155,12 @@ func (de TemplateExecutor) listContainersVolumesImages() (
 }
 
 func (de TemplateExecutor) startContainer(conRef DockerContainerReference) error {
-	imageRef := DockerImageReference{
-		Reference: de.Template.Properties.TemplateDockerReference,
-		Tag:       de.Template.Properties.TemplateDockerTag,
+	imageRef := NewDockerImageReference(de.Template.Properties.TemplateDockerReference, de.Template.Properties.TemplateDockerTag)
+	limits, err := de.Docker.Settings().Limits(conRef.CyanType, de.Template.Properties.Resources)
+	if err != nil {
+		return err
 	}
-	err := de.Docker.CreateContainer(conRef, imageRef)
+	err = de.Docker.CreateContainer(conRef, imageRef, limits)
 	if err != nil {
 
 		return err
@@ -186,11 +170,12 @@ func (de TemplateExecutor) startContainer(conRef DockerContainerReference) error
 }
 
 func (de TemplateExecutor) startResolverContainer(resolver ResolverRes, conRef DockerContainerReference) error {
-	imageRef := DockerImageReference{
-		Reference: resolver.DockerReference,
-		Tag:       resolver.DockerTag,
+	imageRef := NewDockerImageReference(resolver.DockerReference, resolver.DockerTag)
+	limits, err := de.Docker.Settings().Limits(conRef.CyanType, resolver.Resources)
+	if err != nil {
+		return fmt.Errorf("%s: %w", resolver.ID, err)
 	}
-	err := de.Docker.CreateContainer(conRef, imageRef)
+	err = de.Docker.CreateContainer(conRef, imageRef, limits)
 	if err != nil {
 		return err
 	}
@@ -199,49 +184,52 @@ func (de TemplateExecutor) startResolverContainer(resolver ResolverRes, conRef D
 
 func (de TemplateExecutor) startVolume(volRef DockerVolumeReference) error {
 	d := de.Docker
-	fmt.Println("🚧 Creating volume", volRef.CyanId)
+	de.log().Info("Creating volume", LogKeyVolume, DockerVolumeToString(volRef))
 	err := d.CreateVolume(volRef)
 	if err != nil {
-		fmt.Println("🚨 Failed to create volume", volRef.CyanId)
+		de.log().Error("Failed to create volume", LogKeyVolume, DockerVolumeToString(volRef), LogKeyError, err)
 		return err
 	} else {
-		fmt.Println("✅ Volume created", volRef.CyanId)
-	}
-	unzipImage := DockerImageReference{
-		Reference: de.Template.Properties.BlobDockerReference,
-		Tag:       de.Template.Properties.BlobDockerTag,
+		de.log().Info("Volume created", LogKeyVolume, DockerVolumeToString(volRef))
 	}
+	unzipImage := NewDockerImageReference(de.Template.Properties.BlobDockerReference, de.Template.Properties.BlobDockerTag)
 	unzipContainer := DockerContainerReference{
 		CyanId:    de.Template.ID,
 		CyanType:  "volume",
 		SessionId: "",
 	}
 
-	fmt.Println("🚧 Unzipping volume ", volRef.CyanId)
-	err = d.CreateContainerWithVolume(unzipContainer, volRef, unzipImage)
+	return unzipVolume(d, de.log(), unzipContainer, volRef, unzipImage)
+}
+
+// unzipVolume runs a helper container that extracts image into vol, waits for it and removes it.
+// A helper that fails is left behind with its logs; doctor re-extracts such volumes.
+func unzipVolume(d ContainerRuntime, logger *slog.Logger, helper DockerContainerReference, vol DockerVolumeReference, image DockerImageReference) error {
+	logger.Info("Unzipping volume", append(containerAttrs(helper), LogKeyVolume, DockerVolumeToString(vol))...)
+	err := d.CreateContainerWithVolume(helper, vol, image, ResourceLimits{})
 	if err != nil {
-		fmt.Println("🚨 Failed to start unzip container", volRef.CyanId)
+		logger.Error("Failed to start unzip container", append(containerAttrs(helper), LogKeyError, err)...)
 		return err
 	} else {
-		fmt.Println("⚙️ Still unzipping...", volRef.CyanId)
+		logger.Debug("Still unzipping", containerAttrs(helper)...)
 	}
-	exitCode, err := d.WaitContainer(unzipContainer)
+	exitCode, err := d.WaitContainer(helper)
 	if err != nil {
-		fmt.Println("🚨 Failed to unzip volume", volRef.CyanId)
+		logger.Error("Failed to unzip volume", append(containerAttrs(helper), LogKeyError, err)...)
 		return err
 	} else if exitCode != 0 {
-		fmt.Println("🚨 Unzip container failed with exit code", exitCode, volRef.CyanId)
+		logger.Error("Unzip container failed", append(containerAttrs(helper), "exit_code", exitCode)...)
 		return fmt.Errorf("unzip container failed with exit code %d", exitCode)
 	} else {
-		fmt.Println("✅ Volume unzipped", volRef.CyanId)
+		logger.Info("Volume unzipped", LogKeyVolume, DockerVolumeToString(vol))
 	}
-	fmt.Println("🧹 Removing unzip container", volRef.CyanId)
-	err = d.RemoveContainer(unzipContainer)
+	logger.Debug("Removing unzip container", containerAttrs(helper)...)
+	err = d.RemoveContainer(helper)
 	if err != nil {
-		fmt.Println("🚨 Failed to remove unzip container", volRef.CyanId)
+		logger.Error("Failed to remove unzip container", append(containerAttrs(helper), LogKeyError, err)...)
 		return err
 	}
-	fmt.Println("✅ Unzip container removed", volRef.CyanId)
+	logger.Debug("Unzip container removed", containerAttrs(helper)...)
 	return nil
 }
 
@@ -252,12 +240,12 @@ func (de TemplateExecutor) startContainerAndVolume(conMissing, volMissing bool,
 
 	if conMissing {
 		go func() {
-			fmt.Println("🚀 Starting Template container")
+			de.log().Info("Starting template container", containerAttrs(con)...)
 			err := de.startContainer(con)
 			if err != nil {
-				fmt.Println("🚨 Failed to start Template container", err)
+				de.log().Error("Failed to start template container", append(containerAttrs(con), LogKeyError, err)...)
 			} else {
-				fmt.Println("✅ Template container started")
+				de.log().Info("Template container started", containerAttrs(con)...)
 			}
 			errChan <- err
 		}()
@@ -265,12 +253,12 @@ func (de TemplateExecutor) startContainerAndVolume(conMissing, volMissing bool,
 
 	if volMissing {
 		go func() {
-			fmt.Println("📦 Creating Volumes...")
+			de.log().Info("Creating template volume", LogKeyVolume, DockerVolumeToString(vol))
 			err := de.startVolume(vol)
 			if err != nil {
-				fmt.Println("🚨 Failed to create volume", err)
+				de.log().Error("Failed to create template volume", LogKeyVolume, DockerVolumeToString(vol), LogKeyError, err)
 			} else {
-				fmt.Println("✅ Volumes created")
+				de.log().Info("Template volume created", LogKeyVolume, DockerVolumeToString(vol))
 			}
 			errChan <- err
 		}()
@@ -291,37 +279,6 @@ func (de TemplateExecutor) startContainerAndVolume(conMissing, volMissing bool,
 	return errs
 }
 
-func (de TemplateExecutor) statusCheck(endpoint string, maxAttempts int) error {
-
-	for i := 0; i < maxAttempts; i++ {
-		// Send GET request
-		fmt.Println("🏓 Ping endpoint:", endpoint, "Attempt:", i+1)
-		resp, err := http.Get(endpoint)
-		if err != nil {
-			fmt.Println("🚨 Error:", err)
-			fmt.Println("⌛ Waiting for 1 second before next attempt...")
-			time.Sleep(1 * time.Second)
-			continue
-		}
-
-		if resp.StatusCode == http.StatusOK {
-			fmt.Println("✅ Health Check successful, Status code:", resp.StatusCode)
-			resp.Body.Close()
-			return nil // Success - escape from the loop
-		} else {
-			fmt.Println("🚨 Request failed! Status code:", resp.StatusCode)
-			resp.Body.Close()
-		}
-
-		fmt.Println("⌛ Waiting for 1 second before next attempt...")
-		time.Sleep(1 * time.Second)
-	}
-
-	// If we get here, we exhausted all attempts without success
-	fmt.Println("🚨 Reached maximum attempts of", maxAttempts)
-	return fmt.Errorf("reached maximum attempts of %d without successful health check for %s", maxAttempts, endpoint)
-}
-
 func (de TemplateExecutor) WarmTemplate() []error {
 
 	d := de.Docker
@@ -337,25 +294,31 @@ func (de TemplateExecutor) WarmTemplate() []error {
 	volumeImageMissing, volumeImage := de.missingTemplateVolumeImage(imageRefs)
 	volumeMissing, volume := de.missingTemplateVolume(volumeRefs)
 
+	used := []DockerImageReference{image, volumeImage}
+	for _, resolver := range de.Resolvers {
+		used = append(used, NewDockerImageReference(strings.TrimSpace(resolver.DockerReference), strings.TrimSpace(resolver.DockerTag)))
+	}
+	de.Usage.Touch(de.Events.SessionId, used, []DockerVolumeReference{volume})
+
 	if conMissing {
-		fmt.Println("🚧 Template container is missing")
+		de.log().Info("Template container is missing", containerAttrs(container)...)
 	} else {
-		fmt.Println("✅ Template container exists")
+		de.log().Debug("Template container exists", containerAttrs(container)...)
 	}
 	if imageMissing {
-		fmt.Println("🚧 Template image is missing")
+		de.log().Info("Template image is missing", LogKeyImage, DockerImageToString(image))
 	} else {
-		fmt.Println("✅ Template image exists")
+		de.log().Debug("Template image exists", LogKeyImage, DockerImageToString(image))
 	}
 	if volumeImageMissing {
-		fmt.Println("🚧 Template volume image is missing")
+		de.log().Info("Template volume image is missing", LogKeyImage, DockerImageToString(volumeImage))
 	} else {
-		fmt.Println("✅ Template volume image exists")
+		de.log().Debug("Template volume image exists", LogKeyImage, DockerImageToString(volumeImage))
 	}
 	if volumeMissing {
-		fmt.Println("🚧 Template volume is missing")
+		de.log().Info("Template volume is missing", LogKeyVolume, DockerVolumeToString(volume))
 	} else {
-		fmt.Println("✅ Template volume exists")
+		de.log().Debug("Template volume exists", LogKeyVolume, DockerVolumeToString(volume))
 	}
 
 	var images []DockerImageReference
@@ -374,16 +337,14 @@ func (de TemplateExecutor) WarmTemplate() []error {
 		return errs
 	}
 
-	realName := DockerContainerToString(container)
-
-	fmt.Println("🔍 Checking if container is running...")
+	de.log().Info("Checking if template container is ready", containerAttrs(container)...)
 
-	err := de.statusCheck("http://"+realName+":5550/", 60)
+	err := newReadiness(de.Docker, de.Events, de.log()).wait(container)
 	if err != nil {
-		fmt.Println("🚨 Starting template container failed:", err)
+		de.log().Error("Starting template container failed", append(containerAttrs(container), LogKeyError, err)...)
 		return []error{err}
 	}
-	fmt.Println("✅ Template container is running")
+	de.log().Info("Template container is ready", containerAttrs(container)...)
 
 	// De-duplicate resolvers by ID before warming
 	seenResolvers := make(map[string]bool)
@@ -397,7 +358,7 @@ func (de TemplateExecutor) WarmTemplate() []error {
 
 	// Warm resolvers
 	for _, resolver := range uniqueResolvers {
-		if strings.TrimSpace(resolver.DockerReference) == "" || strings.TrimSpace(resolver.DockerTag) == "" {
+		if ref := NewDockerImageReference(strings.TrimSpace(resolver.DockerReference), strings.TrimSpace(resolver.DockerTag)); ref.Reference == "" || (ref.Tag == "" && ref.Digest == "") {
 			return []error{fmt.Errorf("resolver %s is missing docker reference/tag", resolver.ID)}
 		}
 
@@ -405,14 +366,14 @@ func (de TemplateExecutor) WarmTemplate() []error {
 		resolverImageMissing, resolverImage := de.missingResolverImages(resolver, imageRefs)
 
 		if resolverConMissing {
-			fmt.Println("🚧 Resolver container is missing:", resolver.ID)
+			de.log().Info("Resolver container is missing", containerAttrs(resolverCon)...)
 		} else {
-			fmt.Println("✅ Resolver container exists:", resolver.ID)
+			de.log().Debug("Resolver container exists", containerAttrs(resolverCon)...)
 		}
 		if resolverImageMissing {
-			fmt.Println("🚧 Resolver image is missing:", resolver.ID)
+			de.log().Info("Resolver image is missing", LogKeyImage, DockerImageToString(resolverImage))
 		} else {
-			fmt.Println("✅ Resolver image exists:", resolver.ID)
+			de.log().Debug("Resolver image exists", LogKeyImage, DockerImageToString(resolverImage))
 		}
 
 		if resolverImageMissing {
@@ -423,23 +384,22 @@ func (de TemplateExecutor) WarmTemplate() []error {
 		}
 
 		if resolverConMissing {
-			fmt.Println("🚀 Starting Resolver container:", resolver.ID)
+			de.log().Info("Starting resolver container", containerAttrs(resolverCon)...)
 			err := de.startResolverContainer(resolver, resolverCon)
 			if err != nil {
-				fmt.Println("🚨 Failed to start Resolver container:", resolver.ID, err)
+				de.log().Error("Failed to start resolver container", append(containerAttrs(resolverCon), LogKeyError, err)...)
 				return []error{err}
 			}
-			fmt.Println("✅ Resolver container started:", resolver.ID)
+			de.log().Info("Resolver container started", containerAttrs(resolverCon)...)
 		}
 
-		resolverRealName := DockerContainerToString(resolverCon)
-		fmt.Println("🔍 Checking if resolver container is running:", resolver.ID)
-		err := de.statusCheck(fmt.Sprintf("http://%s:%d/", resolverRealName, ResolverPort), 60)
+		de.log().Info("Checking if resolver container is ready", containerAttrs(resolverCon)...)
+		err := newReadiness(de.Docker, de.Events, de.log()).wait(resolverCon)
 		if err != nil {
-			fmt.Println("🚨 Starting resolver container failed:", resolver.ID, err)
+			de.log().Error("Starting resolver container failed", append(containerAttrs(resolverCon), LogKeyError, err)...)
 			return []error{err}
 		}
-		fmt.Println("✅ Resolver container is running:", resolver.ID)
+		de.log().Info("Resolver container is ready", containerAttrs(resolverCon)...)
 	}
 
 	return nil
@@ -0,0 +1,65 @@
+package docker_executor
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/AtomiCloud/sulfone.boron/docker_executor"
+
+// NewHTTPClient creates an HTTP client that propagates the caller's W3C trace context to the
+// containers and services it calls. A zero timeout means no timeout.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+}
+
+var tracedClient = NewHTTPClient(0)
+
+// contextOrBackground guards against components that were constructed without a context
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(contextOrBackground(ctx), name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func containerSpanAttrs(ref DockerContainerReference) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String(LogKeyContainer, DockerContainerToString(ref)),
+		attribute.String(LogKeyCyanType, ref.CyanType),
+		attribute.String(LogKeyCyanId, ref.CyanId),
+		attribute.String(LogKeySession, ref.SessionId),
+	}
+}
+
+// tracedGet performs a GET request carrying the trace context of ctx
+func tracedGet(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(contextOrBackground(ctx), http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
@@ -2,18 +2,20 @@ package docker_executor
 
 import (
 	"fmt"
-	"net/http"
-	"time"
+	"log/slog"
 )
 
-// httpClient with timeout for health checks
-var httpClient = &http.Client{
-	Timeout: 5 * time.Second,
-}
-
 type TryExecutor struct {
-	Docker  DockerClient
+	Docker  ContainerRuntime
 	Request TryExecutorReq
+	// Usage records the images and blob volume the try uses, for garbage collection
+	Usage  *UsageStore
+	Events Emitter
+	Logger *slog.Logger
+}
+
+func (e *TryExecutor) log() *slog.Logger {
+	return loggerOrDefault(e.Logger).With(LogKeySession, e.Request.SessionId, LogKeyTemplate, e.Request.LocalTemplateId)
 }
 
 // TrySetup performs the full try setup flow
@@ -32,6 +34,7 @@ func (e *TryExecutor) TrySetup() (TryExecutorRes, []error) {
 	if len(errs) > 0 {
 		return TryExecutorRes{}, errs
 	}
+	e.Usage.Touch(e.Request.SessionId, e.images(source), []DockerVolumeReference{blobVol})
 
 	// 2. Pull missing images
 	errs = e.pullMissingImages()
@@ -50,6 +53,24 @@ func (e *TryExecutor) TrySetup() (TryExecutorRes, []error) {
 	}, nil
 }
 
+// images are the images the try runs; the blob image too when the template is extracted from it
+func (e *TryExecutor) images(source string) []DockerImageReference {
+	var images []DockerImageReference
+	if props := e.Request.Template.Principal.Properties; source == "image" && props != nil {
+		images = append(images, NewDockerImageReference(props.BlobDockerReference, props.BlobDockerTag))
+	}
+	for _, p := range e.Request.Template.Processors {
+		images = append(images, NewDockerImageReference(p.DockerReference, p.DockerTag))
+	}
+	for _, p := range e.Request.Template.Plugins {
+		images = append(images, NewDockerImageReference(p.DockerReference, p.DockerTag))
+	}
+	for _, r := range e.Request.Template.Resolvers {
+		images = append(images, NewDockerImageReference(r.DockerReference, r.DockerTag))
+	}
+	return images
+}
+
 func (e *TryExecutor) createBlobVolume() (DockerVolumeReference, []error) {
 	blobVol := DockerVolumeReference{
 		CyanId:    e.Request.LocalTemplateId,
@@ -64,13 +85,13 @@ func (e *TryExecutor) createBlobVolume() (DockerVolumeReference, []error) {
 
 	for _, v := range volumes {
 		if v.CyanId == e.Request.LocalTemplateId && v.SessionId == "" {
-			fmt.Println("✅ Blob volume already exists:", DockerVolumeToString(blobVol))
+			e.log().Info("Blob volume already exists", LogKeyVolume, DockerVolumeToString(blobVol))
 			return blobVol, nil
 		}
 	}
 
 	// Create volume
-	fmt.Println("📦 Creating blob volume:", DockerVolumeToString(blobVol))
+	e.log().Info("Creating blob volume", LogKeyVolume, DockerVolumeToString(blobVol))
 	if err := e.Docker.CreateVolume(blobVol); err != nil {
 		return blobVol, []error{err}
 	}
@@ -103,7 +124,7 @@ func (e *TryExecutor) populateBlobFromPath(blobVol DockerVolumeReference) error
 		CyanType:  "copy-helper",
 		SessionId: "",
 	}
-	fmt.Println("📂 Copying files from path:", e.Request.Path)
+	e.log().Info("Copying files from path", "path", e.Request.Path)
 	return e.Docker.CreateContainerWithCopyMount(cc, e.Request.Path, blobVol)
 }
 
@@ -113,10 +134,7 @@ func (e *TryExecutor) populateBlobFromImage(blobVol DockerVolumeReference) error
 		return fmt.Errorf("template properties are required for blob extraction")
 	}
 
-	blobImage := DockerImageReference{
-		Reference: props.BlobDockerReference,
-		Tag:       props.BlobDockerTag,
-	}
+	blobImage := NewDockerImageReference(props.BlobDockerReference, props.BlobDockerTag)
 
 	cc := DockerContainerReference{
 		CyanId:    e.Request.LocalTemplateId,
@@ -124,21 +142,21 @@ func (e *TryExecutor) populateBlobFromImage(blobVol DockerVolumeReference) error
 		SessionId: e.Request.SessionId,
 	}
 
-	fmt.Println("📦 Extracting blob from image:", DockerImageToString(blobImage))
+	e.log().Info("Extracting blob from image", LogKeyImage, DockerImageToString(blobImage))
 
 	// Create and start the unzip container with the blob image
-	if err := e.Docker.CreateContainerWithVolume(cc, blobVol, blobImage); err != nil {
+	if err := e.Docker.CreateContainerWithVolume(cc, blobVol, blobImage, ResourceLimits{}); err != nil {
 		return fmt.Errorf("failed to start unzip container: %w", err)
 	}
 
 	// Ensure container is removed on all exit paths
 	defer func() {
-		fmt.Println("🧹 Removing unzip container")
+		e.log().Debug("Removing unzip container", containerAttrs(cc)...)
 		_ = e.Docker.RemoveContainer(cc) // best effort cleanup
 	}()
 
 	// Wait for the blob extraction (tar) to complete and exit
-	fmt.Println("⚙️ Waiting for blob extraction to complete...")
+	e.log().Info("Waiting for blob extraction to complete", containerAttrs(cc)...)
 	exitCode, err := e.Docker.WaitContainer(cc)
 	if err != nil {
 		return fmt.Errorf("failed waiting for blob extraction: %w", err)
@@ -146,7 +164,7 @@ func (e *TryExecutor) populateBlobFromImage(blobVol DockerVolumeReference) error
 	if exitCode != 0 {
 		return fmt.Errorf("blob extraction failed with exit code %d", exitCode)
 	}
-	fmt.Println("✅ Blob extraction completed")
+	e.log().Info("Blob extraction completed", containerAttrs(cc)...)
 
 	return nil
 }
@@ -161,45 +179,36 @@ func (e *TryExecutor) pullMissingImages() []error {
 
 	// Check processors
 	for _, p := range e.Request.Template.Processors {
-		ref := DockerImageReference{Reference: p.DockerReference, Tag: p.DockerTag}
-		if !imageExistsInList(images, ref) {
+		ref := NewDockerImageReference(p.DockerReference, p.DockerTag)
+		if !ref.foundIn(images) {
 			missing = append(missing, ref)
 		}
 	}
 
 	// Check plugins
 	for _, p := range e.Request.Template.Plugins {
-		ref := DockerImageReference{Reference: p.DockerReference, Tag: p.DockerTag}
-		if !imageExistsInList(images, ref) {
+		ref := NewDockerImageReference(p.DockerReference, p.DockerTag)
+		if !ref.foundIn(images) {
 			missing = append(missing, ref)
 		}
 	}
 
 	// Check resolvers
 	for _, r := range e.Request.Template.Resolvers {
-		ref := DockerImageReference{Reference: r.DockerReference, Tag: r.DockerTag}
-		if !imageExistsInList(images, ref) {
+		ref := NewDockerImageReference(r.DockerReference, r.DockerTag)
+		if !ref.foundIn(images) {
 			missing = append(missing, ref)
 		}
 	}
 
 	if len(missing) > 0 {
-		fmt.Println("📥 Pulling missing images...")
+		e.log().Info("Pulling missing images", "count", len(missing))
 		return e.Docker.PullImages(missing)
 	}
 
 	return nil
 }
 
-func imageExistsInList(images []DockerImageReference, ref DockerImageReference) bool {
-	for _, img := range images {
-		if img.Reference == ref.Reference && img.Tag == ref.Tag {
-			return true
-		}
-	}
-	return false
-}
-
 func (e *TryExecutor) warmResolvers() []error {
 	runningContainers, stoppedContainers, err := e.Docker.ListContainer()
 	if err != nil {
@@ -228,9 +237,8 @@ func (e *TryExecutor) warmResolvers() []error {
 
 		if !missing {
 			// Container already running - verify health before skipping
-			fmt.Println("✅ Resolver container already running:", resolver.ID)
-			ep := fmt.Sprintf("http://%s:%d/", DockerContainerToString(conRef), ResolverPort)
-			if err := e.statusCheck(ep, 10); err != nil {
+			e.log().Info("Resolver container already running", containerAttrs(conRef)...)
+			if err := newReadiness(e.Docker, e.Events, e.log()).wait(conRef); err != nil {
 				allErrs = append(allErrs, fmt.Errorf("resolver %s health check failed: %w", resolver.ID, err))
 			}
 			continue
@@ -239,7 +247,7 @@ func (e *TryExecutor) warmResolvers() []error {
 		// Check if image exists, pull if missing
 		imgMissing, imgRef := e.missingResolverImage(resolver, images)
 		if imgMissing {
-			fmt.Println("📥 Pulling resolver image:", DockerImageToString(imgRef))
+			e.log().Info("Pulling resolver image", LogKeyImage, DockerImageToString(imgRef))
 			if errs := e.Docker.PullImages([]DockerImageReference{imgRef}); len(errs) > 0 {
 				allErrs = append(allErrs, errs...)
 				continue
@@ -247,15 +255,14 @@ func (e *TryExecutor) warmResolvers() []error {
 		}
 
 		// Start container
-		fmt.Println("🚀 Starting resolver container:", resolver.ID)
+		e.log().Info("Starting resolver container", containerAttrs(conRef)...)
 		if err := e.startResolverContainer(resolver, conRef); err != nil {
 			allErrs = append(allErrs, err)
 			continue
 		}
 
 		// Health check
-		ep := fmt.Sprintf("http://%s:%d/", DockerContainerToString(conRef), ResolverPort)
-		if err := e.statusCheck(ep, 60); err != nil {
+		if err := newReadiness(e.Docker, e.Events, e.log()).wait(conRef); err != nil {
 			allErrs = append(allErrs, err)
 		}
 	}
@@ -281,7 +288,7 @@ func (e *TryExecutor) missingResolverContainer(resolver ResolverRes, runningCont
 	for _, c := range stoppedContainers {
 		if c.CyanType == CyanTypeResolver && c.CyanId == resolver.ID {
 			// Remove stopped container so it can be recreated
-			fmt.Println("🧹 Removing stopped resolver container:", resolver.ID)
+			e.log().Info("Removing stopped resolver container", containerAttrs(c)...)
 			_ = e.Docker.RemoveContainer(c) // best effort cleanup
 			break
 		}
@@ -291,39 +298,15 @@ func (e *TryExecutor) missingResolverContainer(resolver ResolverRes, runningCont
 }
 
 func (e *TryExecutor) missingResolverImage(resolver ResolverRes, images []DockerImageReference) (bool, DockerImageReference) {
-	ref := DockerImageReference{Reference: resolver.DockerReference, Tag: resolver.DockerTag}
-	for _, img := range images {
-		if img.Reference == ref.Reference && img.Tag == ref.Tag {
-			return false, ref
-		}
-	}
-	return true, ref
+	ref := NewDockerImageReference(resolver.DockerReference, resolver.DockerTag)
+	return !ref.foundIn(images), ref
 }
 
 func (e *TryExecutor) startResolverContainer(resolver ResolverRes, conRef DockerContainerReference) error {
-	imgRef := DockerImageReference{Reference: resolver.DockerReference, Tag: resolver.DockerTag}
-	return e.Docker.CreateContainer(conRef, imgRef)
-}
-
-func (e *TryExecutor) statusCheck(endpoint string, maxAttempts int) error {
-	for i := 0; i < maxAttempts; i++ {
-		fmt.Println("🏓 Ping endpoint:", endpoint, "Attempt:", i+1)
-		resp, err := httpClient.Get(endpoint)
-		if err != nil {
-			fmt.Println("🚨 Error:", err)
-			fmt.Println("⌛ Waiting for 1 second before next attempt...")
-			time.Sleep(1 * time.Second)
-			continue
-		}
-		if resp.StatusCode == http.StatusOK {
-			fmt.Println("✅ Health Check successful, Status code:", resp.StatusCode)
-			resp.Body.Close()
-			return nil
-		}
-		fmt.Println("🚨 Request failed! Status code:", resp.StatusCode)
-		resp.Body.Close()
-		fmt.Println("⌛ Waiting for 1 second before next attempt...")
-		time.Sleep(1 * time.Second)
+	imgRef := NewDockerImageReference(resolver.DockerReference, resolver.DockerTag)
+	limits, err := e.Docker.Settings().Limits(conRef.CyanType, resolver.Resources)
+	if err != nil {
+		return fmt.Errorf("%s: %w", resolver.ID, err)
 	}
-	return fmt.Errorf("health check failed for %s after %d attempts", endpoint, maxAttempts)
+	return e.Docker.CreateContainer(conRef, imgRef, limits)
 }
@@ -0,0 +1,156 @@
+package docker_executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// usageEntry is when a resource was last used, and by which session
+type usageEntry struct {
+	LastUsed time.Time `json:"last_used"`
+	// Session is the last session to use the resource; empty for templates warmed without one
+	Session string `json:"session,omitempty"`
+}
+
+// UsageStore records when each image and template volume was last used by a warm, start or try, so
+// garbage collection can evict the least recently used. Docker keeps no such time and labels can't be
+// changed after creation, so the store persists to a small JSON file and survives restarts.
+// A nil store is valid and records nothing.
+type UsageStore struct {
+	// Path is the JSON file usage is kept in; empty keeps it in memory only
+	Path string
+	// Now stamps uses; defaults to time.Now
+	Now    func() time.Time
+	Logger *slog.Logger
+
+	mutex   sync.Mutex
+	entries map[string]usageEntry
+}
+
+// LoadUsageStore reads the usage recorded at path, starting empty if the file doesn't exist yet
+func LoadUsageStore(path string) (*UsageStore, error) {
+	u := &UsageStore{Path: path, entries: make(map[string]usageEntry)}
+	if path == "" {
+		return u, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage state: %w", err)
+	}
+	if err := json.Unmarshal(data, &u.entries); err != nil {
+		return nil, fmt.Errorf("failed to parse usage state %s: %w", path, err)
+	}
+	if u.entries == nil {
+		u.entries = make(map[string]usageEntry)
+	}
+	return u, nil
+}
+
+func imageUsageKey(i DockerImageReference) string {
+	if i.Tag != "" {
+		return "image:" + i.name() + ":" + i.Tag
+	}
+	return "image:" + i.name() + "@" + i.Digest
+}
+
+func volumeUsageKey(v DockerVolumeReference) string {
+	return "volume:" + DockerVolumeToString(v)
+}
+
+func (u *UsageStore) log() *slog.Logger {
+	return loggerOrDefault(u.Logger)
+}
+
+func (u *UsageStore) now() time.Time {
+	if u.Now != nil {
+		return u.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// Touch records that session uses images and volumes now. Session volumes are left to the reaper, so
+// only template volumes are recorded.
+func (u *UsageStore) Touch(session string, images []DockerImageReference, volumes []DockerVolumeReference) {
+	if u == nil {
+		return
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	entry := usageEntry{LastUsed: u.now(), Session: session}
+	for _, i := range images {
+		if i.Reference != "" {
+			u.entries[imageUsageKey(i)] = entry
+		}
+	}
+	for _, v := range volumes {
+		if v.SessionId == "" {
+			u.entries[volumeUsageKey(v)] = entry
+		}
+	}
+	u.save()
+}
+
+// lastUse returns the latest use of any of keys
+func (u *UsageStore) lastUse(keys ...string) (usageEntry, bool) {
+	if u == nil {
+		return usageEntry{}, false
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	var last usageEntry
+	found := false
+	for _, key := range keys {
+		if e, ok := u.entries[key]; ok && (!found || e.LastUsed.After(last.LastUsed)) {
+			last, found = e, true
+		}
+	}
+	return last, found
+}
+
+// forget drops the usage of removed resources
+func (u *UsageStore) forget(keys ...string) {
+	if u == nil {
+		return
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for _, key := range keys {
+		delete(u.entries, key)
+	}
+	u.save()
+}
+
+// save writes the entries through a temporary file, so a crash never leaves a truncated state file.
+// Failures are logged: losing usage only makes collection fall back to creation times.
+// Caller must hold the lock.
+func (u *UsageStore) save() {
+	if u.Path == "" {
+		return
+	}
+	err := func() error {
+		data, err := json.Marshal(u.entries)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(u.Path), 0700); err != nil {
+			return err
+		}
+		tmp := u.Path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0600); err != nil {
+			return err
+		}
+		return os.Rename(tmp, u.Path)
+	}()
+	if err != nil {
+		u.log().Warn("Failed to save usage state", "path", u.Path, LogKeyError, err)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AtomiCloud/sulfone.boron/docker_executor"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type JobState string
+
+const (
+	JobPending   JobState = "pending"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// JobStage is the step of the build pipeline an asynchronous job is currently on
+type JobStage string
+
+const (
+	JobStageQueued    JobStage = "queued"
+	JobStageBuilding  JobStage = "building"
+	JobStageArchiving JobStage = "archiving"
+	JobStageDone      JobStage = "done"
+)
+
+// BuildJob is an asynchronous run of POST /executor/:sessionId
+type BuildJob struct {
+	Id          string    `json:"job_id"`
+	SessionId   string    `json:"session_id"`
+	State       JobState  `json:"state"`
+	Stage       JobStage  `json:"stage"`
+	Errors      []string  `json:"errors"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	StatusUrl   string    `json:"status_url"`
+	ArtifactUrl string    `json:"artifact_url"`
+}
+
+// JobStore keeps build jobs in memory and their artifacts on disk under Dir
+type JobStore struct {
+	Dir   string
+	mutex sync.RWMutex
+	jobs  map[string]*BuildJob
+}
+
+// NewJobStore returns an empty store. Jobs don't outlive the coordinator, so artifacts left in dir by an
+// earlier run belong to none and are deleted.
+func NewJobStore(dir string) *JobStore {
+	for _, pattern := range []string{"*.tar.gz", "*.tar.gz.partial"} {
+		stale, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, path := range stale {
+			if err := os.Remove(path); err != nil {
+				slog.Warn("Failed to delete artifact of an earlier run", "path", path, docker_executor.LogKeyError, err)
+			}
+		}
+	}
+	return &JobStore{
+		Dir:  dir,
+		jobs: make(map[string]*BuildJob),
+	}
+}
+
+func (s *JobStore) Create(sessionId string) BuildJob {
+	id := uuid.New().String()
+	now := time.Now().UTC()
+	job := &BuildJob{
+		Id:          id,
+		SessionId:   sessionId,
+		State:       JobPending,
+		Stage:       JobStageQueued,
+		Errors:      []string{},
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		StatusUrl:   "/executor/" + sessionId + "/jobs/" + id,
+		ArtifactUrl: "/executor/" + sessionId + "/jobs/" + id + "/artifact",
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.jobs[id] = job
+	return *job
+}
+
+// Get returns a copy of the job if it exists and belongs to the session
+func (s *JobStore) Get(sessionId, jobId string) (BuildJob, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	job, ok := s.jobs[jobId]
+	if !ok || job.SessionId != sessionId {
+		return BuildJob{}, false
+	}
+	c := *job
+	c.Errors = append([]string{}, job.Errors...)
+	return c, true
+}
+
+func (s *JobStore) update(jobId string, state JobState, stage JobStage, errs []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job, ok := s.jobs[jobId]
+	if !ok {
+		return
+	}
+	job.State = state
+	job.Stage = stage
+	if errs != nil {
+		job.Errors = errs
+	}
+	job.UpdatedAt = time.Now().UTC()
+}
+
+func (s *JobStore) ArtifactPath(jobId string) string {
+	return filepath.Join(s.Dir, jobId+".tar.gz")
+}
+
+// keepArtifact moves the downloaded artifact at tmp into place, unless the job was removed meanwhile, in
+// which case nothing would ever delete it, so it is deleted now
+func (s *JobStore) keepArtifact(jobId, tmp string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.jobs[jobId]; !ok {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("job %s was removed while archiving", jobId)
+	}
+	return os.Rename(tmp, s.ArtifactPath(jobId))
+}
+
+// RemoveSession forgets all jobs of a session and deletes their artifacts
+func (s *JobStore) RemoveSession(sessionId string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, job := range s.jobs {
+		if job.SessionId == sessionId {
+			_ = os.Remove(s.ArtifactPath(id))
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// findJob returns the job of the route's session and job id, responding 404 if there is none
+func findJob(ctx *gin.Context, jobs *JobStore) (BuildJob, bool) {
+	job, ok := jobs.Get(ctx.Param("sessionId"), ctx.Param("jobId"))
+	if !ok {
+		ctx.JSON(http.StatusNotFound, ProblemDetails{
+			Title:   "Job not found",
+			Status:  404,
+			Detail:  "No build job " + ctx.Param("jobId") + " for session " + ctx.Param("sessionId"),
+			Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/404",
+			TraceId: traceId(ctx),
+			Data:    nil,
+		})
+	}
+	return job, ok
+}
+
+// serveJobArtifact serves the output of a job that has succeeded, and 409 for one that hasn't yet
+func serveJobArtifact(ctx *gin.Context, jobs *JobStore) {
+	job, ok := findJob(ctx, jobs)
+	if !ok {
+		return
+	}
+	if job.State != JobSucceeded {
+		ctx.JSON(http.StatusConflict, ProblemDetails{
+			Title:   "Artifact not ready",
+			Status:  409,
+			Detail:  "Build job " + job.Id + " is " + string(job.State),
+			Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/409",
+			TraceId: traceId(ctx),
+			Data:    job.Errors,
+		})
+		return
+	}
+	ctx.Header("Content-Description", "File Transfer")
+	ctx.Header("Content-Disposition", "attachment; filename=cyan-output.tar.gz")
+	ctx.Header("Content-Transfer-Encoding", "binary")
+	ctx.File(jobs.ArtifactPath(job.Id))
+}
+
+// runBuildJob runs the merge pipeline for a job and stores the zipped output as the job's artifact
+func runBuildJob(jobs *JobStore, jobId string, merger docker_executor.Merger, req docker_executor.BuildReq, runtimes *runtimes) {
+	logger := slog.Default().With(docker_executor.LogKeySession, merger.SessionId, "job_id", jobId)
+	logger.Info("Starting build job")
+	jobs.update(jobId, JobRunning, JobStageBuilding, nil)
+
+	mergePath, errs := merger.Merge(req)
+	if len(errs) > 0 {
+		errs = append(errs, runtimes.limitErrors(merger.Context, merger.SessionId)...)
+		logger.Error("Build job failed", "errors", errs)
+		jobs.update(jobId, JobFailed, JobStageBuilding, append(stringifyErrors(errs), runtimes.failureLogs(merger.Context, errs)...))
+		return
+	}
+
+	jobs.update(jobId, JobRunning, JobStageArchiving, nil)
+	if err := saveArtifact(merger.Context, jobs, jobId, merger.SessionId, req.MergerId, mergePath); err != nil {
+		logger.Error("Build job failed to archive output", docker_executor.LogKeyError, err)
+		jobs.update(jobId, JobFailed, JobStageArchiving, []string{err.Error()})
+		return
+	}
+	logger.Info("Build job completed")
+	jobs.update(jobId, JobSucceeded, JobStageDone, nil)
+}
+
+func saveArtifact(ctx context.Context, jobs *JobStore, jobId, sessionId, mergerId, mergePath string) error {
+	resp, problem := requestZip(ctx, sessionId, mergerId, mergePath)
+	if problem != nil {
+		return fmt.Errorf("%s: %v", problem.Detail, problem.Data)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("merger failed to zip output: %d %s", resp.StatusCode, string(body))
+	}
+
+	if err := os.MkdirAll(jobs.Dir, 0700); err != nil {
+		return fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	// write to a temporary file first so a partially written artifact is never served
+	tmp := jobs.ArtifactPath(jobId) + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create artifact: %w", err)
+	}
+	_, err = io.Copy(f, resp.Body)
+	closeErr := f.Close()
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to download artifact from merger: %w", err)
+	}
+	if closeErr != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write artifact: %w", closeErr)
+	}
+	return jobs.keepArtifact(jobId, tmp)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AtomiCloud/sulfone.boron/docker_executor"
+	"github.com/gin-gonic/gin"
+)
+
+// routeContainers sends every request to a container, whatever its host name, to handler instead
+func routeContainers(t *testing.T, handler http.Handler) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	transport := http.DefaultTransport.(*http.Transport)
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	t.Cleanup(func() {
+		transport.DialContext = dial
+		transport.CloseIdleConnections()
+		srv.Close()
+	})
+}
+
+// TestJobStoreSessionScoping tests that a job is only found through the session it was created for
+func TestJobStoreSessionScoping(t *testing.T) {
+	jobs := NewJobStore(t.TempDir())
+	job := jobs.Create("s1")
+	if job.State != JobPending || job.Stage != JobStageQueued {
+		t.Errorf("Expected a new job to be pending and queued, got %s and %s", job.State, job.Stage)
+	}
+	if job.StatusUrl != "/executor/s1/jobs/"+job.Id || job.ArtifactUrl != job.StatusUrl+"/artifact" {
+		t.Errorf("Unexpected job URLs %s and %s", job.StatusUrl, job.ArtifactUrl)
+	}
+
+	if got, ok := jobs.Get("s1", job.Id); !ok || got.Id != job.Id {
+		t.Errorf("Expected to find job %s in its session, got %+v", job.Id, got)
+	}
+	if _, ok := jobs.Get("s2", job.Id); ok {
+		t.Error("Expected job not to be found through another session")
+	}
+	if _, ok := jobs.Get("s1", "missing"); ok {
+		t.Error("Expected an unknown job not to be found")
+	}
+}
+
+// TestRunBuildJob tests that a job runs through building and archiving, ending succeeded with its
+// artifact stored, or failed at the stage that failed
+func TestRunBuildJob(t *testing.T) {
+	tests := []struct {
+		name        string
+		mergeStatus int
+		zipStatus   int
+		wantState   JobState
+		wantStage   JobStage
+	}{
+		{name: "succeeded", mergeStatus: http.StatusOK, zipStatus: http.StatusOK, wantState: JobSucceeded, wantStage: JobStageDone},
+		{name: "merge failed", mergeStatus: http.StatusBadRequest, wantState: JobFailed, wantStage: JobStageBuilding},
+		{name: "zip failed", mergeStatus: http.StatusOK, zipStatus: http.StatusInternalServerError, wantState: JobFailed, wantStage: JobStageArchiving},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := NewJobStore(t.TempDir())
+			job := jobs.Create("s1")
+
+			var mutex sync.Mutex
+			seen := map[string]BuildJob{}
+			record := func(path string) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				seen[path], _ = jobs.Get("s1", job.Id)
+			}
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /merge/s1", func(w http.ResponseWriter, r *http.Request) {
+				record("merge")
+				var req docker_executor.MergeReq
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || os.MkdirAll(req.ToDir, 0o755) != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(tt.mergeStatus)
+				_ = json.NewEncoder(w).Encode(docker_executor.MergeRes{Status: "OK"})
+			})
+			mux.HandleFunc("POST /zip", func(w http.ResponseWriter, r *http.Request) {
+				record("zip")
+				w.WriteHeader(tt.zipStatus)
+				_, _ = w.Write([]byte("artifact"))
+			})
+			routeContainers(t, mux)
+
+			cfg := DefaultConfig()
+			cfg.Docker.Workspace.AreaDir = t.TempDir()
+			runtimes, err := newRuntimes(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create runtimes: %v", err)
+			}
+			merger := docker_executor.Merger{Context: context.Background(), SessionId: "s1", Config: cfg.Docker}
+			runBuildJob(jobs, job.Id, merger, docker_executor.BuildReq{MergerId: "merger-1"}, runtimes)
+
+			if j := seen["merge"]; j.State != JobRunning || j.Stage != JobStageBuilding {
+				t.Errorf("Expected the job to be running and building while merging, got %s and %s", j.State, j.Stage)
+			}
+			if j, zipped := seen["zip"]; zipped && (j.State != JobRunning || j.Stage != JobStageArchiving) {
+				t.Errorf("Expected the job to be running and archiving while zipping, got %s and %s", j.State, j.Stage)
+			}
+			got, _ := jobs.Get("s1", job.Id)
+			if got.State != tt.wantState || got.Stage != tt.wantStage {
+				t.Errorf("Expected the job to end %s at %s, got %s at %s", tt.wantState, tt.wantStage, got.State, got.Stage)
+			}
+			artifact, err := os.ReadFile(jobs.ArtifactPath(job.Id))
+			if tt.wantState == JobSucceeded {
+				if string(artifact) != "artifact" {
+					t.Errorf("Expected the zipped output as the artifact, got %q (%v)", artifact, err)
+				}
+			} else {
+				if len(got.Errors) == 0 {
+					t.Error("Expected the failed job to report errors")
+				}
+				if err == nil {
+					t.Error("Expected no artifact for a failed job")
+				}
+			}
+		})
+	}
+}
+
+// TestJobArtifact tests that an artifact is served once its job has succeeded, with 409 before that
+// and 404 through another session
+func TestJobArtifact(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jobs := NewJobStore(t.TempDir())
+	r := gin.New()
+	r.GET("/executor/:sessionId/jobs/:jobId/artifact", func(ctx *gin.Context) {
+		serveJobArtifact(ctx, jobs)
+	})
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+	job := jobs.Create("s1")
+
+	for _, state := range []JobState{JobPending, JobRunning, JobFailed} {
+		jobs.update(job.Id, state, JobStageBuilding, []string{"boom"})
+		w := get(job.ArtifactUrl)
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), string(state)) {
+			t.Errorf("Expected 409 for a %s job, got %d: %s", state, w.Code, w.Body.String())
+		}
+	}
+	if w := get(strings.Replace(job.ArtifactUrl, "/s1/", "/s2/", 1)); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 through another session, got %d", w.Code)
+	}
+
+	if err := os.WriteFile(jobs.ArtifactPath(job.Id), []byte("artifact"), 0o600); err != nil {
+		t.Fatalf("Failed to write artifact: %v", err)
+	}
+	jobs.update(job.Id, JobSucceeded, JobStageDone, nil)
+	if w := get(job.ArtifactUrl); w.Code != http.StatusOK || w.Body.String() != "artifact" {
+		t.Errorf("Expected the artifact, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestJobStoreRemoveSession tests that removing a session forgets its jobs and deletes their artifacts,
+// leaving other sessions' alone
+func TestJobStoreRemoveSession(t *testing.T) {
+	jobs := NewJobStore(t.TempDir())
+	removed := jobs.Create("s1")
+	kept := jobs.Create("s2")
+	for _, job := range []BuildJob{removed, kept} {
+		if err := os.WriteFile(jobs.ArtifactPath(job.Id), []byte("artifact"), 0o600); err != nil {
+			t.Fatalf("Failed to write artifact: %v", err)
+		}
+	}
+
+	jobs.RemoveSession("s1")
+	if _, ok := jobs.Get("s1", removed.Id); ok {
+		t.Error("Expected the removed session's job to be forgotten")
+	}
+	if _, err := os.Stat(jobs.ArtifactPath(removed.Id)); !os.IsNotExist(err) {
+		t.Errorf("Expected the removed session's artifact to be deleted, got %v", err)
+	}
+	if _, ok := jobs.Get("s2", kept.Id); !ok {
+		t.Error("Expected the other session's job to be kept")
+	}
+	if _, err := os.Stat(jobs.ArtifactPath(kept.Id)); err != nil {
+		t.Errorf("Expected the other session's artifact to be kept, got %v", err)
+	}
+}
+
+// TestRunBuildJobSessionRemoved tests that an artifact downloaded after its session was removed is deleted
+// rather than left behind with no job to own it
+func TestRunBuildJobSessionRemoved(t *testing.T) {
+	jobs := NewJobStore(t.TempDir())
+	job := jobs.Create("s1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /merge/s1", func(w http.ResponseWriter, r *http.Request) {
+		var req docker_executor.MergeReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || os.MkdirAll(req.ToDir, 0o755) != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(docker_executor.MergeRes{Status: "OK"})
+	})
+	mux.HandleFunc("POST /zip", func(w http.ResponseWriter, r *http.Request) {
+		// the session is deleted while its output is being archived
+		jobs.RemoveSession("s1")
+		_, _ = w.Write([]byte("artifact"))
+	})
+	routeContainers(t, mux)
+
+	cfg := DefaultConfig()
+	cfg.Docker.Workspace.AreaDir = t.TempDir()
+	runtimes, err := newRuntimes(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create runtimes: %v", err)
+	}
+	merger := docker_executor.Merger{Context: context.Background(), SessionId: "s1", Config: cfg.Docker}
+	runBuildJob(jobs, job.Id, merger, docker_executor.BuildReq{MergerId: "merger-1"}, runtimes)
+
+	if _, ok := jobs.Get("s1", job.Id); ok {
+		t.Error("Expected the removed job to stay removed")
+	}
+	if left, _ := os.ReadDir(jobs.Dir); len(left) > 0 {
+		t.Errorf("Expected no artifact left behind, got %v", left)
+	}
+}
+
+// TestNewJobStoreStaleArtifacts tests that artifacts and partial downloads of an earlier run are deleted,
+// and other files kept
+func TestNewJobStoreStaleArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"old.tar.gz", "old.tar.gz.partial", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	NewJobStore(dir)
+	left, _ := os.ReadDir(dir)
+	if len(left) != 1 || left[0].Name() != "notes.txt" {
+		t.Errorf("Expected only notes.txt left, got %v", left)
+	}
+}
@@ -2,14 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/AtomiCloud/sulfone.boron/docker_executor"
 	imageTypes "github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
 	"log"
+	"log/slog"
 	"os"
-	rt "runtime"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -39,37 +44,84 @@ func main() {
 				},
 			},
 			{
-				Name: "start",
-				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:    "registry",
-						Aliases: []string{"r"},
-						Value:   "https://api.zinc.sulfone.raichu.cluster.atomi.cloud",
-					},
-				},
+				Name:  "start",
+				Usage: "Run the coordinator",
+				Flags: configFlags(),
 				Action: func(context *cli.Context) error {
-					registry := context.String("registry")
-					server(registry)
-					return nil
+					cfg, err := loadConfig(context)
+					if err != nil {
+						return err
+					}
+					if err := cfg.Validate(); err != nil {
+						return fmt.Errorf("invalid configuration: %w", err)
+					}
+					logger, err := docker_executor.NewLogger(os.Stdout, cfg.Log.Level, cfg.Log.Format)
+					if err != nil {
+						return err
+					}
+					slog.SetDefault(logger)
+					shutdownTracing, err := setupTracing(context.Context, cfg.TraceExporter)
+					if err != nil {
+						return err
+					}
+					defer func() {
+						if err := shutdownTracing(context.Context); err != nil {
+							slog.Error("Failed to flush traces", docker_executor.LogKeyError, err)
+						}
+					}()
+					return server(cfg)
 				},
 			},
 			{
-				Name: "setup",
-				Action: func(c *cli.Context) error {
-					ctx := context.Background()
-					dCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+				Name:  "config",
+				Usage: "Inspect the coordinator configuration",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "print",
+						Usage: "Print the effective configuration after applying the config file, environment and flags",
+						Flags: configFlags(),
+						Action: func(c *cli.Context) error {
+							cfg, err := loadConfig(c)
+							if err != nil {
+								return err
+							}
+							enc := yaml.NewEncoder(os.Stdout)
+							enc.SetIndent(2)
+							if err := enc.Encode(cfg); err != nil {
+								return err
+							}
+							if err := enc.Close(); err != nil {
+								return err
+							}
+							if err := cfg.Validate(); err != nil {
+								return fmt.Errorf("invalid configuration: %w", err)
+							}
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "setup",
+				Usage: "Create the cyanprint Docker network or Kubernetes namespace",
+				Flags: configFlags(),
+				Action: func(cCtx *cli.Context) error {
+					cfg, err := loadConfig(cCtx)
 					if err != nil {
-						panic(err)
+						return err
 					}
-					defer func(dCli *client.Client) {
-						_ = dCli.Close()
-					}(dCli)
-					cpu := rt.NumCPU()
-					d := docker_executor.DockerClient{
-						Docker:           dCli,
-						Context:          ctx,
-						ParallelismLimit: cpu,
+					if err := cfg.Validate(); err != nil {
+						return fmt.Errorf("invalid configuration: %w", err)
 					}
+					runtimes, err := newRuntimes(cfg)
+					if err != nil {
+						return err
+					}
+					d, closeRuntime, err := runtimes.open(context.Background(), docker_executor.Emitter{}, nil)
+					if err != nil {
+						return err
+					}
+					defer closeRuntime()
 					err = d.EnforceNetwork()
 					if err != nil {
 						fmt.Println("🚨 Error enforcing network", err)
@@ -81,46 +133,188 @@ func main() {
 			},
 			{
 				Name:  "cleanup",
-				Usage: "Clean up all cyanprint docker resources",
+				Usage: "Clean up cyanprint resources of the configured runtime, all of them unless filtered",
+				Flags: append(configFlags(),
+					&cli.BoolFlag{
+						Name:  "gc",
+						Usage: "Only evict the least recently used images and template volumes until within --gc-budget",
+					},
+					&cli.StringSliceFlag{
+						Name:  "type",
+						Usage: "Only remove resources of these types: " + strings.Join(docker_executor.CleanupTypes, ", "),
+					},
+					&cli.StringFlag{
+						Name:  "template",
+						Usage: "Only remove the containers and volumes of this template and its sessions",
+					},
+					&cli.StringFlag{
+						Name:  "session",
+						Usage: "Only remove the containers and volumes of this session",
+					},
+					&cli.DurationFlag{
+						Name:  "older-than",
+						Usage: "Only remove resources created at least this long ago",
+					},
+					&cli.BoolFlag{
+						Name:  "stopped",
+						Usage: "Only remove stopped containers",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "List what would be removed without removing it",
+					},
+				),
 				Action: func(cCtx *cli.Context) error {
-					ctx := context.Background()
-					dCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+					cfg, err := loadConfig(cCtx)
 					if err != nil {
-						panic(err)
+						return err
 					}
-					defer func(dCli *client.Client) {
-						_ = dCli.Close()
-					}(dCli)
-					cpu := rt.NumCPU()
-					d := docker_executor.DockerClient{
-						Docker:           dCli,
-						Context:          ctx,
-						ParallelismLimit: cpu,
-					}
-					fmt.Println("🧹 Starting cleanup of cyanprint docker resources...")
-					containersRemoved, imagesRemoved, volumesRemoved, err := d.Cleanup()
+					if err := cfg.Validate(); err != nil {
+						return fmt.Errorf("invalid configuration: %w", err)
+					}
+					filter := docker_executor.CleanupFilter{
+						Types:       cCtx.StringSlice("type"),
+						TemplateId:  cCtx.String("template"),
+						SessionId:   cCtx.String("session"),
+						OlderThan:   cCtx.Duration("older-than"),
+						StoppedOnly: cCtx.Bool("stopped"),
+						DryRun:      cCtx.Bool("dry-run"),
+					}
+					if err := filter.Validate(); err != nil {
+						return err
+					}
+					runtimes, err := newRuntimes(cfg)
+					if err != nil {
+						return err
+					}
+					d, closeRuntime, err := runtimes.open(context.Background(), docker_executor.Emitter{}, nil)
+					if err != nil {
+						return err
+					}
+					defer closeRuntime()
+					if cCtx.Bool("gc") {
+						return collectGarbage(cfg, d)
+					}
+					verb := "removed"
+					if filter.DryRun {
+						verb = "to remove"
+						fmt.Println("🔍 Dry run: listing cyanprint resources cleanup would remove...")
+					} else {
+						fmt.Println("🧹 Starting cleanup of cyanprint resources...")
+					}
+					containersRemoved, imagesRemoved, volumesRemoved, sessionsCleaned, err := docker_executor.Cleanup(d, filter)
 					// Always print partial results
 					fmt.Println("📋 Cleanup results:")
-					fmt.Printf("   Containers removed: %d\n", len(containersRemoved))
+					fmt.Printf("   Containers %s: %d\n", verb, len(containersRemoved))
 					for _, c := range containersRemoved {
 						fmt.Printf("     - %s\n", c)
 					}
-					fmt.Printf("   Images removed: %d\n", len(imagesRemoved))
+					fmt.Printf("   Images %s: %d\n", verb, len(imagesRemoved))
 					for _, img := range imagesRemoved {
 						fmt.Printf("     - %s\n", img)
 					}
-					fmt.Printf("   Volumes removed: %d\n", len(volumesRemoved))
+					fmt.Printf("   Volumes %s: %d\n", verb, len(volumesRemoved))
 					for _, v := range volumesRemoved {
 						fmt.Printf("     - %s\n", v)
 					}
+					if len(sessionsCleaned) > 0 {
+						fmt.Printf("   Sessions cleaned: %d\n", len(sessionsCleaned))
+						for _, s := range sessionsCleaned {
+							fmt.Printf("     - %s\n", s)
+						}
+					}
 					if err != nil {
 						fmt.Println("🚨 Error during cleanup:", err)
 						return err
 					}
+					if filter.DryRun {
+						fmt.Println("✅ Dry run completed, nothing was removed")
+						return nil
+					}
 					fmt.Println("✅ Cleanup completed successfully")
 					return nil
 				},
 			},
+			{
+				Name:  "doctor",
+				Usage: "Report cyanprint resources in inconsistent states, exiting non-zero if any are found",
+				Flags: append(configFlags(), &cli.BoolFlag{
+					Name:  "fix",
+					Usage: "Repair the issues found",
+				}),
+				Action: func(cCtx *cli.Context) error {
+					cfg, err := loadConfig(cCtx)
+					if err != nil {
+						return err
+					}
+					if err := cfg.Validate(); err != nil {
+						return fmt.Errorf("invalid configuration: %w", err)
+					}
+					runtimes, err := newRuntimes(cfg)
+					if err != nil {
+						return err
+					}
+					d, closeRuntime, err := runtimes.open(context.Background(), docker_executor.Emitter{}, nil)
+					if err != nil {
+						return err
+					}
+					defer closeRuntime()
+					return runDoctorCommand(docker_executor.Doctor{Docker: d}, cCtx.Bool("fix"))
+				},
+			},
+			{
+				Name:  "bundle",
+				Usage: "Move a template and its images to hosts without registry access",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "export",
+						Usage: "Save a template's images and metadata into one archive",
+						Flags: append(configFlags(),
+							&cli.StringFlag{
+								Name:     "template",
+								Usage:    "Path to the template version JSON, as the registry returns it",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "output",
+								Aliases:  []string{"o"},
+								Usage:    "Path to write the bundle to",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:  "pull",
+								Usage: "Pull the images before saving them, rather than saving those present",
+							},
+						),
+						Action: func(cCtx *cli.Context) error {
+							return withRuntime(cCtx, func(d docker_executor.ContainerRuntime) error {
+								return runBundleExport(d, cCtx.String("template"), cCtx.String("output"), cCtx.Bool("pull"))
+							})
+						},
+					},
+					{
+						Name:  "import",
+						Usage: "Load a bundle's images so the template warms without pulling",
+						Flags: append(configFlags(),
+							&cli.StringFlag{
+								Name:     "input",
+								Aliases:  []string{"i"},
+								Usage:    "Path to the bundle",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "template-output",
+								Usage: "Path to write the bundle's template version JSON to, for warm and start requests",
+							},
+						),
+						Action: func(cCtx *cli.Context) error {
+							return withRuntime(cCtx, func(d docker_executor.ContainerRuntime) error {
+								return runBundleImport(d, cCtx.String("input"), cCtx.String("template-output"))
+							})
+						},
+					},
+				},
+			},
 		},
 	}
 
@@ -128,3 +322,180 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// runDoctorCommand prints the issues the doctor finds, repairing them with fix
+func runDoctorCommand(doctor docker_executor.Doctor, fix bool) error {
+	fmt.Println("🩺 Examining cyanprint resources...")
+	issues, err := doctor.Examine()
+	if err != nil {
+		fmt.Println("🚨 Error examining resources:", err)
+		return err
+	}
+	if len(issues) == 0 {
+		fmt.Println("✅ No issues found")
+		return nil
+	}
+	if fix {
+		issues = doctor.Repair(issues)
+	}
+	failed := 0
+	fmt.Printf("📋 Issues found: %d\n", len(issues))
+	for _, issue := range issues {
+		fmt.Printf("   - [%s] %s: %s\n", issue.Kind, issue.Resource, issue.Problem)
+		switch {
+		case !fix:
+			fmt.Printf("     fix: %s\n", issue.Fix)
+		case issue.Fixed:
+			fmt.Printf("     fixed: %s\n", issue.Fix)
+		default:
+			failed++
+			fmt.Printf("     failed to %s: %s\n", issue.Fix, issue.Error)
+		}
+	}
+	if !fix {
+		fmt.Println("💡 Run with --fix to repair them")
+		return fmt.Errorf("%d issues found", len(issues))
+	}
+	if failed > 0 {
+		fmt.Printf("🚨 %d of %d repairs failed\n", failed, len(issues))
+		return fmt.Errorf("%d repairs failed", failed)
+	}
+	fmt.Println("✅ All issues repaired")
+	return nil
+}
+
+// withRuntime loads the configuration and runs fn against the configured runtime
+func withRuntime(cCtx *cli.Context, fn func(d docker_executor.ContainerRuntime) error) error {
+	cfg, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	runtimes, err := newRuntimes(cfg)
+	if err != nil {
+		return err
+	}
+	d, closeRuntime, err := runtimes.open(context.Background(), docker_executor.Emitter{}, nil)
+	if err != nil {
+		return err
+	}
+	defer closeRuntime()
+	return fn(d)
+}
+
+// runBundleExport writes the bundle of the template version at templatePath to output
+func runBundleExport(d docker_executor.ContainerRuntime, templatePath, output string, pull bool) error {
+	raw, err := os.ReadFile(templatePath)
+	if err != nil {
+		return err
+	}
+	var template docker_executor.TemplateVersionRes
+	if err := json.Unmarshal(raw, &template); err != nil {
+		return fmt.Errorf("invalid template version %s: %w", templatePath, err)
+	}
+	if pull {
+		images, err := docker_executor.BundleImages(template)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("⬇️ Pulling %d images...\n", len(images))
+		if errs := d.PullImages(images); len(errs) > 0 {
+			fmt.Println("🚨 Error pulling images:", errors.Join(errs...))
+			return errors.Join(errs...)
+		}
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("📦 Exporting template %s to %s...\n", template.Principal.ID, output)
+	manifest, err := docker_executor.ExportBundle(d, template, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(output)
+		fmt.Println("🚨 Error exporting bundle:", err)
+		return err
+	}
+	fmt.Printf("📋 Images: %d\n", len(manifest.Images))
+	for _, image := range manifest.Images {
+		fmt.Printf("   - %s\n", image)
+	}
+	fmt.Println("✅ Bundle exported")
+	return nil
+}
+
+// runBundleImport loads the bundle at input, writing its template version to templateOutput if set
+func runBundleImport(d docker_executor.ContainerRuntime, input, templateOutput string) error {
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	fmt.Printf("📦 Importing %s...\n", input)
+	manifest, err := docker_executor.ImportBundle(d, f)
+	if err != nil {
+		fmt.Println("🚨 Error importing bundle:", err)
+		return err
+	}
+	fmt.Printf("📋 Template %s, exported %s by boron %s\n", manifest.Template.Principal.ID, manifest.CreatedAt.Format(time.RFC3339), manifest.BoronVersion)
+	fmt.Printf("   Images: %d\n", len(manifest.Images))
+	for _, image := range manifest.Images {
+		fmt.Printf("   - %s\n", image)
+	}
+	if templateOutput != "" {
+		raw, err := json.MarshalIndent(manifest.Template, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(templateOutput, raw, 0o644); err != nil {
+			return err
+		}
+		fmt.Println("📝 Template version written to", templateOutput)
+	}
+	fmt.Println("✅ Bundle imported")
+	return nil
+}
+
+// collectGarbage runs one garbage collection for `cleanup --gc`. Without the coordinator's session
+// registry, sessions count as live while they own containers or volumes.
+func collectGarbage(cfg Config, d docker_executor.ContainerRuntime) error {
+	if cfg.GC.Budget <= 0 {
+		return errors.New("cleanup --gc needs a budget, set with --gc-budget or gc.budget")
+	}
+	usage, err := docker_executor.LoadUsageStore(cfg.GC.StateFile)
+	if err != nil {
+		return err
+	}
+	gc := docker_executor.GarbageCollector{
+		Docker: d,
+		Usage:  usage,
+		Budget: cfg.GC.Budget,
+	}
+	fmt.Printf("🧹 Collecting garbage down to %s...\n", cfg.GC.Budget)
+	res, errs := gc.Collect()
+	fmt.Println("📋 Garbage collection results:")
+	fmt.Printf("   Disk usage: %s -> %s\n", res.Before, res.After)
+	fmt.Printf("   Images removed: %d\n", len(res.ImagesRemoved))
+	for _, img := range res.ImagesRemoved {
+		fmt.Printf("     - %s\n", img)
+	}
+	fmt.Printf("   Volumes removed: %d\n", len(res.VolumesRemoved))
+	for _, v := range res.VolumesRemoved {
+		fmt.Printf("     - %s\n", v)
+	}
+	if len(errs) > 0 {
+		fmt.Println("🚨 Errors during garbage collection:", errs)
+		return errors.Join(errs...)
+	}
+	if res.After > res.Budget {
+		fmt.Println("⚠️ Still over budget: everything left is in use or held by a live session")
+		return nil
+	}
+	fmt.Println("✅ Garbage collection completed successfully")
+	return nil
+}
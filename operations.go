@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// operations tracks long-running work (warming, starting, building, cleaning) on behalf of sessions.
+// Operations run on a server-scoped context instead of the request's, so a client disconnecting
+// does not abort a pull or build halfway; they are only cancelled when the server gives up on them
+// during shutdown.
+type operations struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mutex  sync.Mutex
+	active map[string]int
+}
+
+func newOperations() *operations {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &operations{
+		ctx:    ctx,
+		cancel: cancel,
+		active: make(map[string]int),
+	}
+}
+
+// begin registers an operation on the session. The returned context keeps the values (e.g. the trace)
+// of parent but not its cancellation; it is cancelled by abort. done must be called once the operation ends.
+func (o *operations) begin(parent context.Context, sessionId string) (context.Context, func()) {
+	o.wg.Add(1)
+	o.mutex.Lock()
+	o.active[sessionId]++
+	o.mutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	stop := context.AfterFunc(o.ctx, cancel)
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			stop()
+			cancel()
+			o.mutex.Lock()
+			o.active[sessionId]--
+			if o.active[sessionId] <= 0 {
+				delete(o.active, sessionId)
+			}
+			o.mutex.Unlock()
+			o.wg.Done()
+		})
+	}
+}
+
+// wait blocks until every operation has ended or ctx is done, and reports whether all operations ended
+func (o *operations) wait(ctx context.Context) bool {
+	drained := make(chan struct{})
+	go func() {
+		o.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// abort cancels all running operations and returns the sessions they were working on
+func (o *operations) abort() []string {
+	o.mutex.Lock()
+	sessions := make([]string, 0, len(o.active))
+	for s := range o.active {
+		sessions = append(sessions, s)
+	}
+	o.mutex.Unlock()
+	sort.Strings(sessions)
+	o.cancel()
+	return sessions
+}
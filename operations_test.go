@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestOperationsOutliveRequest tests that operations are not cancelled with the request that started them
+func TestOperationsOutliveRequest(t *testing.T) {
+	ops := newOperations()
+	req, cancelReq := context.WithCancel(context.Background())
+	ctx, done := ops.begin(req, "s1")
+	defer done()
+
+	cancelReq()
+	if ctx.Err() != nil {
+		t.Fatalf("Expected operation to survive request cancellation, got %v", ctx.Err())
+	}
+}
+
+// TestOperationsWaitDrains tests that wait returns once every operation has ended
+func TestOperationsWaitDrains(t *testing.T) {
+	ops := newOperations()
+	_, done1 := ops.begin(context.Background(), "s1")
+	_, done2 := ops.begin(context.Background(), "s2")
+
+	go func() {
+		done1()
+		done2()
+		// calling done twice must not release another operation
+		done2()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !ops.wait(ctx) {
+		t.Fatal("Expected operations to drain")
+	}
+	if got := ops.abort(); len(got) != 0 {
+		t.Errorf("Expected no interrupted sessions, got %v", got)
+	}
+}
+
+// TestOperationsAbort tests that abort cancels running operations and reports their sessions
+func TestOperationsAbort(t *testing.T) {
+	ops := newOperations()
+	ctx1, done1 := ops.begin(context.Background(), "s2")
+	defer done1()
+	ctx2, done2 := ops.begin(context.Background(), "s1")
+	defer done2()
+	_, done3 := ops.begin(context.Background(), "s3")
+	done3()
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if ops.wait(waitCtx) {
+		t.Fatal("Expected wait to time out with operations in flight")
+	}
+
+	got := ops.abort()
+	if len(got) != 2 || got[0] != "s1" || got[1] != "s2" {
+		t.Errorf("Expected interrupted sessions [s1 s2], got %v", got)
+	}
+	for _, ctx := range []context.Context{ctx1, ctx2} {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("Expected operation context to be cancelled")
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/AtomiCloud/sulfone.boron/docker_executor"
+	"github.com/docker/docker/client"
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	RuntimeDocker     = "docker"
+	RuntimeKubernetes = "kubernetes"
+)
+
+// runtimes opens the container runtime selected by the configuration. Docker gets a client per
+// operation as before; the Kubernetes clientset is safe for concurrent use, so one is shared.
+type runtimes struct {
+	cfg  Config
+	kube kubernetes.Interface
+}
+
+func newRuntimes(cfg Config) (*runtimes, error) {
+	// mergers guard their internal endpoints with the same networks as the coordinator
+	cfg.Docker.InternalNetworks = cfg.Auth.InternalNetworks
+	r := &runtimes{cfg: cfg}
+	if cfg.Runtime != RuntimeKubernetes {
+		return r, nil
+	}
+	var restCfg *rest.Config
+	var err error
+	if cfg.Kubernetes.Kubeconfig == "" {
+		restCfg, err = rest.InClusterConfig()
+	} else {
+		restCfg, err = clientcmd.BuildConfigFromFlags("", cfg.Kubernetes.Kubeconfig)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubernetes client configuration: %w", err)
+	}
+	r.kube, err = kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	return r, nil
+}
+
+// open returns a runtime whose operations run under ctx, and a function that releases it
+func (r *runtimes) open(ctx context.Context, events docker_executor.Emitter, logger *slog.Logger) (docker_executor.ContainerRuntime, func(), error) {
+	if r.cfg.Runtime == RuntimeKubernetes {
+		return &docker_executor.KubernetesRuntime{
+			Client:           r.kube,
+			Context:          ctx,
+			ParallelismLimit: r.cfg.Parallelism,
+			Events:           events,
+			Logger:           logger,
+			Config:           r.cfg.Docker,
+			Kubernetes:       r.cfg.Kubernetes,
+		}, func() {}, nil
+	}
+	dCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, nil, err
+	}
+	return &docker_executor.DockerClient{
+		Docker:           dCli,
+		Context:          ctx,
+		ParallelismLimit: r.cfg.Parallelism,
+		Events:           events,
+		Logger:           logger,
+		Config:           r.cfg.Docker,
+	}, func() {
+		_ = dCli.Close()
+	}, nil
+}
+
+// limitErrors explains a failed build with the session's containers that were killed for exceeding their limits
+func (r *runtimes) limitErrors(ctx context.Context, sessionId string) []error {
+	d, closeRuntime, err := r.open(ctx, docker_executor.Emitter{}, nil)
+	if err != nil {
+		return nil
+	}
+	defer closeRuntime()
+	return docker_executor.LimitErrors(d, sessionId)
+}
+
+// failureLogs returns the last log lines of the containers errs blame, to explain a failed build
+func (r *runtimes) failureLogs(ctx context.Context, errs []error) []string {
+	d, closeRuntime, err := r.open(ctx, docker_executor.Emitter{}, nil)
+	if err != nil {
+		return nil
+	}
+	defer closeRuntime()
+	return docker_executor.FailureLogs(d, errs)
+}
+
+// headerRegistryConfig carries short-lived pull credentials with warm and try requests, as base64-encoded
+// JSON mapping registry hosts to Docker AuthConfig objects
+const headerRegistryConfig = "X-Registry-Config"
+
+// registryCredentials reads the pull credentials sent with the request, responding 400 if they can't be read.
+// The header's content is never echoed back.
+func registryCredentials(ctx *gin.Context) (docker_executor.RegistryCredentials, bool) {
+	creds, err := docker_executor.ParseRegistryConfigHeader(ctx.GetHeader(headerRegistryConfig))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ProblemDetails{
+			Title:   "Invalid registry credentials",
+			Status:  400,
+			Detail:  "The " + headerRegistryConfig + " header could not be read",
+			Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
+			TraceId: traceId(ctx),
+			Data:    []string{err.Error()},
+		})
+		return nil, false
+	}
+	return creds, true
+}
+
+// runtimeUnavailable responds that the container runtime could not be reached
+func runtimeUnavailable(ctx *gin.Context, err error) {
+	ctx.JSON(http.StatusInternalServerError, ProblemDetails{
+		Title:   "Failed to open container runtime",
+		Status:  500,
+		Detail:  "Failed to connect to the container runtime",
+		Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/500",
+		TraceId: traceId(ctx),
+		Data:    []string{err.Error()},
+	})
+}
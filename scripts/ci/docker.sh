@@ -72,6 +72,7 @@ docker buildx build \
   "${CI_DOCKER_CONTEXT}" \
   -f "${CI_DOCKERFILE}" \
   --platform=${CI_DOCKER_PLATFORM} \
+  --build-arg "VERSION=${version:-${IMAGE_VERSION}}" \
   --push \
   -t "${COMMIT_IMAGE_REF}" $args \
   -t "${BRANCH_IMAGE_REF}"
@@ -4,19 +4,26 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	rt "runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/AtomiCloud/sulfone.boron/docker_executor"
-	"github.com/docker/docker/client"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func stringifyErrors(e []error) []string {
@@ -27,6 +34,58 @@ func stringifyErrors(e []error) []string {
 	return errs
 }
 
+// upstreamClient and proxyClient propagate trace context to the containers the coordinator calls
+var (
+	upstreamClient = docker_executor.NewHTTPClient(0)
+	proxyClient    = docker_executor.NewHTTPClient(30 * time.Second)
+)
+
+// postUpstream forwards a request body to a container as part of the trace of ctx
+func postUpstream(ctx context.Context, client *http.Client, endpoint, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return client.Do(req)
+}
+
+// sessionLogger returns the process logger scoped to a session and, if ctx is traced, to its trace
+func sessionLogger(ctx context.Context, sessionId string) *slog.Logger {
+	l := slog.Default().With(docker_executor.LogKeySession, sessionId)
+	if id := traceId(ctx); id != nil {
+		l = l.With(docker_executor.LogKeyTrace, *id)
+	}
+	return l
+}
+
+// requestLogger logs every request once it has been served
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"duration", time.Since(start),
+			"client_ip", c.ClientIP(),
+		}
+		if id := traceId(c.Request.Context()); id != nil {
+			attrs = append(attrs, docker_executor.LogKeyTrace, *id)
+		}
+		slog.Log(c.Request.Context(), level, "Request served", attrs...)
+	}
+}
+
 // validatePath ensures the given path is within the allow-listed DEV_ROOT directory.
 // It resolves symlinks to prevent bypass attempts through symbolic links.
 func validatePath(path string) (string, error) {
@@ -77,41 +136,501 @@ func validatePath(path string) (string, error) {
 	return realPath, nil
 }
 
-func server(registryEndpoint string) {
-	r := gin.Default()
+// workspacePath resolves path, which must lie within root, the merger's working area. It is checked both
+// as given and, where it exists, with symlinks resolved, so neither ".." nor a link can lead out of root.
+func workspacePath(root, path string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve working area: %w", err)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve path: %w", err)
+	}
+	if !withinDir(absRoot, absPath) {
+		return "", fmt.Errorf("path '%s' is outside the working area '%s'", path, root)
+	}
+	realRoot, rootErr := filepath.EvalSymlinks(absRoot)
+	realPath, pathErr := filepath.EvalSymlinks(absPath)
+	if rootErr == nil && pathErr == nil && !withinDir(realRoot, realPath) {
+		return "", fmt.Errorf("path '%s' is outside the working area '%s'", path, root)
+	}
+	return absPath, nil
+}
+
+// withinDir reports whether the absolute path is dir or below it
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// requestZip asks the session's merger container to archive mergePath, returning its streaming response.
+// The caller must close the response body.
+func requestZip(ctx context.Context, sessionId, mergerId, mergePath string) (*http.Response, *ProblemDetails) {
+	c := docker_executor.DockerContainerReference{
+		CyanId:    mergerId,
+		CyanType:  "merger",
+		SessionId: sessionId,
+	}
+	ep := docker_executor.ContainerHost(c)
+	endpoint := "http://" + ep + ":9000/zip"
+
+	zipR := docker_executor.ZipReq{
+		TargetDir: mergePath,
+	}
+	jsonValue, err := json.Marshal(zipR)
+	if err != nil {
+		return nil, &ProblemDetails{
+			Title:   "Error encoding JSON",
+			Status:  400,
+			Detail:  "Failed encode JSON zipping request",
+			Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
+			TraceId: traceId(ctx),
+			Data:    []string{err.Error()},
+		}
+	}
+	jsonBody := bytes.NewReader(jsonValue)
+
+	zipReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, jsonBody)
+	if err != nil {
+		return nil, &ProblemDetails{
+			Title:   "Failed to generate upstream request",
+			Status:  400,
+			Detail:  "http.NewRequest return error when generating request for upstream errors",
+			Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
+			TraceId: traceId(ctx),
+			Data:    []string{err.Error()},
+		}
+	}
+
+	zipReq.Header.Set("Content-Type", "application/json")
+	resp, err := upstreamClient.Do(zipReq)
+	if err != nil {
+		return nil, &ProblemDetails{
+			Title:   "Failed to contract upstream server",
+			Status:  503,
+			Detail:  "Error contacting upstream (merger) server for zipping",
+			Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/503",
+			TraceId: traceId(ctx),
+			Data:    []string{err.Error()},
+		}
+	}
+	return resp, nil
+}
+
+// cleanupFilter reads the filter of DELETE /cleanup from its query: type (repeated or comma separated),
+// template_id, session_id, older_than (a duration), stopped and dry_run
+func cleanupFilter(ctx *gin.Context) (docker_executor.CleanupFilter, error) {
+	filter := docker_executor.CleanupFilter{
+		TemplateId:  ctx.Query("template_id"),
+		SessionId:   ctx.Query("session_id"),
+		StoppedOnly: ctx.Query("stopped") == "true",
+		DryRun:      ctx.Query("dry_run") == "true",
+	}
+	for _, t := range ctx.QueryArray("type") {
+		for _, part := range strings.Split(t, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				filter.Types = append(filter.Types, part)
+			}
+		}
+	}
+	if age := ctx.Query("older_than"); age != "" {
+		d, err := time.ParseDuration(age)
+		if err != nil {
+			return filter, fmt.Errorf("invalid older_than '%s': %w", age, err)
+		}
+		filter.OlderThan = d
+	}
+	return filter, filter.Validate()
+}
+
+// runDoctor reports the issues Doctor finds, repairing them first if fix is set. Failed repairs are
+// reported with 207 alongside those that succeeded.
+func runDoctor(ctx *gin.Context, ops *operations, runtimes *runtimes, fix bool) {
+	opCtx, done := ops.begin(ctx.Request.Context(), "")
+	defer done()
+	d, closeRuntime, err := runtimes.open(opCtx, docker_executor.Emitter{}, nil)
+	if err != nil {
+		runtimeUnavailable(ctx, err)
+		return
+	}
+	defer closeRuntime()
+	doctor := docker_executor.Doctor{Docker: d}
+	issues, err := doctor.Examine()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ProblemDetails{
+			Title:   "Failed to examine resources",
+			Status:  500,
+			Detail:  "The runtime could not list cyanprint's resources",
+			Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/500",
+			TraceId: traceId(ctx),
+			Data:    []string{err.Error()},
+		})
+		return
+	}
+	if !fix {
+		ctx.JSON(http.StatusOK, gin.H{"issues": issues, "count": len(issues)})
+		return
+	}
+	issues = doctor.Repair(issues)
+	fixed := 0
+	for _, issue := range issues {
+		if issue.Fixed {
+			fixed++
+		}
+	}
+	status := http.StatusOK
+	if fixed < len(issues) {
+		status = http.StatusMultiStatus
+	}
+	ctx.JSON(status, gin.H{"issues": issues, "count": len(issues), "fixed": fixed})
+}
+
+// streamEvents serves the session's events as server-sent events: those published so far, then new ones
+// until the session is cleaned, the client leaves or the coordinator shuts down
+func streamEvents(ctx *gin.Context, shutdown context.Context, bus *docker_executor.EventBus) {
+	sessionId := ctx.Param("sessionId")
+	history, ch, unsubscribe := bus.Subscribe(sessionId)
+	defer unsubscribe()
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+	for _, e := range history {
+		ctx.SSEvent(string(e.Type), e)
+	}
+	ctx.Writer.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				// session was cleaned
+				return false
+			}
+			ctx.SSEvent(string(e.Type), e)
+			return true
+		case <-keepAlive.C:
+			_, _ = io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case <-ctx.Request.Context().Done():
+			return false
+		case <-shutdown.Done():
+			return false
+		}
+	})
+}
+
+// streamContainerLogs serves a container's logs as plain text. ?tail=N starts from its last N lines, and
+// ?follow=true keeps the response open for new output until the container stops, the client leaves or
+// the coordinator shuts down. types are the container types the route serves.
+func streamContainerLogs(ctx *gin.Context, shutdown context.Context, runtimes *runtimes, c docker_executor.DockerContainerReference, types []string) {
+	if !slices.Contains(types, c.CyanType) {
+		ctx.JSON(http.StatusBadRequest, ProblemDetails{
+			Title:   "Invalid container type",
+			Status:  400,
+			Detail:  "Container type must be one of " + strings.Join(types, ", "),
+			Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
+			TraceId: traceId(ctx),
+			Data:    []string{"unknown container type: " + c.CyanType},
+		})
+		return
+	}
+	opts := docker_executor.LogOptions{Follow: ctx.Query("follow") == "true"}
+	if tail := ctx.Query("tail"); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			ctx.JSON(http.StatusBadRequest, ProblemDetails{
+				Title:   "Invalid tail",
+				Status:  400,
+				Detail:  "tail must be a non-negative number of lines",
+				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
+				TraceId: traceId(ctx),
+				Data:    []string{"invalid tail: " + tail},
+			})
+			return
+		}
+		opts.Tail = n
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx.Request.Context())
+	defer cancel()
+	stop := context.AfterFunc(shutdown, cancel)
+	defer stop()
+	d, closeRuntime, err := runtimes.open(streamCtx, docker_executor.Emitter{}, nil)
+	if err != nil {
+		runtimeUnavailable(ctx, err)
+		return
+	}
+	defer closeRuntime()
+	name := docker_executor.DockerContainerToString(c)
+	if _, err := d.InspectContainer(c); err != nil {
+		ctx.JSON(http.StatusNotFound, ProblemDetails{
+			Title:   "Container not found",
+			Status:  404,
+			Detail:  "No container " + name + " is running or stopped on this coordinator",
+			Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/404",
+			TraceId: traceId(ctx),
+			Data:    []string{err.Error()},
+		})
+		return
+	}
+	logs, err := d.StreamLogs(c, opts)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ProblemDetails{
+			Title:   "Failed to read container logs",
+			Status:  500,
+			Detail:  "The runtime could not stream the logs of " + name,
+			Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/500",
+			TraceId: traceId(ctx),
+			Data:    []string{err.Error()},
+		})
+		return
+	}
+	defer func() {
+		_ = logs.Close()
+	}()
+
+	ctx.Header("Content-Type", "text/plain; charset=utf-8")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+	// flush as output arrives, so followers see lines as they are written
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := logs.Read(buf)
+		if n > 0 {
+			if _, werr := ctx.Writer.Write(buf[:n]); werr != nil {
+				return
+			}
+			ctx.Writer.Flush()
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && streamCtx.Err() == nil {
+				slog.Warn("Container log stream failed", docker_executor.LogKeyContainer, name, docker_executor.LogKeyError, err)
+			}
+			return
+		}
+	}
+}
+
+// errShutdown is recorded on sessions whose operations were cut short by a coordinator shutdown
+var errShutdown = errors.New("coordinator shut down while the session was in progress")
+
+// buildAuth sets up the authenticators enabled by the options
+func buildAuth(cfg Config) (Auth, error) {
+	var auth Auth
+	if cfg.Auth.TokenFile != "" {
+		tokens, err := LoadTokens(cfg.Auth.TokenFile)
+		if err != nil {
+			return Auth{}, err
+		}
+		auth.Authenticators = append(auth.Authenticators, tokens)
+	}
+	if cfg.TLS.ClientCA != "" {
+		if cfg.TLS.Cert == "" || cfg.TLS.Key == "" {
+			return Auth{}, errors.New("client certificate authentication requires --tls-cert and --tls-key")
+		}
+		auth.Authenticators = append(auth.Authenticators, CertAuthenticator{DefaultRole: RoleRead})
+	}
+	return auth, nil
+}
+
+// buildInternalGuard restricts merger-internal endpoints to the configured networks, or else to the
+// cyanprint network's subnets, creating the network first if it doesn't exist yet. It fails rather than
+// open the endpoints wider. Only where no runtime can be reached at all, as in a merger container without
+// the Docker socket, does it fall back to the networks of the container's own interfaces.
+func buildInternalGuard(cfg Config, d docker_executor.ContainerRuntime) (NetworkGuard, error) {
+	cidrs := cfg.Auth.InternalNetworks
+	if len(cidrs) == 0 {
+		err := d.EnforceNetwork()
+		if docker_executor.IsUnreachable(err) {
+			networks, err := localNetworks()
+			if err != nil {
+				return NetworkGuard{}, fmt.Errorf("failed to determine internal networks: %w", err)
+			}
+			slog.Warn("No container runtime reachable, restricting merger-internal endpoints to this host's own networks", "networks", networks)
+			return NetworkGuard{Networks: networks}, nil
+		}
+		if err != nil {
+			return NetworkGuard{}, fmt.Errorf("failed to create the cyanprint network to restrict merger-internal endpoints to, set --internal-network instead: %w", err)
+		}
+		cidrs, err = d.NetworkSubnets()
+		if err != nil {
+			return NetworkGuard{}, fmt.Errorf("failed to read the cyanprint network's subnets, set --internal-network instead: %w", err)
+		}
+		if len(cidrs) == 0 {
+			return NetworkGuard{}, errors.New("the cyanprint network has no subnets to restrict merger-internal endpoints to, set --internal-network instead")
+		}
+	}
+	networks, err := ParseCIDRs(cidrs)
+	if err != nil {
+		return NetworkGuard{}, err
+	}
+	return NetworkGuard{Networks: networks}, nil
+}
+
+// checkSessionNetworks fails when session networks are on but the coordinator doesn't run in a container
+// it could attach to them, as when started on the host with go run, rather than fail every session's start
+func checkSessionNetworks(cfg Config, d docker_executor.ContainerRuntime) error {
+	if !cfg.Docker.Isolation.SessionNetworks {
+		return nil
+	}
+	_, err := d.CoordinatorAttached()
+	if errors.Is(err, docker_executor.ErrNoCoordinatorContainer) {
+		return errors.New("session networks need the coordinator to run in a container that can join them: " +
+			"set --coordinator-container to the container it runs in, or turn them off with --session-networks=false")
+	}
+	if err != nil && !docker_executor.IsUnreachable(err) {
+		return fmt.Errorf("failed to look up the coordinator's container for session networks: %w", err)
+	}
+	return nil
+}
+
+func server(cfg Config) error {
+	auth, err := buildAuth(cfg)
+	if err != nil {
+		return err
+	}
+	if !auth.Enabled() {
+		slog.Warn("Authentication is disabled: anyone who can reach the coordinator has admin access")
+	}
+
+	shutdown, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	ops := newOperations()
+
+	sessions := docker_executor.NewSessionRegistry()
+	jobs := NewJobStore(filepath.Join(os.TempDir(), "boron-artifacts"))
+	bus := docker_executor.NewEventBus()
+	prometheus.MustRegister(sessions)
+
+	usage, err := docker_executor.LoadUsageStore(cfg.GC.StateFile)
+	if err != nil {
+		return err
+	}
+
+	runtimes, err := newRuntimes(cfg)
+	if err != nil {
+		return err
+	}
+	slog.Info("Using container runtime", "runtime", cfg.Runtime)
+	reaperRuntime, closeReaperRuntime, err := runtimes.open(ops.ctx, docker_executor.Emitter{}, nil)
+	if err != nil {
+		return err
+	}
+	defer closeReaperRuntime()
+	// releaseSession drops what is kept of a session outside the runtime once its resources are gone
+	releaseSession := func(sessionId string) {
+		jobs.RemoveSession(sessionId)
+		bus.Forget(sessionId)
+	}
+	reaper := docker_executor.Reaper{
+		Docker:   reaperRuntime,
+		Sessions: sessions,
+		TTL:      cfg.SessionTTL,
+		Interval: cfg.ReapInterval,
+		OnClean:  releaseSession,
+	}
+	go reaper.Run(shutdown)
+
+	gc := docker_executor.GarbageCollector{
+		Docker:   reaperRuntime,
+		Usage:    usage,
+		Sessions: sessions,
+		Budget:   cfg.GC.Budget,
+		Interval: cfg.GC.Interval,
+	}
+	go gc.Run(shutdown)
+
+	internal, err := buildInternalGuard(cfg, reaper.Docker)
+	if err != nil {
+		return err
+	}
+	slog.Info("Merger-internal endpoints restricted", "networks", internal.Networks)
+	if err := checkSessionNetworks(cfg, reaper.Docker); err != nil {
+		return err
+	}
+
+	r := gin.New()
+	// client addresses must come from the connection, never from forwarding headers
+	if err := r.SetTrustedProxies(nil); err != nil {
+		return err
+	}
+	// let handlers pass the gin context wherever a context.Context is needed, carrying the request's span
+	r.ContextWithFallback = true
+	r.Use(gin.Recovery(), tracingMiddleware(), requestLogger())
 	r.GET("/", func(c *gin.Context) {
 		c.JSON(200, docker_executor.StandardResponse{Status: "OK"})
 	})
 
-	r.DELETE("/cleanup", func(ctx *gin.Context) {
-		dCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	r.GET("/metrics", auth.Require(RoleRead), gin.WrapH(promhttp.Handler()))
+
+	r.GET("/executors", auth.Require(RoleRead), func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, sessions.List())
+	})
+
+	r.GET("/executor/:sessionId", auth.Require(RoleRead), func(ctx *gin.Context) {
+		sessionId := ctx.Param("sessionId")
+		session, ok := sessions.Get(sessionId)
+		if !ok {
+			ctx.JSON(http.StatusNotFound, ProblemDetails{
+				Title:   "Session not found",
+				Status:  404,
+				Detail:  "No session with id " + sessionId + " is known to this coordinator",
+				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/404",
+				TraceId: traceId(ctx),
+				Data:    nil,
+			})
+			return
+		}
+		ctx.JSON(http.StatusOK, session)
+	})
+
+	r.GET("/doctor", auth.Require(RoleRead), func(ctx *gin.Context) {
+		runDoctor(ctx, ops, runtimes, false)
+	})
+
+	r.POST("/doctor", auth.Require(RoleAdmin), func(ctx *gin.Context) {
+		runDoctor(ctx, ops, runtimes, true)
+	})
+
+	r.DELETE("/cleanup", auth.Require(RoleAdmin), func(ctx *gin.Context) {
+		filter, err := cleanupFilter(ctx)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, ProblemDetails{
-				Title:   "Failed to create docker client",
-				Status:  500,
-				Detail:  "Failed to create docker client for cleanup",
-				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/500",
-				TraceId: nil,
+			ctx.JSON(http.StatusBadRequest, ProblemDetails{
+				Title:   "Invalid cleanup filter",
+				Status:  400,
+				Detail:  err.Error(),
+				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
+				TraceId: traceId(ctx),
 				Data:    []string{err.Error()},
 			})
 			return
 		}
-		defer func(dCli *client.Client) {
-			_ = dCli.Close()
-		}(dCli)
-		cpu := rt.NumCPU()
-		d := docker_executor.DockerClient{
-			Docker:           dCli,
-			Context:          ctx,
-			ParallelismLimit: cpu,
+		opCtx, done := ops.begin(ctx.Request.Context(), "")
+		defer done()
+		d, closeRuntime, err := runtimes.open(opCtx, docker_executor.Emitter{}, nil)
+		if err != nil {
+			runtimeUnavailable(ctx, err)
+			return
+		}
+		defer closeRuntime()
+		containersRemoved, imagesRemoved, volumesRemoved, sessionsCleaned, err := docker_executor.Cleanup(d, filter)
+		for _, sessionId := range sessionsCleaned {
+			sessions.Clear(sessionId)
+			releaseSession(sessionId)
 		}
-		containersRemoved, imagesRemoved, volumesRemoved, err := d.Cleanup()
 		// Always return partial results even when there are errors
 		response := gin.H{
 			"status":             "OK",
+			"dry_run":            filter.DryRun,
 			"containers_removed": containersRemoved,
 			"images_removed":     imagesRemoved,
 			"volumes_removed":    volumesRemoved,
+			"sessions_cleaned":   sessionsCleaned,
 			"containers_count":   len(containersRemoved),
 			"images_count":       len(imagesRemoved),
 			"volumes_count":      len(volumesRemoved),
@@ -124,33 +643,61 @@ func server(registryEndpoint string) {
 		ctx.JSON(http.StatusOK, response)
 	})
 
-	r.DELETE("/executor/:sessionId", func(ctx *gin.Context) {
+	r.GET("/executor/:sessionId/events", auth.Require(RoleRead), func(ctx *gin.Context) {
+		streamEvents(ctx, shutdown, bus)
+	})
+
+	r.GET("/executor/:sessionId/logs/:cyanType/:cyanId", auth.Require(RoleRead), func(ctx *gin.Context) {
+		c := docker_executor.DockerContainerReference{
+			CyanId:    ctx.Param("cyanId"),
+			CyanType:  ctx.Param("cyanType"),
+			SessionId: ctx.Param("sessionId"),
+		}
+		streamContainerLogs(ctx, shutdown, runtimes, c, []string{"processor", "plugin", "merger"})
+	})
+
+	// templates and resolvers are shared between sessions, so their containers have no session
+	r.GET("/template/logs/:cyanType/:cyanId", auth.Require(RoleRead), func(ctx *gin.Context) {
+		c := docker_executor.DockerContainerReference{
+			CyanId:   ctx.Param("cyanId"),
+			CyanType: ctx.Param("cyanType"),
+		}
+		streamContainerLogs(ctx, shutdown, runtimes, c, []string{"template", docker_executor.CyanTypeResolver})
+	})
+
+	r.POST("/executor/:sessionId/heartbeat", auth.Require(RoleWrite), func(ctx *gin.Context) {
+		sessionId := ctx.Param("sessionId")
+		sessions.Touch(sessionId)
+		ctx.JSON(http.StatusOK, docker_executor.StandardResponse{Status: "OK"})
+	})
+
+	r.DELETE("/executor/:sessionId", auth.Require(RoleWrite), func(ctx *gin.Context) {
 		sessionId := ctx.Param("sessionId")
-		dCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		logger := sessionLogger(ctx, sessionId)
+		opCtx, done := ops.begin(ctx.Request.Context(), sessionId)
+		defer done()
+		d, closeRuntime, err := runtimes.open(opCtx, docker_executor.Emitter{}, logger)
 		if err != nil {
-			panic(err)
-		}
-		defer func(dCli *client.Client) {
-			_ = dCli.Close()
-		}(dCli)
-		cpu := rt.NumCPU()
-		d := docker_executor.DockerClient{
-			Docker:           dCli,
-			Context:          ctx,
-			ParallelismLimit: cpu,
+			runtimeUnavailable(ctx, err)
+			return
 		}
+		defer closeRuntime()
 		exec := docker_executor.Executor{
 			Docker:   d,
 			Template: docker_executor.TemplateVersionRes{},
+			Sessions: sessions,
+			Logger:   logger,
 		}
 		e := exec.Clean(sessionId)
+		jobs.RemoveSession(sessionId)
+		bus.Forget(sessionId)
 		if len(e) > 0 {
 			ctx.JSON(http.StatusBadRequest, ProblemDetails{
 				Title:   "Failed to clean",
 				Status:  400,
 				Detail:  "Failed to session " + sessionId,
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    e,
 			})
 			return
@@ -158,7 +705,7 @@ func server(registryEndpoint string) {
 		ctx.JSON(200, docker_executor.StandardResponse{Status: "OK"})
 	})
 
-	r.POST("/executor/try", func(ctx *gin.Context) {
+	r.POST("/executor/try", auth.Require(RoleAdmin), func(ctx *gin.Context) {
 		var req docker_executor.TryExecutorReq
 		if err := ctx.ShouldBindJSON(&req); err != nil {
 			ctx.JSON(http.StatusBadRequest, ProblemDetails{
@@ -166,7 +713,7 @@ func server(registryEndpoint string) {
 				Status:  400,
 				Detail:  "Request body does not match TryExecutorReq",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    []string{err.Error()},
 			})
 			return
@@ -184,7 +731,7 @@ func server(registryEndpoint string) {
 				Status:  400,
 				Detail:  fmt.Sprintf("source must be 'image' or 'path', got '%s'", source),
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    nil,
 			})
 			return
@@ -197,7 +744,7 @@ func server(registryEndpoint string) {
 				Status:  400,
 				Detail:  "image_ref is required when source is 'image'",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    nil,
 			})
 			return
@@ -211,7 +758,7 @@ func server(registryEndpoint string) {
 					Status:  400,
 					Detail:  "path is required when source is 'path'",
 					Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-					TraceId: nil,
+					TraceId: traceId(ctx),
 					Data:    nil,
 				})
 				return
@@ -225,7 +772,7 @@ func server(registryEndpoint string) {
 					Status:  400,
 					Detail:  err.Error(),
 					Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-					TraceId: nil,
+					TraceId: traceId(ctx),
 					Data:    []string{req.Path},
 				})
 				return
@@ -233,36 +780,29 @@ func server(registryEndpoint string) {
 			req.Path = validatedPath
 		}
 
-		dCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, ProblemDetails{
-				Title:   "Failed to create docker client",
-				Status:  500,
-				Detail:  "Failed to create docker client",
-				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/500",
-				TraceId: nil,
-				Data:    []string{err.Error()},
-			})
+		creds, ok := registryCredentials(ctx)
+		if !ok {
 			return
 		}
-		defer func(dCli *client.Client) {
-			_ = dCli.Close()
-		}(dCli)
-
-		cpu := rt.NumCPU()
-		d := docker_executor.DockerClient{
-			Docker:           dCli,
-			Context:          ctx,
-			ParallelismLimit: cpu,
+		events := docker_executor.Emitter{Bus: bus, SessionId: req.SessionId}
+		logger := sessionLogger(ctx, req.SessionId)
+		opCtx, done := ops.begin(ctx.Request.Context(), req.SessionId)
+		defer done()
+		opCtx = docker_executor.WithRegistryCredentials(opCtx, creds)
+		d, closeRuntime, err := runtimes.open(opCtx, events, logger)
+		if err != nil {
+			runtimeUnavailable(ctx, err)
+			return
 		}
+		defer closeRuntime()
 
 		if err := d.EnforceNetwork(); err != nil {
 			ctx.JSON(http.StatusServiceUnavailable, ProblemDetails{
 				Title:   "Failed to configure network",
 				Status:  503,
-				Detail:  "Failed to start cyanprint Docker bridge network",
+				Detail:  "Failed to set up the cyanprint network",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/503",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    []string{err.Error()},
 			})
 			return
@@ -271,6 +811,8 @@ func server(registryEndpoint string) {
 		exec := docker_executor.TryExecutor{
 			Docker:  d,
 			Request: req,
+			Usage:   usage,
+			Events:  events,
 		}
 
 		res, errs := exec.TrySetup()
@@ -280,7 +822,7 @@ func server(registryEndpoint string) {
 				Status:  500,
 				Detail:  "Try setup failed",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/500",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    stringifyErrors(errs),
 			})
 			return
@@ -289,9 +831,8 @@ func server(registryEndpoint string) {
 		ctx.JSON(http.StatusOK, res)
 	})
 
-	r.POST("/executor/:sessionId", func(ctx *gin.Context) {
+	r.POST("/executor/:sessionId", auth.Require(RoleWrite), func(ctx *gin.Context) {
 		sessionId := ctx.Param("sessionId")
-		cpu := rt.NumCPU()
 
 		// req
 		var req docker_executor.BuildReq
@@ -302,83 +843,56 @@ func server(registryEndpoint string) {
 				Status:  400,
 				Detail:  "Failed to session " + sessionId,
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    []string{err.Error()},
 			})
 			return
 		}
+		opCtx, done := ops.begin(ctx.Request.Context(), sessionId)
 		merger := docker_executor.Merger{
-			ParallelismLimit: cpu,
+			Context:          opCtx,
+			ParallelismLimit: cfg.Parallelism,
+			Config:           cfg.Docker,
 			RegistryClient: docker_executor.RegistryClient{
-				Endpoint: registryEndpoint,
+				Endpoint: cfg.Registry,
+				Context:  opCtx,
+				Logger:   sessionLogger(ctx, sessionId),
 			},
 			Template:  req.Template,
 			SessionId: sessionId,
+			Sessions:  sessions,
+			Events:    docker_executor.Emitter{Bus: bus, SessionId: sessionId},
+		}
+
+		// job mode: build in the background and let the client poll for the artifact
+		if ctx.Query("async") == "true" {
+			job := jobs.Create(sessionId)
+			go func() {
+				defer done()
+				runBuildJob(jobs, job.Id, merger, req, runtimes)
+			}()
+			ctx.JSON(http.StatusAccepted, job)
+			return
 		}
+		defer done()
+
 		mergePath, errs := merger.Merge(req)
 		if len(errs) > 0 {
+			errs = append(errs, runtimes.limitErrors(opCtx, sessionId)...)
 			ctx.JSON(http.StatusBadRequest, ProblemDetails{
 				Title:   "Failed to clean",
 				Status:  400,
 				Detail:  "Failed to session " + sessionId,
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
-				Data:    stringifyErrors(errs),
+				TraceId: traceId(ctx),
+				Data:    append(stringifyErrors(errs), runtimes.failureLogs(opCtx, errs)...),
 			})
 			return
 		}
 		// zip
-
-		c := docker_executor.DockerContainerReference{
-			CyanId:    req.MergerId,
-			CyanType:  "merger",
-			SessionId: sessionId,
-		}
-		ep := docker_executor.DockerContainerToString(c)
-		endpoint := "http://" + ep + ":9000/zip"
-
-		zipR := docker_executor.ZipReq{
-			TargetDir: mergePath,
-		}
-		jsonValue, err := json.Marshal(zipR)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, ProblemDetails{
-				Title:   "Error encoding JSON",
-				Status:  400,
-				Detail:  "Failed encode JSON zipping request",
-				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
-				Data:    []string{err.Error()},
-			})
-			return
-		}
-		jsonBody := bytes.NewReader(jsonValue)
-
-		zipReq, err := http.NewRequest("POST", endpoint, jsonBody)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, ProblemDetails{
-				Title:   "Failed to generate upstream request",
-				Status:  400,
-				Detail:  "http.NewRequest return error when generating request for upstream errors",
-				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
-				Data:    []string{err.Error()},
-			})
-			return
-		}
-
-		zipReq.Header.Set("Content-Type", "application/json")
-		cl := &http.Client{}
-		resp, err := cl.Do(zipReq)
-		if err != nil {
-			ctx.JSON(http.StatusServiceUnavailable, ProblemDetails{
-				Title:   "Failed to contract upstream server",
-				Status:  503,
-				Detail:  "Error contacting upstream (merger) server for zipping",
-				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/503",
-				TraceId: nil,
-				Data:    []string{err.Error()},
-			})
+		resp, problem := requestZip(ctx, sessionId, req.MergerId, mergePath)
+		if problem != nil {
+			ctx.JSON(problem.Status, problem)
 			return
 		}
 		defer func(Body io.ReadCloser) {
@@ -397,14 +911,24 @@ func server(registryEndpoint string) {
 				Status:  400,
 				Detail:  "Error copying upstream stream zip response as response of coordinator",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    []string{err.Error()},
 			})
 			return
 		}
 	})
 
-	r.POST("/executor", func(ctx *gin.Context) {
+	r.GET("/executor/:sessionId/jobs/:jobId", auth.Require(RoleRead), func(ctx *gin.Context) {
+		if job, ok := findJob(ctx, jobs); ok {
+			ctx.JSON(http.StatusOK, job)
+		}
+	})
+
+	r.GET("/executor/:sessionId/jobs/:jobId/artifact", auth.Require(RoleRead), func(ctx *gin.Context) {
+		serveJobArtifact(ctx, jobs)
+	})
+
+	r.POST("/executor", auth.Require(RoleWrite), func(ctx *gin.Context) {
 		var req StartExecutorReq
 		err := ctx.BindJSON(&req)
 		if err != nil {
@@ -413,36 +937,37 @@ func server(registryEndpoint string) {
 				Status:  400,
 				Detail:  "Request Body JSON does not match StartExecutorReq",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    []string{err.Error()},
 			})
 			return
 		}
-		dCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		events := docker_executor.Emitter{Bus: bus, SessionId: req.SessionId}
+		logger := sessionLogger(ctx, req.SessionId)
+		opCtx, done := ops.begin(ctx.Request.Context(), req.SessionId)
+		defer done()
+		d, closeRuntime, err := runtimes.open(opCtx, events, logger)
 		if err != nil {
-			panic(err)
-		}
-		defer func(dCli *client.Client) {
-			_ = dCli.Close()
-		}(dCli)
-		cpu := rt.NumCPU()
-		d := docker_executor.DockerClient{
-			Docker:           dCli,
-			Context:          ctx,
-			ParallelismLimit: cpu,
+			runtimeUnavailable(ctx, err)
+			return
 		}
+		defer closeRuntime()
 		exec := docker_executor.Executor{
 			Docker:   d,
 			Template: req.Template,
+			Sessions: sessions,
+			Usage:    usage,
+			Events:   events,
+			Logger:   logger,
 		}
 		err = d.EnforceNetwork()
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, ProblemDetails{
 				Title:   "Failed to configure network",
 				Status:  503,
-				Detail:  "Failed to start cyanprint Docker bridge network",
+				Detail:  "Failed to set up the cyanprint network",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/503",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    []string{err.Error()},
 			})
 			return
@@ -459,8 +984,8 @@ func server(registryEndpoint string) {
 				Status:  503,
 				Detail:  "Failed to start cyanprint executor",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/503",
-				TraceId: nil,
-				Data:    stringifyErrors(errs),
+				TraceId: traceId(ctx),
+				Data:    append(stringifyErrors(errs), docker_executor.FailureLogs(d, errs)...),
 			})
 		} else {
 			ctx.JSON(http.StatusOK, docker_executor.StandardResponse{
@@ -469,7 +994,7 @@ func server(registryEndpoint string) {
 		}
 	})
 
-	r.POST("/executor/:sessionId/warm", func(ctx *gin.Context) {
+	r.POST("/executor/:sessionId/warm", auth.Require(RoleWrite), func(ctx *gin.Context) {
 
 		sessionId := ctx.Param("sessionId")
 		var template docker_executor.TemplateVersionRes
@@ -480,36 +1005,42 @@ func server(registryEndpoint string) {
 				Status:  400,
 				Detail:  "Request Body JSON does not match TemplateVersionRes",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    []string{err.Error()},
 			})
 			return
 		}
-		dCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			panic(err)
+		creds, ok := registryCredentials(ctx)
+		if !ok {
+			return
 		}
-		defer func(dCli *client.Client) {
-			_ = dCli.Close()
-		}(dCli)
-		cpu := rt.NumCPU()
-		d := docker_executor.DockerClient{
-			Docker:           dCli,
-			Context:          ctx,
-			ParallelismLimit: cpu,
+		events := docker_executor.Emitter{Bus: bus, SessionId: sessionId}
+		logger := sessionLogger(ctx, sessionId)
+		opCtx, done := ops.begin(ctx.Request.Context(), sessionId)
+		defer done()
+		opCtx = docker_executor.WithRegistryCredentials(opCtx, creds)
+		d, closeRuntime, err := runtimes.open(opCtx, events, logger)
+		if err != nil {
+			runtimeUnavailable(ctx, err)
+			return
 		}
+		defer closeRuntime()
 		exec := docker_executor.Executor{
 			Docker:   d,
 			Template: template,
+			Sessions: sessions,
+			Usage:    usage,
+			Events:   events,
+			Logger:   logger,
 		}
 		err = d.EnforceNetwork()
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, ProblemDetails{
 				Title:   "Failed to configure network",
 				Status:  503,
-				Detail:  "Failed to start cyanprint Docker bridge network",
+				Detail:  "Failed to set up the cyanprint network",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/503",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    []string{err.Error()},
 			})
 			return
@@ -521,9 +1052,10 @@ func server(registryEndpoint string) {
 				Status:  400,
 				Detail:  "Failed to warn executor image, templates, and volumes",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
-				Data:    stringifyErrors(errs),
+				TraceId: traceId(ctx),
+				Data:    append(stringifyErrors(errs), docker_executor.FailureLogs(d, errs)...),
 			})
+			return
 		}
 
 		ctx.JSON(http.StatusOK, gin.H{
@@ -533,7 +1065,7 @@ func server(registryEndpoint string) {
 
 	})
 
-	r.POST("/template/warm", func(ctx *gin.Context) {
+	r.POST("/template/warm", auth.Require(RoleWrite), func(ctx *gin.Context) {
 		var template docker_executor.TemplateVersionRes
 		err := ctx.BindJSON(&template)
 		if err != nil {
@@ -542,37 +1074,42 @@ func server(registryEndpoint string) {
 				Status:  400,
 				Detail:  "Request Body JSON does not match TemplateVersionRes",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    []string{err.Error()},
 			})
 			return
 		}
-		dCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			panic(err)
+		creds, ok := registryCredentials(ctx)
+		if !ok {
+			return
 		}
-		defer func(dCli *client.Client) {
-			_ = dCli.Close()
-		}(dCli)
-		cpu := rt.NumCPU()
-		d := docker_executor.DockerClient{
-			Docker:           dCli,
-			Context:          ctx,
-			ParallelismLimit: cpu,
+		events := docker_executor.Emitter{Bus: bus, SessionId: ctx.Query("session_id")}
+		logger := sessionLogger(ctx, ctx.Query("session_id"))
+		opCtx, done := ops.begin(ctx.Request.Context(), ctx.Query("session_id"))
+		defer done()
+		opCtx = docker_executor.WithRegistryCredentials(opCtx, creds)
+		d, closeRuntime, err := runtimes.open(opCtx, events, logger)
+		if err != nil {
+			runtimeUnavailable(ctx, err)
+			return
 		}
+		defer closeRuntime()
 		exec := docker_executor.TemplateExecutor{
 			Docker:    d,
 			Template:  template.Principal,
 			Resolvers: template.Resolvers,
+			Usage:     usage,
+			Events:    events,
+			Logger:    logger,
 		}
 		err = d.EnforceNetwork()
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, ProblemDetails{
 				Title:   "Failed to configure network",
 				Status:  503,
-				Detail:  "Failed to start cyanprint Docker bridge network",
+				Detail:  "Failed to set up the cyanprint network",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/503",
-				TraceId: nil,
+				TraceId: traceId(ctx),
 				Data:    []string{err.Error()},
 			})
 			return
@@ -584,28 +1121,28 @@ func server(registryEndpoint string) {
 				Status:  400,
 				Detail:  "Failed to warn template image, templates, and volumes",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
-				Data:    stringifyErrors(errs),
+				TraceId: traceId(ctx),
+				Data:    append(stringifyErrors(errs), docker_executor.FailureLogs(d, errs)...),
 			})
+			return
 		}
 		ctx.JSON(http.StatusOK, docker_executor.StandardResponse{Status: "OK"})
 
 	})
 
 	// proxy
-	r.POST("/proxy/template/:cyanId/api/template/init", func(c *gin.Context) {
+	r.POST("/proxy/template/:cyanId/api/template/init", auth.Require(RoleWrite), func(c *gin.Context) {
 
 		cyanId := c.Param("cyanId")
 
-		fmt.Println("📇 Cyan ID:", cyanId)
+		logger := slog.Default().With(docker_executor.LogKeyCyanId, cyanId, docker_executor.LogKeyCyanType, "template")
 		d := docker_executor.DockerContainerReference{
 			CyanId:    cyanId,
 			CyanType:  "template",
 			SessionId: "",
 		}
-		endpoint := "http://" + docker_executor.DockerContainerToString(d) + ":5550/api/template/init"
-		fmt.Println("🌐 Upstream Endpoint:", endpoint)
-		fmt.Println("🆕 Start forwarding request...")
+		endpoint := "http://" + docker_executor.ContainerHost(d) + ":5550/api/template/init"
+		logger.Debug("Forwarding request to upstream", "endpoint", endpoint)
 
 		reqBody, err := io.ReadAll(c.Request.Body)
 		if err != nil {
@@ -615,15 +1152,15 @@ func server(registryEndpoint string) {
 				Status:  http.StatusBadRequest,
 				Detail:  "Failed read the initial request body",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(c),
 				Data:    []string{err.Error()},
 			})
 			return
 		}
 
-		fmt.Println("📦 Request Body:", string(reqBody))
+		logger.Debug("Read request body", "bytes", len(reqBody))
 
-		resp, err := http.Post(endpoint, c.GetHeader("Content-Type"), bytes.NewBuffer(reqBody))
+		resp, err := postUpstream(c.Request.Context(), upstreamClient, endpoint, c.GetHeader("Content-Type"), reqBody)
 		if err != nil {
 			// Handle error
 			c.JSON(http.StatusBadGateway, ProblemDetails{
@@ -631,7 +1168,7 @@ func server(registryEndpoint string) {
 				Status:  502,
 				Detail:  "Failed to forward request to upstream template",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/502",
-				TraceId: nil,
+				TraceId: traceId(c),
 				Data:    []string{err.Error()},
 			})
 			return
@@ -639,7 +1176,7 @@ func server(registryEndpoint string) {
 		defer func(Body io.ReadCloser) {
 			_ = Body.Close()
 		}(resp.Body)
-		fmt.Println("✅ Request forwarded successfully")
+		logger.Debug("Request forwarded successfully")
 		// Read the response from the new endpoint
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
@@ -650,28 +1187,27 @@ func server(registryEndpoint string) {
 				Status:  502,
 				Detail:  "Failed to read respond from upstream template",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/502",
-				TraceId: nil,
+				TraceId: traceId(c),
 				Data:    []string{err.Error()},
 			})
 			return
 		}
-		fmt.Println("Status Code from upstream:", resp.StatusCode)
+		logger.Debug("Upstream responded", "status", resp.StatusCode)
 		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
 
 	})
-	r.POST("/proxy/template/:cyanId/api/template/validate", func(c *gin.Context) {
+	r.POST("/proxy/template/:cyanId/api/template/validate", auth.Require(RoleWrite), func(c *gin.Context) {
 
 		cyanId := c.Param("cyanId")
-		fmt.Println("📇 Cyan ID:", cyanId)
+		logger := slog.Default().With(docker_executor.LogKeyCyanId, cyanId, docker_executor.LogKeyCyanType, "template")
 
 		d := docker_executor.DockerContainerReference{
 			CyanId:    cyanId,
 			CyanType:  "template",
 			SessionId: "",
 		}
-		endpoint := "http://" + docker_executor.DockerContainerToString(d) + ":5550/api/template/validate"
-		fmt.Println("🌐 Upstream Endpoint:", endpoint)
-		fmt.Println("🆕 Start forwarding request...")
+		endpoint := "http://" + docker_executor.ContainerHost(d) + ":5550/api/template/validate"
+		logger.Debug("Forwarding request to upstream", "endpoint", endpoint)
 
 		reqBody, err := io.ReadAll(c.Request.Body)
 		if err != nil {
@@ -681,17 +1217,16 @@ func server(registryEndpoint string) {
 				Status:  http.StatusBadRequest,
 				Detail:  "Failed read the initial request body",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(c),
 				Data:    []string{err.Error()},
 			})
 			return
 		}
 
-		fmt.Println("📦 Request Body Size:", len(reqBody), "bytes")
+		logger.Debug("Read request body", "bytes", len(reqBody))
 
 		// Forward the request body directly without reading it first
-		client := &http.Client{Timeout: 30 * time.Second}
-		resp, err := client.Post(endpoint, c.GetHeader("Content-Type"), bytes.NewBuffer(reqBody))
+		resp, err := postUpstream(c.Request.Context(), proxyClient, endpoint, c.GetHeader("Content-Type"), reqBody)
 		if err != nil {
 			// Handle error
 			c.JSON(http.StatusBadGateway, ProblemDetails{
@@ -699,7 +1234,7 @@ func server(registryEndpoint string) {
 				Status:  502,
 				Detail:  "Failed to forward request to upstream template",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/502",
-				TraceId: nil,
+				TraceId: traceId(c),
 				Data:    []string{err.Error()},
 			})
 			return
@@ -717,7 +1252,7 @@ func server(registryEndpoint string) {
 				Status:  502,
 				Detail:  "Failed to read respond from upstream template",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/502",
-				TraceId: nil,
+				TraceId: traceId(c),
 				Data:    []string{err.Error()},
 			})
 			return
@@ -725,19 +1260,18 @@ func server(registryEndpoint string) {
 		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
 	})
 
-	r.POST("/proxy/resolver/:cyanId/api/resolve", func(c *gin.Context) {
+	r.POST("/proxy/resolver/:cyanId/api/resolve", auth.Require(RoleWrite), func(c *gin.Context) {
 
 		cyanId := c.Param("cyanId")
-		fmt.Println("📇 Resolver Cyan ID:", cyanId)
+		logger := slog.Default().With(docker_executor.LogKeyCyanId, cyanId, docker_executor.LogKeyCyanType, docker_executor.CyanTypeResolver)
 
 		d := docker_executor.DockerContainerReference{
 			CyanId:    cyanId,
 			CyanType:  docker_executor.CyanTypeResolver,
 			SessionId: "",
 		}
-		endpoint := fmt.Sprintf("http://%s:%d/api/resolve", docker_executor.DockerContainerToString(d), docker_executor.ResolverPort)
-		fmt.Println("🌐 Upstream Endpoint:", endpoint)
-		fmt.Println("🆕 Start forwarding request...")
+		endpoint := fmt.Sprintf("http://%s:%d/api/resolve", docker_executor.ContainerHost(d), docker_executor.ResolverPort)
+		logger.Debug("Forwarding request to upstream", "endpoint", endpoint)
 
 		reqBody, err := io.ReadAll(c.Request.Body)
 		if err != nil {
@@ -746,23 +1280,22 @@ func server(registryEndpoint string) {
 				Status:  http.StatusBadRequest,
 				Detail:  "Failed read the initial request body",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/400",
-				TraceId: nil,
+				TraceId: traceId(c),
 				Data:    []string{err.Error()},
 			})
 			return
 		}
 
-		fmt.Println("📦 Request Body Size:", len(reqBody), "bytes")
+		logger.Debug("Read request body", "bytes", len(reqBody))
 
-		client := &http.Client{Timeout: 30 * time.Second}
-		resp, err := client.Post(endpoint, c.GetHeader("Content-Type"), bytes.NewBuffer(reqBody))
+		resp, err := postUpstream(c.Request.Context(), proxyClient, endpoint, c.GetHeader("Content-Type"), reqBody)
 		if err != nil {
 			c.JSON(http.StatusBadGateway, ProblemDetails{
 				Title:   "Upstream failed",
 				Status:  502,
 				Detail:  "Failed to forward request to upstream resolver",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/502",
-				TraceId: nil,
+				TraceId: traceId(c),
 				Data:    []string{err.Error()},
 			})
 			return
@@ -778,7 +1311,7 @@ func server(registryEndpoint string) {
 				Status:  502,
 				Detail:  "Failed to read respond from upstream resolver",
 				Type:    "https://developer.mozilla.org/en-US/docs/Web/HTTP/Status/502",
-				TraceId: nil,
+				TraceId: traceId(c),
 				Data:    []string{err.Error()},
 			})
 			return
@@ -787,116 +1320,222 @@ func server(registryEndpoint string) {
 	})
 
 	// for merger
-	r.POST("/merge/:sessionId", func(c *gin.Context) {
-		sessionId := c.Param("sessionId")
+	r.POST("/merge/:sessionId", internal.Require(), func(c *gin.Context) {
+		serveMerge(c, cfg)
+	})
 
-		cpu := rt.NumCPU()
+	r.POST("/zip", internal.Require(), func(c *gin.Context) {
+		serveZip(c, cfg.Docker.Workspace.AreaDir)
+	})
 
-		var req docker_executor.MergeReq
-		err := c.BindJSON(&req)
+	srv := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: r,
+	}
+	if cfg.TLS.Cert != "" || cfg.TLS.Key != "" {
+		tlsConfig, err := serverTLSConfig(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.ClientCA)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{err.Error()}})
+			return err
+		}
+		srv.TLSConfig = tlsConfig
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			serveErr <- srv.ListenAndServeTLS("", "")
 			return
 		}
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		ops.abort()
+		return err
+	case <-shutdown.Done():
+	}
 
-		m := docker_executor.Merger{
-			ParallelismLimit: cpu,
-			RegistryClient: docker_executor.RegistryClient{
-				Endpoint: registryEndpoint,
-			},
-			Template:  req.Template,
-			SessionId: sessionId,
+	slog.Info("Shutting down, draining in-flight operations", "timeout", cfg.ShutdownTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelDrain()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Warn("Requests still in flight at shutdown deadline", docker_executor.LogKeyError, err)
+	}
+	if ops.wait(drainCtx) {
+		ops.abort()
+		slog.Info("All operations drained")
+		return nil
+	}
+
+	// the deadline passed: cancel what is left, then clean up the sessions it left half-done
+	interrupted := ops.abort()
+	slog.Warn("Cancelled in-flight operations", "sessions", interrupted)
+	cleanCtx, cancelClean := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelClean()
+	ops.wait(cleanCtx)
+	cleanInterrupted(cleanCtx, runtimes, sessions, interrupted)
+	return nil
+}
+
+// cleanInterrupted marks sessions whose operations were cancelled by shutdown as failed and removes
+// their containers and volumes, since a half-built session can't be resumed by another coordinator
+func cleanInterrupted(ctx context.Context, runtimes *runtimes, sessions *docker_executor.SessionRegistry, interrupted []string) {
+	for _, sessionId := range interrupted {
+		if sessionId == "" {
+			continue
 		}
-		err = m.MergeFiles(req.FromDirs, req.ProcessorIDs, req.ToDir)
+		logger := sessionLogger(ctx, sessionId)
+		sessions.Fail(sessionId, []error{errShutdown})
+		d, closeRuntime, err := runtimes.open(ctx, docker_executor.Emitter{}, logger)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": []string{err.Error()}})
-			return
+			logger.Error("Failed to open container runtime to clean interrupted session", docker_executor.LogKeyError, err)
+			continue
 		}
-		c.JSON(http.StatusOK, docker_executor.StandardResponse{Status: "OK"})
-	})
+		exec := docker_executor.Executor{
+			Docker:   d,
+			Sessions: sessions,
+			Logger:   logger,
+		}
+		errs := exec.Clean(sessionId)
+		closeRuntime()
+		if len(errs) > 0 {
+			logger.Error("Failed to clean interrupted session", "errors", errs)
+			continue
+		}
+		logger.Info("Cleaned interrupted session")
+	}
+}
 
-	r.POST("/zip", func(c *gin.Context) {
-		var req docker_executor.ZipReq
-		err := c.BindJSON(&req)
-		if err != nil {
+// serveMerge merges the processor outputs a merger is asked to, refusing directories outside its working area
+func serveMerge(c *gin.Context, cfg Config) {
+	sessionId := c.Param("sessionId")
+
+	var req docker_executor.MergeReq
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []string{err.Error()}})
+		return
+	}
+	area := cfg.Docker.Workspace.AreaDir
+	for i, dir := range req.FromDirs {
+		if req.FromDirs[i], err = workspacePath(area, dir); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{err.Error()}})
 			return
 		}
-		pr, pw := io.Pipe()
-
-		// Use a goroutine to stream the tar archive
-		go func() {
-			defer func(pw *io.PipeWriter) {
-				_ = pw.Close()
-			}(pw)
-			gw := gzip.NewWriter(pw)
-			defer func(gw *gzip.Writer) {
-				_ = gw.Close()
-			}(gw)
-			tw := tar.NewWriter(gw)
-			defer func(tw *tar.Writer) {
-				_ = tw.Close()
-			}(tw)
-
-			// Your directory to tar and zip
-			dir := req.TargetDir
-
-			// Walk through every file in the folder
-			_ = filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
-				// Return on any error
-				if err != nil {
-					return err
-				}
+	}
+	if req.ToDir, err = workspacePath(area, req.ToDir); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []string{err.Error()}})
+		return
+	}
 
-				if file == dir {
-					return nil
-				}
+	m := docker_executor.Merger{
+		Context:          c,
+		ParallelismLimit: cfg.Parallelism,
+		Config:           cfg.Docker,
+		RegistryClient: docker_executor.RegistryClient{
+			Endpoint: cfg.Registry,
+			Context:  c,
+			Logger:   sessionLogger(c, sessionId),
+		},
+		Template:  req.Template,
+		SessionId: sessionId,
+	}
+	conflicts, err := m.MergeFiles(req.FromDirs, req.ProcessorIDs, req.ToDir)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": []string{err.Error()}})
+		return
+	}
+	c.JSON(http.StatusOK, docker_executor.MergeRes{Status: "OK", Conflicts: conflicts})
+}
 
-				// Create a new dir/file header
-				header, err := tar.FileInfoHeader(fi, fi.Name())
-				if err != nil {
-					return err
-				}
+// serveZip streams a tar.gz of the requested directory of the working area, refusing any other
+func serveZip(c *gin.Context, areaDir string) {
+	var req docker_executor.ZipReq
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []string{err.Error()}})
+		return
+	}
+	dir, err := workspacePath(areaDir, req.TargetDir)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []string{err.Error()}})
+		return
+	}
+	pr, pw := io.Pipe()
+
+	// Use a goroutine to stream the tar archive
+	go func() {
+		defer func(pw *io.PipeWriter) {
+			_ = pw.Close()
+		}(pw)
+		gw := gzip.NewWriter(pw)
+		defer func(gw *gzip.Writer) {
+			_ = gw.Close()
+		}(gw)
+		tw := tar.NewWriter(gw)
+		defer func(tw *tar.Writer) {
+			_ = tw.Close()
+		}(tw)
+
+		// Walk through every file in the folder
+		_ = filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+			// Return on any error
+			if err != nil {
+				return err
+			}
 
-				relPath, err := filepath.Rel(dir, file)
-				if err != nil {
+			if file == dir {
+				return nil
+			}
+
+			// Create a new dir/file header; symlinks are archived as links, never followed out of the area
+			link := ""
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(file); err != nil {
 					return err
 				}
-				// Update the name to correctly reflect the desired directory structure
-				header.Name = relPath
+			}
+			header, err := tar.FileInfoHeader(fi, link)
+			if err != nil {
+				return err
+			}
 
-				// Write the header
-				if errr := tw.WriteHeader(header); errr != nil {
-					return errr
-				}
+			relPath, err := filepath.Rel(dir, file)
+			if err != nil {
+				return err
+			}
+			// Update the name to correctly reflect the desired directory structure
+			header.Name = relPath
 
-				// If not a dir, write file content
-				if !fi.Mode().IsDir() {
-					data, e := os.Open(file)
-					if e != nil {
-						return e
-					}
-					defer func(data *os.File) {
-						_ = data.Close()
-					}(data)
-					if _, er := io.Copy(tw, data); er != nil {
-						return er
-					}
-				}
-				return nil
-			})
-		}()
-
-		// Set the header and serve the file
-		c.Header("Content-Description", "File Transfer")
-		c.Header("Content-Type", "application/octet-stream")
-		c.Header("Content-Disposition", "attachment; filename=cyan-output.tar.gz")
-		c.Header("Content-Transfer-Encoding", "binary")
-		c.Header("Expires", "0")
-		c.Header("Cache-Control", "must-revalidate")
-		c.Header("Pragma", "public")
-		c.DataFromReader(http.StatusOK, -1, "application/x-gzip", pr, nil)
-	})
+			// Write the header
+			if errr := tw.WriteHeader(header); errr != nil {
+				return errr
+			}
 
-	_ = r.Run(":9000")
+			// If a regular file, write its content
+			if fi.Mode().IsRegular() {
+				data, e := os.Open(file)
+				if e != nil {
+					return e
+				}
+				defer func(data *os.File) {
+					_ = data.Close()
+				}(data)
+				if _, er := io.Copy(tw, data); er != nil {
+					return er
+				}
+			}
+			return nil
+		})
+	}()
+
+	// Set the header and serve the file
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", "attachment; filename=cyan-output.tar.gz")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+	c.Header("Cache-Control", "must-revalidate")
+	c.Header("Pragma", "public")
+	c.DataFromReader(http.StatusOK, -1, "application/x-gzip", pr, nil)
 }
@@ -1,10 +1,24 @@
 package main
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/AtomiCloud/sulfone.boron/docker_executor"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // TestValidatePathValidPaths tests that valid paths within DEV_ROOT are accepted
@@ -358,3 +372,228 @@ func TestValidatePathEmptyDevRoot(t *testing.T) {
 		t.Errorf("validatePath(%q) returned empty result", cwd)
 	}
 }
+
+// TestStreamEvents tests that the events stream replays the session's history, then frames new events
+// as server-sent events until the session is forgotten
+func TestStreamEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	bus := docker_executor.NewEventBus()
+	r := gin.New()
+	r.GET("/executor/:sessionId/events", func(ctx *gin.Context) {
+		streamEvents(ctx, context.Background(), bus)
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	events := docker_executor.Emitter{Bus: bus, SessionId: "s1"}
+	events.Emit(docker_executor.Event{Type: docker_executor.EventImagePullStarted, Image: "registry.local/processor:1"})
+
+	resp, err := http.Get(srv.URL + "/executor/s1/events")
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %s", ct)
+	}
+	body := bufio.NewReader(resp.Body)
+	// next reads one event: an event line, a data line and the blank line ending it
+	next := func() (string, docker_executor.Event) {
+		t.Helper()
+		var lines [3]string
+		for i := range lines {
+			line, err := body.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read event: %v", err)
+			}
+			lines[i] = strings.TrimSuffix(line, "\n")
+		}
+		eventType, ok := strings.CutPrefix(lines[0], "event:")
+		data, hasData := strings.CutPrefix(lines[1], "data:")
+		if !ok || !hasData || lines[2] != "" {
+			t.Fatalf("Expected an event frame, got %q", lines)
+		}
+		var e docker_executor.Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			t.Fatalf("Failed to parse event data %s: %v", data, err)
+		}
+		return eventType, e
+	}
+
+	if eventType, e := next(); eventType != string(docker_executor.EventImagePullStarted) || e.Image != "registry.local/processor:1" || e.SessionId != "s1" {
+		t.Errorf("Expected the earlier pull event, got %s %+v", eventType, e)
+	}
+	events.Emit(docker_executor.Event{Type: docker_executor.EventContainerCreated, Container: "cyan-processor-processor1-s1"})
+	if eventType, e := next(); eventType != string(docker_executor.EventContainerCreated) || e.Container != "cyan-processor-processor1-s1" {
+		t.Errorf("Expected the new container event, got %s %+v", eventType, e)
+	}
+
+	bus.Forget("s1")
+	if rest, err := io.ReadAll(body); err != nil || len(rest) != 0 {
+		t.Errorf("Expected the stream to end once the session is forgotten, got %q (%v)", rest, err)
+	}
+}
+
+// TestSessionLogger tests that session loggers add the session, and the trace of a traced context, to
+// every line of the process logger
+func TestSessionLogger(t *testing.T) {
+	var buf strings.Builder
+	logger, err := docker_executor.NewLogger(&buf, "info", "json")
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	traced := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+
+	sessionLogger(traced, "s1").Info("traced")
+	sessionLogger(context.Background(), "s2").Info("untraced")
+	sessionLogger(traced, "s1").Debug("dropped")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines at info level, got %q", lines)
+	}
+	var first, second map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("Failed to parse %s: %v", lines[0], err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("Failed to parse %s: %v", lines[1], err)
+	}
+	if first[docker_executor.LogKeySession] != "s1" || first[docker_executor.LogKeyTrace] != traceID.String() {
+		t.Errorf("Expected the session and trace ids, got %v", first)
+	}
+	if _, ok := second[docker_executor.LogKeyTrace]; ok || second[docker_executor.LogKeySession] != "s2" {
+		t.Errorf("Expected the session id without a trace id, got %v", second)
+	}
+}
+
+// TestCheckSessionNetworks tests that the coordinator refuses to start with session networks on when it
+// doesn't run in a container, and starts otherwise
+func TestCheckSessionNetworks(t *testing.T) {
+	outside := docker_executor.NewFakeRuntime()
+	outside.RunCoordinatorOutside()
+
+	tests := []struct {
+		name            string
+		sessionNetworks bool
+		runtime         docker_executor.ContainerRuntime
+		wantErr         bool
+	}{
+		{name: "off on the host", runtime: outside},
+		{name: "on in a container", sessionNetworks: true, runtime: docker_executor.NewFakeRuntime()},
+		{name: "on on the host", sessionNetworks: true, runtime: outside, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Docker.Isolation.SessionNetworks = tt.sessionNetworks
+			err := checkSessionNetworks(cfg, tt.runtime)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "--session-networks=false") {
+					t.Errorf("Expected an error naming the fix, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// workspaceRequests returns a merger's working area, holding a file and a link out of the area, and a
+// router serving /merge and /zip from it
+func workspaceRequests(t *testing.T) (string, func(path string, body any) *httptest.ResponseRecorder) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	area := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(area, "out"), 0o755); err != nil {
+		t.Fatalf("Failed to create output: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(area, "out", "main.go"), []byte("package main"), 0o644); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+	if err := os.Symlink("/", filepath.Join(area, "root")); err != nil {
+		t.Fatalf("Failed to create link: %v", err)
+	}
+	cfg := DefaultConfig()
+	cfg.Docker.Workspace.AreaDir = area
+	r := gin.New()
+	r.POST("/merge/:sessionId", func(c *gin.Context) { serveMerge(c, cfg) })
+	r.POST("/zip", func(c *gin.Context) { serveZip(c, area) })
+	return area, func(path string, body any) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw)))
+		return w
+	}
+}
+
+// TestServeZipWorkspace tests that /zip archives directories of the working area only
+func TestServeZipWorkspace(t *testing.T) {
+	area, post := workspaceRequests(t)
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{name: "within the area", target: filepath.Join(area, "out"), want: http.StatusOK},
+		{name: "outside the area", target: "/", want: http.StatusBadRequest},
+		{name: "escaping with ..", target: filepath.Join(area, "out", "..", ".."), want: http.StatusBadRequest},
+		{name: "escaping through a link", target: filepath.Join(area, "root", "etc"), want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post("/zip", docker_executor.ZipReq{TargetDir: tt.target})
+			if w.Code != tt.want {
+				t.Fatalf("Expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("Expected a gzip archive: %v", err)
+			}
+			h, err := tar.NewReader(gz).Next()
+			if err != nil || h.Name != "main.go" {
+				t.Errorf("Expected main.go in the archive, got %v (%v)", h, err)
+			}
+		})
+	}
+}
+
+// TestServeMergeWorkspace tests that /merge refuses to read or write outside the working area
+func TestServeMergeWorkspace(t *testing.T) {
+	area, post := workspaceRequests(t)
+	out := filepath.Join(area, "out")
+	tests := []struct {
+		name string
+		req  docker_executor.MergeReq
+		want int
+	}{
+		{name: "within the area", req: docker_executor.MergeReq{FromDirs: []string{out}, ToDir: filepath.Join(area, "merged")}, want: http.StatusOK},
+		{name: "reading outside the area", req: docker_executor.MergeReq{FromDirs: []string{out, "/etc"}, ToDir: filepath.Join(area, "merged")}, want: http.StatusBadRequest},
+		{name: "writing outside the area", req: docker_executor.MergeReq{FromDirs: []string{out}, ToDir: t.TempDir()}, want: http.StatusBadRequest},
+		{name: "escaping with ..", req: docker_executor.MergeReq{FromDirs: []string{out}, ToDir: area + "/../escaped"}, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := post("/merge/s1", tt.req); w.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(area, "merged", "main.go")); err != nil {
+		t.Errorf("Expected the merge within the area to be written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(area), "escaped")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written outside the area, got %v", err)
+	}
+}
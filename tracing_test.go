@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestTracingMiddlewareContinuesTrace tests that an incoming W3C traceparent is continued
+// and that requests without one start a new trace
+func TestTracingMiddlewareContinuesTrace(t *testing.T) {
+	shutdown, err := setupTracing(context.Background(), "none")
+	if err != nil {
+		t.Fatalf("Failed to set up tracing: %v", err)
+	}
+	t.Cleanup(func() { _ = shutdown(context.Background()) })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(tracingMiddleware())
+	var got *string
+	r.GET("/probe", func(c *gin.Context) {
+		got = traceId(c)
+	})
+
+	tests := []struct {
+		name        string
+		traceparent string
+		want        string
+	}{
+		{
+			name:        "continues incoming trace",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			want:        "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			name: "starts new trace",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodGet, "/probe", nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got == nil {
+				t.Fatalf("Expected a trace id, got nil")
+			}
+			if tt.want != "" && *got != tt.want {
+				t.Errorf("Expected trace id %s, got %s", tt.want, *got)
+			}
+		})
+	}
+}
+
+// TestTraceIdWithoutSpan tests that contexts outside a trace yield no trace id
+func TestTraceIdWithoutSpan(t *testing.T) {
+	if id := traceId(context.Background()); id != nil {
+		t.Errorf("Expected nil trace id, got %s", *id)
+	}
+}